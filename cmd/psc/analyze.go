@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/anishathalye/periscope/internal/periscope"
+
+	"github.com/spf13/cobra"
+)
+
+var analyzeFlags struct {
+	chunks   bool
+	relative bool
+}
+
+var analyzeCmd = &cobra.Command{
+	Use:                   "analyze --chunks [path]",
+	Short:                 "Estimate savings from block-level deduplication",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.MaximumNArgs(1),
+	ValidArgsFunction:     analyzeValidArgs,
+	RunE:                  analyzeRun,
+}
+
+func init() {
+	analyzeCmd.Flags().BoolVar(&analyzeFlags.chunks, "chunks", false, "split files into content-defined chunks and report unique and shared data")
+	analyzeCmd.Flags().BoolVarP(&analyzeFlags.relative, "relative", "r", false, "show directories using relative paths")
+	rootCmd.AddCommand(analyzeCmd)
+}
+
+func analyzeValidArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return nil, cobra.ShellCompDirectiveFilterDirs
+}
+
+func analyzeRun(cmd *cobra.Command, paths []string) error {
+	ps, err := periscope.New(&periscope.Options{
+		Debug: rootFlags.debug,
+	})
+	if err != nil {
+		return err
+	}
+	var path string
+	if len(paths) == 1 {
+		path = paths[0]
+	}
+	options := &periscope.AnalyzeOptions{
+		Chunks:   analyzeFlags.chunks,
+		Relative: analyzeFlags.relative,
+	}
+	return ps.Analyze(path, options)
+}
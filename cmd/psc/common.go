@@ -5,6 +5,9 @@ import (
 
 	"fmt"
 	"math"
+	"sort"
+	"strconv"
+	"strings"
 
 	"github.com/dustin/go-humanize"
 )
@@ -32,3 +35,37 @@ func (s *size) String() string {
 func (s *size) Type() string {
 	return "size"
 }
+
+// thread counts by path, given as path=count
+type deviceThreads struct {
+	value map[string]int
+}
+
+func (d *deviceThreads) Set(x string) error {
+	i := strings.LastIndex(x, "=")
+	if i <= 0 {
+		return herror.UserF(nil, "expected path=count")
+	}
+	n, err := strconv.Atoi(x[i+1:])
+	if err != nil || n < 1 {
+		return herror.UserF(nil, "cannot parse as a positive number of threads")
+	}
+	if d.value == nil {
+		d.value = make(map[string]int)
+	}
+	d.value[x[:i]] = n
+	return nil
+}
+
+func (d *deviceThreads) String() string {
+	var entries []string
+	for path, n := range d.value {
+		entries = append(entries, fmt.Sprintf("%s=%d", path, n))
+	}
+	sort.Strings(entries)
+	return strings.Join(entries, ",")
+}
+
+func (d *deviceThreads) Type() string {
+	return "path=count"
+}
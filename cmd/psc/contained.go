@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/anishathalye/periscope/internal/periscope"
+
+	"github.com/spf13/cobra"
+)
+
+var containedFlags struct {
+	by       string
+	relative bool
+}
+
+var containedCmd = &cobra.Command{
+	Use:                   "contained [--by dir] <dir>",
+	Short:                 "Report how much of a directory has copies elsewhere",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.ExactArgs(1),
+	ValidArgsFunction:     containedValidArgs,
+	RunE:                  containedRun,
+}
+
+func init() {
+	containedCmd.Flags().StringVar(&containedFlags.by, "by", "", "only count copies inside this directory")
+	containedCmd.Flags().BoolVarP(&containedFlags.relative, "relative", "r", false, "show paths relative to the given directory")
+	containedCmd.RegisterFlagCompletionFunc("by", containedValidArgs)
+	rootCmd.AddCommand(containedCmd)
+}
+
+func containedValidArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return nil, cobra.ShellCompDirectiveFilterDirs
+}
+
+func containedRun(cmd *cobra.Command, paths []string) error {
+	ps, err := periscope.New(&periscope.Options{
+		Debug: rootFlags.debug,
+	})
+	if err != nil {
+		return err
+	}
+	options := &periscope.ContainedOptions{
+		By:       containedFlags.by,
+		Relative: containedFlags.relative,
+	}
+	return ps.Contained(paths[0], options)
+}
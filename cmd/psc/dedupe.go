@@ -0,0 +1,115 @@
+package main
+
+import (
+	"github.com/anishathalye/periscope/internal/herror"
+	"github.com/anishathalye/periscope/internal/periscope"
+
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var dedupeFlags struct {
+	rules    []periscope.DedupeRule
+	verbose  bool
+	dryRun   bool
+	trash    bool
+	symlink  bool
+	relative bool
+	hardlink bool
+	paranoid bool
+}
+
+// a flag that adds a rule each time it's given, so that rules given with
+// different flags keep the order they were given in
+type dedupeRuleFlag string
+
+func (f dedupeRuleFlag) String() string {
+	var values []string
+	for _, rule := range dedupeFlags.rules {
+		if rule.Flag == string(f) {
+			values = append(values, rule.Value)
+		}
+	}
+	return strings.Join(values, ",")
+}
+
+func (f dedupeRuleFlag) Set(value string) error {
+	dedupeFlags.rules = append(dedupeFlags.rules, periscope.DedupeRule{Flag: string(f), Value: value})
+	return nil
+}
+
+func (f dedupeRuleFlag) Type() string {
+	return "string"
+}
+
+var dedupeCmd = &cobra.Command{
+	Use:                   "dedupe [flags] [path]",
+	Short:                 "Remove duplicates, keeping copies chosen by rules",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.MaximumNArgs(1),
+	ValidArgsFunction:     dedupeValidArgs,
+	PreRunE:               dedupePreRun,
+	RunE:                  dedupeRun,
+}
+
+func init() {
+	dedupeCmd.Flags().VarP(dedupeRuleFlag("keep-under"), "keep-under", "", "keep copies in `dir`")
+	dedupeCmd.Flags().VarP(dedupeRuleFlag("keep"), "keep", "", "keep the copies chosen by `rule`: oldest, newest, shortest-path, or longest-path")
+	dedupeCmd.Flags().VarP(dedupeRuleFlag("keep-regex"), "keep-regex", "", "keep copies whose paths match `regex`")
+	dedupeCmd.Flags().BoolVarP(&dedupeFlags.verbose, "verbose", "v", false, "list files being deleted, and summarize what each rule reclaimed")
+	dedupeCmd.Flags().BoolVarP(&dedupeFlags.dryRun, "dry-run", "n", false, "do not delete files, but show files eligible for deletion")
+	dedupeCmd.Flags().BoolVar(&dedupeFlags.trash, "trash", false, "move files to the trash instead of deleting them")
+	dedupeCmd.Flags().BoolVar(&dedupeFlags.symlink, "symlink", false, "replace files with symbolic links to the copy being kept")
+	dedupeCmd.Flags().BoolVar(&dedupeFlags.relative, "relative", false, "with --symlink, create links relative to the link's directory")
+	dedupeCmd.Flags().BoolVar(&dedupeFlags.hardlink, "hardlink", false, "replace files with hardlinks to the copy being kept, if it's on the same device")
+	dedupeCmd.Flags().BoolVar(&dedupeFlags.paranoid, "paranoid", false, "compare files byte-for-byte with the copy being kept before deleting them")
+	rootCmd.AddCommand(dedupeCmd)
+}
+
+func dedupeValidArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) != 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return nil, cobra.ShellCompDirectiveFilterDirs
+}
+
+func dedupePreRun(cmd *cobra.Command, args []string) error {
+	replacements := 0
+	for _, set := range []bool{dedupeFlags.trash, dedupeFlags.symlink, dedupeFlags.hardlink} {
+		if set {
+			replacements++
+		}
+	}
+	if replacements > 1 {
+		return herror.User(nil, "only one of --trash, --symlink, and --hardlink can be used")
+	}
+	if dedupeFlags.relative && !dedupeFlags.symlink {
+		return herror.User(nil, "--relative requires --symlink")
+	}
+	return nil
+}
+
+func dedupeRun(cmd *cobra.Command, args []string) error {
+	ps, err := periscope.New(&periscope.Options{
+		Debug: rootFlags.debug,
+	})
+	if err != nil {
+		return err
+	}
+	path := ""
+	if len(args) > 0 {
+		path = args[0]
+	}
+	options := &periscope.DedupeOptions{
+		Rules:    dedupeFlags.rules,
+		Verbose:  dedupeFlags.verbose || dedupeFlags.dryRun,
+		DryRun:   dedupeFlags.dryRun,
+		Trash:    dedupeFlags.trash,
+		Symlink:  dedupeFlags.symlink,
+		Relative: dedupeFlags.relative,
+		Hardlink: dedupeFlags.hardlink,
+		Paranoid: dedupeFlags.paranoid,
+	}
+	return ps.Dedupe(path, options)
+}
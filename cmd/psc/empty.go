@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/anishathalye/periscope/internal/periscope"
+
+	"github.com/spf13/cobra"
+)
+
+var emptyCmd = &cobra.Command{
+	Use:                   "empty [path]",
+	Short:                 "List empty files and directories",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.MaximumNArgs(1),
+	ValidArgsFunction:     emptyValidArgs,
+	RunE:                  emptyRun,
+}
+
+func init() {
+	rootCmd.AddCommand(emptyCmd)
+}
+
+func emptyValidArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return nil, cobra.ShellCompDirectiveFilterDirs
+}
+
+func emptyRun(cmd *cobra.Command, paths []string) error {
+	ps, err := periscope.New(&periscope.Options{
+		Debug: rootFlags.debug,
+	})
+	if err != nil {
+		return err
+	}
+	root := "."
+	if len(paths) == 1 {
+		root = paths[0]
+	}
+	options := &periscope.EmptyOptions{}
+	return ps.Empty(root, options)
+}
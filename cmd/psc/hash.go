@@ -6,8 +6,12 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var hashFlags struct {
+	tag bool
+}
+
 var hashCmd = &cobra.Command{
-	Use:                   "hash path ...",
+	Use:                   "hash [flags] path ...",
 	Short:                 "Hash a file",
 	DisableFlagsInUseLine: true,
 	Args:                  cobra.MinimumNArgs(1),
@@ -16,6 +20,7 @@ var hashCmd = &cobra.Command{
 }
 
 func init() {
+	hashCmd.Flags().BoolVar(&hashFlags.tag, "tag", false, "print BSD-style checksums that name the algorithm, like 'sha256sum --tag'")
 	rootCmd.AddCommand(hashCmd)
 }
 
@@ -30,6 +35,8 @@ func hashRun(cmd *cobra.Command, paths []string) error {
 	if err != nil {
 		return err
 	}
-	options := &periscope.HashOptions{}
+	options := &periscope.HashOptions{
+		Tag: hashFlags.tag,
+	}
 	return ps.Hash(paths, options)
 }
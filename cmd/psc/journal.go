@@ -0,0 +1,34 @@
+package main
+
+import (
+	"github.com/anishathalye/periscope/internal/periscope"
+
+	"github.com/spf13/cobra"
+)
+
+var journalCmd = &cobra.Command{
+	Use:                   "journal",
+	Short:                 "List past operations that removed files",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.NoArgs,
+	ValidArgsFunction:     journalValidArgs,
+	RunE:                  journalRun,
+}
+
+func init() {
+	rootCmd.AddCommand(journalCmd)
+}
+
+func journalValidArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
+
+func journalRun(cmd *cobra.Command, args []string) error {
+	ps, err := periscope.New(&periscope.Options{
+		Debug: rootFlags.debug,
+	})
+	if err != nil {
+		return err
+	}
+	return ps.Journal(&periscope.JournalOptions{})
+}
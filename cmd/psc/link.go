@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/anishathalye/periscope/internal/periscope"
+
+	"github.com/spf13/cobra"
+)
+
+var linkFlags struct {
+	recursive bool
+	verbose   bool
+	dryRun    bool
+	contained []string
+	paranoid  bool
+}
+
+var linkCmd = &cobra.Command{
+	Use:                   "link [flags] path ...",
+	Short:                 "Replace duplicates with hardlinks",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.MinimumNArgs(1),
+	ValidArgsFunction:     linkValidArgs,
+	RunE:                  linkRun,
+}
+
+func init() {
+	linkCmd.Flags().BoolVarP(&linkFlags.recursive, "recursive", "r", false, "recursively link duplicates")
+	linkCmd.Flags().BoolVarP(&linkFlags.verbose, "verbose", "v", false, "list files being linked")
+	linkCmd.Flags().BoolVarP(&linkFlags.dryRun, "dry-run", "n", false, "do not link files, but show files eligible for linking")
+	linkCmd.Flags().StringArrayVarP(&linkFlags.contained, "contained", "c", nil, "link only files that have a duplicate in `path` (can be specified multiple times)")
+	linkCmd.Flags().BoolVar(&linkFlags.paranoid, "paranoid", false, "compare files byte-for-byte with the copy they're linked to before linking them")
+	rootCmd.AddCommand(linkCmd)
+}
+
+func linkValidArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return nil, cobra.ShellCompDirectiveDefault
+}
+
+func linkRun(cmd *cobra.Command, paths []string) error {
+	ps, err := periscope.New(&periscope.Options{
+		Debug: rootFlags.debug,
+	})
+	if err != nil {
+		return err
+	}
+	options := &periscope.LinkOptions{
+		Recursive: linkFlags.recursive,
+		Verbose:   linkFlags.verbose || linkFlags.dryRun,
+		DryRun:    linkFlags.dryRun,
+		Contained: linkFlags.contained,
+		Paranoid:  linkFlags.paranoid,
+	}
+	return ps.Link(paths, options)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"github.com/anishathalye/periscope/internal/periscope"
+
+	"github.com/spf13/cobra"
+)
+
+var pairsFlags struct {
+	relative bool
+}
+
+var pairsCmd = &cobra.Command{
+	Use:                   "pairs [dir1 dir2]",
+	Short:                 "Report directories that share duplicates",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.MaximumNArgs(2),
+	ValidArgsFunction:     pairsValidArgs,
+	RunE:                  pairsRun,
+}
+
+func init() {
+	pairsCmd.Flags().BoolVarP(&pairsFlags.relative, "relative", "r", false, "show directories using relative paths")
+	rootCmd.AddCommand(pairsCmd)
+}
+
+func pairsValidArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) >= 2 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return nil, cobra.ShellCompDirectiveFilterDirs
+}
+
+func pairsRun(cmd *cobra.Command, paths []string) error {
+	ps, err := periscope.New(&periscope.Options{
+		Debug: rootFlags.debug,
+	})
+	if err != nil {
+		return err
+	}
+	options := &periscope.PairsOptions{
+		Relative: pairsFlags.relative,
+	}
+	return ps.Pairs(paths, options)
+}
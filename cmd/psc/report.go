@@ -8,6 +8,7 @@ import (
 
 var reportFlags struct {
 	relative bool
+	dirs     bool
 }
 
 var reportCmd = &cobra.Command{
@@ -21,6 +22,7 @@ var reportCmd = &cobra.Command{
 
 func init() {
 	reportCmd.Flags().BoolVarP(&reportFlags.relative, "relative", "r", false, "show duplicates using relative paths")
+	reportCmd.Flags().BoolVar(&reportFlags.dirs, "dirs", false, "report directory trees with identical tracked contents, instead of each of the duplicates inside them (files that weren't scanned, like empty files, are ignored)")
 	rootCmd.AddCommand(reportCmd)
 }
 
@@ -41,6 +43,7 @@ func reportRun(cmd *cobra.Command, paths []string) error {
 	}
 	options := &periscope.ReportOptions{
 		Relative: reportFlags.relative,
+		Dirs:     reportFlags.dirs,
 	}
 	return ps.Report(path, options)
 }
@@ -13,6 +13,10 @@ var rmFlags struct {
 	dryRun    bool
 	contained []string
 	arbitrary bool
+	paranoid  bool
+	metadata  bool
+	prune     bool
+	trash     bool
 }
 
 var rmCmd = &cobra.Command{
@@ -31,6 +35,10 @@ func init() {
 	rmCmd.Flags().BoolVarP(&rmFlags.dryRun, "dry-run", "n", false, "do not delete files, but show files eligible for deletion")
 	rmCmd.Flags().StringArrayVarP(&rmFlags.contained, "contained", "c", nil, "delete only files that have a duplicate in `path` (can be specified multiple times)")
 	rmCmd.Flags().BoolVarP(&rmFlags.arbitrary, "arbitrary", "a", false, "arbitrarily choose a file to leave out when deleting a set with no other duplicates")
+	rmCmd.Flags().BoolVar(&rmFlags.paranoid, "paranoid", false, "compare files byte-for-byte with the copy being kept before deleting them")
+	rmCmd.Flags().BoolVar(&rmFlags.metadata, "allow-metadata-differences", false, "treat images with identical pixels as duplicates, even if their metadata differs")
+	rmCmd.Flags().BoolVar(&rmFlags.trash, "trash", false, "move files to the trash instead of deleting them (see 'psc trash')")
+	rmCmd.Flags().BoolVar(&rmFlags.prune, "prune-empty", false, "also delete directories that are left empty by deleting duplicates (requires -r/--recursive)")
 	rootCmd.AddCommand(rmCmd)
 }
 
@@ -42,6 +50,9 @@ func rmPreRun(cmd *cobra.Command, paths []string) error {
 	if rmFlags.arbitrary && len(rmFlags.contained) > 0 {
 		return herror.User(nil, "-a/--arbitrary and -c/--contained can't be used together")
 	}
+	if rmFlags.prune && !rmFlags.recursive {
+		return herror.User(nil, "--prune-empty requires -r/--recursive")
+	}
 	return nil
 }
 
@@ -53,11 +64,15 @@ func rmRun(cmd *cobra.Command, paths []string) error {
 		return err
 	}
 	options := &periscope.RmOptions{
-		Recursive: rmFlags.recursive,
-		Verbose:   rmFlags.verbose || rmFlags.dryRun,
-		DryRun:    rmFlags.dryRun,
-		Contained: rmFlags.contained,
-		Arbitrary: rmFlags.arbitrary,
+		Recursive:                rmFlags.recursive,
+		Verbose:                  rmFlags.verbose || rmFlags.dryRun,
+		DryRun:                   rmFlags.dryRun,
+		Contained:                rmFlags.contained,
+		Arbitrary:                rmFlags.arbitrary,
+		Paranoid:                 rmFlags.paranoid,
+		AllowMetadataDifferences: rmFlags.metadata,
+		Trash:                    rmFlags.trash,
+		PruneEmpty:               rmFlags.prune,
 	}
 	return ps.Rm(paths, options)
 }
@@ -1,14 +1,29 @@
 package main
 
 import (
+	"github.com/anishathalye/periscope/internal/herror"
 	"github.com/anishathalye/periscope/internal/periscope"
 
+	"strings"
+
 	"github.com/spf13/cobra"
 )
 
 var scanFlags struct {
-	minimum size
-	maximum size
+	minimum    size
+	maximum    size
+	exclude    []string
+	include    []string
+	hash       string
+	paranoid   bool
+	threads    deviceThreads
+	archives   bool
+	images     bool
+	pixels     bool
+	text       bool
+	fuzzy      bool
+	decompress bool
+	resume     bool
 }
 
 var scanCmd = &cobra.Command{
@@ -17,12 +32,26 @@ var scanCmd = &cobra.Command{
 	DisableFlagsInUseLine: true,
 	Args:                  cobra.ArbitraryArgs,
 	ValidArgsFunction:     scanValidArgs,
+	PreRunE:               scanPreRun,
 	RunE:                  scanRun,
 }
 
 func init() {
 	scanCmd.Flags().VarP(&scanFlags.minimum, "minimum", "m", "minimum file size to scan")
 	scanCmd.Flags().VarP(&scanFlags.maximum, "maximum", "M", "maximum file size to scan")
+	scanCmd.Flags().StringArrayVarP(&scanFlags.exclude, "exclude", "x", nil, "skip files and directories matching `pattern` (can be specified multiple times)")
+	scanCmd.Flags().StringArrayVarP(&scanFlags.include, "include", "i", nil, "scan only files matching `pattern` (can be specified multiple times)")
+	scanCmd.Flags().StringVar(&scanFlags.hash, "hash", "", "content hash `algorithm` ("+strings.Join(periscope.HashAlgorithms(), ", ")+"); defaults to the one already in use, or "+periscope.DefaultHashAlgorithm)
+	scanCmd.RegisterFlagCompletionFunc("hash", cobra.FixedCompletions(periscope.HashAlgorithms(), cobra.ShellCompDirectiveNoFileComp))
+	scanCmd.Flags().BoolVar(&scanFlags.paranoid, "paranoid", false, "compare files with equal hashes byte-for-byte")
+	scanCmd.Flags().Var(&scanFlags.threads, "device-threads", "number of files to read concurrently from the device containing path (can be specified multiple times)")
+	scanCmd.Flags().BoolVar(&scanFlags.archives, "archives", false, "also scan files inside zip and tar archives")
+	scanCmd.Flags().BoolVar(&scanFlags.images, "similar-images", false, "compute perceptual hashes of images, for 'psc similar'")
+	scanCmd.Flags().BoolVar(&scanFlags.pixels, "image-pixels", false, "hash the decoded pixels of images, to find images that differ only in metadata")
+	scanCmd.Flags().BoolVar(&scanFlags.text, "normalize-text", false, "hash text files with normalized line endings, to find files that differ only in line endings, a byte order mark, or a trailing newline")
+	scanCmd.Flags().BoolVar(&scanFlags.fuzzy, "fuzzy", false, "compute similarity digests of files, to find files with similar contents with 'psc similar <file>'")
+	scanCmd.Flags().BoolVar(&scanFlags.decompress, "decompress", false, "decompress gzip, bzip2, xz, and zstd files, to find compressed copies of other files")
+	scanCmd.Flags().BoolVar(&scanFlags.resume, "resume", false, "resume an interrupted scan")
 	rootCmd.AddCommand(scanCmd)
 }
 
@@ -30,6 +59,21 @@ func scanValidArgs(cmd *cobra.Command, args []string, toComplete string) ([]stri
 	return nil, cobra.ShellCompDirectiveFilterDirs
 }
 
+func scanPreRun(cmd *cobra.Command, paths []string) error {
+	if !scanFlags.resume {
+		return nil
+	}
+	if len(paths) > 0 {
+		return herror.User(nil, "--resume can't be used with paths; it rescans the paths of the interrupted scan")
+	}
+	for _, name := range []string{"minimum", "maximum", "exclude", "include", "hash", "paranoid", "device-threads", "archives", "similar-images", "image-pixels", "normalize-text", "fuzzy", "decompress"} {
+		if cmd.Flags().Changed(name) {
+			return herror.UserF(nil, "--resume can't be used with --%s; it reuses the options of the interrupted scan", name)
+		}
+	}
+	return nil
+}
+
 func scanRun(cmd *cobra.Command, paths []string) error {
 	ps, err := periscope.New(&periscope.Options{
 		Debug: rootFlags.debug,
@@ -37,12 +81,24 @@ func scanRun(cmd *cobra.Command, paths []string) error {
 	if err != nil {
 		return err
 	}
-	if len(paths) == 0 {
+	if len(paths) == 0 && !scanFlags.resume {
 		paths = []string{"."}
 	}
 	options := &periscope.ScanOptions{
-		Minimum: scanFlags.minimum.value,
-		Maximum: scanFlags.maximum.value,
+		Minimum:       scanFlags.minimum.value,
+		Maximum:       scanFlags.maximum.value,
+		Exclude:       scanFlags.exclude,
+		Include:       scanFlags.include,
+		Hash:          scanFlags.hash,
+		Paranoid:      scanFlags.paranoid,
+		DeviceThreads: scanFlags.threads.value,
+		Archives:      scanFlags.archives,
+		SimilarImages: scanFlags.images,
+		ImagePixels:   scanFlags.pixels,
+		NormalizeText: scanFlags.text,
+		Fuzzy:         scanFlags.fuzzy,
+		Decompress:    scanFlags.decompress,
+		Resume:        scanFlags.resume,
 	}
 	return ps.Scan(paths, options)
 }
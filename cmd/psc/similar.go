@@ -0,0 +1,52 @@
+package main
+
+import (
+	"github.com/anishathalye/periscope/internal/periscope"
+
+	"github.com/spf13/cobra"
+)
+
+var similarFlags struct {
+	distance  int
+	threshold int
+	relative  bool
+}
+
+var similarCmd = &cobra.Command{
+	Use:                   "similar [path]",
+	Short:                 "Report similar images or files",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.MaximumNArgs(1),
+	ValidArgsFunction:     similarValidArgs,
+	RunE:                  similarRun,
+}
+
+func init() {
+	similarCmd.Flags().IntVarP(&similarFlags.distance, "distance", "d", periscope.DefaultSimilarDistance, "maximum number of differing bits between perceptual hashes of similar images (0-64)")
+	similarCmd.Flags().IntVarP(&similarFlags.threshold, "threshold", "t", periscope.DefaultSimilarThreshold, "minimum similarity score of files similar to a given file that isn't an image (0-100)")
+	similarCmd.Flags().BoolVarP(&similarFlags.relative, "relative", "r", false, "show paths relative to the given path")
+	rootCmd.AddCommand(similarCmd)
+}
+
+func similarValidArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return nil, cobra.ShellCompDirectiveDefault
+}
+
+func similarRun(cmd *cobra.Command, paths []string) error {
+	ps, err := periscope.New(&periscope.Options{
+		Debug: rootFlags.debug,
+	})
+	if err != nil {
+		return err
+	}
+	var path string
+	if len(paths) == 1 {
+		path = paths[0]
+	}
+	options := &periscope.SimilarOptions{
+		Distance:  similarFlags.distance,
+		Threshold: similarFlags.threshold,
+		Relative:  similarFlags.relative,
+	}
+	return ps.Similar(path, options)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"github.com/anishathalye/periscope/internal/periscope"
+
+	"github.com/spf13/cobra"
+)
+
+var symlinkFlags struct {
+	recursive bool
+	verbose   bool
+	dryRun    bool
+	relative  bool
+	contained []string
+	paranoid  bool
+}
+
+var symlinkCmd = &cobra.Command{
+	Use:                   "symlink [flags] path ...",
+	Short:                 "Replace duplicates with symbolic links",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.MinimumNArgs(1),
+	ValidArgsFunction:     symlinkValidArgs,
+	RunE:                  symlinkRun,
+}
+
+func init() {
+	symlinkCmd.Flags().BoolVarP(&symlinkFlags.recursive, "recursive", "r", false, "recursively replace duplicates")
+	symlinkCmd.Flags().BoolVarP(&symlinkFlags.verbose, "verbose", "v", false, "list files being replaced")
+	symlinkCmd.Flags().BoolVarP(&symlinkFlags.dryRun, "dry-run", "n", false, "do not replace files, but show files eligible for replacement")
+	symlinkCmd.Flags().BoolVar(&symlinkFlags.relative, "relative", false, "create links relative to the link's directory")
+	symlinkCmd.Flags().StringArrayVarP(&symlinkFlags.contained, "contained", "c", nil, "replace only files that have a duplicate in `path` (can be specified multiple times)")
+	symlinkCmd.Flags().BoolVar(&symlinkFlags.paranoid, "paranoid", false, "compare files byte-for-byte with the copy they're linked to before replacing them")
+	rootCmd.AddCommand(symlinkCmd)
+}
+
+func symlinkValidArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return nil, cobra.ShellCompDirectiveDefault
+}
+
+func symlinkRun(cmd *cobra.Command, paths []string) error {
+	ps, err := periscope.New(&periscope.Options{
+		Debug: rootFlags.debug,
+	})
+	if err != nil {
+		return err
+	}
+	options := &periscope.SymlinkOptions{
+		Recursive: symlinkFlags.recursive,
+		Verbose:   symlinkFlags.verbose || symlinkFlags.dryRun,
+		DryRun:    symlinkFlags.dryRun,
+		Relative:  symlinkFlags.relative,
+		Contained: symlinkFlags.contained,
+		Paranoid:  symlinkFlags.paranoid,
+	}
+	return ps.Symlink(paths, options)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"github.com/anishathalye/periscope/internal/periscope"
+
+	"github.com/spf13/cobra"
+)
+
+var trashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "Manage files moved to the trash by rm --trash",
+}
+
+var trashListFlags struct {
+	relative bool
+}
+
+var trashListCmd = &cobra.Command{
+	Use:                   "list",
+	Short:                 "List files in the trash",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.NoArgs,
+	ValidArgsFunction:     trashNoArgs,
+	RunE:                  trashListRun,
+}
+
+var trashRestoreFlags struct {
+	verbose bool
+}
+
+var trashRestoreCmd = &cobra.Command{
+	Use:                   "restore [flags] path ...",
+	Short:                 "Restore files from the trash",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.MinimumNArgs(1),
+	ValidArgsFunction:     trashRestoreValidArgs,
+	RunE:                  trashRestoreRun,
+}
+
+var trashEmptyFlags struct {
+	verbose bool
+}
+
+var trashEmptyCmd = &cobra.Command{
+	Use:                   "empty",
+	Short:                 "Permanently delete files in the trash",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.NoArgs,
+	ValidArgsFunction:     trashNoArgs,
+	RunE:                  trashEmptyRun,
+}
+
+func init() {
+	trashListCmd.Flags().BoolVarP(&trashListFlags.relative, "relative", "r", false, "show original paths as relative paths")
+	trashRestoreCmd.Flags().BoolVarP(&trashRestoreFlags.verbose, "verbose", "v", false, "list files being restored")
+	trashEmptyCmd.Flags().BoolVarP(&trashEmptyFlags.verbose, "verbose", "v", false, "list files being deleted")
+	trashCmd.AddCommand(trashListCmd)
+	trashCmd.AddCommand(trashRestoreCmd)
+	trashCmd.AddCommand(trashEmptyCmd)
+	rootCmd.AddCommand(trashCmd)
+}
+
+func trashNoArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
+
+func trashRestoreValidArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return nil, cobra.ShellCompDirectiveDefault
+}
+
+func trashListRun(cmd *cobra.Command, args []string) error {
+	ps, err := periscope.New(&periscope.Options{
+		Debug: rootFlags.debug,
+	})
+	if err != nil {
+		return err
+	}
+	return ps.TrashList(&periscope.TrashListOptions{
+		Relative: trashListFlags.relative,
+	})
+}
+
+func trashRestoreRun(cmd *cobra.Command, paths []string) error {
+	ps, err := periscope.New(&periscope.Options{
+		Debug: rootFlags.debug,
+	})
+	if err != nil {
+		return err
+	}
+	return ps.TrashRestore(paths, &periscope.TrashRestoreOptions{
+		Verbose: trashRestoreFlags.verbose,
+	})
+}
+
+func trashEmptyRun(cmd *cobra.Command, args []string) error {
+	ps, err := periscope.New(&periscope.Options{
+		Debug: rootFlags.debug,
+	})
+	if err != nil {
+		return err
+	}
+	return ps.TrashEmpty(&periscope.TrashEmptyOptions{
+		Verbose: trashEmptyFlags.verbose,
+	})
+}
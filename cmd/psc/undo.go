@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/anishathalye/periscope/internal/herror"
+	"github.com/anishathalye/periscope/internal/periscope"
+
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var undoFlags struct {
+	last    int
+	since   string
+	verbose bool
+}
+
+var undoCmd = &cobra.Command{
+	Use:                   "undo [flags]",
+	Short:                 "Restore files removed by past operations",
+	DisableFlagsInUseLine: true,
+	Args:                  cobra.NoArgs,
+	ValidArgsFunction:     undoValidArgs,
+	PreRunE:               undoPreRun,
+	RunE:                  undoRun,
+}
+
+func init() {
+	undoCmd.Flags().IntVar(&undoFlags.last, "last", 1, "undo the last `N` operations")
+	undoCmd.Flags().StringVar(&undoFlags.since, "since", "", "undo operations since `time` (a date, a date and time, or a duration like 2h)")
+	undoCmd.Flags().BoolVarP(&undoFlags.verbose, "verbose", "v", false, "list files being restored")
+	rootCmd.AddCommand(undoCmd)
+}
+
+func undoValidArgs(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	return nil, cobra.ShellCompDirectiveNoFileComp
+}
+
+func undoPreRun(cmd *cobra.Command, args []string) error {
+	if cmd.Flags().Changed("last") && cmd.Flags().Changed("since") {
+		return herror.User(nil, "--last and --since can't be used together")
+	}
+	if undoFlags.last < 1 {
+		return herror.User(nil, "--last must be at least 1")
+	}
+	return nil
+}
+
+// parses a time given on the command line, either as a local date and time or
+// as a duration before now
+func parseSince(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04", "2006-01-02 15:04:05", "2006-01-02T15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, herror.UserF(nil, "invalid time '%s'", s)
+}
+
+func undoRun(cmd *cobra.Command, args []string) error {
+	options := &periscope.UndoOptions{
+		Last:    undoFlags.last,
+		Verbose: undoFlags.verbose,
+	}
+	if undoFlags.since != "" {
+		since, err := parseSince(undoFlags.since)
+		if err != nil {
+			return err
+		}
+		options.Since = since
+	}
+	ps, err := periscope.New(&periscope.Options{
+		Debug: rootFlags.debug,
+	})
+	if err != nil {
+		return err
+	}
+	return ps.Undo(options)
+}
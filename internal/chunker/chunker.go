@@ -0,0 +1,112 @@
+// Package chunker splits data into content-defined chunks with FastCDC.
+//
+// Chunk boundaries are chosen by a rolling (gear) hash of the last few bytes,
+// so they depend only on local content: inserting or deleting data only
+// changes the chunks around the edit, and identical regions of different files
+// are split into identical chunks. This is how deduplicating backup systems
+// find shared data.
+//
+// Normalized chunking makes boundaries less likely before the average size
+// and more likely after it, so chunk sizes cluster around the average.
+package chunker
+
+import (
+	"io"
+)
+
+const (
+	MinSize = 16 * 1024
+	AvgSize = 64 * 1024
+	MaxSize = 256 * 1024
+)
+
+// the boundary conditions before and after AvgSize; the masks use the high
+// bits of the hash, which depend on the most input, with two more bits than
+// log2(AvgSize) before the average and two fewer after it
+const (
+	maskSmall = uint64(1<<18-1) << (64 - 18)
+	maskLarge = uint64(1<<14-1) << (64 - 14)
+)
+
+// random values for each byte, fixed so that chunk boundaries are stable
+var gear [256]uint64
+
+func init() {
+	// splitmix64
+	state := uint64(0x9e3779b97f4a7c15)
+	for i := range gear {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		gear[i] = z ^ (z >> 31)
+	}
+}
+
+// returns the length of the first chunk of data, which must be the rest of
+// the input or at least MaxSize bytes
+func cut(data []byte) int {
+	n := len(data)
+	if n <= MinSize {
+		return n
+	}
+	if n > MaxSize {
+		n = MaxSize
+	}
+	normal := AvgSize
+	if n < normal {
+		normal = n
+	}
+	var fp uint64
+	i := MinSize
+	for ; i < normal; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&maskSmall == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		fp = (fp << 1) + gear[data[i]]
+		if fp&maskLarge == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
+
+// A Chunker splits the data read from a reader into chunks.
+type Chunker struct {
+	r          io.Reader
+	buf        []byte
+	start, end int
+	eof        bool
+}
+
+// Returns a Chunker that reads from r.
+func New(r io.Reader) *Chunker {
+	return &Chunker{r: r, buf: make([]byte, MaxSize)}
+}
+
+// Returns the next chunk, or io.EOF after the last one. The chunk is only
+// valid until the next call to Next.
+func (c *Chunker) Next() ([]byte, error) {
+	if c.end-c.start < MaxSize && !c.eof {
+		copy(c.buf, c.buf[c.start:c.end])
+		c.end -= c.start
+		c.start = 0
+		n, err := io.ReadFull(c.r, c.buf[c.end:])
+		c.end += n
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			c.eof = true
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	n := cut(c.buf[c.start:c.end])
+	chunk := c.buf[c.start : c.start+n]
+	c.start += n
+	return chunk, nil
+}
@@ -0,0 +1,78 @@
+package chunker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func chunks(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+	var result [][]byte
+	c := New(bytes.NewReader(data))
+	for {
+		chunk, err := c.Next()
+		if err == io.EOF {
+			return result
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, append([]byte(nil), chunk...))
+	}
+}
+
+func randomData(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestChunkSizes(t *testing.T) {
+	data := randomData(0, 8*1024*1024)
+	result := chunks(t, data)
+	if got := bytes.Join(result, nil); !bytes.Equal(got, data) {
+		t.Fatal("expected chunks to reassemble the input")
+	}
+	for i, chunk := range result {
+		if len(chunk) > MaxSize || (len(chunk) < MinSize && i != len(result)-1) {
+			t.Errorf("chunk %d has size %d", i, len(chunk))
+		}
+	}
+	avg := len(data) / len(result)
+	if avg < AvgSize/2 || avg > AvgSize*2 {
+		t.Errorf("expected chunks to average about %d bytes, got %d", AvgSize, avg)
+	}
+}
+
+func TestChunkEmpty(t *testing.T) {
+	if result := chunks(t, nil); len(result) != 0 {
+		t.Fatalf("expected no chunks, got %d", len(result))
+	}
+	if result := chunks(t, []byte("x")); len(result) != 1 {
+		t.Fatalf("expected one chunk, got %d", len(result))
+	}
+}
+
+func TestChunkInsertion(t *testing.T) {
+	data := randomData(1, 4*1024*1024)
+	// insert some data near the beginning; all but the first few chunks
+	// should be unaffected
+	edited := append(append(append([]byte(nil), data[:100000]...), randomData(2, 1000)...), data[100000:]...)
+	seen := make(map[[sha256.Size]byte]bool)
+	for _, chunk := range chunks(t, data) {
+		seen[sha256.Sum256(chunk)] = true
+	}
+	editedChunks := chunks(t, edited)
+	shared := 0
+	for _, chunk := range editedChunks {
+		if seen[sha256.Sum256(chunk)] {
+			shared++
+		}
+	}
+	if shared < len(editedChunks)-3 {
+		t.Fatalf("expected most chunks to be shared, got %d of %d", shared, len(editedChunks))
+	}
+}
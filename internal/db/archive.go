@@ -0,0 +1,77 @@
+package db
+
+import (
+	"github.com/anishathalye/periscope/internal/herror"
+
+	"database/sql"
+	"path/filepath"
+	"strings"
+)
+
+// An archive whose members were hashed, along with the archive's stat
+// metadata at the time, so that a later scan can tell whether the archive has
+// changed without reading it again.
+type ArchiveInfo struct {
+	Path   string
+	Size   int64
+	Mtime  int64
+	Ctime  int64
+	Inode  int64
+	Device int64
+	// the size range of the scan that hashed the members; members outside
+	// of it weren't added to the database
+	Minimum int64
+	Maximum int64
+	// the number of members that were added to the database
+	Members int64
+}
+
+// Records an archive whose members were hashed (or carried over) by the scan
+// of the given generation.
+func (s *Session) AddArchive(archive ArchiveInfo, generation int64) herror.Interface {
+	if _, err := s.exec(`
+	REPLACE INTO archive (path, size, mtime, ctime, inode, device, minimum, maximum, members, generation)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, archive.Path, archive.Size, archive.Mtime, archive.Ctime, archive.Inode, archive.Device, archive.Minimum, archive.Maximum, archive.Members, generation); err != nil {
+		return herror.Internal(err, "")
+	}
+	return nil
+}
+
+// Returns the recorded archive with the given path, and whether there is one.
+func (s *Session) LookupArchive(path string) (ArchiveInfo, bool, herror.Interface) {
+	row, herr := s.queryRow(`
+	SELECT size, mtime, ctime, inode, device, minimum, maximum, members
+	FROM archive
+	WHERE path = ?
+	`, path)
+	if herr != nil {
+		return ArchiveInfo{}, false, herr
+	}
+	archive := ArchiveInfo{Path: path}
+	err := row.Scan(&archive.Size, &archive.Mtime, &archive.Ctime, &archive.Inode, &archive.Device, &archive.Minimum, &archive.Maximum, &archive.Members)
+	if err == sql.ErrNoRows {
+		return ArchiveInfo{}, false, nil
+	} else if err != nil {
+		return ArchiveInfo{}, false, herror.Internal(err, "")
+	}
+	return archive, true, nil
+}
+
+// Forgets the archives under the given directory that weren't recorded by the
+// scan of the given generation (or a later one); like RemoveDirOlder, this is
+// used to clean up after a scan.
+func (s *Session) RemoveArchivesOlder(dir string, generation int64) herror.Interface {
+	prefix := dir
+	if !strings.HasSuffix(prefix, string(filepath.Separator)) {
+		prefix += string(filepath.Separator)
+	}
+	if _, err := s.exec(`
+	DELETE FROM archive
+	WHERE (path = ? OR substr(path, 1, ?) = ?)
+		AND generation < ?
+	`, dir, len(prefix), prefix, generation); err != nil {
+		return herror.Internal(err, "")
+	}
+	return nil
+}
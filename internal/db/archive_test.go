@@ -0,0 +1,48 @@
+package db
+
+import (
+	"testing"
+)
+
+func TestArchives(t *testing.T) {
+	db := newInMemoryDb(t)
+	a := ArchiveInfo{Path: "/d/a.zip", Size: 10, Mtime: 1, Ctime: 2, Inode: 3, Device: 4, Minimum: 5, Maximum: 6, Members: 7}
+	check(t, db.AddArchive(a, 1))
+	check(t, db.AddArchive(ArchiveInfo{Path: "/d/e/b.zip"}, 2))
+	check(t, db.AddArchive(ArchiveInfo{Path: "/dd/c.zip"}, 1))
+	got, ok, err := db.LookupArchive("/d/a.zip")
+	check(t, err)
+	if !ok || got != a {
+		t.Fatalf("expected %+v, got %+v", a, got)
+	}
+	// only older archives in the directory are forgotten
+	check(t, db.RemoveArchivesOlder("/d", 2))
+	for path, expected := range map[string]bool{"/d/a.zip": false, "/d/e/b.zip": true, "/dd/c.zip": true} {
+		_, ok, err := db.LookupArchive(path)
+		check(t, err)
+		if ok != expected {
+			t.Errorf("%s: expected present to be %v", path, expected)
+		}
+	}
+}
+
+func TestInfosUnder(t *testing.T) {
+	db := newInMemoryDb(t)
+	for _, path := range []string{"/a/x", "/a/b/y", "/a/b/c/z", "/ab/w", "/v"} {
+		check(t, db.Add(FileInfo{Path: path, Size: 1}))
+	}
+	infos, err := db.InfosUnder("/a")
+	check(t, err)
+	var paths []string
+	for _, info := range infos {
+		paths = append(paths, info.Path)
+	}
+	if len(paths) != 3 || paths[0] != "/a/b/c/z" || paths[1] != "/a/b/y" || paths[2] != "/a/x" {
+		t.Fatalf("unexpected infos %v", paths)
+	}
+	infos, err = db.InfosUnder("/nonexistent")
+	check(t, err)
+	if len(infos) != 0 {
+		t.Fatalf("expected no infos, got %v", infos)
+	}
+}
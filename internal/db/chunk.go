@@ -0,0 +1,285 @@
+package db
+
+import (
+	"github.com/anishathalye/periscope/internal/herror"
+
+	"database/sql"
+	"log"
+	"path/filepath"
+	"sort"
+)
+
+// A content-defined chunk of a file.
+type Chunk struct {
+	Size int64
+	Hash []byte
+}
+
+// A file that has not been chunked yet, identified by its id in the database.
+type UnchunkedFile struct {
+	Id   int64
+	Info FileInfo
+}
+
+// Statistics about the chunks of a set of files.
+type ChunkSummary struct {
+	Files  int64
+	Chunks int64
+	// the total size of all chunks
+	Total int64
+	// the size of the chunks that appear only once; the rest of the data
+	// is in chunks that are shared
+	Unique int64
+	// the size of the data if each distinct chunk is stored once
+	Deduplicated int64
+}
+
+// Statistics about the chunks of the files directly in a directory.
+type DirectoryChunkSummary struct {
+	Path string
+	// the total size of the chunks of files in the directory
+	Total int64
+	// the size of the chunks that appear only once, among all the files
+	// that are summarized
+	Unique int64
+}
+
+// the condition for a chunked_file c to hold the chunks of a file_info: the
+// same path, and the same stat metadata, so the file hasn't changed since it
+// was chunked
+const chunkedFileMatches = `c.directory = file_info.directory
+	AND c.filename = file_info.filename
+	AND c.size = file_info.size
+	AND c.mtime = file_info.mtime
+	AND c.ctime = file_info.ctime
+	AND c.inode = file_info.inode
+	AND c.device = file_info.device`
+
+// the chunks of the files in the files table expression, by file_info id
+const chunksOfFiles = `
+		chunks AS
+		(
+			SELECT file_info.id AS file, chunk.size AS size, chunk.hash AS hash
+			FROM file_info
+			JOIN chunked_file c ON ` + chunkedFileMatches + `
+			JOIN chunk ON chunk.file = c.id
+			WHERE file_info.id IN files
+		)`
+
+// common table expressions for the files under the directory with the given
+// id (or all files, if dirid is -1), and their chunks
+//
+// each set of hardlinks is represented by one file, so its chunks are only
+// counted once
+func chunkedFiles(dirid int64) (string, []interface{}) {
+	if dirid == -1 {
+		return `
+		files AS
+		(
+			SELECT MIN(id) AS id FROM file_info GROUP BY ` + fileKey("file_info") + `
+		),` + chunksOfFiles, nil
+	}
+	return `
+		dirs AS
+		(
+			WITH RECURSIVE sub_directory (id, parent) AS (
+				SELECT id, parent FROM directory WHERE id = ?
+				UNION ALL
+				SELECT d.id, d.parent
+				FROM directory d, sub_directory sd
+				WHERE d.parent = sd.id
+			)
+			SELECT id FROM sub_directory
+		),
+		files AS
+		(
+			SELECT MIN(id) AS id FROM file_info WHERE directory IN dirs GROUP BY ` + fileKey("file_info") + `
+		),` + chunksOfFiles, []interface{}{dirid}
+}
+
+// Returns the non-empty files with the given directory prefix (or all files,
+// if path is "") that have no chunks. Only one of each set of hardlinks is
+// returned.
+func (s *Session) Unchunked(path string) ([]UnchunkedFile, herror.Interface) {
+	dirid := int64(-1)
+	if path != "" {
+		var err error
+		dirid, err = s.pathToDirectoryId(path, false)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		} else if err != nil {
+			return nil, herror.Internal(err, "")
+		}
+	}
+	ctes, args := chunkedFiles(dirid)
+	rows, err := s.query(`
+	WITH `+ctes+`
+	SELECT `+fileInfoColumns+`, id
+	FROM file_info
+	WHERE id IN files
+		AND size > 0
+		AND id NOT IN (SELECT DISTINCT file FROM chunks)
+	`, args...)
+	if err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	defer rows.Close()
+	var results []UnchunkedFile
+	for rows.Next() {
+		var dirid int64
+		var filename string
+		var file UnchunkedFile
+		if err := scanFileInfo(rows, &dirid, &filename, &file.Info, &file.Id); err != nil {
+			return nil, herror.Internal(err, "")
+		}
+		dirname, err := s.directoryIdToPath(dirid)
+		if err != nil {
+			return nil, herror.Internal(err, "")
+		}
+		file.Info.Path = filepath.Join(dirname, filename)
+		results = append(results, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Info.Path < results[j].Info.Path })
+	return results, nil
+}
+
+// Records the chunks of the file with the given id, replacing any chunks
+// recorded for an earlier version of the file at the same path.
+//
+// The chunks are kept for as long as the file is in the database with the
+// same stat metadata, even if it's rescanned.
+func (s *Session) AddChunks(file int64, chunks []Chunk) herror.Interface {
+	if _, err := s.exec(`
+	DELETE FROM chunk
+	WHERE file IN (
+		SELECT c.id
+		FROM chunked_file c
+		JOIN file_info ON c.directory = file_info.directory AND c.filename = file_info.filename
+		WHERE file_info.id = ?
+	)`, file); err != nil {
+		return herror.Internal(err, "")
+	}
+	result, err := s.exec(`
+	REPLACE INTO chunked_file (directory, filename, size, mtime, ctime, inode, device)
+	SELECT directory, filename, size, mtime, ctime, inode, device
+	FROM file_info
+	WHERE id = ?
+	`, file)
+	if err != nil {
+		return herror.Internal(err, "")
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return herror.Internal(err, "")
+	} else if n == 0 {
+		return nil // the file is no longer in the database
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return herror.Internal(err, "")
+	}
+	for _, chunk := range chunks {
+		if _, err := s.exec("INSERT INTO chunk (file, size, hash) VALUES (?, ?, ?)", id, chunk.Size, chunk.Hash); err != nil {
+			return herror.Internal(err, "")
+		}
+	}
+	return nil
+}
+
+// Deletes the chunks of files that are no longer in the database, or that have
+// changed since they were chunked.
+func (s *Session) DeleteOrphanedChunks() herror.Interface {
+	if _, err := s.exec(`
+	DELETE FROM chunked_file
+	WHERE NOT EXISTS (
+		SELECT 1 FROM file_info, chunked_file c
+		WHERE c.id = chunked_file.id AND ` + chunkedFileMatches + `
+	)`); err != nil {
+		return herror.Internal(err, "")
+	}
+	if _, err := s.exec("DELETE FROM chunk WHERE file NOT IN (SELECT id FROM chunked_file)"); err != nil {
+		return herror.Internal(err, "")
+	}
+	return nil
+}
+
+// Summarizes the chunks of the files with the given directory prefix (or all
+// files, if path is ""), overall and for each directory that directly contains
+// chunked files. Directory summaries are sorted by path.
+//
+// Chunks are only compared with the chunks of the other files that are
+// summarized, as if these were the only files.
+func (s *Session) ChunkSummary(path string) (ChunkSummary, []DirectoryChunkSummary, herror.Interface) {
+	dirid := int64(-1)
+	if path != "" {
+		var err error
+		dirid, err = s.pathToDirectoryId(path, false)
+		if err == sql.ErrNoRows {
+			return ChunkSummary{}, nil, nil
+		} else if err != nil {
+			return ChunkSummary{}, nil, herror.Internal(err, "")
+		}
+	}
+	ctes, args := chunkedFiles(dirid)
+	row, herr := s.queryRow(`
+	WITH `+ctes+`,
+	counts AS
+	(
+		SELECT COUNT(*) AS cnt, MAX(size) AS size FROM chunks GROUP BY hash
+	)
+	SELECT
+		(SELECT COUNT(DISTINCT file) FROM chunks),
+		COALESCE(SUM(cnt), 0),
+		COALESCE(SUM(cnt*size), 0),
+		COALESCE(SUM(CASE WHEN cnt = 1 THEN size ELSE 0 END), 0),
+		COALESCE(SUM(size), 0)
+	FROM counts
+	`, args...)
+	if herr != nil {
+		return ChunkSummary{}, nil, herr
+	}
+	var summary ChunkSummary
+	if err := row.Scan(&summary.Files, &summary.Chunks, &summary.Total, &summary.Unique, &summary.Deduplicated); err != nil {
+		return ChunkSummary{}, nil, herror.Internal(err, "")
+	}
+	rows, err := s.query(`
+	WITH `+ctes+`,
+	counts AS
+	(
+		SELECT hash, COUNT(*) AS cnt FROM chunks GROUP BY hash
+	)
+	SELECT
+		file_info.directory,
+		SUM(chunks.size),
+		SUM(CASE WHEN counts.cnt = 1 THEN chunks.size ELSE 0 END)
+	FROM chunks
+	JOIN counts ON chunks.hash = counts.hash
+	JOIN file_info ON file_info.id = chunks.file
+	GROUP BY file_info.directory
+	`, args...)
+	if err != nil {
+		return ChunkSummary{}, nil, herror.Internal(err, "")
+	}
+	defer rows.Close()
+	var dirs []DirectoryChunkSummary
+	for rows.Next() {
+		var dirid int64
+		var dir DirectoryChunkSummary
+		if err := rows.Scan(&dirid, &dir.Total, &dir.Unique); err != nil {
+			return ChunkSummary{}, nil, herror.Internal(err, "")
+		}
+		dir.Path, err = s.directoryIdToPath(dirid)
+		if err != nil {
+			log.Printf("failure while resolving directory name: %s", err)
+			continue
+		}
+		dirs = append(dirs, dir)
+	}
+	if err := rows.Err(); err != nil {
+		return ChunkSummary{}, nil, herror.Internal(err, "")
+	}
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].Path < dirs[j].Path })
+	return summary, dirs, nil
+}
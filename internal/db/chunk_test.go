@@ -0,0 +1,75 @@
+package db
+
+import (
+	"testing"
+)
+
+func countChunks(t *testing.T, db *Session) int {
+	var n int
+	if err := db.db.QueryRow("SELECT COUNT(*) FROM chunk").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestChunks(t *testing.T) {
+	db := newInMemoryDb(t)
+	check(t, addAll(db, []FileInfo{
+		{Path: "/d/a", Size: 10, Inode: 1, Device: 1},
+		{Path: "/d/b", Size: 10, Inode: 1, Device: 1}, // hardlink to /d/a
+		{Path: "/e/c", Size: 15, Inode: 2, Device: 1},
+		{Path: "/e/empty", Size: 0, Inode: 3, Device: 1},
+	}))
+	files, err := db.Unchunked("")
+	check(t, err)
+	if len(files) != 2 || files[0].Info.Path != "/d/a" || files[1].Info.Path != "/e/c" {
+		t.Fatalf("expected one of the hardlinks and the other non-empty file, got %+v", files)
+	}
+	check(t, db.AddChunks(files[0].Id, []Chunk{{5, []byte("x")}, {5, []byte("y")}}))
+	check(t, db.AddChunks(files[1].Id, []Chunk{{5, []byte("x")}, {10, []byte("z")}}))
+	files, err = db.Unchunked("")
+	check(t, err)
+	if len(files) != 0 {
+		t.Fatalf("expected all files to be chunked, got %+v", files)
+	}
+	summary, dirs, err := db.ChunkSummary("")
+	check(t, err)
+	expected := ChunkSummary{Files: 2, Chunks: 4, Total: 25, Unique: 15, Deduplicated: 20}
+	if summary != expected {
+		t.Fatalf("expected %+v, got %+v", expected, summary)
+	}
+	if len(dirs) != 2 || dirs[0] != (DirectoryChunkSummary{"/d", 10, 5}) || dirs[1] != (DirectoryChunkSummary{"/e", 15, 10}) {
+		t.Fatalf("unexpected directory summaries %+v", dirs)
+	}
+	// within a directory, chunks are only compared with each other
+	summary, _, err = db.ChunkSummary("/e")
+	check(t, err)
+	expected = ChunkSummary{Files: 1, Chunks: 2, Total: 15, Unique: 15, Deduplicated: 15}
+	if summary != expected {
+		t.Fatalf("expected %+v, got %+v", expected, summary)
+	}
+
+	// re-adding an unchanged file, like a rescan does, keeps its chunks
+	check(t, db.Add(FileInfo{Path: "/e/c", Size: 15, Inode: 2, Device: 1}))
+	files, err = db.Unchunked("/e")
+	check(t, err)
+	if len(files) != 0 {
+		t.Fatalf("expected re-added file to stay chunked, got %+v", files)
+	}
+	check(t, db.DeleteOrphanedChunks())
+	if n := countChunks(t, db); n != 4 {
+		t.Fatalf("expected 4 chunks, got %d", n)
+	}
+
+	// but a file that has changed since it was chunked orphans its chunks
+	check(t, db.Add(FileInfo{Path: "/e/c", Size: 15, Mtime: 1, Inode: 2, Device: 1}))
+	files, err = db.Unchunked("/e")
+	check(t, err)
+	if len(files) != 1 {
+		t.Fatalf("expected changed file to be unchunked, got %+v", files)
+	}
+	check(t, db.DeleteOrphanedChunks())
+	if n := countChunks(t, db); n != 2 {
+		t.Fatalf("expected 2 chunks after deleting orphans, got %d", n)
+	}
+}
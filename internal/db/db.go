@@ -12,23 +12,80 @@ import (
 	"path/filepath"
 	"sort"
 	"strconv"
+	"strings"
 	"sync/atomic"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 const versionKey = "version"
-const version = 3
+const version = 4
 
 type FileInfo struct {
 	Path      string
 	Size      int64
 	ShortHash []byte
-	FullHash  []byte
+	// hash of samples from the middle and end of the file, only computed
+	// for large files
+	SampleHash []byte
+	FullHash   []byte
+	// perceptual hash of an image's pixels, only computed for images when
+	// requested; similar-looking images have hashes with a small Hamming
+	// distance
+	ImageHash []byte
+	// hash of an image's decoded pixels, only computed for images when
+	// requested; images that differ only in their metadata have the same
+	// pixel hash
+	PixelHash []byte
+	// hash of a text file's contents after normalizing line endings, a
+	// byte order mark, and a trailing newline; only computed for text
+	// files when requested
+	TextHash []byte
+	// context-triggered piecewise hash of the contents, only computed
+	// when requested; files with similar contents have digests that
+	// compare as similar (see package fuzzyhash)
+	FuzzyHash []byte
+	// for compressed files, the full hash and size of the decompressed
+	// contents, only computed when requested; the hash is comparable to
+	// the full hash of an uncompressed copy, and the size is 0 when unknown
+	DecompressedHash []byte
+	DecompressedSize int64
+	// stat metadata at the time the file was hashed, used to decide
+	// whether hashes can be reused on a rescan; times are in nanoseconds
+	// since the epoch, and fields are 0 when unknown
+	Mtime  int64
+	Ctime  int64
+	Inode  int64
+	Device int64
+}
+
+// Reports whether two infos are known to be hardlinks to the same underlying
+// file. Infos with an unknown inode are never considered the same file.
+func (info *FileInfo) SameFile(other *FileInfo) bool {
+	return info.Inode != 0 && info.Inode == other.Inode && info.Device == other.Device
 }
 
 type DuplicateSet []FileInfo
 
+// Returns the number of distinct files in the set, counting hardlinks to the
+// same file only once.
+func (set DuplicateSet) Files() int {
+	files := 0
+	for i := range set {
+		isLink := false
+		for j := 0; j < i; j++ {
+			if set[i].SameFile(&set[j]) {
+				isLink = true
+				break
+			}
+		}
+		if !isLink {
+			files++
+		}
+	}
+	return files
+}
+
 type fileInfosOrdering []FileInfo
 
 func (a fileInfosOrdering) Len() int { return len(a) }
@@ -58,6 +115,7 @@ type InfoSummary struct {
 	Files     int64
 	Unique    int64
 	Duplicate int64
+	Hardlinks int64
 	Overhead  int64
 }
 
@@ -118,7 +176,7 @@ Ensure that the directory is writable, and if the database file already exists,
 	}
 
 	s := &Session{db: db}
-	herr := s.checkVersion()
+	migrated, herr := s.checkVersion()
 	if herr != nil {
 		return nil, herr
 	}
@@ -126,10 +184,18 @@ Ensure that the directory is writable, and if the database file already exists,
 	if err != nil {
 		return nil, herror.Internal(err, "")
 	}
+	if migrated {
+		// the rebuilt file_info table has no indexes yet
+		if herr := s.CreateIndexes(); herr != nil {
+			return nil, herr
+		}
+	}
 	return s, nil
 }
 
-func (s *Session) checkVersion() herror.Interface {
+// checks the database's version, upgrading it if it's an older version that
+// can be migrated; returns whether it was upgraded
+func (s *Session) checkVersion() (bool, herror.Interface) {
 	// ensure metadata table exists
 	_, err := s.db.Exec(`
 	CREATE TABLE IF NOT EXISTS meta
@@ -139,7 +205,7 @@ func (s *Session) checkVersion() herror.Interface {
 	)
 	`)
 	if err != nil {
-		return herror.Internal(err, "")
+		return false, herror.Internal(err, "")
 	}
 	row := s.db.QueryRow("SELECT value FROM meta WHERE key = ?", versionKey)
 	var dbVersion string
@@ -148,21 +214,84 @@ func (s *Session) checkVersion() herror.Interface {
 		// okay, we will initialize version
 		_, err = s.db.Exec("INSERT INTO meta (key, value) VALUES (?, ?)", versionKey, strconv.Itoa(version))
 		if err != nil {
-			return herror.Internal(err, "")
+			return false, herror.Internal(err, "")
 		}
-		return nil
+		return false, nil
 	}
 	// DB has a version, make sure it's the current version
 	dbVersionInt, err := strconv.ParseInt(dbVersion, 10, 0)
+	if err == nil && dbVersionInt == 3 {
+		return true, s.migrateFrom3()
+	}
 	if err != nil || dbVersionInt != version {
-		return herror.Unlikely(nil, fmt.Sprintf("database version mismatch: expected %d, got %s", version, dbVersion), `
+		return false, herror.Unlikely(nil, fmt.Sprintf("database version mismatch: expected %d, got %s", version, dbVersion), `
 This database was likely produced by an incompatible version of Periscope. Either use a compatible version of Periscope, or delete the database (by running 'psc finish') and try again.
 		`)
 	}
 	// correct version
+	return false, nil
+}
+
+// Upgrades a database from version 3, the last released version. Tables added
+// since then are created by initSchema, but file_info has gained columns, and
+// its ids became AUTOINCREMENT, which can't be done by altering the table, so
+// it's rebuilt with its current schema. Files keep their hashes; their stat
+// metadata is unknown, so the next scan reads them again.
+func (s *Session) migrateFrom3() herror.Interface {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return herror.Internal(err, "")
+	}
+	for _, stmt := range []string{
+		"CREATE TABLE file_info_new " + fileInfoSchema,
+		`INSERT INTO file_info_new (id, directory, filename, size, short_hash, full_hash)
+		SELECT id, directory, filename, size, short_hash, full_hash FROM file_info`,
+		"DROP TABLE file_info",
+		"ALTER TABLE file_info_new RENAME TO file_info",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			tx.Rollback()
+			return herror.Internal(err, "")
+		}
+	}
+	if _, err := tx.Exec("UPDATE meta SET value = ? WHERE key = ?", strconv.Itoa(version), versionKey); err != nil {
+		tx.Rollback()
+		return herror.Internal(err, "")
+	}
+	if err := tx.Commit(); err != nil {
+		return herror.Internal(err, "")
+	}
 	return nil
 }
 
+// the columns of the file_info table, shared by initSchema and migrateFrom3
+//
+// ids are AUTOINCREMENT, so they're never reused, and the largest one is a
+// generation number; see Generation
+const fileInfoSchema = `
+	(
+		id                INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+		directory         INTEGER NOT NULL,
+		filename          TEXT NOT NULL,
+		size              INTEGER NOT NULL,
+		short_hash        BLOB NULL,
+		sample_hash       BLOB NULL,
+		full_hash         BLOB NULL,
+		image_hash        BLOB NULL,
+		pixel_hash        BLOB NULL,
+		text_hash         BLOB NULL,
+		fuzzy_hash        BLOB NULL,
+		decompressed_hash BLOB NULL,
+		decompressed_size INTEGER NOT NULL DEFAULT 0,
+		mtime             INTEGER NOT NULL DEFAULT 0,
+		ctime             INTEGER NOT NULL DEFAULT 0,
+		inode             INTEGER NOT NULL DEFAULT 0,
+		device            INTEGER NOT NULL DEFAULT 0,
+		FOREIGN KEY(directory) REFERENCES directory(id),
+		UNIQUE(directory, filename)
+	)
+`
+
 func (s *Session) initSchema() error {
 	// only called in New, so db is non-null
 	_, err := s.db.Exec(`
@@ -178,22 +307,143 @@ func (s *Session) initSchema() error {
 	if err != nil {
 		return err
 	}
+	_, err = s.db.Exec("CREATE TABLE IF NOT EXISTS file_info " + fileInfoSchema)
+	if err != nil {
+		return err
+	}
+	// content-defined chunks of files, computed by analyze
+	//
+	// files are re-added to file_info (with a new id) every time they're
+	// scanned, so chunks belong to a chunked_file instead, which is
+	// matched to a file by its path and stat metadata; chunks of files
+	// that have changed or been removed are cleaned up by
+	// DeleteOrphanedChunks
 	_, err = s.db.Exec(`
-	CREATE TABLE IF NOT EXISTS file_info
+	CREATE TABLE IF NOT EXISTS chunked_file
 	(
-		id         INTEGER PRIMARY KEY NOT NULL,
-		directory  INTEGER NOT NULL,
-		filename   TEXT NOT NULL,
+		id        INTEGER PRIMARY KEY NOT NULL,
+		directory INTEGER NOT NULL,
+		filename  TEXT NOT NULL,
+		size      INTEGER NOT NULL,
+		mtime     INTEGER NOT NULL,
+		ctime     INTEGER NOT NULL,
+		inode     INTEGER NOT NULL,
+		device    INTEGER NOT NULL,
+		UNIQUE(directory, filename)
+	)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+	CREATE TABLE IF NOT EXISTS chunk
+	(
+		file INTEGER NOT NULL,
+		size INTEGER NOT NULL,
+		hash BLOB NOT NULL,
+		FOREIGN KEY(file) REFERENCES chunked_file(id) ON DELETE CASCADE
+	)
+	`)
+	if err != nil {
+		return err
+	}
+	// files that rm moved to the trash, by their names in the trash
+	_, err = s.db.Exec(`
+	CREATE TABLE IF NOT EXISTS trash
+	(
+		name      TEXT PRIMARY KEY NOT NULL,
+		path      TEXT NOT NULL,
+		size      INTEGER NOT NULL,
+		full_hash BLOB NULL,
+		deleted   INTEGER NOT NULL,
+		journal   INTEGER NOT NULL DEFAULT 0
+	)
+	`)
+	if err != nil {
+		return err
+	}
+	// operations that removed files, and the files they removed, so they
+	// can be undone
+	_, err = s.db.Exec(`
+	CREATE TABLE IF NOT EXISTS operation
+	(
+		id      INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+		time    INTEGER NOT NULL,
+		command TEXT NOT NULL
+	)
+	`)
+	if err != nil {
+		return err
+	}
+	// archives whose members were hashed, so that unchanged archives
+	// aren't read again by every scan
+	_, err = s.db.Exec(`
+	CREATE TABLE IF NOT EXISTS archive
+	(
+		path       TEXT PRIMARY KEY NOT NULL,
+		size       INTEGER NOT NULL,
+		mtime      INTEGER NOT NULL,
+		ctime      INTEGER NOT NULL,
+		inode      INTEGER NOT NULL,
+		device     INTEGER NOT NULL,
+		minimum    INTEGER NOT NULL,
+		maximum    INTEGER NOT NULL,
+		members    INTEGER NOT NULL,
+		generation INTEGER NOT NULL
+	)
+	`)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+	CREATE TABLE IF NOT EXISTS journal
+	(
+		id         INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+		operation  INTEGER NOT NULL,
+		path       TEXT NOT NULL,
 		size       INTEGER NOT NULL,
-		short_hash BLOB NULL,
 		full_hash  BLOB NULL,
-		FOREIGN KEY(directory) REFERENCES directory(id),
-		UNIQUE(directory, filename)
+		mode       INTEGER NOT NULL,
+		mtime      INTEGER NOT NULL,
+		survivor   TEXT NOT NULL,
+		pixel_hash BLOB NULL,
+		FOREIGN KEY(operation) REFERENCES operation(id) ON DELETE CASCADE
 	)
 	`)
 	return err
 }
 
+// Returns the value associated with the given key in the metadata table, and
+// whether the key is present.
+func (s *Session) Meta(key string) (string, bool, herror.Interface) {
+	row, herr := s.queryRow("SELECT value FROM meta WHERE key = ?", key)
+	if herr != nil {
+		return "", false, herr
+	}
+	var value string
+	err := row.Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, herror.Internal(err, "")
+	}
+	return value, true, nil
+}
+
+func (s *Session) SetMeta(key, value string) herror.Interface {
+	if _, err := s.exec("REPLACE INTO meta (key, value) VALUES (?, ?)", key, value); err != nil {
+		return herror.Internal(err, "")
+	}
+	return nil
+}
+
+func (s *Session) DeleteMeta(key string) herror.Interface {
+	if _, err := s.exec("DELETE FROM meta WHERE key = ?", key); err != nil {
+		return herror.Internal(err, "")
+	}
+	return nil
+}
+
 func (s *Session) Begin() (*Session, herror.Interface) {
 	if s.tx != nil {
 		return nil, herror.Internal(nil, "cannot Begin(): already in a transaction")
@@ -356,6 +606,24 @@ func (s *Session) directoryIdToPath(id int64) (string, error) {
 	return path, nil
 }
 
+// An expression that identifies the underlying file for a row of file_info,
+// so that hardlinks to the same file have the same key. Rows where the inode is
+// not known are treated as distinct files.
+func fileKey(table string) string {
+	return fmt.Sprintf("CASE WHEN %[1]s.inode = 0 THEN 'id:' || %[1]s.id ELSE %[1]s.device || ':' || %[1]s.inode END", table)
+}
+
+// The columns that are selected by scanFileInfo, in order.
+const fileInfoColumns = "directory, filename, size, short_hash, sample_hash, full_hash, image_hash, pixel_hash, text_hash, fuzzy_hash, decompressed_hash, decompressed_size, mtime, ctime, inode, device"
+
+// Scans a row produced by selecting fileInfoColumns. The info's Path is not
+// set, because that requires resolving the directory id.
+// Columns selected after fileInfoColumns are scanned into extra.
+func scanFileInfo(rows *sql.Rows, dirid *int64, filename *string, info *FileInfo, extra ...interface{}) error {
+	dest := []interface{}{dirid, filename, &info.Size, &info.ShortHash, &info.SampleHash, &info.FullHash, &info.ImageHash, &info.PixelHash, &info.TextHash, &info.FuzzyHash, &info.DecompressedHash, &info.DecompressedSize, &info.Mtime, &info.Ctime, &info.Inode, &info.Device}
+	return rows.Scan(append(dest, extra...)...)
+}
+
 func (s *Session) Add(info FileInfo) herror.Interface {
 	dirname := filepath.Dir(info.Path)
 	filename := filepath.Base(info.Path)
@@ -364,9 +632,9 @@ func (s *Session) Add(info FileInfo) herror.Interface {
 		return herror.Internal(err, "")
 	}
 	if _, err := s.exec(`
-	REPLACE INTO file_info (directory, filename, size, short_hash, full_hash)
-	VALUES (?, ?, ?, ?, ?)
-	`, dirid, filename, info.Size, info.ShortHash, info.FullHash); err != nil {
+	REPLACE INTO file_info (directory, filename, size, short_hash, sample_hash, full_hash, image_hash, pixel_hash, text_hash, fuzzy_hash, decompressed_hash, decompressed_size, mtime, ctime, inode, device)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, dirid, filename, info.Size, info.ShortHash, info.SampleHash, info.FullHash, info.ImageHash, info.PixelHash, info.TextHash, info.FuzzyHash, info.DecompressedHash, info.DecompressedSize, info.Mtime, info.Ctime, info.Inode, info.Device); err != nil {
 		return herror.Internal(err, "")
 	}
 	return nil
@@ -376,7 +644,7 @@ func (s *Session) Add(info FileInfo) herror.Interface {
 // duplicates).
 func (s *Session) AllInfosC() (<-chan FileInfo, herror.Interface) {
 	rows, err := s.query(`
-	SELECT directory, filename, size, short_hash, full_hash
+	SELECT ` + fileInfoColumns + `
 	FROM file_info`)
 	if err != nil {
 		return nil, herror.Internal(err, "")
@@ -388,7 +656,7 @@ func (s *Session) AllInfosC() (<-chan FileInfo, herror.Interface) {
 			var dirid int64
 			var filename string
 			var info FileInfo
-			if err := rows.Scan(&dirid, &filename, &info.Size, &info.ShortHash, &info.FullHash); err != nil {
+			if err := scanFileInfo(rows, &dirid, &filename, &info); err != nil {
 				// similar issue as below in AllDuplicatesC: how to report this?
 				log.Printf("failure while scanning row: %s", err)
 				continue
@@ -433,6 +701,11 @@ func (s *Session) CreateIndexes() herror.Interface {
 	if err != nil {
 		return herror.Internal(err, "")
 	}
+	// for finding compressed files that may be copies of scanned files
+	_, err = s.exec("CREATE INDEX IF NOT EXISTS idx_decompressed_size ON file_info (decompressed_size)")
+	if err != nil {
+		return herror.Internal(err, "")
+	}
 	// for looking up files by directory/filename
 	_, err = s.exec("CREATE INDEX IF NOT EXISTS idx_directory_filename ON file_info (directory, filename)")
 	if err != nil {
@@ -452,14 +725,24 @@ func (s *Session) CreateIndexes() herror.Interface {
 	if err != nil {
 		return herror.Internal(err, "")
 	}
+	_, err = s.exec("CREATE INDEX IF NOT EXISTS idx_chunk_file ON chunk (file)")
+	if err != nil {
+		return herror.Internal(err, "")
+	}
+	// for counting the copies of each chunk
+	_, err = s.exec("CREATE INDEX IF NOT EXISTS idx_chunk_hash ON chunk (hash)")
+	if err != nil {
+		return herror.Internal(err, "")
+	}
 	return nil
 }
 
 // Returns all known duplicates in the database.
 //
 // These are necessarily FileInfos with the FullHash field filled out. Each
-// DuplicateSet that is returned always has > 1 element (i.e. it only includes
-// duplicates, not infos where we happen to know the full hash).
+// DuplicateSet that is returned always has > 1 distinct file (i.e. it only
+// includes duplicates, not infos where we happen to know the full hash, or
+// hardlinks to a single file, which have no space to reclaim).
 //
 // path is optional; if "", then all duplicates are returned, otherwise only
 // ones with the given directory prefix
@@ -479,7 +762,7 @@ func (s *Session) AllDuplicatesC(path string) (<-chan DuplicateSet, herror.Inter
 	var rows *sql.Rows
 	if dirid == -1 {
 		rows, err = s.query(`
-		SELECT directory, filename, size, short_hash, full_hash
+		SELECT ` + fileInfoColumns + `
 		FROM file_info
 		WHERE full_hash IS NOT NULL
 		ORDER BY size DESC, full_hash`)
@@ -500,7 +783,7 @@ func (s *Session) AllDuplicatesC(path string) (<-chan DuplicateSet, herror.Inter
 		(
 			SELECT full_hash FROM file_info WHERE directory IN dirs AND full_hash IS NOT NULL
 		)
-		SELECT directory, filename, size, short_hash, full_hash
+		SELECT `+fileInfoColumns+`
 		FROM file_info
 		WHERE full_hash IN matching_hashes
 		ORDER BY size DESC, full_hash`, dirid)
@@ -516,7 +799,7 @@ func (s *Session) AllDuplicatesC(path string) (<-chan DuplicateSet, herror.Inter
 			var dirid int64
 			var filename string
 			var info FileInfo
-			if err := rows.Scan(&dirid, &filename, &info.Size, &info.ShortHash, &info.FullHash); err != nil {
+			if err := scanFileInfo(rows, &dirid, &filename, &info); err != nil {
 				// how should we handle this error that happens in its own goroutine?
 				// give up on this row?
 				log.Printf("failure while scanning row: %s", err)
@@ -529,7 +812,7 @@ func (s *Session) AllDuplicatesC(path string) (<-chan DuplicateSet, herror.Inter
 			}
 			info.Path = filepath.Join(dirname, filename)
 			if !bytes.Equal(info.FullHash, prevHash) {
-				if len(set) > 1 {
+				if set.Files() > 1 {
 					// note: set may have singletons, we don't remove info about files with single matches
 					sort.Sort(fileInfosOrdering(set))
 					results <- set
@@ -540,7 +823,7 @@ func (s *Session) AllDuplicatesC(path string) (<-chan DuplicateSet, herror.Inter
 			set = append(set, info)
 		}
 		// will usually be some infos left over, if the last file size/hash has duplicates
-		if len(set) > 1 {
+		if set.Files() > 1 {
 			sort.Sort(fileInfosOrdering(set))
 			results <- set
 		}
@@ -562,19 +845,29 @@ func (s *Session) AllDuplicates(path string) ([]DuplicateSet, herror.Interface)
 }
 
 func (s *Session) Summary() (InfoSummary, herror.Interface) {
-	row, err := s.queryRow("SELECT COUNT(*) FROM file_info")
+	row, err := s.queryRow(`
+	SELECT COUNT(*), COUNT(DISTINCT ` + fileKey("file_info") + `)
+	FROM file_info`)
 	if err != nil {
 		return InfoSummary{}, err
 	}
-	var files int64
-	if err := row.Scan(&files); err != nil {
+	var paths, files int64
+	if err := row.Scan(&paths, &files); err != nil {
 		return InfoSummary{}, herror.Internal(err, "")
 	}
+	// hardlinks to the same file are counted once, because deleting one of
+	// them does not free up any space
 	row, err = s.queryRow(`
-	WITH sets AS
+	WITH files AS
 	(
-		SELECT COUNT(*) AS cnt, size
+		SELECT MAX(full_hash) AS full_hash, size
 		FROM file_info
+		GROUP BY ` + fileKey("file_info") + `
+	),
+	sets AS
+	(
+		SELECT COUNT(*) AS cnt, size
+		FROM files
 		GROUP BY full_hash
 		HAVING COUNT(full_hash) > 1
 	)
@@ -590,9 +883,10 @@ func (s *Session) Summary() (InfoSummary, herror.Interface) {
 	}
 	duplicate := filesWithDuplicates.Int64 - uniqueWithDuplicates
 	return InfoSummary{
-		Files:     files,
+		Files:     paths,
 		Unique:    files - duplicate,
 		Duplicate: duplicate,
+		Hardlinks: paths - files,
 		Overhead:  overhead.Int64,
 	}, nil
 }
@@ -612,7 +906,7 @@ func (s *Session) Lookup(path string) (DuplicateSet, herror.Interface) {
 		return nil, herror.Internal(err, "")
 	}
 	row, herr := s.queryRow(`
-	SELECT id, size, short_hash, full_hash
+	SELECT id, size, short_hash, sample_hash, full_hash, image_hash, pixel_hash, text_hash, fuzzy_hash, decompressed_hash, decompressed_size, mtime, ctime, inode, device
 	FROM file_info
 	WHERE directory = ? AND filename = ?
 	`, dirid, filename)
@@ -621,7 +915,7 @@ func (s *Session) Lookup(path string) (DuplicateSet, herror.Interface) {
 	}
 	var id int
 	var info FileInfo
-	err = row.Scan(&id, &info.Size, &info.ShortHash, &info.FullHash)
+	err = row.Scan(&id, &info.Size, &info.ShortHash, &info.SampleHash, &info.FullHash, &info.ImageHash, &info.PixelHash, &info.TextHash, &info.FuzzyHash, &info.DecompressedHash, &info.DecompressedSize, &info.Mtime, &info.Ctime, &info.Inode, &info.Device)
 	if err == sql.ErrNoRows {
 		return set, nil // empty
 	} else if err != nil {
@@ -635,7 +929,7 @@ func (s *Session) Lookup(path string) (DuplicateSet, herror.Interface) {
 	}
 	// get all others
 	rows, err := s.query(`
-	SELECT directory, filename, size, short_hash, full_hash
+	SELECT `+fileInfoColumns+`
 	FROM file_info
 	WHERE full_hash = ? AND id != ?`, info.FullHash, id)
 	if err != nil {
@@ -644,7 +938,7 @@ func (s *Session) Lookup(path string) (DuplicateSet, herror.Interface) {
 	defer rows.Close()
 	for rows.Next() {
 		var info FileInfo
-		if err := rows.Scan(&dirid, &filename, &info.Size, &info.ShortHash, &info.FullHash); err != nil {
+		if err := scanFileInfo(rows, &dirid, &filename, &info); err != nil {
 			return nil, herror.Internal(err, "")
 		}
 		dirname, err := s.directoryIdToPath(dirid)
@@ -664,7 +958,7 @@ func (s *Session) Lookup(path string) (DuplicateSet, herror.Interface) {
 // This includes all infos, even ones where the short hash or full hash is not known.
 func (s *Session) InfosBySize(size int64) ([]FileInfo, herror.Interface) {
 	rows, err := s.query(`
-	SELECT directory, filename, size, short_hash, full_hash
+	SELECT `+fileInfoColumns+`
 	FROM file_info
 	WHERE size = ?
 	`, size)
@@ -677,7 +971,28 @@ func (s *Session) InfosBySize(size int64) ([]FileInfo, herror.Interface) {
 		var dirid int64
 		var filename string
 		var info FileInfo
-		if err := rows.Scan(&dirid, &filename, &info.Size, &info.ShortHash, &info.FullHash); err != nil {
+		if err := scanFileInfo(rows, &dirid, &filename, &info); err != nil {
+			return nil, herror.Internal(err, "")
+		}
+		dirname, err := s.directoryIdToPath(dirid)
+		if err != nil {
+			return nil, herror.Internal(err, "")
+		}
+		info.Path = filepath.Join(dirname, filename)
+		results = append(results, info)
+	}
+	return results, nil
+}
+
+// Reads all rows produced by selecting fileInfoColumns, resolving their paths.
+func (s *Session) readFileInfos(rows *sql.Rows) ([]FileInfo, herror.Interface) {
+	defer rows.Close()
+	var results []FileInfo
+	for rows.Next() {
+		var dirid int64
+		var filename string
+		var info FileInfo
+		if err := scanFileInfo(rows, &dirid, &filename, &info); err != nil {
 			return nil, herror.Internal(err, "")
 		}
 		dirname, err := s.directoryIdToPath(dirid)
@@ -687,9 +1002,273 @@ func (s *Session) InfosBySize(size int64) ([]FileInfo, herror.Interface) {
 		info.Path = filepath.Join(dirname, filename)
 		results = append(results, info)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, herror.Internal(err, "")
+	}
 	return results, nil
 }
 
+// Returns all the infos of compressed files whose decompressed contents have
+// the given size.
+func (s *Session) InfosByDecompressedSize(size int64) ([]FileInfo, herror.Interface) {
+	rows, err := s.query(`
+	SELECT `+fileInfoColumns+`
+	FROM file_info
+	WHERE decompressed_size = ? AND decompressed_hash IS NOT NULL
+	`, size)
+	if err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	return s.readFileInfos(rows)
+}
+
+// Returns all infos that have an image hash.
+func (s *Session) ImageInfos() ([]FileInfo, herror.Interface) {
+	rows, err := s.query(`
+	SELECT ` + fileInfoColumns + `
+	FROM file_info
+	WHERE image_hash IS NOT NULL
+	`)
+	if err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	results, herr := s.readFileInfos(rows)
+	if herr != nil {
+		return nil, herr
+	}
+	sort.Sort(fileInfosOrdering(results))
+	return results, nil
+}
+
+// Returns the infos of the files directly in the given directory (not in its
+// subdirectories), largest first.
+func (s *Session) DirectoryInfos(path string) ([]FileInfo, herror.Interface) {
+	dirid, err := s.pathToDirectoryId(path, false)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	rows, err := s.query(`
+	SELECT `+fileInfoColumns+`
+	FROM file_info
+	WHERE directory = ?
+	`, dirid)
+	if err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	results, herr := s.readFileInfos(rows)
+	if herr != nil {
+		return nil, herr
+	}
+	sort.Sort(fileInfosOrdering(results))
+	return results, nil
+}
+
+// Returns the infos of all files under the given directory, including the ones
+// in its subdirectories, sorted by path.
+func (s *Session) InfosUnder(path string) ([]FileInfo, herror.Interface) {
+	dirid, err := s.pathToDirectoryId(path, false)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	rows, err := s.query(`
+	WITH dirs AS
+	(
+		WITH RECURSIVE sub_directory (id, parent) AS (
+			SELECT id, parent FROM directory WHERE id = ?
+			UNION ALL
+			SELECT d.id, d.parent
+			FROM directory d, sub_directory sd
+			WHERE d.parent = sd.id
+		)
+		SELECT id FROM sub_directory
+	)
+	SELECT `+fileInfoColumns+`
+	FROM file_info
+	WHERE directory IN dirs
+	`, dirid)
+	if err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	results, herr := s.readFileInfos(rows)
+	if herr != nil {
+		return nil, herr
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+	return results, nil
+}
+
+// Returns all infos that have an fuzzy hash.
+func (s *Session) FuzzyInfos() ([]FileInfo, herror.Interface) {
+	rows, err := s.query(`
+	SELECT ` + fileInfoColumns + `
+	FROM file_info
+	WHERE fuzzy_hash IS NOT NULL
+	`)
+	if err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	results, herr := s.readFileInfos(rows)
+	if herr != nil {
+		return nil, herr
+	}
+	sort.Sort(fileInfosOrdering(results))
+	return results, nil
+}
+
+// Kinds of equivalence between files that are not necessarily byte-for-byte
+// duplicates, each based on a hash of some normalized form of a file.
+type Equivalence int
+
+const (
+	// images with the same decoded pixels
+	SamePixels Equivalence = iota
+	// text files that are the same after normalizing line endings, a
+	// byte order mark, and a trailing newline
+	SameText
+	// files with the same contents once compressed files are decompressed
+	SameDecompressed
+)
+
+// the SQL expression for the hash that equivalent files share
+func (e Equivalence) expr() string {
+	switch e {
+	case SamePixels:
+		return "pixel_hash"
+	case SameText:
+		return "text_hash"
+	case SameDecompressed:
+		return "COALESCE(decompressed_hash, full_hash)"
+	}
+	panic("unknown equivalence")
+}
+
+// an aggregate condition that a set of equivalent files must satisfy to be
+// interesting
+func (e Equivalence) having() string {
+	switch e {
+	case SameDecompressed:
+		// otherwise, it's an ordinary set of duplicates
+		return "COUNT(*) > 1 AND COUNT(decompressed_hash) > 0"
+	}
+	return "COUNT(*) > 1"
+}
+
+func (e Equivalence) hash(info *FileInfo) []byte {
+	switch e {
+	case SamePixels:
+		return info.PixelHash
+	case SameText:
+		return info.TextHash
+	case SameDecompressed:
+		if info.DecompressedHash != nil {
+			return info.DecompressedHash
+		}
+		return info.FullHash
+	}
+	panic("unknown equivalence")
+}
+
+// Returns info for everything equivalent to the given file.
+//
+// Like Lookup, but matches on the hash for the given kind of equivalence
+// rather than full hashes. Returns [] if there isn't a matching file in the
+// database. If the file exists in the database, that file is returned first.
+func (s *Session) LookupEquivalent(e Equivalence, path string) (DuplicateSet, herror.Interface) {
+	set, herr := s.Lookup(path)
+	if herr != nil || len(set) == 0 {
+		return nil, herr
+	}
+	info := set[0]
+	if e.hash(&info) == nil {
+		return DuplicateSet{info}, nil
+	}
+	rows, err := s.query(`
+	SELECT `+fileInfoColumns+`
+	FROM file_info
+	WHERE `+e.expr()+` = ?
+	`, e.hash(&info))
+	if err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	others, herr := s.readFileInfos(rows)
+	if herr != nil {
+		return nil, herr
+	}
+	set = DuplicateSet{info}
+	for _, other := range others {
+		if other.Path != info.Path {
+			set = append(set, other)
+		}
+	}
+	sort.Sort(fileInfosOrdering(set[1:]))
+	return set, nil
+}
+
+// Returns all sets of equivalent infos, of the given kind of equivalence.
+//
+// Sets are sorted like AllDuplicates, largest first. The files in a set need
+// not be different; some of them may also be duplicates of each other.
+//
+// path is optional; if "", then all sets are returned, otherwise only ones
+// where at least one file is under the given directory
+func (s *Session) AllEquivalent(e Equivalence, path string) ([]DuplicateSet, herror.Interface) {
+	expr := e.expr()
+	rows, err := s.query(`
+	SELECT ` + fileInfoColumns + `
+	FROM file_info
+	WHERE ` + expr + ` IN
+	(
+		SELECT ` + expr + `
+		FROM file_info
+		WHERE ` + expr + ` IS NOT NULL
+		GROUP BY ` + expr + `
+		HAVING ` + e.having() + `
+	)
+	`)
+	if err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	infos, herr := s.readFileInfos(rows)
+	if herr != nil {
+		return nil, herr
+	}
+	byHash := make(map[string]DuplicateSet)
+	for _, info := range infos {
+		hash := string(e.hash(&info))
+		byHash[hash] = append(byHash[hash], info)
+	}
+	prefix := path
+	if prefix != "" && prefix[len(prefix)-1] != filepath.Separator {
+		prefix += string(filepath.Separator)
+	}
+	var sets []DuplicateSet
+	for _, set := range byHash {
+		contained := path == ""
+		for _, info := range set {
+			if strings.HasPrefix(info.Path, prefix) {
+				contained = true
+				break
+			}
+		}
+		if contained {
+			sort.Sort(fileInfosOrdering(set))
+			sets = append(sets, set)
+		}
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		a, b := sets[i][0], sets[j][0]
+		if a.Size != b.Size {
+			return a.Size > b.Size
+		}
+		return a.Path < b.Path
+	})
+	return sets, nil
+}
+
 // Returns all duplicate sets (size > 1) where at least one file is contained under the given path.
 func (s *Session) LookupAllC(path string, includeHidden bool) (<-chan DuplicateInfo, herror.Interface) {
 	results := make(chan DuplicateInfo)
@@ -714,7 +1293,7 @@ func (s *Session) LookupAllC(path string, includeHidden bool) (<-chan DuplicateI
 			)
 			SELECT id FROM sub_directory
 		)
-		SELECT a.directory, a.filename, a.full_hash, COUNT(b.id)
+		SELECT a.directory, a.filename, a.full_hash, COUNT(DISTINCT `+fileKey("b")+`)
 		FROM file_info a, file_info b
 		WHERE a.full_hash IS NOT NULL
 			AND a.full_hash = b.full_hash
@@ -735,7 +1314,7 @@ func (s *Session) LookupAllC(path string, includeHidden bool) (<-chan DuplicateI
 			)
 			SELECT id FROM sub_directory
 		)
-		SELECT a.directory, a.filename, a.full_hash, COUNT(b.id)
+		SELECT a.directory, a.filename, a.full_hash, COUNT(DISTINCT `+fileKey("b")+`)
 		FROM file_info a, file_info b
 		WHERE a.full_hash IS NOT NULL
 			AND a.full_hash = b.full_hash
@@ -786,6 +1365,96 @@ func (s *Session) LookupAll(path string, includeHidden bool) ([]DuplicateInfo, h
 	return r, nil
 }
 
+// A file, and whether it has a copy in the place it was checked against.
+type ContainedInfo struct {
+	Info      FileInfo
+	Contained bool
+}
+
+// Returns all files with the given directory prefix, and for each one, whether
+// there's a file with the same contents outside the directory; if by is not
+// "", the copy must also have by as a directory prefix. Results are sorted by
+// path.
+//
+// Files without a full hash (those with a unique size) never have a copy.
+func (s *Session) ContainedInfos(dir, by string) ([]ContainedInfo, herror.Interface) {
+	dirid, err := s.pathToDirectoryId(dir, false)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	byid := int64(-1)
+	inBy := ""
+	if by != "" {
+		byid, err = s.pathToDirectoryId(by, false)
+		if err == sql.ErrNoRows {
+			byid = -1 // nothing is under by, so there are no copies
+		} else if err != nil {
+			return nil, herror.Internal(err, "")
+		}
+		inBy = "AND b.directory IN by_dirs"
+	}
+	rows, err := s.query(`
+	WITH dirs AS
+	(
+		WITH RECURSIVE sub_directory (id, parent) AS (
+			SELECT id, parent FROM directory WHERE id = ?
+			UNION ALL
+			SELECT d.id, d.parent
+			FROM directory d, sub_directory sd
+			WHERE d.parent = sd.id
+		)
+		SELECT id FROM sub_directory
+	),
+	by_dirs AS
+	(
+		WITH RECURSIVE sub_directory (id, parent) AS (
+			SELECT id, parent FROM directory WHERE id = ?
+			UNION ALL
+			SELECT d.id, d.parent
+			FROM directory d, sub_directory sd
+			WHERE d.parent = sd.id
+		)
+		SELECT id FROM sub_directory
+	)
+	SELECT `+fileInfoColumns+`, EXISTS
+	(
+		SELECT 1
+		FROM file_info b
+		WHERE b.full_hash = a.full_hash
+			AND b.directory NOT IN dirs
+			`+inBy+`
+	)
+	FROM file_info a
+	WHERE a.directory IN dirs
+	`, dirid, byid)
+	if err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	defer rows.Close()
+	var results []ContainedInfo
+	for rows.Next() {
+		var dirid int64
+		var filename string
+		var result ContainedInfo
+		if err := scanFileInfo(rows, &dirid, &filename, &result.Info, &result.Contained); err != nil {
+			return nil, herror.Internal(err, "")
+		}
+		dirname, err := s.directoryIdToPath(dirid)
+		if err != nil {
+			return nil, herror.Internal(err, "")
+		}
+		result.Info.Path = filepath.Join(dirname, filename)
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Info.Path < results[j].Info.Path })
+	return results, nil
+}
+
 // Deletes a file with the given path from the database.
 func (s *Session) Remove(path string) herror.Interface {
 	dirname := filepath.Dir(path)
@@ -815,6 +1484,37 @@ func (s *Session) Remove(path string) herror.Interface {
 // names (or other directory names) where the prefix is common, e.g. deleting
 // "/a" won't delete file "/aa" or contents under a directory "/aa".
 func (s *Session) RemoveDir(dir string, min, max int64) herror.Interface {
+	return s.removeDir(dir, min, max, math.MaxInt64)
+}
+
+// Returns a generation number for the database contents. Files that are added
+// (or re-added) after this call are part of a later generation.
+func (s *Session) Generation() (int64, herror.Interface) {
+	row, herr := s.queryRow("SELECT seq FROM sqlite_sequence WHERE name = 'file_info'")
+	if herr != nil {
+		return 0, herr
+	}
+	var generation int64
+	err := row.Scan(&generation)
+	if err == sql.ErrNoRows {
+		return 0, nil // no files were ever added
+	} else if err != nil {
+		return 0, herror.Internal(err, "")
+	}
+	return generation, nil
+}
+
+// Like RemoveDir, but only deletes files that were last added in the given
+// generation or earlier.
+//
+// This is used to clean up after a scan, where all files that are found are
+// re-added: anything in the scanned directory from an earlier generation no
+// longer exists.
+func (s *Session) RemoveDirOlder(dir string, min, max int64, generation int64) herror.Interface {
+	return s.removeDir(dir, min, max, generation)
+}
+
+func (s *Session) removeDir(dir string, min, max int64, generation int64) herror.Interface {
 	if max <= 0 {
 		max = math.MaxInt64
 	}
@@ -840,7 +1540,8 @@ func (s *Session) RemoveDir(dir string, min, max int64) herror.Interface {
 		)
 		DELETE FROM file_info
 		WHERE directory IN dirs
-		`, dirid)
+			AND id <= ?
+		`, dirid, generation)
 	} else {
 		_, err = s.exec(`
 		WITH dirs AS
@@ -858,7 +1559,8 @@ func (s *Session) RemoveDir(dir string, min, max int64) herror.Interface {
 		WHERE directory IN dirs
 			AND size > ?
 			AND size <= ?
-		`, dirid, min, max)
+			AND id <= ?
+		`, dirid, min, max, generation)
 	}
 	if err != nil {
 		return herror.Internal(err, "")
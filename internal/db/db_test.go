@@ -1,6 +1,7 @@
 package db
 
 import (
+	"database/sql"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -75,13 +76,83 @@ func TestVersionMismatch(t *testing.T) {
 	}
 }
 
+func TestMigrateFrom3(t *testing.T) {
+	dir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dbPath := filepath.Join(dir, "db.sqlite")
+	old, err := sql.Open("sqlite3", dbPath)
+	check(t, err)
+	for _, stmt := range []string{
+		"CREATE TABLE meta (key TEXT UNIQUE NOT NULL, value BLOB NOT NULL)",
+		`INSERT INTO meta (key, value) VALUES ("version", "3")`,
+		`CREATE TABLE directory
+		(
+			id     INTEGER PRIMARY KEY NOT NULL,
+			name   TEXT NOT NULL,
+			parent INTEGER NULL,
+			FOREIGN KEY(parent) REFERENCES directory(id),
+			UNIQUE(name, parent)
+		)`,
+		`CREATE TABLE file_info
+		(
+			id         INTEGER PRIMARY KEY NOT NULL,
+			directory  INTEGER NOT NULL,
+			filename   TEXT NOT NULL,
+			size       INTEGER NOT NULL,
+			short_hash BLOB NULL,
+			full_hash  BLOB NULL,
+			FOREIGN KEY(directory) REFERENCES directory(id),
+			UNIQUE(directory, filename)
+		)`,
+		`INSERT INTO directory (id, name, parent) VALUES (1, "/", NULL), (2, "a", 1)`,
+		`INSERT INTO file_info (id, directory, filename, size, short_hash, full_hash) VALUES
+			(1, 2, "x", 1000, x'01', x'02'),
+			(2, 2, "y", 1000, x'01', x'02')`,
+	} {
+		_, err = old.Exec(stmt)
+		check(t, err)
+	}
+	old.Close()
+
+	db, err := New(dbPath, true)
+	check(t, err)
+	var dbVersion string
+	err = db.db.QueryRow(`SELECT value FROM meta WHERE key = "version"`).Scan(&dbVersion)
+	check(t, err)
+	if dbVersion != "4" {
+		t.Fatalf("expected version 4, got %s", dbVersion)
+	}
+	// files keep their hashes, with unknown stat metadata
+	expected := []FileInfo{
+		{Path: "/a/x", Size: 1000, ShortHash: []byte{1}, FullHash: []byte{2}},
+		{Path: "/a/y", Size: 1000, ShortHash: []byte{1}, FullHash: []byte{2}},
+	}
+	infos, err := db.InfosBySize(1000)
+	check(t, err)
+	if !reflect.DeepEqual(infos, expected) {
+		t.Fatalf("expected %v, got %v", expected, infos)
+	}
+	// ids aren't reused after the migration
+	generation, err := db.Generation()
+	check(t, err)
+	check(t, db.Add(FileInfo{Path: "/a/z", Size: 10}))
+	later, err := db.Generation()
+	check(t, err)
+	if generation < 2 || later <= generation {
+		t.Fatalf("expected generation to increase from at least 2, got %d then %d", generation, later)
+	}
+}
+
 func TestAdd(t *testing.T) {
 	db := newInMemoryDb(t)
 	expected := []FileInfo{
-		{"/a/x", 1000, []byte("asdf"), []byte("asdfasdf")},
-		{"/b/x", 1000, []byte("asdf"), []byte("asdfasdf")},
-		{"/c/y", 33, []byte("xxxx"), nil},
-		{"/d/z", 2, nil, nil},
+		{Path: "/a/x", Size: 1000, ShortHash: []byte("asdf"), FullHash: []byte("asdfasdf")},
+		{Path: "/b/x", Size: 1000, ShortHash: []byte("asdf"), FullHash: []byte("asdfasdf")},
+		{Path: "/c/y", Size: 33, ShortHash: []byte("xxxx"), FullHash: nil},
+		{Path: "/d/z", Size: 2, ShortHash: nil, FullHash: nil},
 	}
 	db.Add(expected[0])
 	db.Add(expected[1])
@@ -100,13 +171,13 @@ func TestAdd(t *testing.T) {
 
 func TestAddOverwrite(t *testing.T) {
 	db := newInMemoryDb(t)
-	db.Add(FileInfo{"/a", 1000, nil, nil})
-	db.Add(FileInfo{"/a", 1234, nil, nil})
+	db.Add(FileInfo{Path: "/a", Size: 1000, ShortHash: nil, FullHash: nil})
+	db.Add(FileInfo{Path: "/a", Size: 1234, ShortHash: nil, FullHash: nil})
 	got, _ := db.AllInfos()
 	if len(got) != 1 {
 		t.Fatal("expected 1 infos")
 	}
-	expected := FileInfo{"/a", 1234, []byte("asdf"), []byte("asdfasdf")}
+	expected := FileInfo{Path: "/a", Size: 1234, ShortHash: []byte("asdf"), FullHash: []byte("asdfasdf")}
 	db.Add(expected)
 	got, _ = db.AllInfos()
 	if len(got) != 1 {
@@ -117,13 +188,50 @@ func TestAddOverwrite(t *testing.T) {
 	}
 }
 
+func TestAddStatMetadata(t *testing.T) {
+	db := newInMemoryDb(t)
+	expected := FileInfo{
+		Path:      "/a",
+		Size:      1000,
+		ShortHash: []byte("asdf"),
+		FullHash:  []byte("asdfasdf"),
+		Mtime:     1600000000123456789,
+		Ctime:     1600000001987654321,
+		Inode:     1234,
+		Device:    56,
+	}
+	check(t, db.Add(expected))
+	got, err := db.InfosBySize(1000)
+	check(t, err)
+	if len(got) != 1 || !reflect.DeepEqual(expected, got[0]) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestAddSampleHash(t *testing.T) {
+	db := newInMemoryDb(t)
+	expected := FileInfo{
+		Path:       "/a",
+		Size:       1000,
+		ShortHash:  []byte("asdf"),
+		SampleHash: []byte("qwer"),
+		FullHash:   []byte("asdfasdf"),
+	}
+	check(t, db.Add(expected))
+	got, err := db.Lookup("/a")
+	check(t, err)
+	if len(got) != 1 || !reflect.DeepEqual(expected, got[0]) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
+
 func TestAddTransaction(t *testing.T) {
 	db := newInMemoryDb(t)
 	expected := []FileInfo{
-		{"/a/x", 1000, []byte("asdf"), []byte("asdfasdf")},
-		{"/b/x", 1000, []byte("asdf"), []byte("asdfasdf")},
-		{"/c/y", 33, []byte("xxxx"), nil},
-		{"/d/z", 2, nil, nil},
+		{Path: "/a/x", Size: 1000, ShortHash: []byte("asdf"), FullHash: []byte("asdfasdf")},
+		{Path: "/b/x", Size: 1000, ShortHash: []byte("asdf"), FullHash: []byte("asdfasdf")},
+		{Path: "/c/y", Size: 33, ShortHash: []byte("xxxx"), FullHash: nil},
+		{Path: "/d/z", Size: 2, ShortHash: nil, FullHash: nil},
 	}
 	tx, _ := db.Begin()
 	tx.Add(expected[0])
@@ -145,11 +253,11 @@ func TestAddTransaction(t *testing.T) {
 func TestSummary(t *testing.T) {
 	db := newInMemoryDb(t)
 	err := addAll(db, []FileInfo{
-		{"/a/c", 1000, []byte("a"), []byte("aa")},
-		{"/x/c", 1000, []byte("a"), []byte("aa")},
-		{"/y/c", 1000, []byte("a"), []byte("aa")},
-		{"/a/b", 2000, []byte("b"), []byte("bb")},
-		{"/x/b", 2000, []byte("b"), []byte("bb")},
+		{Path: "/a/c", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa")},
+		{Path: "/x/c", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa")},
+		{Path: "/y/c", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa")},
+		{Path: "/a/b", Size: 2000, ShortHash: []byte("b"), FullHash: []byte("bb")},
+		{Path: "/x/b", Size: 2000, ShortHash: []byte("b"), FullHash: []byte("bb")},
 	})
 	check(t, err)
 	expected := InfoSummary{
@@ -168,10 +276,10 @@ func TestSummary(t *testing.T) {
 func TestSummaryNonDuplicate(t *testing.T) {
 	db := newInMemoryDb(t)
 	err := addAll(db, []FileInfo{
-		{"/a/c", 1000, []byte("a"), []byte("aa")},
-		{"/x/c", 1000, []byte("a"), []byte("aa")},
-		{"/y/c", 1000, []byte("a"), []byte("aa")},
-		{"/a/b", 2000, []byte("b"), []byte("bb")}, // has full hash, but no duplicate
+		{Path: "/a/c", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa")},
+		{Path: "/x/c", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa")},
+		{Path: "/y/c", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa")},
+		{Path: "/a/b", Size: 2000, ShortHash: []byte("b"), FullHash: []byte("bb")}, // has full hash, but no duplicate
 	})
 	check(t, err)
 	expected := InfoSummary{
@@ -190,9 +298,9 @@ func TestSummaryNonDuplicate(t *testing.T) {
 func TestSummaryMissingFullHash(t *testing.T) {
 	db := newInMemoryDb(t)
 	err := addAll(db, []FileInfo{
-		{"/a/c", 1000, []byte("a"), []byte("aa")},
-		{"/x/c", 1000, []byte("a"), []byte("aa")},
-		{"/y/c", 1000, []byte("b"), nil},
+		{Path: "/a/c", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa")},
+		{Path: "/x/c", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa")},
+		{Path: "/y/c", Size: 1000, ShortHash: []byte("b"), FullHash: nil},
 	})
 	check(t, err)
 	expected := InfoSummary{
@@ -208,13 +316,55 @@ func TestSummaryMissingFullHash(t *testing.T) {
 	}
 }
 
+func TestSummaryHardlinks(t *testing.T) {
+	db := newInMemoryDb(t)
+	err := addAll(db, []FileInfo{
+		{Path: "/a/c", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa"), Inode: 1, Device: 1},
+		{Path: "/x/c", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa"), Inode: 1, Device: 1},
+		{Path: "/y/c", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa"), Inode: 2, Device: 1},
+		{Path: "/a/b", Size: 2000, ShortHash: []byte("b"), FullHash: []byte("bb"), Inode: 3, Device: 1},
+		{Path: "/x/b", Size: 2000, ShortHash: []byte("b"), FullHash: []byte("bb"), Inode: 3, Device: 1},
+		{Path: "/z/b", Size: 2000, ShortHash: []byte("b"), FullHash: []byte("bb"), Inode: 3, Device: 2},
+	})
+	check(t, err)
+	expected := InfoSummary{
+		Files:     6,
+		Unique:    2,
+		Duplicate: 2,
+		Hardlinks: 2,
+		Overhead:  1000 + 2000,
+	}
+	got, err := db.Summary()
+	check(t, err)
+	if !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestDuplicateSetFiles(t *testing.T) {
+	set := DuplicateSet{
+		{Path: "/a", Inode: 1, Device: 1},
+		{Path: "/b", Inode: 2, Device: 1},
+		{Path: "/c", Inode: 1, Device: 1},
+		{Path: "/d", Inode: 1, Device: 2},
+		{Path: "/e"},
+		{Path: "/f"},
+	}
+	if set.Files() != 5 {
+		t.Fatalf("expected 5 files, got %d", set.Files())
+	}
+	if !set[0].SameFile(&set[2]) || set[0].SameFile(&set[3]) || set[4].SameFile(&set[5]) {
+		t.Fatal("SameFile returned unexpected result")
+	}
+}
+
 func TestAllDuplicates(t *testing.T) {
 	db := newInMemoryDb(t)
 	infos := []FileInfo{
-		{"/a/x", 1000, []byte("asdf"), []byte("asdfasdf")},
-		{"/b/x", 1000, []byte("asdf"), []byte("asdfasdf")},
-		{"/c/y", 33, []byte("xxxx"), nil},
-		{"/d/z", 2, nil, nil},
+		{Path: "/a/x", Size: 1000, ShortHash: []byte("asdf"), FullHash: []byte("asdfasdf")},
+		{Path: "/b/x", Size: 1000, ShortHash: []byte("asdf"), FullHash: []byte("asdfasdf")},
+		{Path: "/c/y", Size: 33, ShortHash: []byte("xxxx"), FullHash: nil},
+		{Path: "/d/z", Size: 2, ShortHash: nil, FullHash: nil},
 	}
 	err := addAll(db, infos)
 	check(t, err)
@@ -238,11 +388,11 @@ func TestAllDuplicates(t *testing.T) {
 func TestLookup(t *testing.T) {
 	db := newInMemoryDb(t)
 	infos := []FileInfo{
-		{"/a", 133, []byte("a"), []byte("aa")},
-		{"/b", 133, []byte("a"), []byte("aa")},
-		{"/x", 1234, []byte("a"), []byte("fff")},
-		{"/y", 1337, nil, nil},
-		{"/z", 1338, nil, nil},
+		{Path: "/a", Size: 133, ShortHash: []byte("a"), FullHash: []byte("aa")},
+		{Path: "/b", Size: 133, ShortHash: []byte("a"), FullHash: []byte("aa")},
+		{Path: "/x", Size: 1234, ShortHash: []byte("a"), FullHash: []byte("fff")},
+		{Path: "/y", Size: 1337, ShortHash: nil, FullHash: nil},
+		{Path: "/z", Size: 1338, ShortHash: nil, FullHash: nil},
 	}
 	check(t, addAll(db, infos))
 	got, err := db.Lookup("/a")
@@ -282,15 +432,15 @@ func TestLookup(t *testing.T) {
 func TestInfosBySize(t *testing.T) {
 	db := newInMemoryDb(t)
 	infos := []FileInfo{
-		{"/a", 133, []byte("a"), []byte("aa")},
-		{"/x", 1234, []byte("a"), []byte("fff")},
-		{"/y", 1337, nil, nil},
-		{"/z", 1338, nil, nil},
+		{Path: "/a", Size: 133, ShortHash: []byte("a"), FullHash: []byte("aa")},
+		{Path: "/x", Size: 1234, ShortHash: []byte("a"), FullHash: []byte("fff")},
+		{Path: "/y", Size: 1337, ShortHash: nil, FullHash: nil},
+		{Path: "/z", Size: 1338, ShortHash: nil, FullHash: nil},
 	}
 	check(t, addAll(db, infos))
 	got, err := db.InfosBySize(1234)
 	check(t, err)
-	expected := []FileInfo{{"/x", 1234, []byte("a"), []byte("fff")}}
+	expected := []FileInfo{{Path: "/x", Size: 1234, ShortHash: []byte("a"), FullHash: []byte("fff")}}
 	if !reflect.DeepEqual(expected, got) {
 		t.Fatalf("expected %v, got %v", expected, got)
 	}
@@ -299,19 +449,19 @@ func TestInfosBySize(t *testing.T) {
 func TestLookupAll(t *testing.T) {
 	db := newInMemoryDb(t)
 	err := addAll(db, []FileInfo{
-		{"/x/y/a", 1000, []byte("a"), []byte("aa")},
-		{"/x/z/a", 1000, []byte("a"), []byte("aa")},
-		{"/x/y/b", 1000, []byte("b"), []byte("bb")},
-		{"/x/z/b", 1000, []byte("b"), []byte("bb")},
-		{"/z/.c", 1000, []byte("c"), []byte("cc")},
-		{"/y/.c", 1000, []byte("c"), []byte("cc")},
-		{"/z/.d/e", 1000, []byte("d"), []byte("dd")},
-		{"/y/.d/e", 1000, []byte("d"), []byte("dd")},
-		{"/w/x/.a", 1000, []byte("e"), []byte("ee")},
-		{"/w/x/.b", 1000, []byte("e"), []byte("ee")},
-		{"/x/x", 1234, []byte("x"), []byte("xx")},
-		{"/x/foo", 1000, []byte("f"), nil},
-		{"/y/bar", 1000, nil, nil},
+		{Path: "/x/y/a", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa")},
+		{Path: "/x/z/a", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa")},
+		{Path: "/x/y/b", Size: 1000, ShortHash: []byte("b"), FullHash: []byte("bb")},
+		{Path: "/x/z/b", Size: 1000, ShortHash: []byte("b"), FullHash: []byte("bb")},
+		{Path: "/z/.c", Size: 1000, ShortHash: []byte("c"), FullHash: []byte("cc")},
+		{Path: "/y/.c", Size: 1000, ShortHash: []byte("c"), FullHash: []byte("cc")},
+		{Path: "/z/.d/e", Size: 1000, ShortHash: []byte("d"), FullHash: []byte("dd")},
+		{Path: "/y/.d/e", Size: 1000, ShortHash: []byte("d"), FullHash: []byte("dd")},
+		{Path: "/w/x/.a", Size: 1000, ShortHash: []byte("e"), FullHash: []byte("ee")},
+		{Path: "/w/x/.b", Size: 1000, ShortHash: []byte("e"), FullHash: []byte("ee")},
+		{Path: "/x/x", Size: 1234, ShortHash: []byte("x"), FullHash: []byte("xx")},
+		{Path: "/x/foo", Size: 1000, ShortHash: []byte("f"), FullHash: nil},
+		{Path: "/y/bar", Size: 1000, ShortHash: nil, FullHash: nil},
 	})
 	check(t, err)
 
@@ -373,14 +523,34 @@ func TestLookupAll(t *testing.T) {
 	}
 }
 
+func TestLookupAllHardlinks(t *testing.T) {
+	db := newInMemoryDb(t)
+	err := addAll(db, []FileInfo{
+		{Path: "/x/a", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa"), Inode: 1, Device: 1},
+		{Path: "/y/a", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa"), Inode: 1, Device: 1},
+		{Path: "/x/b", Size: 1000, ShortHash: []byte("b"), FullHash: []byte("bb"), Inode: 2, Device: 1},
+		{Path: "/y/b", Size: 1000, ShortHash: []byte("b"), FullHash: []byte("bb"), Inode: 2, Device: 1},
+		{Path: "/z/b", Size: 1000, ShortHash: []byte("b"), FullHash: []byte("bb"), Inode: 3, Device: 1},
+	})
+	check(t, err)
+	expected := []DuplicateInfo{
+		{"/x/b", []byte("bb"), 2},
+	}
+	got, err := db.LookupAll("/x", false)
+	check(t, err)
+	if !reflect.DeepEqual(expected, got) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
+
 func TestRemove(t *testing.T) {
 	db := newInMemoryDb(t)
 	err := addAll(db, []FileInfo{
-		{"/x/y/a", 1000, []byte("a"), []byte("aa")},
-		{"/x/z/a", 1000, []byte("a"), []byte("aa")},
-		{"/x/y/b", 1000, []byte("b"), []byte("bb")},
-		{"/x/z/b", 1000, []byte("b"), []byte("bb")},
-		{"/z/.c", 1000, []byte("c"), []byte("cc")},
+		{Path: "/x/y/a", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa")},
+		{Path: "/x/z/a", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa")},
+		{Path: "/x/y/b", Size: 1000, ShortHash: []byte("b"), FullHash: []byte("bb")},
+		{Path: "/x/z/b", Size: 1000, ShortHash: []byte("b"), FullHash: []byte("bb")},
+		{Path: "/z/.c", Size: 1000, ShortHash: []byte("c"), FullHash: []byte("cc")},
 	})
 	check(t, err)
 	check(t, db.Remove("/x/y/a"))
@@ -398,12 +568,12 @@ func TestRemove(t *testing.T) {
 func TestRemoveDir(t *testing.T) {
 	db := newInMemoryDb(t)
 	addAll(db, []FileInfo{
-		{"/hello/x", 1000, []byte("a"), []byte("aa")},
-		{"/hello/y", 1000, []byte("a"), []byte("aa")},
-		{"/helloasdf", 1000, []byte("a"), []byte("aa")},
-		{"/goodbye/z", 1000, []byte("b"), []byte("bb")},
-		{"/goodbye/w", 1000, []byte("b"), []byte("bb")},
-		{"/goodbyeasdf", 1000, []byte("b"), []byte("bb")},
+		{Path: "/hello/x", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa")},
+		{Path: "/hello/y", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa")},
+		{Path: "/helloasdf", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa")},
+		{Path: "/goodbye/z", Size: 1000, ShortHash: []byte("b"), FullHash: []byte("bb")},
+		{Path: "/goodbye/w", Size: 1000, ShortHash: []byte("b"), FullHash: []byte("bb")},
+		{Path: "/goodbyeasdf", Size: 1000, ShortHash: []byte("b"), FullHash: []byte("bb")},
 	})
 	check(t, db.RemoveDir("/hello", 0, 0))
 	got, err := db.AllInfos()
@@ -419,11 +589,61 @@ func TestRemoveDir(t *testing.T) {
 	}
 }
 
+func TestRemoveDirOlder(t *testing.T) {
+	db := newInMemoryDb(t)
+	addAll(db, []FileInfo{
+		{Path: "/a/x", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa")},
+		{Path: "/a/y", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa")},
+		{Path: "/a/z", Size: 10, ShortHash: nil, FullHash: nil},
+		{Path: "/b/x", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa")},
+	})
+	generation, err := db.Generation()
+	check(t, err)
+	// re-adding the most recently added file must still result in a newer
+	// generation
+	check(t, db.Add(FileInfo{Path: "/b/x", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa")}))
+	check(t, db.Add(FileInfo{Path: "/a/y", Size: 1000, ShortHash: []byte("a"), FullHash: []byte("aa")}))
+	check(t, db.Add(FileInfo{Path: "/a/w", Size: 1000, ShortHash: nil, FullHash: nil}))
+	check(t, db.RemoveDirOlder("/a", 100, 0, generation))
+	got, err := db.AllInfos()
+	check(t, err)
+	var paths []string
+	for _, info := range got {
+		paths = append(paths, info.Path)
+	}
+	expected := []string{"/a/w", "/a/y", "/b/x", "/a/z"}
+	if !reflect.DeepEqual(expected, paths) {
+		t.Fatalf("expected %v, got %v", expected, paths)
+	}
+}
+
+func TestMeta(t *testing.T) {
+	db := newInMemoryDb(t)
+	_, ok, err := db.Meta("key")
+	check(t, err)
+	if ok {
+		t.Fatal("expected key to be missing")
+	}
+	check(t, db.SetMeta("key", "value"))
+	check(t, db.SetMeta("key", "value2"))
+	value, ok, err := db.Meta("key")
+	check(t, err)
+	if !ok || value != "value2" {
+		t.Fatalf("expected 'value2', got '%s'", value)
+	}
+	check(t, db.DeleteMeta("key"))
+	_, ok, err = db.Meta("key")
+	check(t, err)
+	if ok {
+		t.Fatal("expected key to be deleted")
+	}
+}
+
 func TestRollback(t *testing.T) {
 	db := newInMemoryDb(t)
 	infos := []FileInfo{
-		{"/a/x", 1000, []byte("asdf"), []byte("asdfasdf")},
-		{"/b/x", 1000, []byte("asdf"), []byte("asdfasdf")},
+		{Path: "/a/x", Size: 1000, ShortHash: []byte("asdf"), FullHash: []byte("asdfasdf")},
+		{Path: "/b/x", Size: 1000, ShortHash: []byte("asdf"), FullHash: []byte("asdfasdf")},
 	}
 	tx, err := db.Begin()
 	check(t, err)
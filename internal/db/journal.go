@@ -0,0 +1,138 @@
+package db
+
+import (
+	"github.com/anishathalye/periscope/internal/herror"
+)
+
+// A command that removed files, such as a single run of rm.
+type Operation struct {
+	ID int64
+	// when the operation started, in seconds since the epoch
+	Time    int64
+	Command string
+	// the number and total size of the files it removed that haven't been
+	// restored
+	Files int64
+	Size  int64
+}
+
+// A file that was removed, along with what's needed to recreate it from the
+// copy it was verified against.
+type JournalEntry struct {
+	ID        int64
+	Operation int64
+	Path      string
+	Size      int64
+	FullHash  []byte
+	Mode      uint32
+	// in nanoseconds since the epoch
+	Mtime int64
+	// the copy that was kept
+	Survivor string
+	// if the file was removed because the survivor has the same pixels,
+	// rather than the same contents, the pixel hash that they shared; the
+	// file can then only be recreated with the survivor's metadata
+	PixelHash []byte
+}
+
+// Records the start of an operation, returning its ID.
+func (s *Session) AddOperation(time int64, command string) (int64, herror.Interface) {
+	result, err := s.exec("INSERT INTO operation (time, command) VALUES (?, ?)", time, command)
+	if err != nil {
+		return 0, herror.Internal(err, "")
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, herror.Internal(err, "")
+	}
+	return id, nil
+}
+
+// Records a file that was removed, returning the entry's ID.
+func (s *Session) AddJournalEntry(entry JournalEntry) (int64, herror.Interface) {
+	result, err := s.exec(`
+	INSERT INTO journal (operation, path, size, full_hash, mode, mtime, survivor, pixel_hash)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, entry.Operation, entry.Path, entry.Size, entry.FullHash, entry.Mode, entry.Mtime, entry.Survivor, entry.PixelHash)
+	if err != nil {
+		return 0, herror.Internal(err, "")
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, herror.Internal(err, "")
+	}
+	return id, nil
+}
+
+// Returns all operations that have files in the journal, oldest first.
+func (s *Session) Operations() ([]Operation, herror.Interface) {
+	rows, err := s.query(`
+	SELECT operation.id, operation.time, operation.command, COUNT(journal.id), SUM(journal.size)
+	FROM operation
+	JOIN journal ON journal.operation = operation.id
+	GROUP BY operation.id
+	ORDER BY operation.id
+	`)
+	if err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	defer rows.Close()
+	var results []Operation
+	for rows.Next() {
+		var op Operation
+		if err := rows.Scan(&op.ID, &op.Time, &op.Command, &op.Files, &op.Size); err != nil {
+			return nil, herror.Internal(err, "")
+		}
+		results = append(results, op)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	return results, nil
+}
+
+// Returns the files removed by an operation, in the order they were removed.
+func (s *Session) JournalEntries(operation int64) ([]JournalEntry, herror.Interface) {
+	rows, err := s.query(`
+	SELECT id, operation, path, size, full_hash, mode, mtime, survivor, pixel_hash
+	FROM journal
+	WHERE operation = ?
+	ORDER BY id
+	`, operation)
+	if err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	defer rows.Close()
+	var results []JournalEntry
+	for rows.Next() {
+		var entry JournalEntry
+		if err := rows.Scan(&entry.ID, &entry.Operation, &entry.Path, &entry.Size, &entry.FullHash, &entry.Mode, &entry.Mtime, &entry.Survivor, &entry.PixelHash); err != nil {
+			return nil, herror.Internal(err, "")
+		}
+		results = append(results, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	return results, nil
+}
+
+// Forgets a file in the journal, after it's been restored, or if it turned out
+// not to be removed.
+func (s *Session) RemoveJournalEntry(id int64) herror.Interface {
+	if _, err := s.exec("DELETE FROM journal WHERE id = ?", id); err != nil {
+		return herror.Internal(err, "")
+	}
+	return nil
+}
+
+// Forgets operations that have no files left in the journal.
+func (s *Session) RemoveEmptyOperations() herror.Interface {
+	if _, err := s.exec(`
+	DELETE FROM operation
+	WHERE NOT EXISTS (SELECT 1 FROM journal WHERE journal.operation = operation.id)
+	`); err != nil {
+		return herror.Internal(err, "")
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestJournal(t *testing.T) {
+	db := newInMemoryDb(t)
+	op1, err := db.AddOperation(100, "rm")
+	check(t, err)
+	op2, err := db.AddOperation(200, "rm --trash")
+	check(t, err)
+	empty, err := db.AddOperation(300, "rm")
+	check(t, err)
+	x, err := db.AddJournalEntry(JournalEntry{Operation: op1, Path: "/d/x", Size: 10, FullHash: []byte{1}, Mode: 0o644, Mtime: 1000, Survivor: "/e/x"})
+	check(t, err)
+	_, err = db.AddJournalEntry(JournalEntry{Operation: op1, Path: "/d/y", Size: 20, FullHash: []byte{2}, Mode: 0o600, Mtime: 2000, Survivor: "/e/y", PixelHash: []byte{3}})
+	check(t, err)
+	z, err := db.AddJournalEntry(JournalEntry{Operation: op2, Path: "/d/z", Size: 30, Survivor: "/e/z"})
+	check(t, err)
+	ops, err := db.Operations()
+	check(t, err)
+	if len(ops) != 2 || ops[0].ID != op1 || ops[0].Files != 2 || ops[0].Size != 30 || ops[0].Time != 100 || ops[1].Command != "rm --trash" {
+		t.Fatalf("unexpected operations %+v", ops)
+	}
+	entries, err := db.JournalEntries(op1)
+	check(t, err)
+	if len(entries) != 2 || entries[0].Path != "/d/x" || entries[1].Path != "/d/y" {
+		t.Fatalf("expected entries in order, got %+v", entries)
+	}
+	if !bytes.Equal(entries[0].FullHash, []byte{1}) || entries[1].Mode != 0o600 || entries[1].Mtime != 2000 || entries[1].Survivor != "/e/y" ||
+		entries[0].PixelHash != nil || !bytes.Equal(entries[1].PixelHash, []byte{3}) {
+		t.Fatalf("unexpected entries %+v", entries)
+	}
+	check(t, db.RemoveJournalEntry(x))
+	check(t, db.RemoveJournalEntry(z))
+	ops, err = db.Operations()
+	check(t, err)
+	if len(ops) != 1 || ops[0].ID != op1 || ops[0].Files != 1 || ops[0].Size != 20 {
+		t.Fatalf("unexpected operations %+v", ops)
+	}
+	check(t, db.RemoveEmptyOperations())
+	var count int
+	row, err := db.queryRow("SELECT COUNT(*) FROM operation WHERE id IN (?, ?, ?)", op1, op2, empty)
+	check(t, err)
+	if err := row.Scan(&count); err != nil || count != 1 {
+		t.Fatalf("expected only one operation left, got %d (%v)", count, err)
+	}
+}
@@ -0,0 +1,301 @@
+package db
+
+import (
+	"github.com/anishathalye/periscope/internal/herror"
+
+	"database/sql"
+)
+
+// Kinds of infos in a spool.
+type SpoolKind int
+
+const (
+	// a file found by the scan in progress
+	SpoolFound SpoolKind = iota
+	// a file from the database, outside the paths being scanned
+	SpoolKnown
+	// a file from the database, inside the paths being scanned; it will be
+	// replaced by whatever the scan finds, but its hashes may be reusable
+	SpoolPrevious
+	// the decompressed contents of a compressed file, either found by the
+	// scan or from the database; the entry's size and full hash are the
+	// ones of the decompressed contents, and the entry is only used to
+	// find uncompressed copies
+	SpoolDecompressed
+)
+
+type SpoolEntry struct {
+	Info FileInfo
+	Kind SpoolKind
+}
+
+// Kinds of files that a scan reads in a separate pass, before the spooled
+// files are processed by size.
+type PendingKind int
+
+const (
+	// an archive, whose members are hashed
+	PendingArchive PendingKind = iota
+	// a compressed file, which is decompressed
+	PendingCompressed
+)
+
+// A file waiting for a separate pass, identified by its position in the
+// spool, so that pending files can be read in batches.
+type PendingEntry struct {
+	ID   int64
+	Info FileInfo
+}
+
+// spool writes are committed in batches of this size
+const spoolBatchSize = 10000
+
+// Temporary storage for the files found by a scan, so that they can be
+// processed one size at a time without keeping all of them in memory.
+//
+// A spool is backed by a private, temporary SQLite database that is deleted
+// when the spool is closed. A spool is not safe for concurrent use.
+type Spool struct {
+	db      *sql.DB
+	tx      *sql.Tx
+	pending int
+}
+
+func NewSpool() (*Spool, herror.Interface) {
+	// an empty filename creates a temporary on-disk database that is
+	// deleted when the connection is closed; there must only ever be a
+	// single connection
+	db, err := sql.Open("sqlite3", "")
+	if err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	// nothing in the spool needs to survive a crash
+	_, err = db.Exec("PRAGMA journal_mode = OFF")
+	if err != nil {
+		db.Close()
+		return nil, herror.Unlikely(err, "unable to create temporary database", `
+Ensure that the temporary directory is writable. The location can be changed by setting $SQLITE_TMPDIR or $TMPDIR.
+		`)
+	}
+	_, err = db.Exec("PRAGMA synchronous = OFF")
+	if err != nil {
+		db.Close()
+		return nil, herror.Internal(err, "")
+	}
+	_, err = db.Exec(`
+	CREATE TABLE spool
+	(
+		size              INTEGER NOT NULL,
+		path              TEXT NOT NULL,
+		kind              INTEGER NOT NULL,
+		short_hash        BLOB NULL,
+		sample_hash       BLOB NULL,
+		full_hash         BLOB NULL,
+		image_hash        BLOB NULL,
+		pixel_hash        BLOB NULL,
+		text_hash         BLOB NULL,
+		fuzzy_hash        BLOB NULL,
+		decompressed_hash BLOB NULL,
+		decompressed_size INTEGER NOT NULL,
+		mtime             INTEGER NOT NULL,
+		ctime             INTEGER NOT NULL,
+		inode             INTEGER NOT NULL,
+		device            INTEGER NOT NULL
+	)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, herror.Internal(err, "")
+	}
+	_, err = db.Exec("CREATE INDEX spool_size ON spool (size)")
+	if err != nil {
+		db.Close()
+		return nil, herror.Internal(err, "")
+	}
+	// only stat metadata is kept for pending files
+	_, err = db.Exec(`
+	CREATE TABLE pending
+	(
+		id     INTEGER PRIMARY KEY NOT NULL,
+		kind   INTEGER NOT NULL,
+		path   TEXT NOT NULL,
+		size   INTEGER NOT NULL,
+		mtime  INTEGER NOT NULL,
+		ctime  INTEGER NOT NULL,
+		inode  INTEGER NOT NULL,
+		device INTEGER NOT NULL
+	)
+	`)
+	if err != nil {
+		db.Close()
+		return nil, herror.Internal(err, "")
+	}
+	return &Spool{db: db}, nil
+}
+
+func (s *Spool) begin() herror.Interface {
+	if s.tx == nil {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return herror.Internal(err, "")
+		}
+		s.tx = tx
+	}
+	return nil
+}
+
+func (s *Spool) Add(info FileInfo, kind SpoolKind) herror.Interface {
+	if err := s.begin(); err != nil {
+		return err
+	}
+	_, err := s.tx.Exec(`
+	INSERT INTO spool (size, path, kind, short_hash, sample_hash, full_hash, image_hash, pixel_hash, text_hash, fuzzy_hash, decompressed_hash, decompressed_size, mtime, ctime, inode, device)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, info.Size, info.Path, kind, info.ShortHash, info.SampleHash, info.FullHash, info.ImageHash, info.PixelHash, info.TextHash, info.FuzzyHash, info.DecompressedHash, info.DecompressedSize, info.Mtime, info.Ctime, info.Inode, info.Device)
+	if err != nil {
+		return herror.Internal(err, "")
+	}
+	s.pending++
+	if s.pending >= spoolBatchSize {
+		return s.flush()
+	}
+	return nil
+}
+
+// Adds a file to read in a separate pass; only its path, size, and stat
+// metadata are kept.
+func (s *Spool) AddPending(info FileInfo, kind PendingKind) herror.Interface {
+	if err := s.begin(); err != nil {
+		return err
+	}
+	_, err := s.tx.Exec(`
+	INSERT INTO pending (kind, path, size, mtime, ctime, inode, device)
+	VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, kind, info.Path, info.Size, info.Mtime, info.Ctime, info.Inode, info.Device)
+	if err != nil {
+		return herror.Internal(err, "")
+	}
+	s.pending++
+	if s.pending >= spoolBatchSize {
+		return s.flush()
+	}
+	return nil
+}
+
+// Returns the number of pending files of the given kind.
+func (s *Spool) PendingCount(kind PendingKind) (int, herror.Interface) {
+	if err := s.flush(); err != nil {
+		return 0, err
+	}
+	var count int
+	err := s.db.QueryRow("SELECT COUNT(*) FROM pending WHERE kind = ?", kind).Scan(&count)
+	if err != nil {
+		return 0, herror.Internal(err, "")
+	}
+	return count, nil
+}
+
+// Returns up to limit pending files of the given kind that were added after
+// the one with the given ID, in the order they were added; start with an ID
+// of 0. Like with NextSize, no query is left open, so the spool can be added
+// to between batches.
+func (s *Spool) PendingAfter(kind PendingKind, after int64, limit int) ([]PendingEntry, herror.Interface) {
+	if err := s.flush(); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`
+	SELECT id, path, size, mtime, ctime, inode, device
+	FROM pending
+	WHERE kind = ? AND id > ?
+	ORDER BY id
+	LIMIT ?
+	`, kind, after, limit)
+	if err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	defer rows.Close()
+	var results []PendingEntry
+	for rows.Next() {
+		var entry PendingEntry
+		info := &entry.Info
+		if err := rows.Scan(&entry.ID, &info.Path, &info.Size, &info.Mtime, &info.Ctime, &info.Inode, &info.Device); err != nil {
+			return nil, herror.Internal(err, "")
+		}
+		results = append(results, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	return results, nil
+}
+
+func (s *Spool) flush() herror.Interface {
+	if s.tx == nil {
+		return nil
+	}
+	err := s.tx.Commit()
+	s.tx = nil
+	s.pending = 0
+	if err != nil {
+		return herror.Internal(err, "")
+	}
+	return nil
+}
+
+// Returns the smallest size in the spool that is larger than the given size,
+// and whether there is such a size.
+//
+// Iterating over sizes this way, rather than with a single query, means that
+// no query is left open, so the spool can be modified while iterating.
+func (s *Spool) NextSize(after int64) (int64, bool, herror.Interface) {
+	if err := s.flush(); err != nil {
+		return 0, false, err
+	}
+	var size sql.NullInt64
+	err := s.db.QueryRow("SELECT MIN(size) FROM spool WHERE size > ?", after).Scan(&size)
+	if err != nil {
+		return 0, false, herror.Internal(err, "")
+	}
+	return size.Int64, size.Valid, nil
+}
+
+// Returns all entries with the given size, in the order they were added.
+func (s *Spool) EntriesBySize(size int64) ([]SpoolEntry, herror.Interface) {
+	if err := s.flush(); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(`
+	SELECT path, kind, short_hash, sample_hash, full_hash, image_hash, pixel_hash, text_hash, fuzzy_hash, decompressed_hash, decompressed_size, mtime, ctime, inode, device
+	FROM spool
+	WHERE size = ?
+	ORDER BY rowid
+	`, size)
+	if err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	defer rows.Close()
+	var results []SpoolEntry
+	for rows.Next() {
+		entry := SpoolEntry{Info: FileInfo{Size: size}}
+		info := &entry.Info
+		if err := rows.Scan(&info.Path, &entry.Kind, &info.ShortHash, &info.SampleHash, &info.FullHash, &info.ImageHash, &info.PixelHash, &info.TextHash, &info.FuzzyHash, &info.DecompressedHash, &info.DecompressedSize, &info.Mtime, &info.Ctime, &info.Inode, &info.Device); err != nil {
+			return nil, herror.Internal(err, "")
+		}
+		results = append(results, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	return results, nil
+}
+
+// Closes the spool, deleting its contents.
+func (s *Spool) Close() {
+	if s.tx != nil {
+		s.tx.Rollback()
+		s.tx = nil
+	}
+	s.db.Close()
+}
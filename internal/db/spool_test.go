@@ -0,0 +1,108 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSpool(t *testing.T) {
+	spool, err := NewSpool()
+	check(t, err)
+	defer spool.Close()
+	check(t, spool.Add(FileInfo{Path: "/a", Size: 20, Mtime: 1, Inode: 2, Device: 3}, SpoolFound))
+	check(t, spool.Add(FileInfo{Path: "/b", Size: 10}, SpoolFound))
+	check(t, spool.Add(FileInfo{Path: "/c", Size: 20, ShortHash: []byte("s"), FullHash: []byte("f")}, SpoolKnown))
+
+	var sizes []int64
+	size := int64(-1)
+	for {
+		var ok bool
+		size, ok, err = spool.NextSize(size)
+		check(t, err)
+		if !ok {
+			break
+		}
+		sizes = append(sizes, size)
+		if size == 10 {
+			// the spool can be added to while iterating
+			check(t, spool.Add(FileInfo{Path: "/d", Size: 10}, SpoolPrevious))
+		}
+	}
+	if !reflect.DeepEqual(sizes, []int64{10, 20}) {
+		t.Fatalf("expected sizes [10 20], got %v", sizes)
+	}
+
+	entries, err := spool.EntriesBySize(20)
+	check(t, err)
+	expected := []SpoolEntry{
+		{Info: FileInfo{Path: "/a", Size: 20, Mtime: 1, Inode: 2, Device: 3}, Kind: SpoolFound},
+		{Info: FileInfo{Path: "/c", Size: 20, ShortHash: []byte("s"), FullHash: []byte("f")}, Kind: SpoolKnown},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %v, got %v", expected, entries)
+	}
+	entries, err = spool.EntriesBySize(10)
+	check(t, err)
+	if len(entries) != 2 || entries[1].Info.Path != "/d" || entries[1].Kind != SpoolPrevious {
+		t.Fatalf("unexpected entries %v", entries)
+	}
+}
+
+func TestSpoolBatches(t *testing.T) {
+	spool, err := NewSpool()
+	check(t, err)
+	defer spool.Close()
+	n := spoolBatchSize + 10
+	for i := 0; i < n; i++ {
+		check(t, spool.Add(FileInfo{Path: "/x", Size: 1}, SpoolFound))
+	}
+	entries, err := spool.EntriesBySize(1)
+	check(t, err)
+	if len(entries) != n {
+		t.Fatalf("expected %d entries, got %d", n, len(entries))
+	}
+}
+
+func TestSpoolPending(t *testing.T) {
+	spool, err := NewSpool()
+	check(t, err)
+	defer spool.Close()
+	check(t, spool.AddPending(FileInfo{Path: "/a.zip", Size: 1, Mtime: 2, Ctime: 3, Inode: 4, Device: 5}, PendingArchive))
+	check(t, spool.AddPending(FileInfo{Path: "/b.gz", Size: 6}, PendingCompressed))
+	check(t, spool.AddPending(FileInfo{Path: "/c.tar", Size: 7, FullHash: []byte("f")}, PendingArchive))
+	check(t, spool.AddPending(FileInfo{Path: "/d.zip", Size: 8}, PendingArchive))
+
+	count, err := spool.PendingCount(PendingArchive)
+	check(t, err)
+	if count != 3 {
+		t.Fatalf("expected 3 pending archives, got %d", count)
+	}
+
+	// hashes aren't kept
+	expected := []FileInfo{
+		{Path: "/a.zip", Size: 1, Mtime: 2, Ctime: 3, Inode: 4, Device: 5},
+		{Path: "/c.tar", Size: 7},
+		{Path: "/d.zip", Size: 8},
+	}
+	var infos []FileInfo
+	after := int64(0)
+	for {
+		entries, err := spool.PendingAfter(PendingArchive, after, 2)
+		check(t, err)
+		if len(entries) == 0 {
+			break
+		}
+		if len(entries) > 2 {
+			t.Fatalf("expected at most 2 entries, got %d", len(entries))
+		}
+		for _, entry := range entries {
+			infos = append(infos, entry.Info)
+		}
+		after = entries[len(entries)-1].ID
+		// the spool can be added to between batches
+		check(t, spool.Add(FileInfo{Path: "/x", Size: 1}, SpoolFound))
+	}
+	if !reflect.DeepEqual(infos, expected) {
+		t.Fatalf("expected %v, got %v", expected, infos)
+	}
+}
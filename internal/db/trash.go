@@ -0,0 +1,88 @@
+package db
+
+import (
+	"github.com/anishathalye/periscope/internal/herror"
+
+	"database/sql"
+)
+
+// A file that was moved to the trash.
+type TrashedFile struct {
+	// the name of the file in the trash, which is unique
+	Name string
+	// the path the file was moved from
+	Path     string
+	Size     int64
+	FullHash []byte
+	// when the file was moved to the trash, in seconds since the epoch
+	Deleted int64
+	// the ID of the journal entry that records the removal, or 0 if there
+	// is none; the entry is forgotten when the file is restored
+	Journal int64
+}
+
+// Records a file that was moved to the trash.
+func (s *Session) AddTrashed(file TrashedFile) herror.Interface {
+	if _, err := s.exec(`
+	REPLACE INTO trash (name, path, size, full_hash, deleted, journal)
+	VALUES (?, ?, ?, ?, ?, ?)
+	`, file.Name, file.Path, file.Size, file.FullHash, file.Deleted, file.Journal); err != nil {
+		return herror.Internal(err, "")
+	}
+	return nil
+}
+
+// Returns all files in the trash, sorted by their original paths, and then by
+// when they were moved to the trash, most recent first.
+func (s *Session) AllTrashed() ([]TrashedFile, herror.Interface) {
+	rows, err := s.query(`
+	SELECT name, path, size, full_hash, deleted, journal
+	FROM trash
+	ORDER BY path, deleted DESC, name
+	`)
+	if err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	defer rows.Close()
+	var results []TrashedFile
+	for rows.Next() {
+		var file TrashedFile
+		if err := rows.Scan(&file.Name, &file.Path, &file.Size, &file.FullHash, &file.Deleted, &file.Journal); err != nil {
+			return nil, herror.Internal(err, "")
+		}
+		results = append(results, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, herror.Internal(err, "")
+	}
+	return results, nil
+}
+
+// Returns the file in the trash whose removal is recorded by the given journal
+// entry, and whether there is one.
+func (s *Session) TrashedByJournal(id int64) (TrashedFile, bool, herror.Interface) {
+	row, herr := s.queryRow(`
+	SELECT name, path, size, full_hash, deleted, journal
+	FROM trash
+	WHERE journal = ?
+	`, id)
+	if herr != nil {
+		return TrashedFile{}, false, herr
+	}
+	var file TrashedFile
+	err := row.Scan(&file.Name, &file.Path, &file.Size, &file.FullHash, &file.Deleted, &file.Journal)
+	if err == sql.ErrNoRows {
+		return TrashedFile{}, false, nil
+	} else if err != nil {
+		return TrashedFile{}, false, herror.Internal(err, "")
+	}
+	return file, true, nil
+}
+
+// Forgets a file in the trash, after it's been restored or deleted.
+func (s *Session) RemoveTrashed(name string) herror.Interface {
+	if _, err := s.exec("DELETE FROM trash WHERE name = ?", name); err != nil {
+		return herror.Internal(err, "")
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package db
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTrashed(t *testing.T) {
+	db := newInMemoryDb(t)
+	check(t, db.AddTrashed(TrashedFile{Name: "x", Path: "/d/x", Size: 10, FullHash: []byte{1}, Deleted: 100}))
+	check(t, db.AddTrashed(TrashedFile{Name: "x.2", Path: "/e/x", Size: 20, FullHash: []byte{2}, Deleted: 200}))
+	check(t, db.AddTrashed(TrashedFile{Name: "x.3", Path: "/d/x", Size: 30, Deleted: 300}))
+	files, err := db.AllTrashed()
+	check(t, err)
+	if len(files) != 3 || files[0].Name != "x.3" || files[1].Name != "x" || files[2].Name != "x.2" {
+		t.Fatalf("expected files sorted by path and then most recent first, got %+v", files)
+	}
+	if files[0].FullHash != nil || !bytes.Equal(files[1].FullHash, []byte{1}) || files[2].Size != 20 || files[2].Deleted != 200 {
+		t.Fatalf("unexpected files %+v", files)
+	}
+	check(t, db.RemoveTrashed("x"))
+	files, err = db.AllTrashed()
+	check(t, err)
+	if len(files) != 2 || files[0].Name != "x.3" || files[1].Name != "x.2" {
+		t.Fatalf("expected file to be forgotten, got %+v", files)
+	}
+}
+
+func TestTrashedByJournal(t *testing.T) {
+	db := newInMemoryDb(t)
+	check(t, db.AddTrashed(TrashedFile{Name: "x", Path: "/d/x", Size: 10, Deleted: 100}))
+	check(t, db.AddTrashed(TrashedFile{Name: "x.2", Path: "/d/x", Size: 10, Deleted: 200, Journal: 7}))
+	file, ok, err := db.TrashedByJournal(7)
+	check(t, err)
+	if !ok || file.Name != "x.2" || file.Journal != 7 {
+		t.Fatalf("expected x.2, got %+v", file)
+	}
+	_, ok, err = db.TrashedByJournal(8)
+	check(t, err)
+	if ok {
+		t.Fatal("expected no file")
+	}
+}
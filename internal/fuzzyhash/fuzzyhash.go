@@ -0,0 +1,309 @@
+// Package fuzzyhash implements a context-triggered piecewise hash, in the
+// style of ssdeep, for finding files with similar contents.
+//
+// The input is split into pieces at points chosen by a rolling hash of the
+// last few bytes, so the split points depend only on local content, and an
+// insertion or deletion only affects the pieces around it. Each piece
+// contributes one character to the digest. Digests of similar inputs share
+// long runs of characters, and Compare scores them by their edit distance.
+package fuzzyhash
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+const (
+	// the maximum length of the first signature in a digest; the second is
+	// at most half as long
+	spamsumLength = 64
+	minBlockSize  = 3
+	numBlockSizes = 31
+	rollingWindow = 7
+	hashInit      = 0x28021967
+	hashPrime     = 0x01000193
+)
+
+const b64 = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/"
+
+func blockSize(i int) uint32 {
+	return minBlockSize << uint(i)
+}
+
+// A Digest summarizes an input at two granularities: Sig1 has a character
+// for each piece chosen with BlockSize, and Sig2 for each piece chosen with
+// twice that, so digests with block sizes that differ by a factor of two can
+// still be compared.
+type Digest struct {
+	BlockSize uint32
+	Sig1      string
+	Sig2      string
+}
+
+// Formats the digest as "blocksize:sig1:sig2", like ssdeep.
+func (d Digest) String() string {
+	return strconv.FormatUint(uint64(d.BlockSize), 10) + ":" + d.Sig1 + ":" + d.Sig2
+}
+
+// Parses a digest in the format produced by String.
+func Parse(s string) (Digest, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return Digest{}, errors.New("fuzzyhash: malformed digest")
+	}
+	bs, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil || bs < minBlockSize {
+		return Digest{}, errors.New("fuzzyhash: malformed block size")
+	}
+	return Digest{BlockSize: uint32(bs), Sig1: parts[1], Sig2: parts[2]}, nil
+}
+
+type rollingState struct {
+	window     [rollingWindow]byte
+	h1, h2, h3 uint32
+	n          uint32
+}
+
+func (r *rollingState) update(c byte) {
+	r.h2 -= r.h1
+	r.h2 += rollingWindow * uint32(c)
+	r.h1 += uint32(c)
+	r.h1 -= uint32(r.window[r.n%rollingWindow])
+	r.window[r.n%rollingWindow] = c
+	r.n++
+	r.h3 = (r.h3 << 5) ^ uint32(c)
+}
+
+func (r *rollingState) sum() uint32 {
+	return r.h1 + r.h2 + r.h3
+}
+
+// the piecewise hashes and digest for one block size
+type blockHash struct {
+	h uint32
+	// like h, but no longer reset once the digest is half full, so its
+	// last character covers the rest of the input in the second signature
+	halfh  uint32
+	digest []byte
+}
+
+// A Hasher computes the digest of the data written to it.
+//
+// It tracks several block sizes at once, starting small and adding larger
+// ones as the input grows, and drops small ones once a larger one is certain
+// to produce a long enough signature, so the input is only read once.
+type Hasher struct {
+	roll   rollingState
+	blocks [numBlockSizes]blockHash
+	// the block sizes being tracked are [start, end)
+	start, end int
+	total      uint64
+}
+
+// Returns a Hasher with no data written to it.
+func New() *Hasher {
+	h := &Hasher{end: 1}
+	h.blocks[0] = blockHash{h: hashInit, halfh: hashInit}
+	return h
+}
+
+func (h *Hasher) Write(p []byte) (int, error) {
+	for _, c := range p {
+		h.step(c)
+	}
+	h.total += uint64(len(p))
+	return len(p), nil
+}
+
+func (h *Hasher) step(c byte) {
+	h.roll.update(c)
+	sum := h.roll.sum()
+	for i := h.start; i < h.end; i++ {
+		b := &h.blocks[i]
+		b.h = (b.h * hashPrime) ^ uint32(c)
+		b.halfh = (b.halfh * hashPrime) ^ uint32(c)
+	}
+	// block sizes are multiples of each other, so a trigger point for a
+	// block size is also one for all smaller block sizes
+	for i := h.start; i < h.end; i++ {
+		bs := blockSize(i)
+		if sum%bs != bs-1 {
+			break
+		}
+		b := &h.blocks[i]
+		if len(b.digest) == 0 {
+			h.fork()
+		}
+		if len(b.digest) < spamsumLength-1 {
+			b.digest = append(b.digest, b64[b.h%64])
+			b.h = hashInit
+			if len(b.digest) < spamsumLength/2 {
+				b.halfh = hashInit
+			}
+		} else {
+			// the digest is full; the last character, added at the
+			// end, covers the rest of the input
+			h.reduce()
+		}
+	}
+}
+
+// starts tracking the next larger block size, which hasn't been triggered yet,
+// so it has the same piecewise hash as the largest one tracked so far
+func (h *Hasher) fork() {
+	if h.end >= numBlockSizes {
+		return
+	}
+	last := h.blocks[h.end-1]
+	h.blocks[h.end] = blockHash{h: last.h, halfh: last.halfh}
+	h.end++
+}
+
+// stops tracking the smallest block size if it can no longer be chosen
+func (h *Hasher) reduce() {
+	if h.end-h.start < 2 {
+		return
+	}
+	if uint64(blockSize(h.start))*spamsumLength >= h.total {
+		return
+	}
+	if len(h.blocks[h.start+1].digest) < spamsumLength/2 {
+		return
+	}
+	h.start++
+}
+
+// Returns the digest of the data written so far.
+//
+// The block size is the smallest one that would give at most spamsumLength
+// pieces for an input of this size, reduced while that gives a signature that
+// is too short to be useful.
+func (h *Hasher) Sum() Digest {
+	i := h.start
+	for uint64(blockSize(i))*spamsumLength < h.total && i < h.end-1 {
+		i++
+	}
+	for i > h.start && len(h.blocks[i].digest) < spamsumLength/2 {
+		i--
+	}
+	tail := h.roll.sum() != 0
+	b := &h.blocks[i]
+	sig1 := string(b.digest)
+	if tail {
+		sig1 += string(b64[b.h%64])
+	}
+	var sig2 string
+	if i+1 < h.end {
+		next := &h.blocks[i+1]
+		n := len(next.digest)
+		if n > spamsumLength/2-1 {
+			n = spamsumLength/2 - 1
+		}
+		sig2 = string(next.digest[:n])
+		if tail {
+			sig2 += string(b64[next.halfh%64])
+		}
+	} else if tail {
+		sig2 = string(b64[b.h%64])
+	}
+	return Digest{BlockSize: blockSize(i), Sig1: sig1, Sig2: sig2}
+}
+
+// Scores the similarity of two digests, from 0 (no meaningful similarity) to
+// 100 (very similar or identical). Digests can only be compared if their
+// block sizes are equal or differ by a factor of two; otherwise, the score is
+// 0.
+func Compare(a, b Digest) int {
+	if a.BlockSize != b.BlockSize && a.BlockSize != 2*b.BlockSize && b.BlockSize != 2*a.BlockSize {
+		return 0
+	}
+	a1, a2 := eliminateSequences(a.Sig1), eliminateSequences(a.Sig2)
+	b1, b2 := eliminateSequences(b.Sig1), eliminateSequences(b.Sig2)
+	switch {
+	case a.BlockSize == b.BlockSize:
+		if a1 == b1 && a1 != "" {
+			return 100
+		}
+		return max(scoreStrings(a1, b1, a.BlockSize), scoreStrings(a2, b2, 2*a.BlockSize))
+	case a.BlockSize == 2*b.BlockSize:
+		return scoreStrings(a1, b2, a.BlockSize)
+	default:
+		return scoreStrings(a2, b1, b.BlockSize)
+	}
+}
+
+// runs of the same character carry little information, so they're shortened
+// to at most three characters
+func eliminateSequences(s string) string {
+	out := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if i < 3 || s[i] != s[i-1] || s[i] != s[i-2] || s[i] != s[i-3] {
+			out = append(out, s[i])
+		}
+	}
+	return string(out)
+}
+
+func scoreStrings(s1, s2 string, bs uint32) int {
+	if len(s1) > spamsumLength || len(s2) > spamsumLength {
+		return 0
+	}
+	// signatures without a run of characters in common are unlikely to
+	// come from related inputs, even if their edit distance is small
+	if !hasCommonSubstring(s1, s2) {
+		return 0
+	}
+	score := editDistance(s1, s2) * spamsumLength / (len(s1) + len(s2))
+	score = 100 * score / spamsumLength
+	if score >= 100 {
+		return 0
+	}
+	score = 100 - score
+	// with small block sizes, short signatures match too easily, so the
+	// score is limited by the amount of input they cover
+	if bs < (99+rollingWindow)/rollingWindow*minBlockSize {
+		if limit := int(bs) / minBlockSize * min(len(s1), len(s2)); score > limit {
+			score = limit
+		}
+	}
+	return score
+}
+
+func hasCommonSubstring(s1, s2 string) bool {
+	if len(s1) < rollingWindow || len(s2) < rollingWindow {
+		return false
+	}
+	substrings := make(map[string]struct{})
+	for i := 0; i+rollingWindow <= len(s1); i++ {
+		substrings[s1[i:i+rollingWindow]] = struct{}{}
+	}
+	for i := 0; i+rollingWindow <= len(s2); i++ {
+		if _, ok := substrings[s2[i:i+rollingWindow]]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// the edit distance, where insertions and deletions cost 1 and substitutions
+// cost 2
+func editDistance(s1, s2 string) int {
+	prev := make([]int, len(s2)+1)
+	cur := make([]int, len(s2)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s1); i++ {
+		cur[0] = i
+		for j := 1; j <= len(s2); j++ {
+			cost := prev[j-1]
+			if s1[i-1] != s2[j-1] {
+				cost += 2
+			}
+			cur[j] = min(cost, prev[j]+1, cur[j-1]+1)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(s2)]
+}
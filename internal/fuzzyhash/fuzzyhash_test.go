@@ -0,0 +1,117 @@
+package fuzzyhash
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// a document of random words, so that there's structure for the rolling hash
+// to find
+func document(rng *rand.Rand, words int) []byte {
+	vocabulary := []string{"the", "quick", "brown", "fox", "jumps", "over", "lazy", "dog", "periscope", "duplicate", "file", "scan", "report", "similar", "hash", "digest"}
+	var buf bytes.Buffer
+	for i := 0; i < words; i++ {
+		buf.WriteString(vocabulary[rng.Intn(len(vocabulary))])
+		if i%12 == 11 {
+			buf.WriteString(".\n")
+		} else {
+			buf.WriteString(" ")
+		}
+	}
+	return buf.Bytes()
+}
+
+func digestOf(data []byte) Digest {
+	h := New()
+	h.Write(data)
+	return h.Sum()
+}
+
+func TestCompareSimilar(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	original := document(rng, 20000)
+	// insert a paragraph in the middle and change the ending
+	var edited []byte
+	edited = append(edited, original[:len(original)/2]...)
+	edited = append(edited, document(rng, 300)...)
+	edited = append(edited, original[len(original)/2:len(original)-500]...)
+	edited = append(edited, document(rng, 80)...)
+	a, b := digestOf(original), digestOf(edited)
+	if score := Compare(a, b); score < 50 {
+		t.Errorf("expected edited document to be similar, got score %d (%s vs %s)", score, a, b)
+	}
+	if score := Compare(a, a); score != 100 {
+		t.Errorf("expected identical digests to have score 100, got %d", score)
+	}
+	other := digestOf(document(rng, 20000))
+	if score := Compare(a, other); score > 0 {
+		t.Errorf("expected unrelated document to be dissimilar, got score %d", score)
+	}
+}
+
+func TestWriteSplit(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	data := document(rng, 5000)
+	expected := digestOf(data)
+	h := New()
+	for i := 0; i < len(data); i += 1000 {
+		h.Write(data[i:min(i+1000, len(data))])
+	}
+	if got := h.Sum(); got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestBlockSize(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+	for _, words := range []int{10, 1000, 100000} {
+		d := digestOf(document(rng, words))
+		if len(d.Sig1) > spamsumLength || len(d.Sig2) > spamsumLength/2 {
+			t.Errorf("%d words: signatures too long: %s", words, d)
+		}
+		if words > 10 && len(d.Sig1) < spamsumLength/2 {
+			t.Errorf("%d words: signature too short: %s", words, d)
+		}
+	}
+}
+
+func TestCompareBlockSizes(t *testing.T) {
+	a := Digest{BlockSize: 3, Sig1: "ABCDEFGHIJ", Sig2: "ABCDE"}
+	b := Digest{BlockSize: 12, Sig1: "ABCDEFGHIJ", Sig2: "ABCDE"}
+	if score := Compare(a, b); score != 0 {
+		t.Errorf("expected incomparable block sizes to score 0, got %d", score)
+	}
+}
+
+func TestParse(t *testing.T) {
+	d := Digest{BlockSize: 192, Sig1: "abc+/", Sig2: "xy"}
+	got, err := Parse(d.String())
+	if err != nil || got != d {
+		t.Fatalf("expected %v, got %v (%v)", d, got, err)
+	}
+	for _, s := range []string{"", "3:abc", "x:a:b", "1:a:b", fmt.Sprintf("%d:a:b", uint64(1)<<40)} {
+		if _, err := Parse(s); err == nil {
+			t.Errorf("expected error parsing %q", s)
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	cases := []struct {
+		s1, s2   string
+		distance int
+	}{
+		{"", "", 0},
+		{"abc", "", 3},
+		{"abc", "abc", 0},
+		{"abc", "abd", 2},
+		{"abc", "abxc", 1},
+	}
+	for _, c := range cases {
+		if got := editDistance(c.s1, c.s2); got != c.distance {
+			t.Errorf("editDistance(%q, %q): expected %d, got %d", c.s1, c.s2, c.distance, got)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+// Package ignore implements matching of gitignore-style patterns.
+//
+// Patterns follow the syntax of gitignore(5): blank lines and lines starting
+// with '#' are ignored, a leading '!' negates a pattern, a trailing '/' makes a
+// pattern match only directories, and a pattern that contains a '/' anywhere
+// other than at the end is anchored to the directory containing the patterns.
+// Unanchored patterns match a name at any depth. '*', '?', and '[...]' match
+// within a single path component, while '**' matches across components.
+package ignore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+type rule struct {
+	re      *regexp.Regexp
+	negate  bool
+	dirOnly bool
+}
+
+// A Matcher is a list of patterns, where later patterns take precedence over
+// earlier ones.
+type Matcher struct {
+	rules []rule
+}
+
+// Compiles the given patterns, one per element, in gitignore syntax.
+func New(patterns []string) (*Matcher, error) {
+	m := &Matcher{}
+	for _, p := range patterns {
+		if err := m.add(p); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Reads patterns from an ignore file, one per line.
+//
+// Lines with invalid patterns are skipped; they are reported in the returned
+// error, but the returned Matcher is usable regardless.
+func Parse(r io.Reader) (*Matcher, error) {
+	m := &Matcher{}
+	var bad []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if err := m.add(scanner.Text()); err != nil {
+			bad = append(bad, err.Error())
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return m, err
+	}
+	if len(bad) > 0 {
+		return m, fmt.Errorf("%s", strings.Join(bad, "; "))
+	}
+	return m, nil
+}
+
+func (m *Matcher) add(line string) error {
+	line = strings.TrimRight(line, "\r")
+	line = trimTrailingSpaces(line)
+	if line == "" || line[0] == '#' {
+		return nil
+	}
+	r := rule{}
+	if line[0] == '!' {
+		r.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		r.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil
+	}
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	expr, err := translate(line)
+	if err != nil {
+		return fmt.Errorf("bad pattern '%s': %s", line, err)
+	}
+	if anchored {
+		expr = "^" + expr + "$"
+	} else {
+		expr = "^(?:.*/)?" + expr + "$"
+	}
+	r.re, err = regexp.Compile(expr)
+	if err != nil {
+		return fmt.Errorf("bad pattern '%s': %s", line, err)
+	}
+	m.rules = append(m.rules, r)
+	return nil
+}
+
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, `\ `) {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+// translates a glob into a regular expression (without anchors)
+func translate(glob string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				atStart := i == 0 || glob[i-1] == '/'
+				atEnd := i+2 == len(glob) || glob[i+2] == '/'
+				if atStart && atEnd {
+					i++ // skip second '*'
+					if i+1 < len(glob) {
+						// "**/" matches zero or more directories
+						i++ // skip '/'
+						b.WriteString("(?:.*/)?")
+					} else {
+						// trailing "**" matches everything inside
+						b.WriteString(".*")
+					}
+					continue
+				}
+			}
+			b.WriteString("[^/]*")
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			j := i + 1
+			if j < len(glob) && (glob[j] == '!' || glob[j] == '^') {
+				j++
+			}
+			if j < len(glob) && glob[j] == ']' {
+				j++
+			}
+			for j < len(glob) && glob[j] != ']' {
+				j++
+			}
+			if j >= len(glob) {
+				return "", fmt.Errorf("unterminated character class")
+			}
+			class := glob[i+1 : j]
+			if class[0] == '!' {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i = j
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return b.String(), nil
+}
+
+// Match reports whether any pattern matches the given path, and if so, whether
+// the path is ignored (the last matching pattern is not negated).
+//
+// The path must be relative to the directory the patterns apply to, using '/'
+// as a separator.
+func (m *Matcher) Match(path string, isDir bool) (matched, ignored bool) {
+	if m == nil {
+		return false, false
+	}
+	for i := len(m.rules) - 1; i >= 0; i-- {
+		r := m.rules[i]
+		if r.dirOnly && !isDir {
+			continue
+		}
+		if r.re.MatchString(path) {
+			return true, !r.negate
+		}
+	}
+	return false, false
+}
+
+// Empty reports whether the matcher has no patterns.
+func (m *Matcher) Empty() bool {
+	return m == nil || len(m.rules) == 0
+}
@@ -0,0 +1,148 @@
+package ignore
+
+import (
+	"strings"
+	"testing"
+)
+
+type matchCase struct {
+	path    string
+	isDir   bool
+	ignored bool
+}
+
+func checkMatches(t *testing.T, m *Matcher, cases []matchCase) {
+	for _, c := range cases {
+		_, ignored := m.Match(c.path, c.isDir)
+		if ignored != c.ignored {
+			t.Errorf("Match(%q, %v): expected ignored=%v, got %v", c.path, c.isDir, c.ignored, ignored)
+		}
+	}
+}
+
+func TestUnanchored(t *testing.T) {
+	m, err := New([]string{"node_modules", "*.vmdk"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMatches(t, m, []matchCase{
+		{"node_modules", true, true},
+		{"a/b/node_modules", true, true},
+		{"a/node_modules_x", true, false},
+		{"disk.vmdk", false, true},
+		{"vms/disk.vmdk", false, true},
+		{"vms/disk.vmdk.txt", false, false},
+	})
+}
+
+func TestAnchored(t *testing.T) {
+	m, err := New([]string{"/build", ".git/objects"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMatches(t, m, []matchCase{
+		{"build", true, true},
+		{"a/build", true, false},
+		{".git/objects", true, true},
+		{"x/.git/objects", true, false},
+	})
+}
+
+func TestDirOnly(t *testing.T) {
+	m, err := New([]string{"cache/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMatches(t, m, []matchCase{
+		{"cache", true, true},
+		{"a/cache", true, true},
+		{"cache", false, false},
+	})
+}
+
+func TestDoubleStar(t *testing.T) {
+	m, err := New([]string{"**/tmp", "a/**/b", "logs/**"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMatches(t, m, []matchCase{
+		{"tmp", true, true},
+		{"x/y/tmp", true, true},
+		{"a/b", false, true},
+		{"a/x/y/b", false, true},
+		{"a/x/y/c", false, false},
+		{"logs/x", false, true},
+		{"logs/x/y", false, true},
+		{"logs", true, false},
+	})
+}
+
+func TestNegation(t *testing.T) {
+	m, err := New([]string{"*.log", "!keep.log"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMatches(t, m, []matchCase{
+		{"a.log", false, true},
+		{"keep.log", false, false},
+		{"d/keep.log", false, false},
+	})
+	matched, _ := m.Match("keep.log", false)
+	if !matched {
+		t.Fatal("expected negated pattern to count as a match")
+	}
+	matched, _ = m.Match("a.txt", false)
+	if matched {
+		t.Fatal("expected no match")
+	}
+}
+
+func TestClassAndEscapes(t *testing.T) {
+	m, err := New([]string{"file[0-9].txt", `\#notes`, `\!important`, "?.bak"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkMatches(t, m, []matchCase{
+		{"file1.txt", false, true},
+		{"filex.txt", false, false},
+		{"#notes", false, true},
+		{"!important", false, true},
+		{"a.bak", false, true},
+		{"ab.bak", false, false},
+	})
+}
+
+func TestParse(t *testing.T) {
+	m, err := Parse(strings.NewReader(strings.Join([]string{
+		"# comment",
+		"*.tmp",
+		"trailing   ",
+		"",
+		"[unterminated",
+		"!b.tmp",
+	}, "\n")))
+	if err == nil {
+		t.Fatal("expected error for bad pattern")
+	}
+	checkMatches(t, m, []matchCase{
+		{"a.tmp", false, true},
+		{"b.tmp", false, false},
+		{"trailing", false, true},
+		{"# comment", false, false},
+	})
+}
+
+func TestEmpty(t *testing.T) {
+	var m *Matcher
+	if !m.Empty() {
+		t.Fatal("expected nil matcher to be empty")
+	}
+	matched, _ := m.Match("x", false)
+	if matched {
+		t.Fatal("expected nil matcher not to match")
+	}
+	m, _ = New([]string{"# just a comment", ""})
+	if !m.Empty() {
+		t.Fatal("expected matcher to be empty")
+	}
+}
@@ -0,0 +1,245 @@
+// Package imagehash implements perceptual hashing of images.
+//
+// The hash is a difference hash (dHash): the image is converted to grayscale
+// and shrunk to 9x8 pixels, and each bit of the 64-bit hash records whether a
+// pixel is brighter than its neighbor to the right. Re-encoding, resizing, or
+// stripping metadata from an image changes few or no bits, so similar-looking
+// images have hashes with a small Hamming distance.
+//
+// The package also provides a canonical encoding of an image's pixels, for
+// recognizing images that have identical pixels but differ in their metadata.
+//
+// JPEG, PNG, and GIF images are supported.
+package imagehash
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math/bits"
+)
+
+const (
+	hashWidth  = 9
+	hashHeight = 8
+)
+
+// images with more pixels than this are not decoded, to bound memory use
+const maxPixels = 1 << 28
+
+var ErrTooLarge = errors.New("image is too large")
+
+// Decodes an image, refusing images that are too large.
+func Decode(r io.Reader) (image.Image, error) {
+	// buffering lets us check the dimensions before decoding the whole
+	// image, without needing a seekable reader
+	br := newPeekReader(r)
+	config, _, err := image.DecodeConfig(br)
+	if err != nil {
+		return nil, err
+	}
+	if int64(config.Width)*int64(config.Height) > maxPixels {
+		return nil, ErrTooLarge
+	}
+	img, _, err := image.Decode(br.rewind())
+	return img, err
+}
+
+// Returns the perceptual hash of an image.
+func Hash(img image.Image) uint64 {
+	var cells [hashHeight][hashWidth]float64
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	// each cell is the average brightness of the corresponding region of
+	// the image; for tiny images, regions overlap
+	for cy := 0; cy < hashHeight; cy++ {
+		y0, y1 := span(cy, hashHeight, h)
+		for cx := 0; cx < hashWidth; cx++ {
+			x0, x1 := span(cx, hashWidth, w)
+			sum := 0.0
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					sum += luminance(img, bounds.Min.X+x, bounds.Min.Y+y)
+				}
+			}
+			cells[cy][cx] = sum / float64((x1-x0)*(y1-y0))
+		}
+	}
+	var hash uint64
+	for cy := 0; cy < hashHeight; cy++ {
+		for cx := 0; cx < hashWidth-1; cx++ {
+			hash <<= 1
+			if cells[cy][cx] > cells[cy][cx+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// the range of pixels [lo, hi) covered by cell i out of n, along a dimension
+// of the given length; never empty, as long as length > 0
+func span(i, n, length int) (lo, hi int) {
+	lo = i * length / n
+	hi = (i + 1) * length / n
+	if hi <= lo {
+		hi = lo + 1
+	}
+	if hi > length {
+		lo, hi = length-1, length
+	}
+	return lo, hi
+}
+
+func luminance(img image.Image, x, y int) float64 {
+	switch img := img.(type) {
+	case *image.YCbCr:
+		return float64(img.Y[img.YOffset(x, y)])
+	case *image.Gray:
+		return float64(img.GrayAt(x, y).Y)
+	}
+	return float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+}
+
+// Writes a canonical encoding of an image's pixels: its dimensions, followed
+// by the 16-bit RGBA values of every pixel, row by row. Images with the same
+// pixels have the same encoding, regardless of their file format or metadata.
+func WritePixels(w io.Writer, img image.Image) error {
+	bounds := img.Bounds()
+	var header [8]byte
+	binary.BigEndian.PutUint32(header[0:], uint32(bounds.Dx()))
+	binary.BigEndian.PutUint32(header[4:], uint32(bounds.Dy()))
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+	row := make([]byte, 8*bounds.Dx())
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, a := img.At(x, y).RGBA()
+			pixel := row[8*(x-bounds.Min.X):]
+			binary.BigEndian.PutUint16(pixel[0:], uint16(r))
+			binary.BigEndian.PutUint16(pixel[2:], uint16(g))
+			binary.BigEndian.PutUint16(pixel[4:], uint16(b))
+			binary.BigEndian.PutUint16(pixel[6:], uint16(a))
+		}
+		if _, err := w.Write(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reports whether two images have the same dimensions and pixels.
+func SamePixels(a, b image.Image) bool {
+	ba, bb := a.Bounds(), b.Bounds()
+	if ba.Dx() != bb.Dx() || ba.Dy() != bb.Dy() {
+		return false
+	}
+	for y := 0; y < ba.Dy(); y++ {
+		for x := 0; x < ba.Dx(); x++ {
+			r1, g1, b1, a1 := a.At(ba.Min.X+x, ba.Min.Y+y).RGBA()
+			r2, g2, b2, a2 := b.At(bb.Min.X+x, bb.Min.Y+y).RGBA()
+			if r1 != r2 || g1 != g2 || b1 != b2 || a1 != a2 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Returns the number of bits that differ between two hashes.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// records everything read from the underlying reader, so that it can be
+// replayed once
+type peekReader struct {
+	r   io.Reader
+	buf []byte
+}
+
+func newPeekReader(r io.Reader) *peekReader {
+	return &peekReader{r: r}
+}
+
+func (p *peekReader) Read(b []byte) (int, error) {
+	n, err := p.r.Read(b)
+	p.buf = append(p.buf, b[:n]...)
+	return n, err
+}
+
+// returns a reader that reads everything that was read so far, followed by
+// the rest of the underlying reader
+func (p *peekReader) rewind() io.Reader {
+	return io.MultiReader(bytes.NewReader(p.buf), p.r)
+}
+
+// A BK-tree of hashes, for finding all hashes within a given distance of a
+// hash without comparing against every hash.
+type Tree struct {
+	root *node
+}
+
+type node struct {
+	hash     uint64
+	values   []int
+	children map[int]*node // by distance from this node's hash
+}
+
+// Adds a hash to the tree, associated with the given value.
+func (t *Tree) Add(hash uint64, value int) {
+	if t.root == nil {
+		t.root = &node{hash: hash, values: []int{value}}
+		return
+	}
+	n := t.root
+	for {
+		d := Distance(hash, n.hash)
+		if d == 0 {
+			n.values = append(n.values, value)
+			return
+		}
+		child, ok := n.children[d]
+		if !ok {
+			if n.children == nil {
+				n.children = make(map[int]*node)
+			}
+			n.children[d] = &node{hash: hash, values: []int{value}}
+			return
+		}
+		n = child
+	}
+}
+
+// Calls f with the value of every hash in the tree that is within the given
+// distance of hash.
+func (t *Tree) Within(hash uint64, distance int, f func(value int)) {
+	if t.root == nil {
+		return
+	}
+	stack := []*node{t.root}
+	for len(stack) > 0 {
+		n := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		d := Distance(hash, n.hash)
+		if d <= distance {
+			for _, v := range n.values {
+				f(v)
+			}
+		}
+		// by the triangle inequality, only children at a distance in
+		// [d-distance, d+distance] can contain matches
+		for cd, child := range n.children {
+			if cd >= d-distance && cd <= d+distance {
+				stack = append(stack, child)
+			}
+		}
+	}
+}
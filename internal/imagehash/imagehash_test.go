@@ -0,0 +1,130 @@
+package imagehash
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+// a picture with some structure, drawn at the given size
+func picture(w, h int, inverted bool) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x)/float64(w), float64(y)/float64(h)
+			v := uint8(255 * (fx*fx + fy) / 2)
+			if (fx-0.3)*(fx-0.3)+(fy-0.6)*(fy-0.6) < 0.04 {
+				v = 255 - v
+			}
+			if inverted {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+	return img
+}
+
+func hashOf(t *testing.T, data []byte) uint64 {
+	t.Helper()
+	img, err := Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Hash(img)
+}
+
+func TestHashSimilar(t *testing.T) {
+	var original, resized, small bytes.Buffer
+	png.Encode(&original, picture(640, 480, false))
+	jpeg.Encode(&resized, picture(320, 240, false), &jpeg.Options{Quality: 50})
+	gif.Encode(&small, picture(64, 48, false), nil)
+	h := hashOf(t, original.Bytes())
+	if d := Distance(h, hashOf(t, resized.Bytes())); d > 4 {
+		t.Errorf("expected re-encoded image to be similar, got distance %d", d)
+	}
+	if d := Distance(h, hashOf(t, small.Bytes())); d > 4 {
+		t.Errorf("expected shrunk image to be similar, got distance %d", d)
+	}
+	var other bytes.Buffer
+	png.Encode(&other, picture(640, 480, true))
+	if d := Distance(h, hashOf(t, other.Bytes())); d < 20 {
+		t.Errorf("expected different image to be dissimilar, got distance %d", d)
+	}
+}
+
+func TestHashTinyImage(t *testing.T) {
+	var buf bytes.Buffer
+	png.Encode(&buf, picture(3, 2, false))
+	hashOf(t, buf.Bytes())
+}
+
+func TestDecodeNotImage(t *testing.T) {
+	_, err := Decode(bytes.NewReader([]byte("not an image")))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestPixels(t *testing.T) {
+	img := picture(40, 30, false)
+	// the same pixels, encoded differently
+	var encoded bytes.Buffer
+	png.Encode(&encoded, img)
+	decoded, err := Decode(&encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p1, p2 bytes.Buffer
+	WritePixels(&p1, img)
+	WritePixels(&p2, decoded)
+	if !bytes.Equal(p1.Bytes(), p2.Bytes()) {
+		t.Error("expected pixel encodings to be equal")
+	}
+	if !SamePixels(img, decoded) {
+		t.Error("expected same pixels")
+	}
+	changed := picture(40, 30, false).(*image.RGBA)
+	changed.Set(5, 5, color.RGBA{1, 2, 3, 255})
+	p2.Reset()
+	WritePixels(&p2, changed)
+	if bytes.Equal(p1.Bytes(), p2.Bytes()) {
+		t.Error("expected pixel encodings to differ")
+	}
+	if SamePixels(img, changed) {
+		t.Error("expected different pixels")
+	}
+	if SamePixels(img, picture(30, 40, false)) {
+		t.Error("expected images with different dimensions to differ")
+	}
+}
+
+func TestTreeWithin(t *testing.T) {
+	rng := rand.New(rand.NewSource(0))
+	hashes := make([]uint64, 500)
+	var tree Tree
+	for i := range hashes {
+		hashes[i] = rng.Uint64()
+		if i%10 == 0 && i > 0 {
+			// some hashes near earlier ones
+			hashes[i] = hashes[i-1] ^ (1 << uint(rng.Intn(64)))
+		}
+		tree.Add(hashes[i], i)
+	}
+	for _, distance := range []int{0, 1, 20, 30} {
+		for i, h := range hashes {
+			found := make(map[int]bool)
+			tree.Within(h, distance, func(v int) { found[v] = true })
+			for j, other := range hashes {
+				if want := Distance(h, other) <= distance; found[j] != want {
+					t.Fatalf("distance %d: hash %d vs %d: expected %v, got %v", distance, i, j, want, found[j])
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package periscope
+
+import (
+	"crypto/sha256"
+	"hash"
+	"sort"
+
+	"github.com/zeebo/xxh3"
+	"golang.org/x/crypto/blake2b"
+)
+
+// the content hash algorithm used for short and full hashes
+//
+// all hashes in a database must be computed with the same algorithm, so the
+// algorithm is recorded in the database
+type hashAlgorithm struct {
+	name string
+	// the name used by checksum tools in BSD-style ("--tag") output
+	tag string
+	// returns a new hash; if key is non-nil, the hash must depend on the
+	// key, so that hashes computed with different keys never collide
+	new func(key []byte) hash.Hash
+}
+
+const DefaultHashAlgorithm = "blake2b"
+
+// the meta key under which the algorithm is recorded
+const hashAlgorithmKey = "hash"
+
+var hashAlgorithms = map[string]*hashAlgorithm{
+	"blake2b": {
+		name: "blake2b",
+		tag:  "BLAKE2b-256",
+		new: func(key []byte) hash.Hash {
+			h, err := blake2b.New256(key)
+			if err != nil {
+				// only possible if the key is too long
+				panic(err)
+			}
+			return h
+		},
+	},
+	"sha256": {
+		name: "sha256",
+		tag:  "SHA256",
+		new: func(key []byte) hash.Hash {
+			return prefixed(sha256.New(), key)
+		},
+	},
+	"xxh3": {
+		name: "xxh3",
+		tag:  "XXH128",
+		new: func(key []byte) hash.Hash {
+			return prefixed(xxh128{xxh3.New()}, key)
+		},
+	},
+}
+
+// Returns the names of the supported hash algorithms.
+func HashAlgorithms() []string {
+	var names []string
+	for name := range hashAlgorithms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// keys a hash that doesn't support keys natively by hashing the key first
+func prefixed(h hash.Hash, key []byte) hash.Hash {
+	h.Write(key)
+	return h
+}
+
+// the 128-bit variant of XXH3, as computed by xxhsum -H2
+type xxh128 struct {
+	*xxh3.Hasher
+}
+
+func (h xxh128) Size() int {
+	return 16
+}
+
+func (h xxh128) Sum(b []byte) []byte {
+	sum := h.Sum128().Bytes()
+	return append(b, sum[:]...)
+}
@@ -0,0 +1,204 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/chunker"
+	"github.com/anishathalye/periscope/internal/db"
+	"github.com/anishathalye/periscope/internal/herror"
+	"github.com/anishathalye/periscope/internal/par"
+
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+type AnalyzeOptions struct {
+	// estimate how much space content-defined chunking would save
+	Chunks   bool
+	Relative bool
+}
+
+// Analyzes the files in the database, or the files in the given directory.
+//
+// With the Chunks option, every file is split into content-defined chunks (see
+// package chunker), like a deduplicating backup system would, and the amount
+// of data in chunks that are unique and chunks that are shared is reported,
+// overall and for each directory. Chunks are stored in the database, so only
+// files that are new or have changed since the last analysis are read.
+func (ps *Periscope) Analyze(dir string, options *AnalyzeOptions) herror.Interface {
+	if !options.Chunks {
+		return herror.UserF(nil, "nothing to analyze (use --chunks)")
+	}
+	var absDir string
+	if dir != "" {
+		var err herror.Interface
+		absDir, _, err = ps.checkFile(dir, false, true, "analyze", false, true)
+		if err != nil {
+			return err
+		}
+	}
+	if err := ps.chunkFiles(absDir); err != nil {
+		return err
+	}
+	summary, dirs, err := ps.db.ChunkSummary(absDir)
+	if err != nil {
+		return err
+	}
+	var refDir string
+	if options.Relative {
+		var err error
+		refDir, err = filepath.Abs(dir) // if dir == "", this will treat it like dir = "."
+		if err != nil {
+			return herror.Internal(err, "")
+		}
+	}
+	w := tabwriter.NewWriter(ps.outStream, 0, 0, 0, ' ', tabwriter.DiscardEmptyColumns|tabwriter.AlignRight)
+	fmt.Fprintf(w, "files\v %s\v\n", humanize.Comma(summary.Files))
+	fmt.Fprintf(w, "chunks\v %s\v\n", humanize.Comma(summary.Chunks))
+	fmt.Fprintf(w, "total\v %s\v\n", humanize.Bytes(uint64(summary.Total)))
+	fmt.Fprintf(w, "unique\v %s\v\n", humanize.Bytes(uint64(summary.Unique)))
+	fmt.Fprintf(w, "shared\v %s\v\n", humanize.Bytes(uint64(summary.Total-summary.Unique)))
+	fmt.Fprintf(w, "deduplicated\v %s\v\n", humanize.Bytes(uint64(summary.Deduplicated)))
+	fmt.Fprintf(w, "savings\v %s\v%s\n", humanize.Bytes(uint64(summary.Total-summary.Deduplicated)), percent(summary.Total-summary.Deduplicated, summary.Total))
+	w.Flush()
+	if len(dirs) == 0 {
+		return nil
+	}
+	fmt.Fprintf(ps.outStream, "\n")
+	w = tabwriter.NewWriter(ps.outStream, 0, 0, 0, ' ', tabwriter.DiscardEmptyColumns|tabwriter.AlignRight)
+	fmt.Fprintf(w, "unique\v shared\v  directory\n")
+	for _, d := range dirs {
+		path := d.Path
+		if options.Relative {
+			path = relPath(refDir, path)
+		}
+		fmt.Fprintf(w, "%s\v %s\v  %s\n", humanize.Bytes(uint64(d.Unique)), humanize.Bytes(uint64(d.Total-d.Unique)), path)
+	}
+	w.Flush()
+	return nil
+}
+
+// formats a fraction as " (n%)", or "" if it's undefined
+func percent(part, total int64) string {
+	if total == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%.0f%%)", 100*float64(part)/float64(total))
+}
+
+type chunkedFile struct {
+	id     int64
+	chunks []db.Chunk
+}
+
+// chunks the files with the given directory prefix (or all files, if dir is
+// "") that haven't been chunked yet, and records their chunks in the database
+func (ps *Periscope) chunkFiles(dir string) herror.Interface {
+	if err := ps.db.DeleteOrphanedChunks(); err != nil {
+		return err
+	}
+	files, err := ps.db.Unchunked(dir)
+	if err != nil {
+		return err
+	}
+	var todo []db.UnchunkedFile
+	for _, file := range files {
+		// files inside archives can't be read directly
+		if !isArchiveMember(file.Info.Path) {
+			todo = append(todo, file)
+		}
+	}
+	limiter, err := ps.newIOLimiter(nil)
+	if err != nil {
+		return err
+	}
+	devices := make(map[int64]struct{})
+	for _, file := range todo {
+		devices[file.Info.Device] = struct{}{}
+	}
+	bar := ps.progressBar(len(todo), `chunking: {{ counters . }} {{ bar . "[" "=" ">" " " "]" }} {{ etime . }} {{ rtime . "ETA %s" "%.0s" " " }} `)
+	results := par.MapN(todo, limiter.total(devices), func(_, v interface{}, emit func(x interface{})) {
+		file := v.(db.UnchunkedFile)
+		release := limiter.acquire(file.Info.Device)
+		chunks, err := ps.chunkFile(file.Info.Path)
+		release()
+		bar.Increment()
+		if err != nil {
+			log.Printf("unable to chunk '%s': %s", file.Info.Path, err)
+			return
+		}
+		var size int64
+		for _, chunk := range chunks {
+			size += chunk.Size
+		}
+		if size != file.Info.Size {
+			fmt.Fprintf(ps.errStream, "WARNING: '%s' has changed since it was scanned; rescan it to include it in the analysis\n", file.Info.Path)
+			return
+		}
+		emit(chunkedFile{id: file.Id, chunks: chunks})
+	})
+	// like scan, results are committed in batches, so the work that's
+	// been done isn't lost if the analysis is interrupted
+	tx, err := ps.db.Begin()
+	if err != nil {
+		return err
+	}
+	pending := 0
+	lastCheckpoint := time.Now()
+	for result := range results {
+		if err != nil {
+			continue // drain the remaining results
+		}
+		file := result.(chunkedFile)
+		if err = tx.AddChunks(file.id, file.chunks); err != nil {
+			tx.Rollback()
+			continue
+		}
+		pending++
+		if pending >= checkpointSize || time.Since(lastCheckpoint) >= checkpointInterval {
+			if err = tx.Commit(); err != nil {
+				continue
+			}
+			if tx, err = ps.db.Begin(); err != nil {
+				continue
+			}
+			pending = 0
+			lastCheckpoint = time.Now()
+		}
+	}
+	bar.Finish()
+	if err != nil {
+		return err
+	}
+	if err = tx.CreateIndexes(); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (ps *Periscope) chunkFile(path string) ([]db.Chunk, error) {
+	f, err := ps.open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var chunks []db.Chunk
+	c := chunker.New(f)
+	for {
+		data, err := c.Next()
+		if err == io.EOF {
+			return chunks, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		h := ps.hash.new(nil)
+		h.Write(data)
+		chunks = append(chunks, db.Chunk{Size: int64(len(data)), Hash: h.Sum(nil)})
+	}
+}
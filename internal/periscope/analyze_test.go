@@ -0,0 +1,162 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/testfs"
+
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func randomBytes(seed int64, n int) []byte {
+	data := make([]byte, n)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func TestAnalyzeChunks(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	// the images differ by an insertion, so they share most of their
+	// chunks, and the documents are duplicates
+	image := randomBytes(1, 2*1024*1024)
+	edited := append(append(append([]byte(nil), image[:300000]...), randomBytes(2, 1000)...), image[300000:]...)
+	afero.WriteFile(fs, "/vm/a.img", image, 0o644)
+	afero.WriteFile(fs, "/vm/b.img", edited, 0o644)
+	doc := randomBytes(3, 100000)
+	afero.WriteFile(fs, "/docs/x", doc, 0o644)
+	afero.WriteFile(fs, "/docs/y", doc, 0o644)
+	afero.WriteFile(fs, "/docs/empty", nil, 0o644)
+	afero.WriteFile(fs, "/other/z", randomBytes(4, 50000), 0o644)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Analyze("", &AnalyzeOptions{Chunks: true})
+	check(t, err)
+	summary, dirs, err := ps.db.ChunkSummary("")
+	check(t, err)
+	total := int64(2*2*1024*1024 + 1000 + 2*100000 + 50000)
+	if summary.Files != 5 || summary.Total != total {
+		t.Fatalf("expected 5 files with %d bytes, got %+v", total, summary)
+	}
+	// the duplicates are entirely shared, and the images are mostly
+	// shared
+	if summary.Unique > 50000+2*256*1024 {
+		t.Fatalf("expected most data to be shared, got %+v", summary)
+	}
+	if summary.Deduplicated < total/2 || summary.Deduplicated > total/2+50000+2*256*1024 {
+		t.Fatalf("expected deduplication to save about half, got %+v", summary)
+	}
+	if len(dirs) != 3 || dirs[0].Path != "/docs" || dirs[1].Path != "/other" || dirs[2].Path != "/vm" {
+		t.Fatalf("unexpected directories %+v", dirs)
+	}
+	if dirs[0].Total != 200000 || dirs[0].Unique != 0 {
+		t.Fatalf("expected duplicates to be entirely shared, got %+v", dirs[0])
+	}
+	if dirs[1].Total != 50000 || dirs[1].Unique != 50000 {
+		t.Fatalf("expected unrelated file to be entirely unique, got %+v", dirs[1])
+	}
+	got := out.String()
+	for _, line := range []string{"       files ", "      chunks ", "     savings ", "unique shared  directory\n", "  0 B 200 kB  /docs\n", "50 kB    0 B  /other\n"} {
+		if !strings.Contains(got, line) {
+			t.Fatalf("expected output to contain '%s', got '%s'", line, got)
+		}
+	}
+}
+
+func TestAnalyzeChunksDirectory(t *testing.T) {
+	fs := testfs.Read(`
+/docs/x [100000 3]
+/docs/y [100000 3]
+/other/z [50000 4]
+	`).Mkfs()
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Analyze("/docs", &AnalyzeOptions{Chunks: true, Relative: true})
+	check(t, err)
+	// only the files in the directory are chunked
+	unchunked, _ := ps.db.Unchunked("")
+	if len(unchunked) != 1 {
+		t.Fatalf("expected 1 unchunked file, got %d", len(unchunked))
+	}
+	got := strings.TrimSpace(out.String())
+	expected := strings.TrimSpace(`
+       files      2
+      chunks      4
+       total 200 kB
+      unique    0 B
+      shared 200 kB
+deduplicated 100 kB
+     savings 100 kB (50%)
+
+unique shared  directory
+   0 B 200 kB  .
+	`)
+	if got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+}
+
+func TestAnalyzeChunksRescan(t *testing.T) {
+	fs := testfs.Read(`
+/docs/x [100000 3]
+/docs/y [100000 3]
+/other/z [50000 4]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	check(t, ps.Analyze("", &AnalyzeOptions{Chunks: true}))
+	before, _, _ := ps.db.ChunkSummary("")
+	unchunked, _ := ps.db.Unchunked("")
+	if len(unchunked) != 0 {
+		t.Fatalf("expected all files to be chunked, got %d unchunked", len(unchunked))
+	}
+	// files that haven't changed keep their chunks when they're rescanned,
+	// so they aren't chunked again
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	unchunked, _ = ps.db.Unchunked("")
+	if len(unchunked) != 0 {
+		t.Fatalf("expected rescanned files to stay chunked, got %d unchunked", len(unchunked))
+	}
+	check(t, ps.Analyze("", &AnalyzeOptions{Chunks: true}))
+	again, _, _ := ps.db.ChunkSummary("")
+	if again != before {
+		t.Fatalf("expected the same summary, got %+v before and %+v after", before, again)
+	}
+	// files that have changed are chunked again, and their old chunks are
+	// removed
+	afero.WriteFile(fs, "/other/z", bytes.Repeat([]byte("z"), 50000), 0o644)
+	ps.Scan([]string{"/other"}, &ScanOptions{})
+	check(t, ps.Analyze("", &AnalyzeOptions{Chunks: true}))
+	after, _, _ := ps.db.ChunkSummary("")
+	if after.Files != before.Files || after.Total != before.Total {
+		t.Fatalf("expected the same totals, got %+v before and %+v after", before, after)
+	}
+}
+
+func TestAnalyzeChanged(t *testing.T) {
+	fs := testfs.Read(`
+/docs/x [100000 3]
+/docs/y [100000 3]
+/other/z [50000 4]
+	`).Mkfs()
+	ps, _, errStream := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	afero.WriteFile(fs, "/other/z", []byte("changed"), 0o644)
+	check(t, ps.Analyze("", &AnalyzeOptions{Chunks: true}))
+	if !strings.Contains(errStream.String(), "'/other/z' has changed") {
+		t.Fatalf("expected warning, got '%s'", errStream.String())
+	}
+	summary, _, _ := ps.db.ChunkSummary("")
+	if summary.Files != 2 {
+		t.Fatalf("expected changed file to be left out, got %+v", summary)
+	}
+}
+
+func TestAnalyzeNothing(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	ps, _, _ := newTest(fs)
+	err := ps.Analyze("", &AnalyzeOptions{})
+	checkErr(t, err)
+}
@@ -0,0 +1,307 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/db"
+
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// files inside archives are given virtual paths, where the path of the member
+// within the archive follows the path of the archive, separated by "!/"; for
+// example, "/backups/a.zip!/photos/x.jpg"
+const archiveSeparator = "!/"
+
+type archiveFormat int
+
+const (
+	notArchive archiveFormat = iota
+	zipArchive
+	tarArchive
+	tarGzArchive
+)
+
+func archiveFormatOf(path string) archiveFormat {
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".zip"):
+		return zipArchive
+	case strings.HasSuffix(lower, ".tar"):
+		return tarArchive
+	case strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz"):
+		return tarGzArchive
+	}
+	return notArchive
+}
+
+// splits a virtual path into the path of the archive and the path of the
+// member within the archive
+func splitArchivePath(virtualPath string) (archive, member string, ok bool) {
+	for i := 0; ; {
+		j := strings.Index(virtualPath[i:], archiveSeparator)
+		if j < 0 {
+			return "", "", false
+		}
+		i += j
+		if archiveFormatOf(virtualPath[:i]) != notArchive {
+			return virtualPath[:i], virtualPath[i+len(archiveSeparator):], true
+		}
+		i += len(archiveSeparator)
+	}
+}
+
+func isArchiveMember(virtualPath string) bool {
+	_, _, ok := splitArchivePath(virtualPath)
+	return ok
+}
+
+// the directory in the database that contains all of an archive's members
+func archiveMembersDir(archive string) string {
+	return archive + strings.TrimSuffix(archiveSeparator, "/")
+}
+
+// normalizes the name of a member, returning false for names that can't be
+// represented as a virtual path (like ones that escape the archive)
+func cleanMemberName(name string) (string, bool) {
+	name = path.Clean("/" + name)[1:]
+	if name == "" || strings.Contains(name, archiveSeparator) {
+		return "", false
+	}
+	return name, true
+}
+
+// reads the regular files in an archive, in order
+type archiveReader struct {
+	file afero.File
+	gz   *gzip.Reader
+	// for zip archives
+	zipFiles []*zip.File
+	current  io.ReadCloser
+	// for tar archives
+	tar *tar.Reader
+}
+
+func (ps *Periscope) openArchive(archive string) (*archiveReader, error) {
+	format := archiveFormatOf(archive)
+	f, err := ps.fs.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+	ar := &archiveReader{file: f}
+	switch format {
+	case zipArchive:
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		zr, err := zip.NewReader(f, info.Size())
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		ar.zipFiles = zr.File
+	case tarGzArchive:
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		ar.gz = gz
+		ar.tar = tar.NewReader(gz)
+	default:
+		ar.tar = tar.NewReader(f)
+	}
+	return ar, nil
+}
+
+// advances to the next regular file in the archive, returning its cleaned
+// name, its size, and a reader for its contents that is valid until the next
+// call to next; returns io.EOF at the end of the archive
+func (ar *archiveReader) next() (name string, size int64, r io.Reader, err error) {
+	if ar.current != nil {
+		ar.current.Close()
+		ar.current = nil
+	}
+	if ar.tar == nil {
+		for len(ar.zipFiles) > 0 {
+			zf := ar.zipFiles[0]
+			ar.zipFiles = ar.zipFiles[1:]
+			if !zf.Mode().IsRegular() {
+				continue
+			}
+			name, ok := cleanMemberName(zf.Name)
+			if !ok {
+				continue
+			}
+			rc, err := zf.Open()
+			if err != nil {
+				return "", 0, nil, err
+			}
+			ar.current = rc
+			return name, int64(zf.UncompressedSize64), rc, nil
+		}
+		return "", 0, nil, io.EOF
+	}
+	for {
+		hdr, err := ar.tar.Next()
+		if err != nil {
+			return "", 0, nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		name, ok := cleanMemberName(hdr.Name)
+		if !ok {
+			continue
+		}
+		return name, hdr.Size, ar.tar, nil
+	}
+}
+
+func (ar *archiveReader) Close() error {
+	if ar.current != nil {
+		ar.current.Close()
+	}
+	if ar.gz != nil {
+		ar.gz.Close()
+	}
+	return ar.file.Close()
+}
+
+// a member of an open archive; closing it closes the archive
+type memberReader struct {
+	io.Reader
+	archive *archiveReader
+}
+
+func (m *memberReader) Close() error {
+	return m.archive.Close()
+}
+
+// opens a file, which may be an archive member
+//
+// archive members are not seekable, and opening one may require reading
+// through the archive up to the member
+func (ps *Periscope) open(virtualPath string) (io.ReadCloser, error) {
+	archive, member, ok := splitArchivePath(virtualPath)
+	if !ok {
+		return ps.fs.Open(virtualPath)
+	}
+	ar, err := ps.openArchive(archive)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		name, _, r, err := ar.next()
+		if err == io.EOF {
+			ar.Close()
+			return nil, &os.PathError{Op: "open", Path: virtualPath, Err: os.ErrNotExist}
+		}
+		if err != nil {
+			ar.Close()
+			return nil, err
+		}
+		if name == member {
+			return &memberReader{Reader: r, archive: ar}, nil
+		}
+	}
+}
+
+// hashes every member of an archive, emitting infos with all hashes filled in
+//
+// members can only be read sequentially, so all the hashes are computed in a
+// single pass, rather than lazily like for regular files; members get the
+// archive's stat metadata, except that their inode is unknown
+func (ps *Periscope) hashArchive(archive *db.FileInfo, options *ScanOptions, emit func(info db.FileInfo)) error {
+	ar, err := ps.openArchive(archive.Path)
+	if err != nil {
+		return err
+	}
+	defer ar.Close()
+	for {
+		name, size, r, err := ar.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !(size > options.Minimum && (options.Maximum == 0 || size <= options.Maximum)) {
+			continue
+		}
+		info := db.FileInfo{
+			Path:   archive.Path + archiveSeparator + name,
+			Size:   size,
+			Mtime:  archive.Mtime,
+			Ctime:  archive.Ctime,
+			Device: archive.Device,
+		}
+		info.ShortHash, info.SampleHash, info.FullHash, err = ps.hashStream(r, size)
+		if err != nil {
+			return fmt.Errorf("%s: %s", info.Path, err)
+		}
+		emit(info)
+	}
+}
+
+// computes the short hash, sample hash (if applicable), and full hash of a
+// file from a single sequential read; the hashes are the same as the ones
+// hashPartial, hashSamples, and hashFile compute
+func (ps *Periscope) hashStream(r io.Reader, size int64) (shortHash, sampleHash, fullHash []byte, err error) {
+	szBuf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(szBuf, uint64(size))
+	short := ps.hash.new(szBuf)
+	full := ps.hash.new(nil)
+	var sampleOffsets []int64
+	var samples [][]byte
+	if needsSampleHash(size) {
+		sampleOffsets = []int64{(size - sampleChunkSize) / 2, size - sampleChunkSize}
+		samples = make([][]byte, len(sampleOffsets))
+	}
+	buf := make([]byte, readChunkSize)
+	var offset int64
+	for {
+		n, err := r.Read(buf)
+		chunk := buf[:n]
+		if offset < initialChunkSize {
+			short.Write(chunk[:min(int64(n), initialChunkSize-offset)])
+		}
+		for i, start := range sampleOffsets {
+			// the part of this chunk in [start, start+sampleChunkSize)
+			lo := max(start, offset)
+			hi := min(start+sampleChunkSize, offset+int64(n))
+			if lo < hi {
+				samples[i] = append(samples[i], chunk[lo-offset:hi-offset]...)
+			}
+		}
+		full.Write(chunk)
+		offset += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+	if offset != size {
+		return nil, nil, nil, fmt.Errorf("expected %d bytes, read %d", size, offset)
+	}
+	if sampleOffsets != nil {
+		h := ps.hash.new(szBuf)
+		for _, sample := range samples {
+			h.Write(sample)
+		}
+		sampleHash = h.Sum(nil)[:ShortHashSize]
+	}
+	return short.Sum(nil)[:ShortHashSize], sampleHash, full.Sum(nil), nil
+}
@@ -0,0 +1,269 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/db"
+
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func writeZip(fs afero.Fs, path string, members map[string][]byte) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, data := range members {
+		f, _ := w.Create(name)
+		f.Write(data)
+	}
+	w.Close()
+	afero.WriteFile(fs, path, buf.Bytes(), 0o644)
+}
+
+func writeTarGz(fs afero.Fs, path string, members map[string][]byte) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	w := tar.NewWriter(gz)
+	for name, data := range members {
+		w.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+		w.Write(data)
+	}
+	w.Close()
+	gz.Close()
+	afero.WriteFile(fs, path, buf.Bytes(), 0o644)
+}
+
+func TestSplitArchivePath(t *testing.T) {
+	cases := []struct {
+		path    string
+		archive string
+		member  string
+		ok      bool
+	}{
+		{"/a/b.zip!/c/d", "/a/b.zip", "c/d", true},
+		{"/a/b.TAR.GZ!/c", "/a/b.TAR.GZ", "c", true},
+		{"/a!/b.tgz!/c", "/a!/b.tgz", "c", true},
+		{"/a/b.zip", "", "", false},
+		{"/a/b!/c", "", "", false},
+	}
+	for _, c := range cases {
+		archive, member, ok := splitArchivePath(c.path)
+		if archive != c.archive || member != c.member || ok != c.ok {
+			t.Errorf("splitArchivePath(%q): expected (%q, %q, %v), got (%q, %q, %v)", c.path, c.archive, c.member, c.ok, archive, member, ok)
+		}
+	}
+}
+
+func TestHashStream(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	ps, _, _ := newTest(fs)
+	for _, size := range []int{10, initialChunkSize + 1, sampleThreshold + 12345} {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i * 7)
+		}
+		afero.WriteFile(fs, "/f", data, 0o644)
+		short, sample, full, err := ps.hashStream(bytes.NewReader(data), int64(size))
+		check(t, err)
+		szBuf := make([]byte, 8)
+		binary.LittleEndian.PutUint64(szBuf, uint64(size))
+		refShort, _ := ps.hashPartial("/f", szBuf)
+		refFull, _ := ps.hashFile("/f")
+		if !bytes.Equal(short, refShort) || !bytes.Equal(full, refFull) {
+			t.Fatalf("size %d: hashes differ from hashPartial/hashFile", size)
+		}
+		if needsSampleHash(int64(size)) {
+			refSample, _ := ps.hashSamples("/f", int64(size), szBuf)
+			if !bytes.Equal(sample, refSample) {
+				t.Fatalf("size %d: sample hash differs from hashSamples", size)
+			}
+		} else if sample != nil {
+			t.Fatalf("size %d: expected no sample hash", size)
+		}
+	}
+}
+
+func TestScanArchives(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	photo := bytes.Repeat([]byte("photo"), 1000)
+	doc := bytes.Repeat([]byte("doc"), 1000)
+	afero.WriteFile(fs, "/photos/x.jpg", photo, 0o644)
+	afero.WriteFile(fs, "/docs/y.txt", doc, 0o644)
+	writeZip(fs, "/backups/a.zip", map[string][]byte{"photos/x.jpg": photo, "other": []byte("other")})
+	writeTarGz(fs, "/backups/b.tar.gz", map[string][]byte{"./docs/y.txt": doc})
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{Archives: true})
+	check(t, err)
+	got, _ := ps.db.AllDuplicates("")
+	expected := []db.DuplicateSet{
+		{
+			{Path: "/backups/a.zip!/photos/x.jpg", Size: 5000, ShortHash: dummyHash, FullHash: dummyHash},
+			{Path: "/photos/x.jpg", Size: 5000, ShortHash: dummyHash, FullHash: dummyHash},
+		},
+		{
+			{Path: "/backups/b.tar.gz!/docs/y.txt", Size: 3000, ShortHash: dummyHash, FullHash: dummyHash},
+			{Path: "/docs/y.txt", Size: 3000, ShortHash: dummyHash, FullHash: dummyHash},
+		},
+	}
+	checkEquivalentDuplicateSet(t, expected, got)
+
+	// without --archives, members are dropped from the database
+	err = ps.Scan([]string{"/"}, &ScanOptions{})
+	check(t, err)
+	got, _ = ps.db.AllDuplicates("")
+	if len(got) != 0 {
+		t.Fatalf("expected no duplicates, got %v", got)
+	}
+}
+
+func TestRmArchiveMember(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	photo := bytes.Repeat([]byte("photo"), 1000)
+	afero.WriteFile(fs, "/x.jpg", photo, 0o644)
+	writeZip(fs, "/a.zip", map[string][]byte{"x.jpg": photo})
+	ps, _, errStream := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{Archives: true})
+	err := ps.Rm([]string{"/a.zip!/x.jpg"}, &RmOptions{})
+	checkErr(t, err)
+	if !strings.Contains(errStream.String(), "inside an archive") {
+		t.Fatalf("expected error about archive, got '%s'", errStream.String())
+	}
+	if _, err := fs.Stat("/x.jpg"); err != nil {
+		t.Fatal("expected /x.jpg to be kept")
+	}
+}
+
+func TestRmCopyOfArchiveMember(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	photo := bytes.Repeat([]byte("photo"), 1000)
+	afero.WriteFile(fs, "/photos/x.jpg", photo, 0o644)
+	writeTarGz(fs, "/a.tgz", map[string][]byte{"x.jpg": photo})
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{Archives: true})
+	err := ps.Rm([]string{"/photos"}, &RmOptions{Recursive: true, Paranoid: true})
+	check(t, err)
+	if _, err := fs.Stat("/photos/x.jpg"); !os.IsNotExist(err) {
+		t.Fatal("expected /photos/x.jpg to be removed")
+	}
+	if _, err := fs.Stat("/a.tgz"); err != nil {
+		t.Fatal("expected /a.tgz to be kept")
+	}
+}
+
+func TestRmCopyOfChangedArchive(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	photo := bytes.Repeat([]byte("photo"), 1000)
+	afero.WriteFile(fs, "/x.jpg", photo, 0o644)
+	writeZip(fs, "/a.zip", map[string][]byte{"x.jpg": photo})
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{Archives: true})
+	// the member is gone, so it doesn't count as a copy
+	writeZip(fs, "/a.zip", map[string][]byte{"y.jpg": photo})
+	err := ps.Rm([]string{"/x.jpg"}, &RmOptions{})
+	checkErr(t, err)
+	if _, err := fs.Stat("/x.jpg"); err != nil {
+		t.Fatal("expected /x.jpg to be kept")
+	}
+}
+
+func TestRmArchiveForgetsMembers(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	photo := bytes.Repeat([]byte("photo"), 1000)
+	writeZip(fs, "/a.zip", map[string][]byte{"x.jpg": photo})
+	data, _ := afero.ReadFile(fs, "/a.zip")
+	afero.WriteFile(fs, "/b.zip", data, 0o644)
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{Archives: true})
+	err := ps.Rm([]string{"/a.zip"}, &RmOptions{})
+	check(t, err)
+	infos, _ := ps.db.AllInfos()
+	for _, info := range infos {
+		if strings.HasPrefix(info.Path, "/a.zip") {
+			t.Fatalf("expected %s to be removed from the database", info.Path)
+		}
+	}
+}
+
+func TestRefreshArchive(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	photo := bytes.Repeat([]byte("photo"), 1000)
+	afero.WriteFile(fs, "/x.jpg", photo, 0o644)
+	writeZip(fs, "/a.zip", map[string][]byte{"x.jpg": photo})
+	writeZip(fs, "/b.zip", map[string][]byte{"x.jpg": photo})
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{Archives: true})
+	fs.Remove("/a.zip")
+	err := ps.Refresh(&RefreshOptions{})
+	check(t, err)
+	got, _ := ps.db.AllDuplicates("")
+	expected := []db.DuplicateSet{{
+		{Path: "/b.zip!/x.jpg", Size: 5000, ShortHash: dummyHash, FullHash: dummyHash},
+		{Path: "/x.jpg", Size: 5000, ShortHash: dummyHash, FullHash: dummyHash},
+	}}
+	checkEquivalentDuplicateSet(t, expected, got)
+}
+
+func TestScanUnchangedArchive(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	photo := bytes.Repeat([]byte("photo"), 1000)
+	afero.WriteFile(fs, "/x.jpg", photo, 0o644)
+	writeZip(fs, "/a.zip", map[string][]byte{"x.jpg": photo})
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{Archives: true})
+	check(t, err)
+	expected := []db.DuplicateSet{{
+		{Path: "/a.zip!/x.jpg", Size: 5000, ShortHash: dummyHash, FullHash: dummyHash},
+		{Path: "/x.jpg", Size: 5000, ShortHash: dummyHash, FullHash: dummyHash},
+	}}
+
+	// the archive is unreadable now, but its stat metadata is unchanged,
+	// so it isn't read again, and its members are carried over
+	stat, _ := fs.Stat("/a.zip")
+	size, mtime := stat.Size(), stat.ModTime()
+	afero.WriteFile(fs, "/a.zip", make([]byte, size), 0o644)
+	fs.Chtimes("/a.zip", mtime, mtime)
+	err = ps.Scan([]string{"/"}, &ScanOptions{Archives: true})
+	check(t, err)
+	got, _ := ps.db.AllDuplicates("")
+	checkEquivalentDuplicateSet(t, expected, got)
+
+	// with a different size range, it's read again
+	err = ps.Scan([]string{"/"}, &ScanOptions{Archives: true, Minimum: 1})
+	check(t, err)
+	got, _ = ps.db.AllDuplicates("")
+	if len(got) != 0 {
+		t.Fatalf("expected no duplicates, got %v", got)
+	}
+}
+
+func TestScanChangedArchive(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	photo := bytes.Repeat([]byte("photo"), 1000)
+	doc := bytes.Repeat([]byte("doc"), 1000)
+	afero.WriteFile(fs, "/x.jpg", photo, 0o644)
+	afero.WriteFile(fs, "/y.txt", doc, 0o644)
+	writeZip(fs, "/a.zip", map[string][]byte{"x.jpg": photo})
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{Archives: true})
+	check(t, err)
+
+	stat, _ := fs.Stat("/a.zip")
+	later := stat.ModTime().Add(time.Second)
+	writeZip(fs, "/a.zip", map[string][]byte{"y.txt": doc})
+	fs.Chtimes("/a.zip", later, later)
+	err = ps.Scan([]string{"/"}, &ScanOptions{Archives: true})
+	check(t, err)
+	got, _ := ps.db.AllDuplicates("")
+	expected := []db.DuplicateSet{{
+		{Path: "/a.zip!/y.txt", Size: 3000, ShortHash: dummyHash, FullHash: dummyHash},
+		{Path: "/y.txt", Size: 3000, ShortHash: dummyHash, FullHash: dummyHash},
+	}}
+	checkEquivalentDuplicateSet(t, expected, got)
+}
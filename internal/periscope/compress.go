@@ -0,0 +1,86 @@
+package periscope
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+type compression int
+
+const (
+	notCompressed compression = iota
+	gzipCompressed
+	bzip2Compressed
+	xzCompressed
+	zstdCompressed
+)
+
+// judges by the file name; the contents are checked when decompressing
+func compressionOf(path string) compression {
+	lower := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lower, ".gz") || strings.HasSuffix(lower, ".tgz"):
+		return gzipCompressed
+	case strings.HasSuffix(lower, ".bz2") || strings.HasSuffix(lower, ".tbz2"):
+		return bzip2Compressed
+	case strings.HasSuffix(lower, ".xz") || strings.HasSuffix(lower, ".txz"):
+		return xzCompressed
+	case strings.HasSuffix(lower, ".zst") || strings.HasSuffix(lower, ".tzst"):
+		return zstdCompressed
+	}
+	return notCompressed
+}
+
+func decompressor(c compression, r io.Reader) (io.Reader, func(), error) {
+	switch c {
+	case gzipCompressed:
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return gz, func() { gz.Close() }, nil
+	case bzip2Compressed:
+		return bzip2.NewReader(r), func() {}, nil
+	case xzCompressed:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		return xr, func() {}, nil
+	case zstdCompressed:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, nil, err
+		}
+		return zr, zr.Close, nil
+	}
+	return r, func() {}, nil
+}
+
+// decompresses a compressed file, returning the full hash and size of the
+// decompressed contents; the hash is the same as the one hashFile computes
+// for an uncompressed copy
+func (ps *Periscope) hashDecompressed(path string) ([]byte, int64, error) {
+	f, err := ps.open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	r, closeDecompressor, err := decompressor(compressionOf(path), f)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer closeDecompressor()
+	h := ps.hash.new(nil)
+	buf := make([]byte, readChunkSize)
+	size, err := io.CopyBuffer(h, r, buf)
+	if err != nil {
+		return nil, 0, err
+	}
+	return h.Sum(nil), size, nil
+}
@@ -0,0 +1,179 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/db"
+
+	"bytes"
+	"compress/gzip"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/afero"
+	"github.com/ulikunitz/xz"
+)
+
+// "hello, compressed world\n", compressed with bzip2 -9
+var bzip2Hello = []byte{
+	0x42, 0x5a, 0x68, 0x39, 0x31, 0x41, 0x59, 0x26, 0x53, 0x59, 0x8b, 0x72,
+	0x22, 0x3f, 0x00, 0x00, 0x05, 0x51, 0x80, 0x00, 0x10, 0x40, 0x04, 0x0e,
+	0x46, 0xd8, 0x80, 0x20, 0x00, 0x22, 0x9a, 0x3d, 0x27, 0xa9, 0xe9, 0x3d,
+	0x21, 0x00, 0x00, 0x06, 0xe1, 0x0e, 0x96, 0x9a, 0x81, 0x26, 0x2a, 0xdd,
+	0xcd, 0x1f, 0x7c, 0x5d, 0xc9, 0x14, 0xe1, 0x42, 0x42, 0x2d, 0xc8, 0x88,
+	0xfc,
+}
+
+func gzipped(data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func xzed(data []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := xz.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func zstded(data []byte) []byte {
+	var buf bytes.Buffer
+	w, _ := zstd.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestHashDecompressed(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	hello := []byte("hello, compressed world\n")
+	afero.WriteFile(fs, "/hello", hello, 0o644)
+	afero.WriteFile(fs, "/hello.gz", gzipped(hello), 0o644)
+	afero.WriteFile(fs, "/hello.bz2", bzip2Hello, 0o644)
+	afero.WriteFile(fs, "/hello.xz", xzed(hello), 0o644)
+	afero.WriteFile(fs, "/hello.ZST", zstded(hello), 0o644)
+	ps, _, _ := newTest(fs)
+	expected, _ := ps.hashFile("/hello")
+	for _, path := range []string{"/hello.gz", "/hello.bz2", "/hello.xz", "/hello.ZST"} {
+		hash, size, err := ps.hashDecompressed(path)
+		check(t, err)
+		if !bytes.Equal(hash, expected) || size != int64(len(hello)) {
+			t.Errorf("%s: expected the hash and size of the decompressed contents", path)
+		}
+	}
+	afero.WriteFile(fs, "/corrupt.gz", []byte("not gzip"), 0o644)
+	if _, _, err := ps.hashDecompressed("/corrupt.gz"); err == nil {
+		t.Error("expected error for corrupt file")
+	}
+}
+
+// checks that the compressed copies found are the given pairs of
+// uncompressed and compressed paths
+func expectCompressedCopies(t *testing.T, ps *Periscope, expected ...string) {
+	t.Helper()
+	sets, _ := ps.db.AllEquivalent(db.SameDecompressed, "")
+	var got []string
+	for _, set := range sets {
+		var paths []string
+		for _, info := range set {
+			paths = append(paths, info.Path)
+		}
+		got = append(got, strings.Join(paths, " "))
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected compressed copies %v, got %v", expected, got)
+	}
+}
+
+func TestScanDecompress(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	dump := bytes.Repeat([]byte("INSERT INTO t VALUES (1);\n"), 1000)
+	log := bytes.Repeat([]byte("GET /index.html 200\n"), 500)
+	afero.WriteFile(fs, "/data/dump.sql", dump, 0o644)
+	afero.WriteFile(fs, "/backup/dump.sql.gz", gzipped(dump), 0o644)
+	afero.WriteFile(fs, "/data/log.txt", log, 0o644)
+	afero.WriteFile(fs, "/backup/log.txt.xz", xzed(log), 0o644)
+	afero.WriteFile(fs, "/backup/other.gz", gzipped([]byte("other")), 0o644)
+	ps, out, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{Decompress: true})
+	check(t, err)
+	expectCompressedCopies(t, ps, "/data/dump.sql /backup/dump.sql.gz", "/data/log.txt /backup/log.txt.xz")
+	err = ps.Report("", &ReportOptions{})
+	check(t, err)
+	got := out.String()
+	if !strings.Contains(got, "compressed copies\n  /data/dump.sql (26 kB, uncompressed)\n  /backup/dump.sql.gz (") || !strings.Contains(got, ", compressed)\n") {
+		t.Fatalf("unexpected report '%s'", got)
+	}
+	if strings.Contains(got, "other.gz") {
+		t.Fatalf("unexpected report '%s'", got)
+	}
+
+	// compressed files are not duplicates, so they're never deleted
+	err = ps.Rm([]string{"/data/dump.sql"}, &RmOptions{})
+	checkErr(t, err)
+	if _, err := fs.Stat("/data/dump.sql"); err != nil {
+		t.Fatal("expected /data/dump.sql to be kept")
+	}
+}
+
+func TestScanDecompressCorrupt(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	dump := bytes.Repeat([]byte("INSERT INTO t VALUES (1);\n"), 1000)
+	afero.WriteFile(fs, "/data/dump.sql", dump, 0o644)
+	afero.WriteFile(fs, "/backup/dump.sql.gz", gzipped(dump), 0o644)
+	afero.WriteFile(fs, "/backup/corrupt.gz", []byte("not gzip"), 0o644)
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{Decompress: true})
+	check(t, err)
+	infos, _ := ps.db.AllInfos()
+	found := false
+	for _, info := range infos {
+		if info.Path == "/backup/corrupt.gz" {
+			found = true
+			if info.DecompressedHash != nil {
+				t.Fatal("expected no decompressed hash for corrupt file")
+			}
+		}
+	}
+	if !found {
+		t.Fatal("expected corrupt file to be scanned")
+	}
+	expectCompressedCopies(t, ps, "/data/dump.sql /backup/dump.sql.gz")
+}
+
+func TestScanDecompressSeparately(t *testing.T) {
+	dump := bytes.Repeat([]byte("INSERT INTO t VALUES (1);\n"), 1000)
+	// the compressed copies are found regardless of which is scanned first
+	for _, order := range [][]string{{"/data", "/backup"}, {"/backup", "/data"}} {
+		fs := afero.NewMemMapFs()
+		afero.WriteFile(fs, "/data/dump.sql", dump, 0o644)
+		afero.WriteFile(fs, "/backup/dump.sql.gz", gzipped(dump), 0o644)
+		ps, _, _ := newTest(fs)
+		for _, path := range order {
+			err := ps.Scan([]string{path}, &ScanOptions{Decompress: true})
+			check(t, err)
+		}
+		expectCompressedCopies(t, ps, "/data/dump.sql /backup/dump.sql.gz")
+	}
+}
+
+func TestInfoCompressedCopies(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	dump := bytes.Repeat([]byte("INSERT INTO t VALUES (1);\n"), 1000)
+	afero.WriteFile(fs, "/data/dump.sql", dump, 0o644)
+	afero.WriteFile(fs, "/backup/dump.sql.gz", gzipped(dump), 0o644)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{Decompress: true})
+	err := ps.Info([]string{"/data/dump.sql", "/backup/dump.sql.gz"}, &InfoOptions{})
+	check(t, err)
+	got := out.String()
+	if !strings.Contains(got, "compressed copies: 1\n    /backup/dump.sql.gz (compressed)\n") {
+		t.Fatalf("unexpected info '%s'", got)
+	}
+	if !strings.Contains(got, "decompressed hash: ") || !strings.Contains(got, "    /data/dump.sql (uncompressed)\n") {
+		t.Fatalf("unexpected info '%s'", got)
+	}
+}
@@ -0,0 +1,86 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/herror"
+
+	"fmt"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/dustin/go-humanize"
+)
+
+type ContainedOptions struct {
+	// only count copies inside this directory
+	By       string
+	Relative bool
+}
+
+// Reports how much of a directory has copies elsewhere, by number of files and
+// by size, and lists the files that don't, to help decide whether the whole
+// directory can be deleted.
+//
+// Copies are found using the hashes in the database, so the result is only as
+// accurate as the last scan. Files inside archives in the directory are part
+// of the archive, so they're not counted separately, but copies inside
+// archives elsewhere do count.
+func (ps *Periscope) Contained(dir string, options *ContainedOptions) herror.Interface {
+	absDir, _, err := ps.checkFile(dir, false, true, "check", false, true)
+	if err != nil {
+		return err
+	}
+	var absBy string
+	if options.By != "" {
+		absBy, _, err = ps.checkFile(options.By, false, true, "check", false, true)
+		if err != nil {
+			return err
+		}
+		if absBy == absDir || containedInAny(absBy, []string{absDir}) {
+			return herror.UserF(nil, "cannot check '%s' against '%s': it is inside the directory", dir, options.By)
+		}
+	}
+	infos, err := ps.db.ContainedInfos(absDir, absBy)
+	if err != nil {
+		return err
+	}
+	var files, contained, size, containedSize int64
+	var missing []int
+	for i, info := range infos {
+		if isArchiveMember(info.Info.Path) {
+			continue
+		}
+		files++
+		size += info.Info.Size
+		if info.Contained {
+			contained++
+			containedSize += info.Info.Size
+		} else {
+			missing = append(missing, i)
+		}
+	}
+	w := tabwriter.NewWriter(ps.outStream, 0, 0, 0, ' ', tabwriter.DiscardEmptyColumns|tabwriter.AlignRight)
+	fmt.Fprintf(w, "files\v %s\v of %s%s\n", humanize.Comma(contained), humanize.Comma(files), percent(contained, files))
+	fmt.Fprintf(w, "size\v %s\v of %s%s\n", humanize.Bytes(uint64(containedSize)), humanize.Bytes(uint64(size)), percent(containedSize, size))
+	w.Flush()
+	if len(missing) == 0 {
+		return nil
+	}
+	var refDir string
+	if options.Relative {
+		var err error
+		refDir, err = filepath.Abs(dir)
+		if err != nil {
+			return herror.Internal(err, "")
+		}
+	}
+	fmt.Fprintf(ps.outStream, "\nnot contained:\n")
+	for _, i := range missing {
+		info := infos[i].Info
+		path := info.Path
+		if options.Relative {
+			path = relPath(refDir, path)
+		}
+		fmt.Fprintf(ps.outStream, "  %s (%s)\n", path, humanize.Bytes(uint64(info.Size)))
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/testfs"
+
+	"strings"
+	"testing"
+)
+
+func TestContainedBasic(t *testing.T) {
+	fs := testfs.Read(`
+/old/a [10000 1]
+/old/b [2000 2]
+/old/sub/c [3000 3]
+/old/sub/d [4000 4]
+/old/sub/e [4000 4]
+/new/a [10000 1]
+/backup/c [3000 3]
+	`).Mkfs()
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Contained("/old", &ContainedOptions{})
+	check(t, err)
+	got := strings.TrimSpace(out.String())
+	// duplicates inside the directory itself don't count
+	expected := strings.TrimSpace(`
+files     2 of 5 (40%)
+ size 13 kB of 23 kB (57%)
+
+not contained:
+  /old/b (2.0 kB)
+  /old/sub/d (4.0 kB)
+  /old/sub/e (4.0 kB)
+	`)
+	if got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+}
+
+func TestContainedBy(t *testing.T) {
+	fs := testfs.Read(`
+/old/a [10000 1]
+/old/sub/c [3000 3]
+/new/a [10000 1]
+/backup/c [3000 3]
+	`).Mkfs()
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Contained("/old", &ContainedOptions{By: "/backup", Relative: true})
+	check(t, err)
+	got := strings.TrimSpace(out.String())
+	expected := strings.TrimSpace(`
+files      1 of 2 (50%)
+ size 3.0 kB of 13 kB (23%)
+
+not contained:
+  a (10 kB)
+	`)
+	if got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+}
+
+func TestContainedAll(t *testing.T) {
+	fs := testfs.Read(`
+/old/a [10000 1]
+/new/a [10000 1]
+	`).Mkfs()
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Contained("/old", &ContainedOptions{})
+	check(t, err)
+	got := strings.TrimSpace(out.String())
+	expected := strings.TrimSpace(`
+files     1 of 1 (100%)
+ size 10 kB of 10 kB (100%)
+	`)
+	if got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+}
+
+func TestContainedByInside(t *testing.T) {
+	fs := testfs.Read(`
+/old/a [10000 1]
+/old/sub/a [10000 1]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Contained("/old", &ContainedOptions{By: "/old/sub"})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	err = ps.Contained("/old", &ContainedOptions{By: "/old"})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,310 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/db"
+	"github.com/anishathalye/periscope/internal/herror"
+
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// A rule for choosing the copy to keep in a duplicate set, as given on the
+// command line: Flag is one of "keep-under", "keep", or "keep-regex", and
+// Value is its argument.
+type DedupeRule struct {
+	Flag  string
+	Value string
+}
+
+type DedupeOptions struct {
+	// rules in the order they're applied
+	Rules   []DedupeRule
+	Verbose bool
+	DryRun  bool
+	// move files to the trash, or replace them with symbolic links or
+	// hardlinks, instead of deleting them
+	Trash    bool
+	Symlink  bool
+	Relative bool
+	Hardlink bool
+	Paranoid bool
+}
+
+// the values of the keep rule
+var dedupeKeep = map[string]func(a, b dedupeFile) int{
+	"oldest":        func(a, b dedupeFile) int { return a.stat.ModTime().Compare(b.stat.ModTime()) },
+	"newest":        func(a, b dedupeFile) int { return b.stat.ModTime().Compare(a.stat.ModTime()) },
+	"shortest-path": func(a, b dedupeFile) int { return len(a.path) - len(b.path) },
+	"longest-path":  func(a, b dedupeFile) int { return len(b.path) - len(a.path) },
+}
+
+type dedupeFile struct {
+	path string
+	stat os.FileInfo
+	info db.FileInfo
+}
+
+type dedupeRule struct {
+	name string
+	// the files that the rule prefers among the given ones
+	keep func(files []dedupeFile) []dedupeFile
+	// what the rule reclaimed
+	files int64
+	size  int64
+}
+
+func (ps *Periscope) dedupeRules(rules []DedupeRule) ([]*dedupeRule, herror.Interface) {
+	var compiled []*dedupeRule
+	for _, rule := range rules {
+		r := &dedupeRule{name: fmt.Sprintf("--%s %s", rule.Flag, rule.Value)}
+		switch rule.Flag {
+		case "keep-under":
+			absDir, _, err := ps.checkFile(rule.Value, false, true, "access", false, true)
+			if err != nil {
+				return nil, err
+			}
+			r.keep = func(files []dedupeFile) []dedupeFile {
+				return filterFiles(files, func(f dedupeFile) bool {
+					return containedInAny(f.path, []string{absDir})
+				})
+			}
+		case "keep-regex":
+			re, err := regexp.Compile(rule.Value)
+			if err != nil {
+				return nil, herror.UserF(err, "invalid regular expression '%s'", rule.Value)
+			}
+			r.keep = func(files []dedupeFile) []dedupeFile {
+				return filterFiles(files, func(f dedupeFile) bool {
+					return re.MatchString(f.path)
+				})
+			}
+		case "keep":
+			compare, ok := dedupeKeep[rule.Value]
+			if !ok {
+				return nil, herror.UserF(nil, "invalid value '%s' for --keep (must be oldest, newest, shortest-path, or longest-path)", rule.Value)
+			}
+			r.keep = func(files []dedupeFile) []dedupeFile {
+				best := files[0]
+				for _, f := range files[1:] {
+					if compare(f, best) < 0 {
+						best = f
+					}
+				}
+				return filterFiles(files, func(f dedupeFile) bool {
+					return compare(f, best) == 0
+				})
+			}
+		default:
+			return nil, herror.Internal(nil, fmt.Sprintf("unknown rule '%s'", rule.Flag))
+		}
+		compiled = append(compiled, r)
+	}
+	return compiled, nil
+}
+
+func filterFiles(files []dedupeFile, keep func(f dedupeFile) bool) []dedupeFile {
+	var kept []dedupeFile
+	for _, f := range files {
+		if keep(f) {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// Removes duplicates everywhere (or, if a path is given, in that directory),
+// keeping one copy of each file, chosen by the given rules.
+//
+// The rules are applied in order, each narrowing down the copies that could
+// be kept to the ones it prefers; a rule that matches none of them is
+// skipped. If copies are still tied after all the rules, the first one by path
+// is kept. Duplicate sets that none of the rules apply to are left alone.
+// Files are removed like in Rm, which checks again that the copy being kept
+// has the same contents, and files inside archives are neither kept nor
+// removed. Sets where that check fails, because the files changed or the copy
+// being kept can't be linked to, are left alone and counted.
+//
+// In a dry run, and in verbose mode, a summary of how many files (and bytes)
+// each rule reclaimed is printed at the end, along with the number of sets
+// that were left alone.
+func (ps *Periscope) Dedupe(path string, options *DedupeOptions) herror.Interface {
+	if len(options.Rules) == 0 {
+		return herror.User(nil, "no rules given: specify at least one of --keep-under, --keep, and --keep-regex")
+	}
+	rules, err := ps.dedupeRules(options.Rules)
+	if err != nil {
+		return err
+	}
+	var absPath string
+	directory := string(filepath.Separator)
+	if path != "" {
+		absPath, _, err = ps.checkFile(path, false, true, "access", false, true)
+		if err != nil {
+			return err
+		}
+		directory = path
+	}
+	sets, err := ps.db.AllDuplicates(absPath)
+	if err != nil {
+		return err
+	}
+	if options.Trash {
+		devices := make(map[int64]struct{})
+		for _, set := range sets {
+			for _, info := range set {
+				if !isArchiveMember(info.Path) && (absPath == "" || containedInAny(info.Path, []string{absPath})) {
+					devices[info.Device] = struct{}{}
+				}
+			}
+		}
+		if err := ps.checkTrashDevices(directory, devices); err != nil {
+			return err
+		}
+	}
+	rmOptions := &RmOptions{
+		Verbose:  options.Verbose,
+		DryRun:   options.DryRun,
+		Trash:    options.Trash,
+		Paranoid: options.Paranoid,
+	}
+	command := "dedupe"
+	if options.Symlink {
+		rmOptions.symlink = &SymlinkOptions{Relative: options.Relative}
+		command += " --symlink"
+	} else if options.Hardlink {
+		rmOptions.hardlink = true
+		command += " --hardlink"
+	} else if options.Trash {
+		command += " --trash"
+	}
+	ps.operation = &journalOperation{command: command, time: time.Now()}
+	defer func() { ps.operation = nil }()
+
+	tie := &dedupeRule{name: "first path"}
+	skipped := 0
+	unverified := 0
+	for _, set := range sets {
+		files := ps.dedupeFiles(set)
+		if len(files) < 2 {
+			continue
+		}
+		// the rule that each file stopped being a candidate for keeping
+		// at
+		eliminated := make(map[string]*dedupeRule)
+		remaining := files
+		applied := false
+		for _, rule := range rules {
+			kept := rule.keep(remaining)
+			if len(kept) == 0 {
+				continue
+			}
+			applied = true
+			for _, f := range remaining {
+				eliminated[f.path] = rule
+			}
+			for _, f := range kept {
+				delete(eliminated, f.path)
+			}
+			remaining = kept
+		}
+		if !applied {
+			skipped++
+			continue
+		}
+		// files are sorted by path
+		survivor := remaining[0]
+		for _, f := range remaining[1:] {
+			eliminated[f.path] = tie
+		}
+		candidates := make(map[string]struct{})
+		for _, f := range files {
+			if f.path == survivor.path || f.info.SameFile(&survivor.info) {
+				continue // not a separate copy
+			}
+			if absPath != "" && !containedInAny(f.path, []string{absPath}) {
+				continue
+			}
+			candidates[f.path] = struct{}{}
+		}
+		if len(candidates) == 0 {
+			continue
+		}
+		setOptions := *rmOptions
+		setOptions.survivor = survivor.path
+		verified := true
+		setOptions.unverified = func() { verified = false }
+		removed := make(map[string]struct{})
+		if err := ps.remove1(candidates, &setOptions, false, directory, nil, removed); err != nil && !herror.IsSilent(err) {
+			return err
+		}
+		if !verified {
+			unverified++
+		}
+		for _, f := range files {
+			if _, ok := removed[f.path]; ok {
+				eliminated[f.path].files++
+				eliminated[f.path].size += f.info.Size
+			}
+		}
+	}
+
+	if !options.DryRun && !options.Verbose {
+		return nil
+	}
+	if tie.files > 0 {
+		rules = append(rules, tie)
+	}
+	var total dedupeRule
+	for _, rule := range rules {
+		total.files += rule.files
+		total.size += rule.size
+	}
+	if options.Verbose && total.files > 0 {
+		fmt.Fprintf(ps.outStream, "\n")
+	}
+	w := tabwriter.NewWriter(ps.outStream, 0, 0, 0, ' ', tabwriter.DiscardEmptyColumns|tabwriter.AlignRight)
+	for _, rule := range rules {
+		fmt.Fprintf(w, "%s\v %s\v %s\v\n", rule.name, fileCount(rule.files), humanize.Bytes(uint64(rule.size)))
+	}
+	fmt.Fprintf(w, "total\v %s\v %s\v\n", fileCount(total.files), humanize.Bytes(uint64(total.size)))
+	w.Flush()
+	if skipped > 0 {
+		fmt.Fprintf(ps.outStream, "\nno rule applies to %s\n", setCount(skipped))
+	}
+	if unverified > 0 {
+		fmt.Fprintf(ps.outStream, "\ncould not verify the copy to keep for %s\n", setCount(unverified))
+	}
+	return nil
+}
+
+// the files in a duplicate set that can be kept or removed, sorted by path:
+// regular files that still exist, and not files inside archives
+func (ps *Periscope) dedupeFiles(set db.DuplicateSet) []dedupeFile {
+	var files []dedupeFile
+	for _, info := range set {
+		if isArchiveMember(info.Path) {
+			continue
+		}
+		_, stat, err := ps.checkFile(info.Path, true, false, "", true, false)
+		if err != nil {
+			continue
+		}
+		files = append(files, dedupeFile{path: info.Path, stat: stat, info: info})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].path < files[j].path })
+	return files
+}
+
+func setCount(n int) string {
+	if n == 1 {
+		return "1 duplicate set"
+	}
+	return humanize.Comma(int64(n)) + " duplicate sets"
+}
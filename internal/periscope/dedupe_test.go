@@ -0,0 +1,199 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/testfs"
+
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestDedupeDryRun(t *testing.T) {
+	fs := testfs.Read(`
+/master/x [1000 1]
+/backup/x [1000 1]
+/other/x [1000 1]
+/backup/y [2000 2]
+/other/y [2000 2]
+/a/z [300 3]
+/b/z [300 3]
+	`).Mkfs()
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Dedupe("", &DedupeOptions{
+		Rules: []DedupeRule{
+			{Flag: "keep-under", Value: "/master"},
+			{Flag: "keep-regex", Value: "^/backup/"},
+		},
+		Verbose: true,
+		DryRun:  true,
+	})
+	check(t, err)
+	got := strings.SplitN(out.String(), "\n\n", 2)
+	removed := strings.Split(strings.TrimSpace(got[0]), "\n")
+	sort.Strings(removed)
+	if strings.Join(removed, ",") != "rm /backup/x,rm /other/x,rm /other/y" {
+		t.Fatalf("unexpected files removed %v", removed)
+	}
+	expected := "" +
+		"  --keep-under /master 2 files 2.0 kB\n" +
+		"--keep-regex ^/backup/  1 file 2.0 kB\n" +
+		"                 total 3 files 4.0 kB\n" +
+		"\n" +
+		"no rule applies to 1 duplicate set\n"
+	if len(got) != 2 || got[1] != expected {
+		t.Fatalf("expected summary:\n%s\ngot:\n%s", expected, out.String())
+	}
+	// nothing is removed in a dry run
+	if !testfs.Equal(fs, testfs.Read(`
+/master/x [1000 1]
+/backup/x [1000 1]
+/other/x [1000 1]
+/backup/y [2000 2]
+/other/y [2000 2]
+/a/z [300 3]
+/b/z [300 3]
+	`)) {
+		t.Fatal("expected no files to be removed")
+	}
+}
+
+func TestDedupeKeep(t *testing.T) {
+	fs := testfs.Read(`
+/a/x [1000 1]
+/b/x [1000 1]
+/c/long/x [1000 1]
+/a/y [2000 2]
+/b/y [2000 2]
+	`).Mkfs()
+	old := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	fs.Chtimes("/b/x", old, old)
+	fs.Chtimes("/c/long/x", old, old)
+	fs.Chtimes("/a/y", old, old)
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	// the oldest copies of x are tied, so the longest path breaks the tie
+	err := ps.Dedupe("", &DedupeOptions{
+		Rules: []DedupeRule{
+			{Flag: "keep", Value: "oldest"},
+			{Flag: "keep", Value: "longest-path"},
+		},
+	})
+	check(t, err)
+	expected := testfs.Read(`
+/a/y [2000 2]
+/c/long/x [1000 1]
+	`)
+	if !testfs.Equal(fs, expected) {
+		t.Fatalf("expected:\n%sgot:\n%s", expected.ShowIndent(2), testfs.ShowIndent(fs, 2))
+	}
+	ops, err := ps.db.Operations()
+	check(t, err)
+	if len(ops) != 1 || ops[0].Command != "dedupe" || ops[0].Files != 3 {
+		t.Fatalf("unexpected operations %+v", ops)
+	}
+}
+
+func TestDedupePath(t *testing.T) {
+	fs := testfs.Read(`
+/master/x [1000 1]
+/backup/x [1000 1]
+/other/x [1000 1]
+/backup/y [2000 2]
+/other/y [2000 2]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	// only files in the given directory are removed, but copies can be kept
+	// anywhere
+	err := ps.Dedupe("/backup", &DedupeOptions{
+		Rules: []DedupeRule{{Flag: "keep", Value: "shortest-path"}},
+	})
+	check(t, err)
+	expected := testfs.Read(`
+/master/x [1000 1]
+/other/x [1000 1]
+/other/y [2000 2]
+	`)
+	if !testfs.Equal(fs, expected) {
+		t.Fatalf("expected:\n%sgot:\n%s", expected.ShowIndent(2), testfs.ShowIndent(fs, 2))
+	}
+}
+
+func TestDedupeInvalidRules(t *testing.T) {
+	fs := testfs.Read(`
+/a [100 1]
+/b [100 1]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	for _, rules := range [][]DedupeRule{
+		nil,
+		{{Flag: "keep", Value: "biggest"}},
+		{{Flag: "keep-regex", Value: "("}},
+		{{Flag: "keep-under", Value: "/nonexistent"}},
+	} {
+		checkErr(t, ps.Dedupe("", &DedupeOptions{Rules: rules}))
+	}
+	if !testfs.Equal(fs, testfs.Read(`
+/a [100 1]
+/b [100 1]
+	`)) {
+		t.Fatal("expected no files to be removed")
+	}
+}
+
+func TestDedupeUnverified(t *testing.T) {
+	fs := testfs.Read(`
+/master/x [1000 1]
+/backup/x [1000 1]
+/backup/y [2000 2]
+/other/y [2000 2]
+	`).Mkfs()
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	// the copy to keep changes after the scan
+	afero.WriteFile(fs, "/master/x", bytes.Repeat([]byte{'z'}, 1000), 0o644)
+	err := ps.Dedupe("", &DedupeOptions{
+		Rules:   []DedupeRule{{Flag: "keep-under", Value: "/master"}, {Flag: "keep-under", Value: "/backup"}},
+		Verbose: true,
+	})
+	check(t, err)
+	if _, err := fs.Stat("/backup/x"); err != nil {
+		t.Fatalf("expected /backup/x to be kept, got %s", err)
+	}
+	if !strings.HasPrefix(out.String(), "rm /other/y\n") || !strings.HasSuffix(out.String(), "\n\ncould not verify the copy to keep for 1 duplicate set\n") {
+		t.Fatalf("unexpected output '%s'", out.String())
+	}
+}
+
+func TestDedupeHardlink(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "master"), 0o755)
+	os.MkdirAll(filepath.Join(dir, "backup"), 0o755)
+	os.WriteFile(filepath.Join(dir, "master", "x"), []byte("contents"), 0o644)
+	os.WriteFile(filepath.Join(dir, "backup", "x"), []byte("contents"), 0o644)
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{dir}, &ScanOptions{})
+	err := ps.Dedupe("", &DedupeOptions{
+		Rules:    []DedupeRule{{Flag: "keep-under", Value: filepath.Join(dir, "master")}},
+		Hardlink: true,
+	})
+	check(t, err)
+	if !sameFile(t, filepath.Join(dir, "master", "x"), filepath.Join(dir, "backup", "x")) {
+		t.Fatal("expected files to be linked")
+	}
+	ops, err := ps.db.Operations()
+	check(t, err)
+	if len(ops) != 1 || ops[0].Command != "dedupe --hardlink" {
+		t.Fatalf("unexpected operations %+v", ops)
+	}
+}
@@ -0,0 +1,99 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/herror"
+
+	"sort"
+	"sync"
+)
+
+// limits the number of concurrent reads from each device (by st_dev), so that
+// spinning disks aren't thrashed by many concurrent readers while SSDs are
+// kept busy
+//
+// unless configured otherwise, rotational devices get rotationalScanThreads
+// concurrent readers, and other devices (or devices we know nothing about) get
+// scanThreads
+type ioLimiter struct {
+	mu         sync.Mutex
+	configured map[int64]int
+	slots      map[int64]chan struct{} // by device
+}
+
+// limits are given by path; each path's limit applies to the device it's on
+func (ps *Periscope) newIOLimiter(limits map[string]int) (*ioLimiter, herror.Interface) {
+	configured := make(map[int64]int)
+	for path, limit := range limits {
+		if limit < 1 {
+			return nil, herror.UserF(nil, "invalid thread count for '%s': %d", path, limit)
+		}
+		info, err := ps.fs.Stat(path)
+		if err != nil {
+			return nil, herror.UserF(err, "cannot set thread count for '%s': %s", path, err)
+		}
+		_, _, device := sysStat(info)
+		configured[device] = limit
+	}
+	return &ioLimiter{
+		configured: configured,
+		slots:      make(map[int64]chan struct{}),
+	}, nil
+}
+
+func (l *ioLimiter) limit(device int64) int {
+	if limit, ok := l.configured[device]; ok {
+		return limit
+	}
+	if device != 0 {
+		if isRotational, ok := rotational(device); ok && isRotational {
+			return rotationalScanThreads
+		}
+	}
+	return scanThreads
+}
+
+func (l *ioLimiter) deviceSlots(device int64) chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	slots, ok := l.slots[device]
+	if !ok {
+		slots = make(chan struct{}, l.limit(device))
+		l.slots[device] = slots
+	}
+	return slots
+}
+
+// waits until reads are allowed from all the given devices; the returned
+// function must be called once the reads are done
+func (l *ioLimiter) acquire(devices ...int64) func() {
+	// acquire in a consistent order to avoid deadlock, and only once per
+	// device
+	sorted := append([]int64(nil), devices...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	var held []chan struct{}
+	for i, device := range sorted {
+		if i > 0 && device == sorted[i-1] {
+			continue
+		}
+		slots := l.deviceSlots(device)
+		slots <- struct{}{}
+		held = append(held, slots)
+	}
+	return func() {
+		for _, slots := range held {
+			<-slots
+		}
+	}
+}
+
+// the total number of concurrent reads allowed across the given devices
+func (l *ioLimiter) total(devices map[int64]struct{}) int {
+	total := 0
+	for device := range devices {
+		total += l.limit(device)
+	}
+	if total == 0 {
+		return 1
+	}
+	return total
+}
@@ -0,0 +1,30 @@
+package periscope
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// reports whether the block device is a spinning disk, according to sysfs,
+// and whether this could be determined
+func rotational(device int64) (isRotational, ok bool) {
+	dev := uint64(device)
+	sysPath, err := filepath.EvalSymlinks(fmt.Sprintf("/sys/dev/block/%d:%d", unix.Major(dev), unix.Minor(dev)))
+	if err != nil {
+		// e.g., network filesystems, or filesystems like btrfs that
+		// use anonymous device numbers
+		return false, false
+	}
+	// partitions don't have a queue directory; their parent disk does
+	for _, dir := range []string{sysPath, filepath.Dir(sysPath)} {
+		data, err := os.ReadFile(filepath.Join(dir, "queue", "rotational"))
+		if err == nil {
+			return strings.TrimSpace(string(data)) == "1", true
+		}
+	}
+	return false, false
+}
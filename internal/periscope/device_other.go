@@ -0,0 +1,9 @@
+//go:build !linux
+
+package periscope
+
+// there's no portable way to tell whether a disk is rotational, so all
+// devices get the default limit
+func rotational(device int64) (isRotational, ok bool) {
+	return false, false
+}
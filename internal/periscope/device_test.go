@@ -0,0 +1,117 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/db"
+
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestIOLimiterLimitsConcurrency(t *testing.T) {
+	l := &ioLimiter{
+		configured: map[int64]int{5: 2},
+		slots:      make(map[int64]chan struct{}),
+	}
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release := l.acquire(5)
+			mu.Lock()
+			active++
+			if active > maxActive {
+				maxActive = active
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+			mu.Lock()
+			active--
+			mu.Unlock()
+			release()
+		}()
+	}
+	wg.Wait()
+	if maxActive > 2 {
+		t.Fatalf("expected at most 2 concurrent readers, got %d", maxActive)
+	}
+}
+
+func TestIOLimiterSameDeviceTwice(t *testing.T) {
+	l := &ioLimiter{
+		configured: map[int64]int{7: 1},
+		slots:      make(map[int64]chan struct{}),
+	}
+	// would deadlock if the device's only slot were acquired twice
+	release := l.acquire(7, 7)
+	release()
+	release = l.acquire(8, 7)
+	release()
+}
+
+func TestIOLimiterByPath(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	fs.Mkdir("/a", 0o755)
+	ps, _, _ := newTest(fs)
+	l, err := ps.newIOLimiter(map[string]int{"/a": 3})
+	check(t, err)
+	// the in-memory filesystem has no device numbers
+	if l.limit(0) != 3 {
+		t.Fatalf("expected limit 3, got %d", l.limit(0))
+	}
+	if l.limit(1) != scanThreads {
+		t.Fatalf("expected default limit %d, got %d", scanThreads, l.limit(1))
+	}
+	if l.total(map[int64]struct{}{0: {}, 1: {}}) != 3+scanThreads {
+		t.Fatal("unexpected total")
+	}
+	_, err = ps.newIOLimiter(map[string]int{"/b": 3})
+	checkErr(t, err)
+	_, err = ps.newIOLimiter(map[string]int{"/a": 0})
+	checkErr(t, err)
+}
+
+func TestReadFilesConcurrentlyWithinBucket(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	ps, _, _ := newTest(fs)
+	l := &ioLimiter{
+		configured: map[int64]int{5: 3},
+		slots:      make(map[int64]chan struct{}),
+	}
+	infos := make([]db.FileInfo, 10)
+	indices := make([]int, len(infos))
+	for i := range infos {
+		infos[i].Device = 5
+		indices[i] = i
+	}
+	var mu sync.Mutex
+	active, maxActive := 0, 0
+	ps.readFiles(infos, indices, l, func(info *db.FileInfo) func(info *db.FileInfo) {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		active--
+		mu.Unlock()
+		return func(info *db.FileInfo) { info.Size = 1 }
+	})
+	// the files are all in one bucket, but are still read concurrently,
+	// up to the device's limit
+	if maxActive != 3 {
+		t.Fatalf("expected 3 concurrent readers, got %d", maxActive)
+	}
+	for i := range infos {
+		if infos[i].Size != 1 {
+			t.Fatalf("expected results to be stored for file %d", i)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/db"
+
+	"path/filepath"
+	"sort"
+
+	"github.com/dustin/go-humanize"
+)
+
+// the key for directory hashes, so that they never collide with file hashes
+var directoryHashKey = []byte("directory")
+
+// the contents of a directory in the database, including its subdirectories
+type dirSummary struct {
+	path   string
+	parent *dirSummary
+	// Merkle hash of the directory, computed from the names and full
+	// hashes of its files and the names and hashes of its subdirectories;
+	// nil if any file in the tree has no full hash, in which case the
+	// directory can't have a duplicate
+	hash  []byte
+	size  int64
+	files int64
+	// whether some other directory has the same hash
+	duplicated bool
+}
+
+type dirNode struct {
+	summary  *dirSummary
+	files    map[string][]byte // name -> full hash
+	children map[string]*dirNode
+}
+
+// computes summaries of all directories that contain files in the database,
+// directly or in subdirectories; files inside archives are not included, so
+// archives are treated like any other file
+//
+// Only tracked files are summarized: files that the scan skipped (empty,
+// below the minimum size, or excluded) and empty subdirectories aren't in the
+// database, so directories that differ only in those have the same hash.
+func (ps *Periscope) directorySummaries(infos []db.FileInfo) map[string]*dirSummary {
+	nodes := make(map[string]*dirNode)
+	var node func(path string) *dirNode
+	node = func(path string) *dirNode {
+		if n, ok := nodes[path]; ok {
+			return n
+		}
+		n := &dirNode{
+			summary:  &dirSummary{path: path},
+			files:    make(map[string][]byte),
+			children: make(map[string]*dirNode),
+		}
+		nodes[path] = n
+		if parent := filepath.Dir(path); parent != path {
+			p := node(parent)
+			p.children[filepath.Base(path)] = n
+			n.summary.parent = p.summary
+		}
+		return n
+	}
+	for i := range infos {
+		info := &infos[i]
+		if isArchiveMember(info.Path) {
+			continue
+		}
+		n := node(filepath.Dir(info.Path))
+		n.files[filepath.Base(info.Path)] = info.FullHash
+		n.summary.size += info.Size
+		n.summary.files++
+	}
+	for _, n := range nodes {
+		if n.summary.parent == nil {
+			ps.hashDirectory(n)
+		}
+	}
+	summaries := make(map[string]*dirSummary, len(nodes))
+	byHash := make(map[string][]*dirSummary)
+	for path, n := range nodes {
+		summaries[path] = n.summary
+		if n.summary.hash != nil {
+			byHash[string(n.summary.hash)] = append(byHash[string(n.summary.hash)], n.summary)
+		}
+	}
+	for _, set := range byHash {
+		if len(set) > 1 {
+			for _, summary := range set {
+				summary.duplicated = true
+			}
+		}
+	}
+	return summaries
+}
+
+// computes the hash, size, and number of files of a directory, after its
+// subdirectories
+func (ps *Periscope) hashDirectory(n *dirNode) {
+	type entry struct {
+		name string
+		kind byte
+		hash []byte
+	}
+	var entries []entry
+	complete := true
+	for name, hash := range n.files {
+		if hash == nil {
+			complete = false
+		}
+		entries = append(entries, entry{name, 'f', hash})
+	}
+	for name, child := range n.children {
+		ps.hashDirectory(child)
+		n.summary.size += child.summary.size
+		n.summary.files += child.summary.files
+		if child.summary.hash == nil {
+			complete = false
+		}
+		entries = append(entries, entry{name, 'd', child.summary.hash})
+	}
+	if !complete {
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+	h := ps.hash.new(directoryHashKey)
+	for _, e := range entries {
+		// names can't contain NUL bytes, and hashes have a fixed size
+		h.Write([]byte(e.name))
+		h.Write([]byte{0, e.kind})
+		h.Write(e.hash)
+	}
+	n.summary.hash = h.Sum(nil)
+}
+
+// returns sets of identical directories, largest first, leaving out sets that
+// are implied by their parents being identical
+//
+// a set is implied if every directory in it has a parent with a duplicate:
+// when the parents are listed, listing the children too would be redundant
+func duplicateDirectories(summaries map[string]*dirSummary) [][]*dirSummary {
+	byHash := make(map[string][]*dirSummary)
+	for _, summary := range summaries {
+		if summary.duplicated {
+			byHash[string(summary.hash)] = append(byHash[string(summary.hash)], summary)
+		}
+	}
+	var sets [][]*dirSummary
+	for _, set := range byHash {
+		implied := true
+		for _, summary := range set {
+			if summary.parent == nil || !summary.parent.duplicated {
+				implied = false
+				break
+			}
+		}
+		if implied {
+			continue
+		}
+		sort.Slice(set, func(i, j int) bool { return set[i].path < set[j].path })
+		sets = append(sets, set)
+	}
+	sort.Slice(sets, func(i, j int) bool {
+		if sets[i][0].size != sets[j][0].size {
+			return sets[i][0].size > sets[j][0].size
+		}
+		return sets[i][0].path < sets[j][0].path
+	})
+	return sets
+}
+
+// whether a file is inside a directory that has a duplicate
+func inDuplicatedDirectory(summaries map[string]*dirSummary, path string) bool {
+	summary, ok := summaries[filepath.Dir(path)]
+	if !ok {
+		return false
+	}
+	for ; summary != nil; summary = summary.parent {
+		if summary.duplicated {
+			return true
+		}
+	}
+	return false
+}
+
+// whether all the files in a duplicate set are inside directories that have
+// duplicates, so the set is implied by the duplicate directories
+func coveredByDirectories(summaries map[string]*dirSummary, set db.DuplicateSet) bool {
+	for _, info := range set {
+		if !inDuplicatedDirectory(summaries, info.Path) {
+			return false
+		}
+	}
+	return true
+}
+
+// whether any of the directories is dir or inside it
+func anyDirectoryIn(set []*dirSummary, dir string) bool {
+	for _, summary := range set {
+		if summary.path == dir || containedInAny(summary.path, []string{dir}) {
+			return true
+		}
+	}
+	return false
+}
+
+func fileCount(n int64) string {
+	if n == 1 {
+		return "1 file"
+	}
+	return humanize.Comma(n) + " files"
+}
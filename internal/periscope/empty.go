@@ -0,0 +1,169 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/db"
+	"github.com/anishathalye/periscope/internal/herror"
+
+	"fmt"
+	"log"
+	"math"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+type EmptyOptions struct {
+}
+
+// Lists zero-length files and empty directories in the given directory, along
+// with directories that would be left empty by `psc rm -r`, because every file
+// in them has a copy outside of them.
+//
+// Zero-length files aren't tracked by scan, so the directory is read from the
+// file system, and the duplicate database is only used to find copies. A
+// directory inside one that's listed is not listed separately.
+func (ps *Periscope) Empty(root string, options *EmptyOptions) herror.Interface {
+	absRoot, _, err := ps.checkFile(root, false, true, "show", false, true)
+	if err != nil {
+		return err
+	}
+	sets, err := ps.db.AllDuplicates(absRoot)
+	if err != nil {
+		return err
+	}
+	copies := copiesAbove(sets)
+	e := &emptyWalk{ps: ps, copies: copies, qualifies: make(map[string]bool)}
+	e.visit(absRoot)
+	var emptyDirs, duplicateDirs []string
+	for _, dir := range e.dirs {
+		if !e.qualifies[dir.path] || (dir.path != absRoot && e.qualifies[filepath.Dir(dir.path)]) {
+			continue
+		}
+		if dir.files {
+			duplicateDirs = append(duplicateDirs, dir.path)
+		} else {
+			emptyDirs = append(emptyDirs, dir.path)
+		}
+	}
+	first := true
+	for _, section := range []struct {
+		header string
+		paths  []string
+		dirs   bool
+	}{
+		{"empty files", e.emptyFiles, false},
+		{"empty directories", emptyDirs, true},
+		{"directories with only duplicates", duplicateDirs, true},
+	} {
+		if len(section.paths) == 0 {
+			continue
+		}
+		if !first {
+			fmt.Fprintf(ps.outStream, "\n")
+		}
+		fmt.Fprintf(ps.outStream, "%s:\n", section.header)
+		for _, path := range section.paths {
+			path = relPath(absRoot, path)
+			if section.dirs {
+				path += string(filepath.Separator)
+			}
+			fmt.Fprintf(ps.outStream, "  %s\n", path)
+		}
+		first = false
+	}
+	return nil
+}
+
+// for each file in the duplicate sets, the length of the path of the deepest
+// directory that contains both it and a copy of it; removing a directory with
+// a longer path, that contains the file, leaves a copy elsewhere
+//
+// hardlinks to the same file are not copies of each other
+func copiesAbove(sets []db.DuplicateSet) map[string]int {
+	copies := make(map[string]int)
+	for _, set := range sets {
+		for i := range set {
+			info := &set[i]
+			if isArchiveMember(info.Path) {
+				continue
+			}
+			common := ""
+			for j := range set {
+				other := &set[j]
+				if i == j || info.SameFile(other) {
+					continue
+				}
+				if common == "" {
+					common = filepath.Dir(info.Path)
+				}
+				for !containedInAny(other.Path, []string{common}) {
+					common = filepath.Dir(common)
+				}
+			}
+			if common != "" {
+				copies[info.Path] = len(common)
+			}
+		}
+	}
+	return copies
+}
+
+type emptyDir struct {
+	path string
+	// whether there are any files in the directory, including its
+	// subdirectories
+	files bool
+}
+
+type emptyWalk struct {
+	ps     *Periscope
+	copies map[string]int
+	// directories in the order they were visited
+	dirs       []emptyDir
+	emptyFiles []string
+	// whether a directory is empty, or would be left empty by rm -r
+	qualifies map[string]bool
+}
+
+// visits a directory, returning the length of the longest path of a directory
+// that rm -r can't leave empty because of something inside this one, and
+// whether there are any files inside it
+func (e *emptyWalk) visit(dir string) (int, bool) {
+	index := len(e.dirs)
+	e.dirs = append(e.dirs, emptyDir{path: dir})
+	entries, err := afero.ReadDir(e.ps.fs, dir)
+	if err != nil {
+		log.Printf("%s", err)
+		e.dirs[index].files = true
+		return math.MaxInt, true
+	}
+	blocked := -1
+	files := false
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		switch {
+		case entry.IsDir():
+			childBlocked, childFiles := e.visit(path)
+			blocked = max(blocked, childBlocked)
+			files = files || childFiles
+		case entry.Mode().IsRegular():
+			files = true
+			if entry.Size() == 0 {
+				e.emptyFiles = append(e.emptyFiles, path)
+				blocked = math.MaxInt
+			} else if common, ok := e.copies[path]; ok {
+				blocked = max(blocked, common)
+			} else {
+				blocked = math.MaxInt
+			}
+		default:
+			// symbolic links and other special files are never
+			// removed
+			files = true
+			blocked = math.MaxInt
+		}
+	}
+	e.dirs[index].files = files
+	e.qualifies[dir] = blocked < len(dir)
+	return blocked, files
+}
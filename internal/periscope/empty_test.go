@@ -0,0 +1,93 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/testfs"
+
+	"strings"
+	"testing"
+)
+
+func TestEmptyBasic(t *testing.T) {
+	fs := testfs.Read(`
+/a/empty [0 0]
+/a/x [1000 1]
+/b/x [1000 1]
+/b/y [2000 2]
+/c/d/x [1000 1]
+/c/d/z [3000 3]
+/c/d/z2 [3000 3]
+	`).Mkfs()
+	fs.MkdirAll("/e/f/g", 0o755)
+	fs.MkdirAll("/a/h", 0o755)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Empty("/", &EmptyOptions{})
+	check(t, err)
+	got := strings.TrimSpace(out.String())
+	// /c/d has duplicates of some of its files, but they aren't copies
+	// outside of /c/d, and /a and /b can't be left empty
+	expected := strings.TrimSpace(`
+empty files:
+  a/empty
+
+empty directories:
+  a/h/
+  e/
+	`)
+	if got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+}
+
+func TestEmptyDuplicates(t *testing.T) {
+	fs := testfs.Read(`
+/keep/x [1000 1]
+/keep/y [2000 2]
+/old/x [1000 1]
+/old/sub/y [2000 2]
+/old/sub/y2 [2000 2]
+/mixed/x [1000 1]
+/mixed/unique [3000 3]
+/mixed/dup/y [2000 2]
+	`).Mkfs()
+	fs.MkdirAll("/old/sub/empty", 0o755)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Empty("/", &EmptyOptions{})
+	check(t, err)
+	got := strings.TrimSpace(out.String())
+	// /keep and /old only have copies of each other, so rm -r on either
+	// would leave it empty, but not on both, which is why / isn't listed
+	expected := strings.TrimSpace(`
+directories with only duplicates:
+  keep/
+  mixed/dup/
+  old/
+	`)
+	if got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+}
+
+func TestEmptyHardlinksAreNotCopies(t *testing.T) {
+	fs := testfs.Read(`
+/a/x [1000 1]
+/b/x [1000 1]
+	`).Mkfs()
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	// make them look like hardlinks
+	infos, _ := ps.db.AllInfos()
+	tx, _ := ps.db.Begin()
+	for _, info := range infos {
+		info.Inode = 42
+		info.Device = 1
+		tx.Add(info)
+	}
+	tx.Commit()
+	err := ps.Empty("/", &EmptyOptions{})
+	check(t, err)
+	if got := out.String(); got != "" {
+		t.Fatalf("expected no output, got '%s'", got)
+	}
+}
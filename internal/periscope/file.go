@@ -3,25 +3,23 @@ package periscope
 import (
 	"github.com/anishathalye/periscope/internal/herror"
 
+	"bytes"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
-
-	"golang.org/x/crypto/blake2b"
 )
 
 const initialChunkSize = 4 * 1024
 const readChunkSize = 1024 * 1024
 const ShortHashSize = 8
-const HashSize = blake2b.Size256
 
-func hashToArray(hash []byte) [HashSize]byte {
-	var res [HashSize]byte
-	copy(res[:], hash)
-	return res
-}
+// files larger than this get a sample hash, computed after the short hash but
+// before the full hash, so that large files that share a header but differ
+// later on don't need to be read in full
+const sampleThreshold = 4 * readChunkSize
+const sampleChunkSize = 64 * 1024
 
 func shortHashToArray(hash []byte) [ShortHashSize]byte {
 	var res [ShortHashSize]byte
@@ -31,36 +29,61 @@ func shortHashToArray(hash []byte) [ShortHashSize]byte {
 
 func (ps *Periscope) hashPartial(path string, key []byte) ([]byte, error) {
 	buf := make([]byte, initialChunkSize)
-	h, err := blake2b.New256(key)
+	h := ps.hash.new(key)
+	f, err := ps.open(path)
 	if err != nil {
 		return nil, err
 	}
-	f, err := ps.fs.Open(path)
+	defer f.Close()
+
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	h.Write(buf[:n])
+	return h.Sum(nil)[:ShortHashSize], nil
+}
+
+func needsSampleHash(size int64) bool {
+	return size > sampleThreshold
+}
+
+// hashes a chunk from the middle of the file and a chunk from the end of the
+// file; the file must be larger than sampleThreshold
+func (ps *Periscope) hashSamples(path string, size int64, key []byte) ([]byte, error) {
+	buf := make([]byte, sampleChunkSize)
+	h := ps.hash.new(key)
+	f, err := ps.open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
+	ra, ok := f.(io.ReaderAt)
+	if !ok {
+		// archive members can only be read sequentially
+		_, sampleHash, _, err := ps.hashStream(f, size)
+		return sampleHash, err
+	}
 
-	n, err := f.Read(buf)
-	if err != nil && err != io.EOF {
-		return nil, err
+	for _, offset := range []int64{(size - sampleChunkSize) / 2, size - sampleChunkSize} {
+		n, err := ra.ReadAt(buf, offset)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		h.Write(buf[:n])
 	}
-	h.Write(buf[:n])
 	return h.Sum(nil)[:ShortHashSize], nil
 }
 
 // a simpler hashFile that hashes the full file
 // purposefully avoiding code reuse with the above
 func (ps *Periscope) hashFile(path string) ([]byte, error) {
-	f, err := ps.fs.Open(path)
+	f, err := ps.open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer f.Close()
-	h, err := blake2b.New256(nil)
-	if err != nil {
-		return nil, err
-	}
+	h := ps.hash.new(nil)
 	buf := make([]byte, readChunkSize)
 	if _, err := io.CopyBuffer(h, f, buf); err != nil {
 		return nil, err
@@ -68,6 +91,38 @@ func (ps *Periscope) hashFile(path string) ([]byte, error) {
 	return h.Sum(nil), nil
 }
 
+// compares the contents of two files byte-for-byte
+func (ps *Periscope) sameContents(path1, path2 string) (bool, error) {
+	f1, err := ps.open(path1)
+	if err != nil {
+		return false, err
+	}
+	defer f1.Close()
+	f2, err := ps.open(path2)
+	if err != nil {
+		return false, err
+	}
+	defer f2.Close()
+	buf1 := make([]byte, readChunkSize)
+	buf2 := make([]byte, readChunkSize)
+	for {
+		n1, err1 := io.ReadFull(f1, buf1)
+		if err1 != nil && err1 != io.EOF && err1 != io.ErrUnexpectedEOF {
+			return false, err1
+		}
+		n2, err2 := io.ReadFull(f2, buf2)
+		if err2 != nil && err2 != io.EOF && err2 != io.ErrUnexpectedEOF {
+			return false, err2
+		}
+		if n1 != n2 || !bytes.Equal(buf1[:n1], buf2[:n2]) {
+			return false, nil
+		}
+		if n1 < readChunkSize {
+			return true, nil
+		}
+	}
+}
+
 func relPath(absDirectory, absPath string) string {
 	relPath, err := filepath.Rel(absDirectory, absPath)
 	if err != nil || len(relPath) > len(absPath) {
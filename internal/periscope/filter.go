@@ -0,0 +1,141 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/herror"
+	"github.com/anishathalye/periscope/internal/ignore"
+
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/spf13/afero"
+)
+
+const ignoreFileName = ".periscopeignore"
+
+// decides which files and directories are visited while walking a scan root
+//
+// exclude and include patterns are matched against paths relative to the
+// scan root; patterns in ignore files are matched against paths relative to
+// the directory containing the ignore file, and patterns in deeper ignore
+// files take precedence over ones closer to the root
+type scanFilter struct {
+	fs        afero.Fs
+	errStream io.Writer
+	exclude   *ignore.Matcher
+	include   *ignore.Matcher
+
+	root        string
+	ignoreFiles map[string]*ignore.Matcher // by directory
+}
+
+func (ps *Periscope) newScanFilter(options *ScanOptions) (*scanFilter, herror.Interface) {
+	exclude, err := ignore.New(options.Exclude)
+	if err != nil {
+		return nil, herror.UserF(nil, "invalid exclude pattern: %s", err)
+	}
+	include, err := ignore.New(options.Include)
+	if err != nil {
+		return nil, herror.UserF(nil, "invalid include pattern: %s", err)
+	}
+	return &scanFilter{
+		fs:        ps.fs,
+		errStream: ps.errStream,
+		exclude:   exclude,
+		include:   include,
+	}, nil
+}
+
+// must be called before walking each root
+func (f *scanFilter) start(root string) {
+	f.root = root
+	f.ignoreFiles = make(map[string]*ignore.Matcher)
+}
+
+// reports whether the walk should skip the given path; for directories, the
+// entire subtree should be skipped
+//
+// directories must be visited before their contents
+func (f *scanFilter) skip(absPath string, info os.FileInfo) bool {
+	isDir := info.IsDir()
+	if absPath != f.root {
+		rel := f.relToRoot(absPath)
+		if _, ignored := f.exclude.Match(rel, isDir); ignored {
+			return true
+		}
+		if f.ignoredByFiles(absPath, isDir) {
+			return true
+		}
+		if !isDir && !f.included(rel) {
+			return true
+		}
+	}
+	if isDir {
+		f.loadIgnoreFile(absPath)
+	}
+	return false
+}
+
+func (f *scanFilter) relToRoot(absPath string) string {
+	rel, err := filepath.Rel(f.root, absPath)
+	if err != nil {
+		return filepath.ToSlash(absPath)
+	}
+	return filepath.ToSlash(rel)
+}
+
+func (f *scanFilter) ignoredByFiles(absPath string, isDir bool) bool {
+	dir := filepath.Dir(absPath)
+	for {
+		if m, ok := f.ignoreFiles[dir]; ok {
+			rel, err := filepath.Rel(dir, absPath)
+			if err == nil {
+				if matched, ignored := m.Match(filepath.ToSlash(rel), isDir); matched {
+					return ignored
+				}
+			}
+		}
+		if dir == f.root || dir == filepath.Dir(dir) {
+			return false
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// a file is included if there are no include patterns, or if the file or any
+// directory containing it (under the root) matches one
+func (f *scanFilter) included(rel string) bool {
+	if f.include.Empty() {
+		return true
+	}
+	isDir := false
+	for p := rel; p != "." && p != "/"; p = path.Dir(p) {
+		if _, included := f.include.Match(p, isDir); included {
+			return true
+		}
+		isDir = true
+	}
+	return false
+}
+
+func (f *scanFilter) loadIgnoreFile(dir string) {
+	ignorePath := filepath.Join(dir, ignoreFileName)
+	file, err := f.fs.Open(ignorePath)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("unable to open '%s': %s", ignorePath, err)
+		}
+		return
+	}
+	defer file.Close()
+	m, err := ignore.Parse(file)
+	if err != nil {
+		fmt.Fprintf(f.errStream, "invalid patterns in '%s': %s\n", ignorePath, err)
+	}
+	if !m.Empty() {
+		f.ignoreFiles[dir] = m
+	}
+}
@@ -0,0 +1,23 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/fuzzyhash"
+
+	"io"
+)
+
+// computes a similarity digest of a file's contents; the digest is stored in
+// the database as a string, in the format of fuzzyhash.Digest.String
+func (ps *Periscope) hashFuzzy(path string) ([]byte, error) {
+	f, err := ps.open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := fuzzyhash.New()
+	buf := make([]byte, readChunkSize)
+	if _, err := io.CopyBuffer(h, f, buf); err != nil {
+		return nil, err
+	}
+	return []byte(h.Sum().String()), nil
+}
@@ -0,0 +1,118 @@
+package periscope
+
+import (
+	"bytes"
+	"math/rand"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// a document of random words
+func writeDocument(rng *rand.Rand, words int) []byte {
+	vocabulary := []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel", "india", "juliett", "kilo", "lima"}
+	var buf bytes.Buffer
+	for i := 0; i < words; i++ {
+		buf.WriteString(vocabulary[rng.Intn(len(vocabulary))])
+		if i%10 == 9 {
+			buf.WriteString(".\n")
+		} else {
+			buf.WriteString(" ")
+		}
+	}
+	return buf.Bytes()
+}
+
+func TestScanFuzzy(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	rng := rand.New(rand.NewSource(0))
+	afero.WriteFile(fs, "/docs/report.txt", writeDocument(rng, 10000), 0o644)
+	afero.WriteFile(fs, "/docs/other.txt", writeDocument(rng, 10000), 0o644)
+	afero.WriteFile(fs, "/empty", nil, 0o644)
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{Fuzzy: true})
+	check(t, err)
+	infos, _ := ps.db.AllInfos()
+	for _, info := range infos {
+		if (info.FuzzyHash == nil) != (info.Path == "/empty") {
+			t.Errorf("%s: unexpected fuzzy hash %q", info.Path, info.FuzzyHash)
+		}
+	}
+
+	// a scan without the option doesn't compute digests
+	ps, _, _ = newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	infos, _ = ps.db.AllInfos()
+	for _, info := range infos {
+		if info.FuzzyHash != nil {
+			t.Errorf("%s: expected no fuzzy hash", info.Path)
+		}
+	}
+}
+
+func TestSimilarFiles(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	rng := rand.New(rand.NewSource(0))
+	report := writeDocument(rng, 10000)
+	var edited []byte
+	edited = append(edited, report[:len(report)/3]...)
+	edited = append(edited, writeDocument(rng, 200)...)
+	edited = append(edited, report[len(report)/3:]...)
+	afero.WriteFile(fs, "/docs/report.txt", report, 0o644)
+	afero.WriteFile(fs, "/docs/copy.txt", report, 0o644)
+	afero.WriteFile(fs, "/old/report-v2.txt", edited, 0o644)
+	afero.WriteFile(fs, "/docs/other.txt", writeDocument(rng, 10000), 0o644)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{Fuzzy: true})
+	err := ps.Similar("/docs/report.txt", &SimilarOptions{Threshold: DefaultSimilarThreshold})
+	check(t, err)
+	got := strings.TrimSpace(out.String())
+	expected := regexp.MustCompile(`^100 /docs/copy\.txt \(63 kB\)\n *\d+ /old/report-v2\.txt \(64 kB\)$`)
+	if !expected.MatchString(got) {
+		t.Fatalf("unexpected output '%s'", got)
+	}
+
+	out.Reset()
+	err = ps.Similar("/docs/report.txt", &SimilarOptions{Threshold: DefaultSimilarThreshold, Relative: true})
+	check(t, err)
+	got = out.String()
+	if !strings.Contains(got, " copy.txt (") || !strings.Contains(got, " /old/report-v2.txt (") {
+		t.Fatalf("unexpected output '%s'", got)
+	}
+
+	// the file doesn't need to be in the database
+	afero.WriteFile(fs, "/new.txt", report, 0o644)
+	out.Reset()
+	err = ps.Similar("/new.txt", &SimilarOptions{Threshold: 100})
+	check(t, err)
+	got = strings.TrimSpace(out.String())
+	expectedExact := "100 /docs/copy.txt (63 kB)\n100 /docs/report.txt (63 kB)"
+	if got != expectedExact {
+		t.Fatalf("expected '%s', got '%s'", expectedExact, got)
+	}
+}
+
+func TestSimilarFilesImage(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writePicture(fs, "/a.png", 400, 300, false)
+	copyPNGWithText(fs, "/a.png", "/b.png", "edited")
+	ps, out, _ := newTest(fs)
+	// without perceptual hashes, images are compared by their similarity
+	// digests
+	ps.Scan([]string{"/"}, &ScanOptions{Fuzzy: true})
+	err := ps.Similar("/a.png", &SimilarOptions{Distance: DefaultSimilarDistance, Threshold: DefaultSimilarThreshold})
+	check(t, err)
+	got := out.String()
+	if !regexp.MustCompile(`^ *\d+ /b\.png \(`).MatchString(got) {
+		t.Fatalf("unexpected output '%s'", got)
+	}
+}
+
+func TestSimilarInvalidThreshold(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	ps, _, _ := newTest(fs)
+	err := ps.Similar("", &SimilarOptions{Threshold: 101})
+	checkErr(t, err)
+}
@@ -10,10 +10,19 @@ import (
 )
 
 type HashOptions struct {
+	// print hashes in the BSD-style format of checksum tools with "--tag",
+	// e.g. "sha256sum --tag", which names the algorithm on every line;
+	// otherwise, the algorithm is named once, in a comment line before the
+	// hashes
+	Tag bool
 }
 
 func (ps *Periscope) Hash(paths []string, options *HashOptions) herror.Interface {
 	szBuf := make([]byte, 8)
+	printedAlgorithm := false
+	if err := ps.setHashAlgorithm(""); err != nil {
+		return err
+	}
 	tx, herr := ps.db.Begin()
 	if herr != nil {
 		return herr
@@ -33,22 +42,42 @@ func (ps *Periscope) Hash(paths []string, options *HashOptions) herror.Interface
 			tx.Rollback()
 			return herror.Internal(err, "")
 		}
+		var sampleHash []byte
+		if needsSampleHash(size) {
+			sampleHash, err = ps.hashSamples(abs, size, szBuf)
+			if err != nil {
+				tx.Rollback()
+				return herror.Internal(err, "")
+			}
+		}
 		fullHash, err := ps.hashFile(abs)
 		if err != nil {
 			tx.Rollback()
 			return herror.Internal(err, "")
 		}
 		info := db.FileInfo{
-			Path:      abs,
-			Size:      size,
-			ShortHash: shortHash,
-			FullHash:  fullHash,
+			Path:       abs,
+			Size:       size,
+			ShortHash:  shortHash,
+			SampleHash: sampleHash,
+			FullHash:   fullHash,
 		}
+		setStatMetadata(&info, statInfo)
 		if err := tx.Add(info); err != nil {
 			tx.Rollback()
 			return err
 		}
-		fmt.Fprintf(ps.outStream, "%s  %s\n", hex.EncodeToString(fullHash), path)
+		if options.Tag {
+			fmt.Fprintf(ps.outStream, "%s (%s) = %s\n", ps.hash.tag, path, hex.EncodeToString(fullHash))
+		} else {
+			if !printedAlgorithm {
+				// checksum tools skip lines starting with '#' when
+				// checking, so the output can still be checked
+				fmt.Fprintf(ps.outStream, "# %s\n", ps.hash.tag)
+				printedAlgorithm = true
+			}
+			fmt.Fprintf(ps.outStream, "%s  %s\n", hex.EncodeToString(fullHash), path)
+		}
 	}
 	if err := tx.Commit(); err != nil {
 		return err
@@ -8,6 +8,8 @@ import (
 	"fmt"
 	"strings"
 	"testing"
+
+	"github.com/spf13/afero"
 )
 
 func TestHashBasic(t *testing.T) {
@@ -26,7 +28,7 @@ func TestHashBasic(t *testing.T) {
 		t.Fatal("expected hashes to be populated and correct")
 	}
 	got := strings.TrimSpace(out.String())
-	expected := fmt.Sprintf("%s  /a", hex.EncodeToString(ref))
+	expected := fmt.Sprintf("# BLAKE2b-256\n%s  /a", hex.EncodeToString(ref))
 	if got != expected {
 		t.Fatalf("expected '%s', got '%s'", expected, got)
 	}
@@ -76,3 +78,61 @@ func TestHashComplete(t *testing.T) {
 		t.Fatal("expected hashes to be populated")
 	}
 }
+
+func TestHashAlgorithms(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/empty", []byte{}, 0o644)
+	ps, _, _ := newTest(fs)
+	expected := map[string]string{
+		"blake2b": "0e5751c026e543b2e8ab2eb06099daa1d1e5df47778f7787faab45cdf12fe3a8",
+		"sha256":  "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		"xxh3":    "99aa06d3014798d86001c324468d497f",
+	}
+	for _, name := range HashAlgorithms() {
+		ps.hash = hashAlgorithms[name]
+		hash, err := ps.hashFile("/empty")
+		check(t, err)
+		if hex.EncodeToString(hash) != expected[name] {
+			t.Errorf("%s: expected %s, got %x", name, expected[name], hash)
+		}
+		// short hashes are keyed
+		short1, err := ps.hashPartial("/empty", []byte{1})
+		check(t, err)
+		short2, err := ps.hashPartial("/empty", []byte{2})
+		check(t, err)
+		if len(short1) != ShortHashSize || bytes.Equal(short1, short2) {
+			t.Errorf("%s: expected distinct short hashes, got %x and %x", name, short1, short2)
+		}
+	}
+}
+
+func TestHashPrintsAlgorithm(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/empty", []byte{}, 0o644)
+	ps, out, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{Hash: "sha256"})
+	check(t, err)
+	err = ps.Hash([]string{"/empty"}, &HashOptions{Tag: true})
+	check(t, err)
+	got := strings.TrimSpace(out.String())
+	expected := "SHA256 (/empty) = e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	if got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+	out.Reset()
+	err = ps.Hash([]string{"/empty"}, &HashOptions{})
+	check(t, err)
+	got = strings.TrimSpace(out.String())
+	expected = "# SHA256\ne3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855  /empty"
+	if got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+}
+
+func TestLoadUnknownHashAlgorithm(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	ps, _, _ := newTest(fs)
+	check(t, ps.db.SetMeta(hashAlgorithmKey, "md4"))
+	_, err := loadHashAlgorithm(ps.db)
+	checkErr(t, err)
+}
@@ -0,0 +1,77 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/imagehash"
+
+	"encoding/binary"
+	"image"
+	"path/filepath"
+	"strings"
+)
+
+var imageExtensions = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".gif":  true,
+}
+
+// the key for pixel hashes, so that they never collide with full hashes
+var pixelHashKey = []byte("pixels")
+
+// whether a file is an image that can get a perceptual hash, judging by its
+// name
+func isImage(path string) bool {
+	return imageExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+func (ps *Periscope) decodeImage(path string) (image.Image, error) {
+	f, err := ps.open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return imagehash.Decode(f)
+}
+
+// decodes an image once, computing its perceptual hash and its pixel hash, as
+// requested
+func (ps *Periscope) hashImage(path string, perceptual, pixels bool) (imageHash, pixelHash []byte, err error) {
+	img, err := ps.decodeImage(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if perceptual {
+		imageHash = binary.BigEndian.AppendUint64(nil, imagehash.Hash(img))
+	}
+	if pixels {
+		h := ps.hash.new(pixelHashKey)
+		if err := imagehash.WritePixels(h, img); err != nil {
+			return nil, nil, err
+		}
+		pixelHash = h.Sum(nil)
+	}
+	return imageHash, pixelHash, nil
+}
+
+func (ps *Periscope) hashPixels(path string) ([]byte, error) {
+	_, pixelHash, err := ps.hashImage(path, false, true)
+	return pixelHash, err
+}
+
+// compares the decoded pixels of two images
+func (ps *Periscope) samePixels(path1, path2 string) (bool, error) {
+	img1, err := ps.decodeImage(path1)
+	if err != nil {
+		return false, err
+	}
+	img2, err := ps.decodeImage(path2)
+	if err != nil {
+		return false, err
+	}
+	return imagehash.SamePixels(img1, img2), nil
+}
+
+func imageHashToUint64(hash []byte) uint64 {
+	return binary.BigEndian.Uint64(hash)
+}
@@ -0,0 +1,208 @@
+package periscope
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// copies a PNG, adding a text chunk, so the copy has the same pixels but
+// different metadata
+func copyPNGWithText(fs afero.Fs, src, dst, text string) {
+	data, _ := afero.ReadFile(fs, src)
+	const ihdrEnd = 8 + 4 + 4 + 13 + 4 // signature, then the IHDR chunk
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len("Comment")+1+len(text)))
+	body := append([]byte("tEXtComment\x00"), text...)
+	chunk = append(chunk, body...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(body))
+	var out bytes.Buffer
+	out.Write(data[:ihdrEnd])
+	out.Write(chunk)
+	out.Write(data[ihdrEnd:])
+	afero.WriteFile(fs, dst, out.Bytes(), 0o644)
+}
+
+func TestScanImagePixels(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writePicture(fs, "/a.png", 100, 80, false)
+	copyPNGWithText(fs, "/a.png", "/b.png", "edited")
+	writePicture(fs, "/c.png", 100, 80, true)
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{ImagePixels: true})
+	check(t, err)
+	infos, _ := ps.db.AllInfos()
+	pixelHashes := make(map[string][]byte)
+	for _, info := range infos {
+		if info.ImageHash != nil {
+			t.Errorf("%s: expected no perceptual hash", info.Path)
+		}
+		pixelHashes[info.Path] = info.PixelHash
+	}
+	if pixelHashes["/a.png"] == nil || !bytes.Equal(pixelHashes["/a.png"], pixelHashes["/b.png"]) {
+		t.Fatal("expected /a.png and /b.png to have the same pixel hash")
+	}
+	if bytes.Equal(pixelHashes["/a.png"], pixelHashes["/c.png"]) {
+		t.Fatal("expected /c.png to have a different pixel hash")
+	}
+}
+
+func TestReportMetadataDiffers(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writePicture(fs, "/a.png", 100, 80, false)
+	copyPNGWithText(fs, "/a.png", "/b.png", "edited")
+	writePicture(fs, "/c.png", 100, 80, true)
+	afero.WriteFile(fs, "/x", []byte("x"), 0o644)
+	afero.WriteFile(fs, "/y", []byte("x"), 0o644)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{ImagePixels: true})
+	err := ps.Report("", &ReportOptions{})
+	check(t, err)
+	got := strings.TrimSpace(out.String())
+	expected := strings.TrimSpace(`
+1 B
+  /x
+  /y
+
+same pixels, different metadata
+  /b.png (`) // sizes checked below
+	if !strings.HasPrefix(got, expected) || !strings.Contains(got, "\n  /a.png (") || strings.Contains(got, "/c.png") {
+		t.Fatalf("expected '%s...', got '%s'", expected, got)
+	}
+}
+
+func TestReportIdenticalImages(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writePicture(fs, "/a.png", 100, 80, false)
+	writePicture(fs, "/b.png", 100, 80, false)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{ImagePixels: true})
+	err := ps.Report("", &ReportOptions{})
+	check(t, err)
+	if strings.Contains(out.String(), "metadata") {
+		t.Fatalf("expected identical images to only be reported as duplicates, got '%s'", out.String())
+	}
+}
+
+func TestInfoMetadataDiffers(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writePicture(fs, "/a.png", 100, 80, false)
+	copyPNGWithText(fs, "/a.png", "/b.png", "edited")
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{ImagePixels: true})
+	err := ps.Info([]string{"/a.png"}, &InfoOptions{})
+	check(t, err)
+	got := out.String()
+	if !strings.Contains(got, "pixel hash: ") || !strings.Contains(got, "metadata differs: 1") || !strings.Contains(got, "    /b.png (metadata differs)\n") {
+		t.Fatalf("unexpected info output '%s'", got)
+	}
+}
+
+func TestRmAllowMetadataDifferences(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writePicture(fs, "/a.png", 100, 80, false)
+	copyPNGWithText(fs, "/a.png", "/b.png", "edited")
+	ps, _, errStream := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{ImagePixels: true})
+	err := ps.Rm([]string{"/b.png"}, &RmOptions{})
+	checkErr(t, err)
+	if !strings.Contains(errStream.String(), "no duplicates") {
+		t.Fatalf("expected error about no duplicates, got '%s'", errStream.String())
+	}
+	err = ps.Rm([]string{"/b.png"}, &RmOptions{AllowMetadataDifferences: true, Paranoid: true})
+	check(t, err)
+	if _, err := fs.Stat("/b.png"); !os.IsNotExist(err) {
+		t.Fatal("expected /b.png to be removed")
+	}
+	// the last copy is never removed
+	err = ps.Rm([]string{"/a.png"}, &RmOptions{AllowMetadataDifferences: true})
+	checkErr(t, err)
+	if _, err := fs.Stat("/a.png"); err != nil {
+		t.Fatal("expected /a.png to be kept")
+	}
+}
+
+func TestRmAllowMetadataDifferencesRecursive(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writePicture(fs, "/keep/a.png", 100, 80, false)
+	copyPNGWithText(fs, "/keep/a.png", "/dir/b.png", "edited")
+	copyPNGWithText(fs, "/keep/a.png", "/dir/c.png", "edited")
+	writePicture(fs, "/dir/d.png", 100, 80, true)
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{ImagePixels: true})
+	err := ps.Rm([]string{"/dir"}, &RmOptions{Recursive: true, AllowMetadataDifferences: true})
+	check(t, err)
+	for _, path := range []string{"/dir/b.png", "/dir/c.png"} {
+		if _, err := fs.Stat(path); !os.IsNotExist(err) {
+			t.Fatalf("expected %s to be removed", path)
+		}
+	}
+	for _, path := range []string{"/keep/a.png", "/dir/d.png"} {
+		if _, err := fs.Stat(path); err != nil {
+			t.Fatalf("expected %s to be kept", path)
+		}
+	}
+}
+
+func TestRmAllowMetadataDifferencesChanged(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writePicture(fs, "/a.png", 100, 80, false)
+	copyPNGWithText(fs, "/a.png", "/b.png", "edited")
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{ImagePixels: true})
+	// the copy is changed after the scan, so it no longer has the same
+	// pixels
+	writePicture(fs, "/a.png", 100, 80, true)
+	err := ps.Rm([]string{"/b.png"}, &RmOptions{AllowMetadataDifferences: true})
+	checkErr(t, err)
+	if _, err := fs.Stat("/b.png"); err != nil {
+		t.Fatal("expected /b.png to be kept")
+	}
+}
+
+func TestUndoAllowMetadataDifferences(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writePicture(fs, "/a.png", 100, 80, false)
+	copyPNGWithText(fs, "/a.png", "/b.png", "edited")
+	ps, out, errStream := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{ImagePixels: true})
+	original, _ := afero.ReadFile(fs, "/b.png")
+	survivor, _ := afero.ReadFile(fs, "/a.png")
+	check(t, ps.Rm([]string{"/b.png"}, &RmOptions{AllowMetadataDifferences: true}))
+	check(t, ps.Undo(&UndoOptions{Verbose: true}))
+	if got := out.String(); got != "cp /a.png /b.png\n" {
+		t.Fatalf("unexpected output '%s'", got)
+	}
+	// the image is restored with the survivor's metadata, and that's
+	// pointed out
+	restored, err := afero.ReadFile(fs, "/b.png")
+	check(t, err)
+	if !bytes.Equal(restored, survivor) || bytes.Equal(restored, original) {
+		t.Fatal("expected /b.png to be restored as a copy of /a.png")
+	}
+	if !strings.Contains(errStream.String(), "WARNING: '/b.png' was restored from '/a.png', which has the same pixels but different metadata") {
+		t.Fatalf("expected a warning about metadata, got '%s'", errStream.String())
+	}
+}
+
+func TestUndoAllowMetadataDifferencesChanged(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writePicture(fs, "/a.png", 100, 80, false)
+	copyPNGWithText(fs, "/a.png", "/b.png", "edited")
+	ps, _, errStream := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{ImagePixels: true})
+	check(t, ps.Rm([]string{"/b.png"}, &RmOptions{AllowMetadataDifferences: true}))
+	// the survivor no longer has the removed image's pixels
+	writePicture(fs, "/a.png", 100, 80, true)
+	checkErr(t, ps.Undo(&UndoOptions{}))
+	if !strings.Contains(errStream.String(), "cannot restore '/b.png': '/a.png' no longer has the same pixels") {
+		t.Fatalf("unexpected error output '%s'", errStream.String())
+	}
+	if _, err := fs.Stat("/b.png"); !os.IsNotExist(err) {
+		t.Fatal("expected /b.png not to be restored")
+	}
+}
@@ -1,6 +1,7 @@
 package periscope
 
 import (
+	"github.com/anishathalye/periscope/internal/db"
 	"github.com/anishathalye/periscope/internal/herror"
 
 	"encoding/hex"
@@ -39,27 +40,78 @@ func (ps *Periscope) info1(path string, options *InfoOptions) herror.Interface {
 	if herr != nil {
 		return herr
 	}
-	nCopies := len(dupeSet)
 	nDupes := 0
-	if nCopies > 1 {
-		nDupes = nCopies - 1
+	nLinks := 0
+	if len(dupeSet) > 1 {
+		nDupes = dupeSet.Files() - 1
+		for _, info := range dupeSet[1:] {
+			if info.SameFile(&dupeSet[0]) {
+				nLinks++
+			}
+		}
+	}
+	// files that are equivalent but aren't duplicates, for each kind of
+	// equivalence
+	equivalent := make([][]db.FileInfo, len(equivalences))
+	for i, equivalence := range equivalences {
+		if len(dupeSet) == 0 {
+			break
+		}
+		set, herr := ps.db.LookupEquivalent(equivalence.kind, absPath)
+		if herr != nil {
+			return herr
+		}
+		for _, info := range set[1:] {
+			if !knownIdentical(&info, &set[0]) {
+				equivalent[i] = append(equivalent[i], info)
+			}
+		}
 	}
 	fmt.Fprintf(ps.outStream, "%s\n", path)
 	w := tabwriter.NewWriter(ps.outStream, 0, 0, 0, ' ', tabwriter.DiscardEmptyColumns|tabwriter.AlignRight)
 	if len(dupeSet) > 0 {
 		info := dupeSet[0]
+		if info.ShortHash != nil || info.FullHash != nil {
+			fmt.Fprintf(w, "  algorithm:\v %s\n", ps.hash.tag)
+		}
 		if info.ShortHash != nil {
 			fmt.Fprintf(w, "  short hash:\v %s\n", hex.EncodeToString(info.ShortHash))
 		}
+		if info.SampleHash != nil {
+			fmt.Fprintf(w, "  sample hash:\v %s\n", hex.EncodeToString(info.SampleHash))
+		}
 		if info.FullHash != nil {
 			fmt.Fprintf(w, "  full hash:\v %s\n", hex.EncodeToString(info.FullHash))
 		}
+		if info.ImageHash != nil {
+			fmt.Fprintf(w, "  image hash:\v %s\n", hex.EncodeToString(info.ImageHash))
+		}
+		if info.PixelHash != nil {
+			fmt.Fprintf(w, "  pixel hash:\v %s\n", hex.EncodeToString(info.PixelHash))
+		}
+		if info.TextHash != nil {
+			fmt.Fprintf(w, "  text hash:\v %s\n", hex.EncodeToString(info.TextHash))
+		}
+		if info.FuzzyHash != nil {
+			fmt.Fprintf(w, "  fuzzy hash:\v %s\n", info.FuzzyHash)
+		}
+		if info.DecompressedHash != nil {
+			fmt.Fprintf(w, "  decompressed hash:\v %s\n", hex.EncodeToString(info.DecompressedHash))
+		}
 	}
 	if nDupes > 0 {
 		fmt.Fprintf(w, "  duplicates:\v %d\n", nDupes)
 	}
+	if nLinks > 0 {
+		fmt.Fprintf(w, "  hardlinks:\v %d\n", nLinks)
+	}
+	for i, equivalence := range equivalences {
+		if len(equivalent[i]) > 0 {
+			fmt.Fprintf(w, "  %s:\v %d\n", equivalence.label, len(equivalent[i]))
+		}
+	}
 	w.Flush()
-	if nDupes > 0 {
+	if nDupes > 0 || nLinks > 0 {
 		dirPath := filepath.Dir(absPath)
 		for _, info := range dupeSet {
 			if info.Path != absPath {
@@ -67,8 +119,25 @@ func (ps *Periscope) info1(path string, options *InfoOptions) herror.Interface {
 				if options.Relative {
 					showPath = relPath(dirPath, info.Path)
 				}
-				fmt.Fprintf(ps.outStream, "    %s\n", showPath)
+				if info.SameFile(&dupeSet[0]) {
+					fmt.Fprintf(ps.outStream, "    %s (hardlink)\n", showPath)
+				} else {
+					fmt.Fprintf(ps.outStream, "    %s\n", showPath)
+				}
+			}
+		}
+	}
+	for i, equivalence := range equivalences {
+		for _, info := range equivalent[i] {
+			showPath := info.Path
+			if options.Relative {
+				showPath = relPath(filepath.Dir(absPath), info.Path)
+			}
+			note := equivalence.label
+			if equivalence.annotate != nil {
+				note = equivalence.annotate(&info)
 			}
+			fmt.Fprintf(ps.outStream, "    %s (%s)\n", showPath, note)
 		}
 	}
 	return nil
@@ -26,6 +26,7 @@ func TestInfoBasic(t *testing.T) {
 	got := strings.TrimSpace(out.String())
 	expected := regexp.MustCompile(strings.TrimSpace(`
 ^/b
+   algorithm: BLAKE2b-256
   short hash: ................
    full hash: ................................................................
   duplicates: 2
@@ -51,6 +52,7 @@ func TestInfoMultiple(t *testing.T) {
 	got := strings.TrimSpace(out.String())
 	expected := regexp.MustCompile(strings.TrimSpace(`
 ^/b
+   algorithm: BLAKE2b-256
   short hash: ................
    full hash: ................................................................
   duplicates: 2
@@ -58,6 +60,7 @@ func TestInfoMultiple(t *testing.T) {
     /c
 
 /a
+   algorithm: BLAKE2b-256
   short hash: ................
    full hash: ................................................................
   duplicates: 2
@@ -83,6 +86,7 @@ func TestInfoRelative(t *testing.T) {
 	got := strings.TrimSpace(out.String())
 	expected := regexp.MustCompile(strings.TrimSpace(`
 ^/long/directory/path/a
+   algorithm: BLAKE2b-256
   short hash: ................
    full hash: ................................................................
   duplicates: 2
@@ -108,6 +112,7 @@ func TestInfoRelativeTooLong(t *testing.T) {
 	got := strings.TrimSpace(out.String())
 	expected := regexp.MustCompile(strings.TrimSpace(`
 ^/long/directory/path/a
+   algorithm: BLAKE2b-256
   short hash: ................
    full hash: ................................................................
   duplicates: 2
@@ -141,3 +146,34 @@ func TestInfoBadSymlinks(t *testing.T) {
 	err := ps.Info([]string{filepath.Join(dir, "a")}, &InfoOptions{})
 	checkErr(t, err)
 }
+
+func TestInfoHardlinks(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := hardlinkDir()
+	defer os.RemoveAll(dir)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{dir}, &ScanOptions{})
+	err := ps.Info([]string{filepath.Join(dir, "a"), filepath.Join(dir, "x")}, &InfoOptions{Relative: true})
+	check(t, err)
+	got := strings.TrimSpace(out.String())
+	expected := regexp.MustCompile(strings.TrimSpace(`
+^.*/a
+   algorithm: BLAKE2b-256
+  short hash: ................
+   full hash: ................................................................
+  duplicates: 1
+   hardlinks: 1
+    b \(hardlink\)
+    c
+
+.*/x
+   algorithm: BLAKE2b-256
+  short hash: ................
+   full hash: ................................................................
+   hardlinks: 1
+    y \(hardlink\)$
+	`))
+	if !expected.MatchString(got) {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+}
@@ -0,0 +1,271 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/db"
+	"github.com/anishathalye/periscope/internal/herror"
+
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/afero"
+)
+
+// an operation whose removals are being recorded in the journal; it's only
+// added to the database once it removes a file
+type journalOperation struct {
+	command string
+	time    time.Time
+	id      int64
+}
+
+// Records a file in the journal before it's removed, so that it can be
+// recreated from the survivor later; the file's mode and modification time
+// are taken from stat, and pixelHash is set if the survivor was only checked
+// to have the same pixels.
+func (ps *Periscope) journal(absPath, survivor string, info db.FileInfo, stat os.FileInfo, pixelHash []byte) (int64, herror.Interface) {
+	op := ps.operation
+	if op.id == 0 {
+		id, err := ps.db.AddOperation(op.time.Unix(), op.command)
+		if err != nil {
+			return 0, err
+		}
+		op.id = id
+	}
+	return ps.db.AddJournalEntry(db.JournalEntry{
+		Operation: op.id,
+		Path:      absPath,
+		Size:      info.Size,
+		FullHash:  info.FullHash,
+		Mode:      uint32(stat.Mode()),
+		Mtime:     stat.ModTime().UnixNano(),
+		Survivor:  survivor,
+		PixelHash: pixelHash,
+	})
+}
+
+type JournalOptions struct {
+}
+
+// Lists past operations that removed files, oldest first, with the number and
+// size of the files that can still be restored by Undo.
+func (ps *Periscope) Journal(options *JournalOptions) herror.Interface {
+	ops, err := ps.db.Operations()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(ps.outStream, 0, 0, 0, ' ', tabwriter.DiscardEmptyColumns|tabwriter.AlignRight)
+	for _, op := range ops {
+		when := time.Unix(op.Time, 0).Format("2006-01-02 15:04")
+		fmt.Fprintf(w, "%s\v %s\v %s\v  %s\n", when, fileCount(op.Files), humanize.Bytes(uint64(op.Size)), op.Command)
+	}
+	w.Flush()
+	return nil
+}
+
+type UndoOptions struct {
+	// undo the given number of most recent operations; if neither this nor
+	// Since is set, only the most recent operation is undone
+	Last int
+	// undo all operations that started at or after this time
+	Since   time.Time
+	Verbose bool
+}
+
+// Recreates files removed by past operations, most recent first, by copying
+// the duplicate that each file was verified against back to where the file
+// was. The copy must still have the removed file's hash, and the file's mode
+// and modification time are restored too. Images that were removed because
+// the copy has the same pixels are restored if the copy still has those
+// pixels, with a warning, because the restored file has the copy's metadata
+// rather than its own. Files that were moved to the trash and are still there
+// are moved back instead, and are no longer in the trash.
+//
+// Files are only restored where nothing exists, except for symbolic links to
+// the duplicate, which is what Symlink leaves behind, and hardlinks to it,
+// which is what Link leaves behind. Restored files aren't
+// added back to the database; scan them again to find their duplicates.
+func (ps *Periscope) Undo(options *UndoOptions) herror.Interface {
+	ops, err := ps.db.Operations()
+	if err != nil {
+		return err
+	}
+	var selected []db.Operation
+	if !options.Since.IsZero() {
+		for _, op := range ops {
+			if op.Time >= options.Since.Unix() {
+				selected = append(selected, op)
+			}
+		}
+	} else {
+		last := max(options.Last, 1)
+		selected = ops[max(len(ops)-last, 0):]
+	}
+	if len(selected) == 0 {
+		return herror.User(nil, "nothing to undo")
+	}
+	var herr herror.Interface
+	for i := len(selected) - 1; i >= 0; i-- {
+		entries, err := ps.db.JournalEntries(selected[i].ID)
+		if err != nil {
+			return err
+		}
+		for j := len(entries) - 1; j >= 0; j-- {
+			if err := ps.undo1(entries[j], options); err != nil {
+				if !herror.IsSilent(err) {
+					return err
+				}
+				herr = err
+			}
+		}
+	}
+	if err := ps.db.RemoveEmptyOperations(); err != nil {
+		return err
+	}
+	return herr
+}
+
+func (ps *Periscope) undo1(entry db.JournalEntry, options *UndoOptions) herror.Interface {
+	// a file that was moved to the trash is moved back, like TrashRestore
+	// does, unless it's been deleted from the trash since
+	file, ok, herr := ps.db.TrashedByJournal(entry.ID)
+	if herr != nil {
+		return herr
+	}
+	if ok {
+		trash, herr := ps.trashDir()
+		if herr != nil {
+			return herr
+		}
+		if _, err := ps.fs.Stat(filepath.Join(trash, "files", file.Name)); err == nil {
+			return ps.restore1(trash, file, &TrashRestoreOptions{Verbose: options.Verbose})
+		}
+		ps.fs.Remove(filepath.Join(trash, "info", file.Name+".trashinfo"))
+		if herr := ps.db.RemoveTrashed(file.Name); herr != nil {
+			return herr
+		}
+	}
+	if ps.occupied(entry.Path, entry.Survivor) {
+		fmt.Fprintf(ps.errStream, "cannot restore '%s': file exists\n", entry.Path)
+		return herror.Silent()
+	}
+	if err := ps.fs.MkdirAll(filepath.Dir(entry.Path), 0o755); err != nil {
+		fmt.Fprintf(ps.errStream, "cannot restore '%s': %s\n", entry.Path, err)
+		return herror.Silent()
+	}
+	pixelsOnly, err := ps.restoreCopy(entry)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Fprintf(ps.errStream, "cannot restore '%s': '%s' no longer exists\n", entry.Path, entry.Survivor)
+		} else if os.IsPermission(err) {
+			fmt.Fprintf(ps.errStream, "cannot restore '%s': permission denied\n", entry.Path)
+		} else {
+			fmt.Fprintf(ps.errStream, "cannot restore '%s': %s\n", entry.Path, err)
+		}
+		return herror.Silent()
+	}
+	if options.Verbose {
+		fmt.Fprintf(ps.outStream, "cp %s %s\n", entry.Survivor, entry.Path)
+	}
+	if pixelsOnly {
+		fmt.Fprintf(ps.errStream, "WARNING: '%s' was restored from '%s', which has the same pixels but different metadata, so it's not byte-identical to the file that was removed\n", entry.Path, entry.Survivor)
+	}
+	return ps.db.RemoveJournalEntry(entry.ID)
+}
+
+// whether there's something at path that a restored file shouldn't replace;
+// a symbolic link or a hardlink to the survivor can be replaced
+func (ps *Periscope) occupied(path, survivor string) bool {
+	if ps.realFs {
+		stat, err := ps.fs.Stat(path)
+		if err == nil && stat.Mode().IsRegular() {
+			survivorStat, err := ps.fs.Stat(survivor)
+			if err == nil && os.SameFile(stat, survivorStat) {
+				return false
+			}
+		}
+	}
+	lstater, ok := ps.fs.(afero.Lstater)
+	if !ok {
+		_, err := ps.fs.Stat(path)
+		return err == nil
+	}
+	info, _, err := lstater.LstatIfPossible(path)
+	if err != nil {
+		return false
+	}
+	reader, ok := ps.fs.(afero.LinkReader)
+	if info.Mode()&os.ModeSymlink == 0 || !ok {
+		return true
+	}
+	target, err := reader.ReadlinkIfPossible(path)
+	if err != nil {
+		return true
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(path), target)
+	}
+	return filepath.Clean(target) != survivor
+}
+
+// copies the survivor to a temporary file next to the removed file, checking
+// its hash as it's copied, and then renames it into place; returns whether the
+// copy only has the same pixels as the removed file, rather than the same
+// contents
+func (ps *Periscope) restoreCopy(entry db.JournalEntry) (bool, error) {
+	src, err := ps.open(entry.Survivor)
+	if err != nil {
+		return false, err
+	}
+	defer src.Close()
+	dir, base := filepath.Dir(entry.Path), filepath.Base(entry.Path)
+	var tmp string
+	var dst afero.File
+	for {
+		tmp = filepath.Join(dir, "."+base+".psc-undo-"+strconv.FormatUint(uint64(rand.Uint32()), 36))
+		dst, err = ps.fs.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if os.IsExist(err) {
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		break
+	}
+	h := ps.hash.new(nil)
+	buf := make([]byte, readChunkSize)
+	_, err = io.CopyBuffer(io.MultiWriter(dst, h), src, buf)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	pixelsOnly := false
+	if err == nil && !bytes.Equal(h.Sum(nil), entry.FullHash) {
+		if entry.PixelHash == nil {
+			err = fmt.Errorf("'%s' no longer has the same contents", entry.Survivor)
+		} else if pixelHash, pixelErr := ps.hashPixels(tmp); pixelErr != nil || !bytes.Equal(pixelHash, entry.PixelHash) {
+			err = fmt.Errorf("'%s' no longer has the same pixels", entry.Survivor)
+		} else {
+			pixelsOnly = true
+		}
+	}
+	if err == nil {
+		err = ps.fs.Chmod(tmp, os.FileMode(entry.Mode).Perm())
+	}
+	if err == nil {
+		mtime := time.Unix(0, entry.Mtime)
+		err = ps.fs.Chtimes(tmp, mtime, mtime)
+	}
+	if err == nil {
+		err = ps.fs.Rename(tmp, entry.Path)
+	}
+	if err != nil {
+		ps.fs.Remove(tmp)
+	}
+	return pixelsOnly, err
+}
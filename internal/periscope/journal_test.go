@@ -0,0 +1,196 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/testfs"
+
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/afero"
+)
+
+func TestUndo(t *testing.T) {
+	fs := testfs.Read(`
+/a/x [10000 1]
+/b/x [10000 1]
+/c/y [2000 2]
+/d/y [2000 2]
+	`).Mkfs()
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC)
+	fs.Chmod("/b/x", 0o600)
+	fs.Chtimes("/b/x", mtime, mtime)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	check(t, ps.Rm([]string{"/b/x"}, &RmOptions{}))
+	check(t, ps.Rm([]string{"/d"}, &RmOptions{Recursive: true, PruneEmpty: true}))
+	check(t, ps.Journal(&JournalOptions{}))
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], " 1 file  10 kB  rm") || !strings.HasSuffix(lines[1], " 1 file 2.0 kB  rm") {
+		t.Fatalf("unexpected journal '%s'", out.String())
+	}
+	// only the most recent operation is undone by default
+	out.Reset()
+	check(t, ps.Undo(&UndoOptions{Verbose: true}))
+	if got := out.String(); got != "cp /c/y /d/y\n" {
+		t.Fatalf("unexpected output '%s'", got)
+	}
+	expected := testfs.Read(`
+/a/x [10000 1]
+/c/y [2000 2]
+/d/y [2000 2]
+	`)
+	if !testfs.Equal(fs, expected) {
+		t.Fatalf("expected:\n%sgot:\n%s", expected.ShowIndent(2), testfs.ShowIndent(fs, 2))
+	}
+	check(t, ps.Undo(&UndoOptions{Last: 5}))
+	expected = testfs.Read(`
+/a/x [10000 1]
+/b/x [10000 1]
+/c/y [2000 2]
+/d/y [2000 2]
+	`)
+	if !testfs.Equal(fs, expected) {
+		t.Fatalf("expected:\n%sgot:\n%s", expected.ShowIndent(2), testfs.ShowIndent(fs, 2))
+	}
+	// the mode and modification time are restored too
+	stat, err := fs.Stat("/b/x")
+	check(t, err)
+	if stat.Mode().Perm() != 0o600 || !stat.ModTime().Equal(mtime) {
+		t.Fatalf("expected mode 0600 and mtime %s, got %s and %s", mtime, stat.Mode(), stat.ModTime())
+	}
+	// the journal is empty now
+	out.Reset()
+	check(t, ps.Journal(&JournalOptions{}))
+	if got := out.String(); got != "" {
+		t.Fatalf("expected empty journal, got '%s'", got)
+	}
+	checkErr(t, ps.Undo(&UndoOptions{}))
+}
+
+func TestUndoSince(t *testing.T) {
+	fs := testfs.Read(`
+/a [100 1]
+/b [100 1]
+/c [100 1]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	check(t, ps.Rm([]string{"/b"}, &RmOptions{}))
+	check(t, ps.Rm([]string{"/c"}, &RmOptions{}))
+	checkErr(t, ps.Undo(&UndoOptions{Since: time.Now().Add(time.Hour)}))
+	check(t, ps.Undo(&UndoOptions{Since: time.Now().Add(-time.Hour)}))
+	expected := testfs.Read(`
+/a [100 1]
+/b [100 1]
+/c [100 1]
+	`)
+	if !testfs.Equal(fs, expected) {
+		t.Fatalf("expected:\n%sgot:\n%s", expected.ShowIndent(2), testfs.ShowIndent(fs, 2))
+	}
+}
+
+func TestUndoDryRun(t *testing.T) {
+	fs := testfs.Read(`
+/a [100 1]
+/b [100 1]
+	`).Mkfs()
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	check(t, ps.Rm([]string{"/b"}, &RmOptions{DryRun: true}))
+	check(t, ps.Journal(&JournalOptions{}))
+	if got := out.String(); got != "" {
+		t.Fatalf("expected empty journal, got '%s'", got)
+	}
+	checkErr(t, ps.Undo(&UndoOptions{}))
+}
+
+func TestUndoConflicts(t *testing.T) {
+	fs := testfs.Read(`
+/a [100 1]
+/b [100 1]
+/c [200 2]
+/d [200 2]
+	`).Mkfs()
+	ps, _, errOut := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	check(t, ps.Rm([]string{"/b", "/d"}, &RmOptions{}))
+	// something else was created where a file was removed, and the copy
+	// of the other file changed
+	afero.WriteFile(fs, "/b", []byte("new"), 0o644)
+	afero.WriteFile(fs, "/c", []byte("changed"), 0o644)
+	checkErr(t, ps.Undo(&UndoOptions{}))
+	expectedErr := "cannot restore '/d': '/c' no longer has the same contents\ncannot restore '/b': file exists\n"
+	if got := errOut.String(); got != expectedErr {
+		t.Fatalf("expected '%s', got '%s'", expectedErr, got)
+	}
+	if _, err := fs.Stat("/d"); err == nil {
+		t.Fatal("expected '/d' not to be restored")
+	}
+	if data, _ := afero.ReadFile(fs, "/b"); string(data) != "new" {
+		t.Fatal("expected '/b' to be left alone")
+	}
+	// no temporary files are left behind
+	entries, _ := afero.ReadDir(fs, "/")
+	if len(entries) != 3 {
+		t.Fatalf("expected 3 files, got %d", len(entries))
+	}
+	// the files are still in the journal
+	ops, err := ps.db.Operations()
+	check(t, err)
+	if len(ops) != 1 || ops[0].Files != 2 {
+		t.Fatalf("unexpected operations %+v", ops)
+	}
+}
+
+func TestUndoSymlink(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+	os.WriteFile(filepath.Join(dir, "x1"), []byte("xxxx"), 0o644)
+	os.WriteFile(filepath.Join(dir, "x2"), []byte("xxxx"), 0o640)
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{dir}, &ScanOptions{})
+	check(t, ps.Symlink([]string{filepath.Join(dir, "x2")}, &SymlinkOptions{Relative: true}))
+	check(t, ps.Undo(&UndoOptions{}))
+	stat, err := os.Lstat(filepath.Join(dir, "x2"))
+	check(t, err)
+	if !stat.Mode().IsRegular() || stat.Mode().Perm() != 0o640 {
+		t.Fatalf("expected a regular file with mode 0640, got %s", stat.Mode())
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "x2"))
+	check(t, err)
+	if string(data) != "xxxx" {
+		t.Fatalf("unexpected contents '%s'", data)
+	}
+}
+
+func TestUndoLink(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+	os.WriteFile(filepath.Join(dir, "x1"), []byte("xxxx"), 0o644)
+	os.WriteFile(filepath.Join(dir, "x2"), []byte("xxxx"), 0o640)
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{dir}, &ScanOptions{})
+	check(t, ps.Link([]string{filepath.Join(dir, "x2")}, &LinkOptions{}))
+	if !sameFile(t, filepath.Join(dir, "x1"), filepath.Join(dir, "x2")) {
+		t.Fatal("expected files to be linked")
+	}
+	check(t, ps.Undo(&UndoOptions{}))
+	if sameFile(t, filepath.Join(dir, "x1"), filepath.Join(dir, "x2")) {
+		t.Fatal("expected files to be separate again")
+	}
+	stat, err := os.Stat(filepath.Join(dir, "x2"))
+	check(t, err)
+	if stat.Mode().Perm() != 0o640 {
+		t.Fatalf("expected mode 0640, got %s", stat.Mode())
+	}
+	stat, err = os.Stat(filepath.Join(dir, "x1"))
+	check(t, err)
+	if stat.Mode().Perm() != 0o644 {
+		t.Fatalf("expected the kept copy to have mode 0644, got %s", stat.Mode())
+	}
+}
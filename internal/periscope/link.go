@@ -0,0 +1,78 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/db"
+	"github.com/anishathalye/periscope/internal/herror"
+
+	"errors"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+type LinkOptions struct {
+	Recursive bool
+	Verbose   bool
+	DryRun    bool
+	Contained []string
+	// compare files byte-for-byte with the file they're linked to, rather
+	// than relying on hashes alone
+	Paranoid bool
+}
+
+// Replaces duplicates with hardlinks to a copy on the same device, so every
+// path is kept but the contents are only stored once.
+//
+// Files are checked exactly like in Rm, and each one is linked to the copy
+// that Rm would have kept, as long as it's on the same device; when linking a
+// directory, files with no such copy elsewhere are linked to each other. Each
+// file is replaced atomically, by creating a link with a temporary name and
+// renaming it over the file, and recorded in the journal, so Undo turns it
+// back into a separate copy.
+func (ps *Periscope) Link(paths []string, options *LinkOptions) herror.Interface {
+	return ps.Rm(paths, &RmOptions{
+		Recursive: options.Recursive,
+		Verbose:   options.Verbose,
+		DryRun:    options.DryRun,
+		Contained: options.Contained,
+		Paranoid:  options.Paranoid,
+		hardlink:  true,
+	})
+}
+
+// records a file that was linked, or linked to, in the database: it now
+// shares an inode with the other links, and linking changes its ctime, but its
+// contents were verified before it was linked
+func (ps *Periscope) updateLinked(info db.FileInfo) herror.Interface {
+	stat, err := ps.fs.Stat(info.Path)
+	if err != nil {
+		log.Printf("Stat('%s') returned an error: %s", info.Path, err)
+		return nil
+	}
+	setStatMetadata(&info, stat)
+	return ps.db.Add(info)
+}
+
+// atomically replaces path with a hardlink to target
+func (ps *Periscope) replaceWithLink(target, path string) error {
+	if !ps.realFs {
+		return errors.New("hardlinks are not supported")
+	}
+	dir, base := filepath.Dir(path), filepath.Base(path)
+	for {
+		tmp := filepath.Join(dir, "."+base+".psc-link-"+strconv.FormatUint(uint64(rand.Uint32()), 36))
+		err := os.Link(target, tmp)
+		if os.IsExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, path); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		return nil
+	}
+}
@@ -0,0 +1,171 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/testfs"
+
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func sameFile(t *testing.T, a, b string) bool {
+	sa, err := os.Stat(a)
+	check(t, err)
+	sb, err := os.Stat(b)
+	check(t, err)
+	return os.SameFile(sa, sb)
+}
+
+func TestLinkBasic(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "a"), 0o755)
+	os.MkdirAll(filepath.Join(dir, "b"), 0o755)
+	os.WriteFile(filepath.Join(dir, "a", "x"), []byte("contents"), 0o644)
+	os.WriteFile(filepath.Join(dir, "b", "x"), []byte("contents"), 0o644)
+	os.WriteFile(filepath.Join(dir, "b", "y"), []byte("unique"), 0o644)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{dir}, &ScanOptions{})
+	err := ps.Link([]string{filepath.Join(dir, "b", "x")}, &LinkOptions{Verbose: true})
+	check(t, err)
+	if !sameFile(t, filepath.Join(dir, "a", "x"), filepath.Join(dir, "b", "x")) {
+		t.Fatal("expected files to be linked")
+	}
+	expected := "ln " + filepath.Join(dir, "a", "x") + " " + filepath.Join(dir, "b", "x") + "\n"
+	if got := out.String(); got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+	// no temporary files are left behind
+	entries, _ := os.ReadDir(filepath.Join(dir, "b"))
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(entries))
+	}
+	// the database records that the files share an inode
+	set, err := ps.db.Lookup(filepath.Join(dir, "a", "x"))
+	check(t, err)
+	if len(set) != 2 || !set[0].SameFile(&set[1]) {
+		t.Fatalf("expected files to be recorded as hardlinks, got %+v", set)
+	}
+	// unique files can't be linked
+	err = ps.Link([]string{filepath.Join(dir, "b", "y")}, &LinkOptions{})
+	checkErr(t, err)
+}
+
+func TestLinkRecursive(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "a", "sub"), 0o755)
+	os.MkdirAll(filepath.Join(dir, "b"), 0o755)
+	os.WriteFile(filepath.Join(dir, "a", "x1"), []byte("xxxx"), 0o644)
+	os.WriteFile(filepath.Join(dir, "a", "sub", "x2"), []byte("xxxx"), 0o644)
+	os.WriteFile(filepath.Join(dir, "a", "y1"), []byte("yyyy"), 0o644)
+	os.WriteFile(filepath.Join(dir, "a", "y2"), []byte("yyyy"), 0o644)
+	os.WriteFile(filepath.Join(dir, "b", "x"), []byte("xxxx"), 0o644)
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{dir}, &ScanOptions{})
+	err := ps.Link([]string{filepath.Join(dir, "a")}, &LinkOptions{})
+	checkErr(t, err)
+	err = ps.Link([]string{filepath.Join(dir, "a")}, &LinkOptions{Recursive: true})
+	check(t, err)
+	// files are linked to a copy outside the directory if there is one,
+	// and to each other otherwise
+	if !sameFile(t, filepath.Join(dir, "b", "x"), filepath.Join(dir, "a", "x1")) || !sameFile(t, filepath.Join(dir, "b", "x"), filepath.Join(dir, "a", "sub", "x2")) {
+		t.Fatal("expected files to be linked to the copy outside the directory")
+	}
+	if !sameFile(t, filepath.Join(dir, "a", "y1"), filepath.Join(dir, "a", "y2")) {
+		t.Fatal("expected files to be linked to each other")
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "a", "sub", "x2"))
+	if string(data) != "xxxx" {
+		t.Fatalf("unexpected contents '%s'", data)
+	}
+	// linking again does nothing
+	err = ps.Link([]string{filepath.Join(dir, "a")}, &LinkOptions{Recursive: true, Verbose: true})
+	check(t, err)
+}
+
+func TestLinkDryRun(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+	os.WriteFile(filepath.Join(dir, "x"), []byte("contents"), 0o644)
+	os.WriteFile(filepath.Join(dir, "y"), []byte("contents"), 0o644)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{dir}, &ScanOptions{})
+	err := ps.Link([]string{filepath.Join(dir, "y")}, &LinkOptions{DryRun: true, Verbose: true})
+	check(t, err)
+	if sameFile(t, filepath.Join(dir, "x"), filepath.Join(dir, "y")) {
+		t.Fatal("expected files not to be linked in a dry run")
+	}
+	if !strings.HasPrefix(out.String(), "ln ") {
+		t.Fatalf("expected link to be listed, got '%s'", out.String())
+	}
+}
+
+func TestLinkChanged(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+	os.WriteFile(filepath.Join(dir, "x"), []byte("contents"), 0o644)
+	os.WriteFile(filepath.Join(dir, "y"), []byte("contents"), 0o644)
+	ps, _, errOut := newTest(fs)
+	ps.Scan([]string{dir}, &ScanOptions{})
+	os.WriteFile(filepath.Join(dir, "x"), []byte("modified"), 0o644)
+	err := ps.Link([]string{filepath.Join(dir, "y")}, &LinkOptions{})
+	checkErr(t, err)
+	if !strings.Contains(errOut.String(), "no copy on the same device") {
+		t.Fatalf("unexpected error output '%s'", errOut.String())
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "y"))
+	if string(data) != "contents" {
+		t.Fatalf("expected file to be unchanged, got '%s'", data)
+	}
+}
+
+func TestLinkUnsupported(t *testing.T) {
+	fs := testfs.Read(`
+/a [10000 1]
+/b [10000 1]
+	`).Mkfs()
+	ps, _, errOut := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Link([]string{"/b"}, &LinkOptions{})
+	checkErr(t, err)
+	if !strings.Contains(errOut.String(), "hardlinks are not supported") {
+		t.Fatalf("unexpected error output '%s'", errOut.String())
+	}
+}
+
+func TestLinkContained(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+	for _, sub := range []string{"a", "b", "c"} {
+		os.MkdirAll(filepath.Join(dir, sub), 0o755)
+	}
+	os.WriteFile(filepath.Join(dir, "a", "x"), []byte("xxxx"), 0o644)
+	os.WriteFile(filepath.Join(dir, "b", "x"), []byte("xxxx"), 0o644)
+	os.WriteFile(filepath.Join(dir, "a", "y"), []byte("yyyy"), 0o644)
+	os.WriteFile(filepath.Join(dir, "c", "y"), []byte("yyyy"), 0o644)
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{dir}, &ScanOptions{})
+	err := ps.Link([]string{filepath.Join(dir, "a")}, &LinkOptions{Recursive: true, Contained: []string{filepath.Join(dir, "b")}})
+	check(t, err)
+	if !sameFile(t, filepath.Join(dir, "a", "x"), filepath.Join(dir, "b", "x")) {
+		t.Fatal("expected file with a copy in the contained directory to be linked")
+	}
+	if sameFile(t, filepath.Join(dir, "a", "y"), filepath.Join(dir, "c", "y")) {
+		t.Fatal("expected file without a copy in the contained directory to be kept")
+	}
+	// links are journaled like removals
+	ops, err := ps.db.Operations()
+	check(t, err)
+	if len(ops) != 1 || ops[0].Command != "link" || ops[0].Files != 1 {
+		t.Fatalf("unexpected operations %+v", ops)
+	}
+}
@@ -110,6 +110,7 @@ func (ps *Periscope) list1(out io.Writer, file os.FileInfo, dirPath string, opti
 	var desc string
 	var fullPath string
 	var dupeSet db.DuplicateSet
+	nDupes := 0 // not counting hardlinks
 	if mode&os.ModeDir == os.ModeDir {
 		desc = "d"
 		isDirectory = true
@@ -130,7 +131,9 @@ func (ps *Periscope) list1(out io.Writer, file os.FileInfo, dirPath string, opti
 		if err != nil {
 			return false, false, err
 		}
-		nDupes := len(dupeSet) - 1
+		if len(dupeSet) > 0 {
+			nDupes = dupeSet.Files() - 1
+		}
 		if nDupes > 0 {
 			desc = strconv.Itoa(nDupes)
 		}
@@ -138,10 +141,10 @@ func (ps *Periscope) list1(out io.Writer, file os.FileInfo, dirPath string, opti
 		desc = "?"
 	}
 	show := true
-	if options.Unique && len(dupeSet) > 1 {
+	if options.Unique && nDupes > 0 {
 		show = false
 	}
-	if options.Duplicate && len(dupeSet) < 2 {
+	if options.Duplicate && nDupes == 0 {
 		show = false
 	}
 	if options.Files && isDirectory {
@@ -156,7 +159,11 @@ func (ps *Periscope) list1(out io.Writer, file os.FileInfo, dirPath string, opti
 					if options.Relative {
 						showPath = relPath(dirPath, info.Path)
 					}
-					fmt.Fprintf(out, "\v  %s\n", showPath)
+					if info.SameFile(&dupeSet[0]) {
+						fmt.Fprintf(out, "\v  %s (hardlink)\n", showPath)
+					} else {
+						fmt.Fprintf(out, "\v  %s\n", showPath)
+					}
 				}
 			}
 		}
@@ -805,3 +805,44 @@ e
 		t.Fatalf("expected '%s', got '%s'", expected, got)
 	}
 }
+
+func TestLsHardlinks(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := hardlinkDir()
+	defer os.RemoveAll(dir)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{dir}, &ScanOptions{})
+	err := ps.Ls([]string{dir}, &LsOptions{Verbose: true, Relative: true})
+	check(t, err)
+	got := strings.TrimRight(out.String(), "\n")
+	expected := strings.TrimSpace(`
+1 a
+    b (hardlink)
+    c
+1 b
+    a (hardlink)
+    c
+1 c
+    a
+    b
+  x
+    y (hardlink)
+  y
+    x (hardlink)
+	`)
+	if got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+	out.Reset()
+	err = ps.Ls([]string{dir}, &LsOptions{Duplicate: true})
+	check(t, err)
+	got = strings.TrimRight(out.String(), "\n")
+	expected = strings.TrimSpace(`
+1 a
+1 b
+1 c
+	`)
+	if got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+}
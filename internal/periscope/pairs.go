@@ -0,0 +1,217 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/db"
+	"github.com/anishathalye/periscope/internal/herror"
+
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+type PairsOptions struct {
+	Relative bool
+}
+
+// the duplicates shared by two directories
+type dirPair struct {
+	a, b string
+	// the total size of the shared contents, counting each duplicate set
+	// once
+	size  int64
+	files int64
+}
+
+// Reports pairs of directories that contain copies of the same files, ranked
+// by the amount of data they share, or, given two directories, which of their
+// files are shared and which are only in one of them.
+//
+// Files are grouped by the directory they're directly in, so a directory tree
+// that was copied shows up as one pair for each of its subdirectories.
+// Duplicates with copies in a great many directories are left out, and only
+// counted.
+func (ps *Periscope) Pairs(dirs []string, options *PairsOptions) herror.Interface {
+	if len(dirs) == 2 {
+		return ps.comparePair(dirs[0], dirs[1])
+	}
+	if len(dirs) != 0 {
+		return herror.UserF(nil, "expected zero or two directories, got %d", len(dirs))
+	}
+	sets, err := ps.db.AllDuplicates("")
+	if err != nil {
+		return err
+	}
+	var refDir string
+	if options.Relative {
+		var err error
+		refDir, err = filepath.Abs(".")
+		if err != nil {
+			return herror.Internal(err, "")
+		}
+	}
+	pairs, skipped := directoryPairs(sets)
+	for i, pair := range pairs {
+		if i != 0 {
+			fmt.Fprintf(ps.outStream, "\n")
+		}
+		a, b := pair.a, pair.b
+		if options.Relative {
+			a, b = relPath(refDir, a), relPath(refDir, b)
+		}
+		fmt.Fprintf(ps.outStream, "%s in %s\n", humanize.Bytes(uint64(pair.size)), fileCount(pair.files))
+		fmt.Fprintf(ps.outStream, "  %s%c\n", a, filepath.Separator)
+		fmt.Fprintf(ps.outStream, "  %s%c\n", b, filepath.Separator)
+	}
+	if skipped > 0 {
+		if len(pairs) > 0 {
+			fmt.Fprintf(ps.outStream, "\n")
+		}
+		fmt.Fprintf(ps.outStream, "left out %s with copies in more than %d directories\n", setCount(skipped), maxPairDirectories)
+	}
+	return nil
+}
+
+// duplicate sets with copies in more directories than this are left out of
+// the pairs, because the number of pairs grows quadratically with the number
+// of directories, and a file that is everywhere (like a license or a
+// placeholder image) says little about which directories are copies
+const maxPairDirectories = 50
+
+// aggregates duplicate sets by the pairs of directories their files are in,
+// and returns the pairs sorted by shared size, largest first, along with the
+// number of sets that were left out for being in too many directories
+func directoryPairs(sets []db.DuplicateSet) ([]*dirPair, int) {
+	type key struct{ a, b string }
+	pairs := make(map[key]*dirPair)
+	skipped := 0
+	for _, set := range sets {
+		seen := make(map[string]struct{})
+		var dirs []string
+		for _, info := range set {
+			dir := filepath.Dir(info.Path)
+			if _, ok := seen[dir]; !ok {
+				seen[dir] = struct{}{}
+				dirs = append(dirs, dir)
+			}
+		}
+		if len(dirs) < 2 {
+			continue
+		}
+		if set.Files() < 2 {
+			continue // hardlinks to a single file take no extra space
+		}
+		if len(dirs) > maxPairDirectories {
+			skipped++
+			continue
+		}
+		sort.Strings(dirs)
+		for i := range dirs {
+			for j := i + 1; j < len(dirs); j++ {
+				k := key{dirs[i], dirs[j]}
+				pair, ok := pairs[k]
+				if !ok {
+					pair = &dirPair{a: k.a, b: k.b}
+					pairs[k] = pair
+				}
+				pair.size += set[0].Size
+				pair.files++
+			}
+		}
+	}
+	sorted := make([]*dirPair, 0, len(pairs))
+	for _, pair := range pairs {
+		sorted = append(sorted, pair)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.size != b.size {
+			return a.size > b.size
+		}
+		if a.files != b.files {
+			return a.files > b.files
+		}
+		if a.a != b.a {
+			return a.a < b.a
+		}
+		return a.b < b.b
+	})
+	return sorted, skipped
+}
+
+func (ps *Periscope) comparePair(dir1, dir2 string) herror.Interface {
+	absDir1, _, err := ps.checkFile(dir1, false, true, "compare", false, true)
+	if err != nil {
+		return err
+	}
+	absDir2, _, err := ps.checkFile(dir2, false, true, "compare", false, true)
+	if err != nil {
+		return err
+	}
+	if absDir1 == absDir2 {
+		return herror.UserF(nil, "cannot compare '%s' with itself", dir1)
+	}
+	infos1, err := ps.db.DirectoryInfos(absDir1)
+	if err != nil {
+		return err
+	}
+	infos2, err := ps.db.DirectoryInfos(absDir2)
+	if err != nil {
+		return err
+	}
+	names1 := namesByHash(infos1)
+	names2 := namesByHash(infos2)
+	var shared []string
+	var sharedSize int64
+	var only1, only2 []db.FileInfo
+	for _, info := range infos1 {
+		if info.FullHash == nil || names2[string(info.FullHash)] == nil {
+			only1 = append(only1, info)
+			continue
+		}
+		name := filepath.Base(info.Path)
+		others := names2[string(info.FullHash)]
+		if len(others) == 1 && others[0] == name {
+			shared = append(shared, name)
+		} else {
+			shared = append(shared, name+" = "+strings.Join(others, ", "))
+		}
+		sharedSize += info.Size
+	}
+	for _, info := range infos2 {
+		if info.FullHash == nil || names1[string(info.FullHash)] == nil {
+			only2 = append(only2, info)
+		}
+	}
+	fmt.Fprintf(ps.outStream, "shared: %s, %s\n", fileCount(int64(len(shared))), humanize.Bytes(uint64(sharedSize)))
+	for _, line := range shared {
+		fmt.Fprintf(ps.outStream, "  %s\n", line)
+	}
+	for _, side := range []struct {
+		dir   string
+		infos []db.FileInfo
+	}{{dir1, only1}, {dir2, only2}} {
+		var size int64
+		for _, info := range side.infos {
+			size += info.Size
+		}
+		fmt.Fprintf(ps.outStream, "\nonly in %s: %s, %s\n", side.dir, fileCount(int64(len(side.infos))), humanize.Bytes(uint64(size)))
+		for _, info := range side.infos {
+			fmt.Fprintf(ps.outStream, "  %s\n", filepath.Base(info.Path))
+		}
+	}
+	return nil
+}
+
+// the names of the files with each full hash
+func namesByHash(infos []db.FileInfo) map[string][]string {
+	names := make(map[string][]string)
+	for _, info := range infos {
+		if info.FullHash != nil {
+			names[string(info.FullHash)] = append(names[string(info.FullHash)], filepath.Base(info.Path))
+		}
+	}
+	return names
+}
@@ -0,0 +1,173 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/db"
+	"github.com/anishathalye/periscope/internal/testfs"
+
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestPairsBasic(t *testing.T) {
+	fs := testfs.Read(`
+/photos/a [10000 1]
+/photos/b [20000 2]
+/photos/c [3000 3]
+/backup/a [10000 1]
+/backup/b [20000 2]
+/backup/d [4000 4]
+/misc/c [3000 3]
+/misc/x [5000 5]
+/misc/y [5000 5]
+	`).Mkfs()
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Pairs(nil, &PairsOptions{})
+	check(t, err)
+	got := strings.TrimSpace(out.String())
+	// duplicates within a directory don't make a pair
+	expected := strings.TrimSpace(`
+30 kB in 2 files
+  /backup/
+  /photos/
+
+3.0 kB in 1 file
+  /misc/
+  /photos/
+	`)
+	if got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+}
+
+func TestPairsSetInManyDirectories(t *testing.T) {
+	fs := testfs.Read(`
+/d1/a [10000 1]
+/d2/a [10000 1]
+/d3/a [10000 1]
+/d3/b [2000 2]
+/d4/b [2000 2]
+	`).Mkfs()
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Pairs(nil, &PairsOptions{})
+	check(t, err)
+	got := strings.TrimSpace(out.String())
+	expected := strings.TrimSpace(`
+10 kB in 1 file
+  /d1/
+  /d2/
+
+10 kB in 1 file
+  /d1/
+  /d3/
+
+10 kB in 1 file
+  /d2/
+  /d3/
+
+2.0 kB in 1 file
+  /d3/
+  /d4/
+	`)
+	if got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+}
+
+func TestPairsCompare(t *testing.T) {
+	fs := testfs.Read(`
+/photos/a [10000 1]
+/photos/b [20000 2]
+/photos/c [3000 3]
+/photos/sub/e [6000 6]
+/backup/a [10000 1]
+/backup/renamed [20000 2]
+/backup/d [4000 4]
+/backup/e [6000 6]
+	`).Mkfs()
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Pairs([]string{"/photos", "/backup"}, &PairsOptions{})
+	check(t, err)
+	got := strings.TrimSpace(out.String())
+	expected := strings.TrimSpace(`
+shared: 2 files, 30 kB
+  b = renamed
+  a
+
+only in /photos: 1 file, 3.0 kB
+  c
+
+only in /backup: 2 files, 10 kB
+  e
+  d
+	`)
+	if got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+}
+
+func TestPairsCompareSame(t *testing.T) {
+	fs := testfs.Read(`
+/d1/a [10000 1]
+/d2/a [10000 1]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Pairs([]string{"/d1", "/d1/"}, &PairsOptions{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	err = ps.Pairs([]string{"/d1"}, &PairsOptions{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestPairsSetInTooManyDirectories(t *testing.T) {
+	var spec strings.Builder
+	for i := 0; i <= maxPairDirectories; i++ {
+		fmt.Fprintf(&spec, "/d%d/license [1000 1]\n", i)
+	}
+	spec.WriteString("/d0/a [10000 2]\n/d1/a [10000 2]\n")
+	fs := testfs.Read(spec.String()).Mkfs()
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Pairs(nil, &PairsOptions{})
+	check(t, err)
+	got := strings.TrimSpace(out.String())
+	expected := strings.TrimSpace(`
+10 kB in 1 file
+  /d0/
+  /d1/
+
+left out 1 duplicate set with copies in more than 50 directories
+	`)
+	if got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+}
+
+func TestPairsHardlinksOnly(t *testing.T) {
+	// copies that are all hardlinks to the same file share no space
+	set := db.DuplicateSet{
+		{Path: "/backup/b", Size: 20000, Inode: 7, Device: 1},
+		{Path: "/misc/b", Size: 20000, Inode: 7, Device: 1},
+	}
+	pairs, skipped := directoryPairs([]db.DuplicateSet{set})
+	if len(pairs) != 0 || skipped != 0 {
+		t.Fatalf("expected no pairs, got %d (%d left out)", len(pairs), skipped)
+	}
+	// even in too many directories to pair up, they aren't left out, since
+	// they aren't duplicates
+	set = nil
+	for i := 0; i <= maxPairDirectories; i++ {
+		set = append(set, db.FileInfo{Path: fmt.Sprintf("/d%d/b", i), Size: 20000, Inode: 7, Device: 1})
+	}
+	pairs, skipped = directoryPairs([]db.DuplicateSet{set})
+	if len(pairs) != 0 || skipped != 0 {
+		t.Fatalf("expected no pairs, got %d (%d left out)", len(pairs), skipped)
+	}
+}
@@ -7,6 +7,7 @@ import (
 )
 
 var scanThreads = envGetInt("PERISCOPE_SCAN_THREADS", 32)
+var rotationalScanThreads = envGetInt("PERISCOPE_ROTATIONAL_SCAN_THREADS", 2)
 var testDebug = envGetBool("PERISCOPE_TEST_DEBUG", false)
 
 func envGetInt(key string, fallback int) int {
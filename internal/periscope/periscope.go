@@ -10,6 +10,7 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/cheggaaa/pb/v3"
@@ -28,6 +29,11 @@ type Periscope struct {
 	outStream io.Writer
 	errStream io.Writer
 	options   *Options
+	hash      *hashAlgorithm
+	// the trash directory, determined when it's first needed
+	trash string
+	// the operation that files are being removed by, if any
+	operation *journalOperation
 }
 
 type Options struct {
@@ -61,6 +67,10 @@ func New(options *Options) (*Periscope, herror.Interface) {
 	if err != nil {
 		return nil, err
 	}
+	hash, err := loadHashAlgorithm(db)
+	if err != nil {
+		return nil, err
+	}
 	fs := afero.NewOsFs()
 	return &Periscope{
 		fs:        fs,
@@ -70,9 +80,65 @@ func New(options *Options) (*Periscope, herror.Interface) {
 		outStream: os.Stdout,
 		errStream: os.Stderr,
 		options:   options,
+		hash:      hash,
 	}, nil
 }
 
+// returns the hash algorithm that the database's hashes were computed with
+func loadHashAlgorithm(db *db.Session) (*hashAlgorithm, herror.Interface) {
+	name, ok, err := db.Meta(hashAlgorithmKey)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return hashAlgorithms[DefaultHashAlgorithm], nil
+	}
+	hash, ok := hashAlgorithms[name]
+	if !ok {
+		return nil, herror.Unlikely(nil, fmt.Sprintf("database uses unknown hash algorithm '%s'", name), `
+This database was likely produced by a newer version of Periscope. Either use a compatible version of Periscope, or delete the database (by running 'psc finish') and try again.
+		`)
+	}
+	return hash, nil
+}
+
+// switches to the given hash algorithm and records it in the database
+//
+// the algorithm can only be changed if the database has no hashes computed
+// with another algorithm, including the ones of removed files in the journal
+// and the trash, which are checked when the files are restored
+func (ps *Periscope) setHashAlgorithm(name string) herror.Interface {
+	if name != "" && name != ps.hash.name {
+		hash, ok := hashAlgorithms[name]
+		if !ok {
+			return herror.UserF(nil, "unknown hash algorithm '%s' (supported: %s)", name, strings.Join(HashAlgorithms(), ", "))
+		}
+		summary, err := ps.db.Summary()
+		if err != nil {
+			return err
+		}
+		if summary.Files > 0 {
+			return herror.UserF(nil, "database contains hashes computed with %s; run 'psc finish' to start over with %s", ps.hash.name, name)
+		}
+		operations, err := ps.db.Operations()
+		if err != nil {
+			return err
+		}
+		if len(operations) > 0 {
+			return herror.UserF(nil, "the journal has removals verified with %s hashes, which couldn't be undone after switching to %s; run 'psc finish' to start over with %s", ps.hash.name, name, name)
+		}
+		trashed, err := ps.db.AllTrashed()
+		if err != nil {
+			return err
+		}
+		if len(trashed) > 0 {
+			return herror.UserF(nil, "the trash has files with %s hashes, which couldn't be restored after switching to %s; run 'psc trash empty' or 'psc trash restore' first", ps.hash.name, name)
+		}
+		ps.hash = hash
+	}
+	return ps.db.SetMeta(hashAlgorithmKey, ps.hash.name)
+}
+
 func (ps *Periscope) progressBar(total int, template string) *pb.ProgressBar {
 	bar := pb.New(total)
 	bar.SetRefreshRate(25 * time.Millisecond)
@@ -4,6 +4,8 @@ import (
 	"github.com/anishathalye/periscope/internal/db"
 
 	"bytes"
+	"crypto/sha256"
+	"hash"
 	"io"
 	"log"
 	"os"
@@ -35,6 +37,8 @@ func newTest(fs afero.Fs) (*Periscope, *bytes.Buffer, *bytes.Buffer) {
 		outStream: outStream,
 		errStream: errStream,
 		options:   &Options{Debug: false},
+		hash:      hashAlgorithms[DefaultHashAlgorithm],
+		trash:     "/trash",
 	}, outStream, errStream
 }
 
@@ -106,3 +110,32 @@ func checkEquivalentInfos(t *testing.T, expected, got []db.FileInfo) {
 }
 
 var dummyHash []byte = []byte{0x01, 0x03, 0x03, 0x07}
+
+// creates a directory with "a", "b" (a hardlink to "a"), "c" (a copy of "a"),
+// "x", and "y" (a hardlink to "x")
+func hardlinkDir() string {
+	dir := tempDir()
+	os.WriteFile(filepath.Join(dir, "a"), []byte("aaaa"), 0o644)
+	os.Link(filepath.Join(dir, "a"), filepath.Join(dir, "b"))
+	os.WriteFile(filepath.Join(dir, "c"), []byte("aaaa"), 0o644)
+	os.WriteFile(filepath.Join(dir, "x"), []byte("xx"), 0o644)
+	os.Link(filepath.Join(dir, "x"), filepath.Join(dir, "y"))
+	return dir
+}
+
+// a hash that ignores its input, so that every file collides
+type collidingHash struct {
+	hash.Hash
+}
+
+func (h collidingHash) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+var collidingHashAlgorithm = &hashAlgorithm{
+	name: "colliding",
+	tag:  "COLLIDING",
+	new: func(key []byte) hash.Hash {
+		return collidingHash{sha256.New()}
+	},
+}
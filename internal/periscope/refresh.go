@@ -7,6 +7,7 @@ import (
 
 	"fmt"
 	"log"
+	"os"
 )
 
 type RefreshOptions struct {
@@ -26,8 +27,20 @@ func (ps *Periscope) Refresh(options *RefreshOptions) herror.Interface {
 
 	var gone []string
 	for path := range par.MapN(infos, scanThreads, func(_, v interface{}, emit func(x interface{})) {
-		path := v.(db.FileInfo).Path
-		_, _, err := ps.checkFile(path, true, false, "", true, false)
+		info := v.(db.FileInfo)
+		path := info.Path
+		var err herror.Interface
+		if archive, _, ok := splitArchivePath(path); ok {
+			// archive members are gone if the archive is gone, or if
+			// it has changed since it was scanned
+			var stat os.FileInfo
+			_, stat, err = ps.checkFile(archive, true, false, "", true, false)
+			if err == nil && stat.ModTime().UnixNano() != info.Mtime {
+				err = herror.Silent()
+			}
+		} else {
+			_, _, err = ps.checkFile(path, true, false, "", true, false)
+		}
 		bar.Increment()
 		if err != nil {
 			log.Printf("removing '%s' from database", path)
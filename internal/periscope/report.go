@@ -14,6 +14,30 @@ import (
 
 type ReportOptions struct {
 	Relative bool
+	// also report directory trees whose tracked contents are identical,
+	// and leave out duplicate files that are all inside them
+	Dirs bool
+}
+
+// kinds of equivalence between files that are reported separately from
+// duplicates, in the order they're reported
+var equivalences = []struct {
+	kind db.Equivalence
+	// the heading for sets of equivalent files in report
+	header string
+	// how equivalent files are described in info
+	label string
+	// optionally, a note about each file, shown in report and info
+	annotate func(info *db.FileInfo) string
+}{
+	{db.SamePixels, "same pixels, different metadata", "metadata differs", nil},
+	{db.SameText, "equivalent modulo line endings/BOM", "equivalent text", nil},
+	{db.SameDecompressed, "compressed copies", "compressed copies", func(info *db.FileInfo) string {
+		if info.DecompressedHash != nil {
+			return "compressed"
+		}
+		return "uncompressed"
+	}},
 }
 
 func (ps *Periscope) Report(dir string, options *ReportOptions) herror.Interface {
@@ -32,6 +56,17 @@ func (ps *Periscope) Report(dir string, options *ReportOptions) herror.Interface
 	// another, they'd get a "database is locked" error. This seems like
 	// it's a common enough use case that it's worth avoiding it. We
 	// achieve this by buffering the results in memory.
+	//
+	// Directory summaries are computed from all infos up front, which
+	// doesn't hold the database either.
+	var summaries map[string]*dirSummary
+	if options.Dirs {
+		infos, err := ps.db.AllInfos()
+		if err != nil {
+			return err
+		}
+		summaries = ps.directorySummaries(infos)
+	}
 	sets, err := ps.db.AllDuplicatesC(absDir)
 	if err != nil {
 		return err
@@ -63,6 +98,25 @@ func (ps *Periscope) Report(dir string, options *ReportOptions) herror.Interface
 		}
 	}
 	first := true
+	if options.Dirs {
+		for _, set := range duplicateDirectories(summaries) {
+			if absDir != "" && !anyDirectoryIn(set, absDir) {
+				continue
+			}
+			if !first {
+				fmt.Fprintf(ps.outStream, "\n")
+			}
+			fmt.Fprintf(ps.outStream, "%s in %s (identical tracked contents)\n", humanize.Bytes(uint64(set[0].size)), fileCount(set[0].files))
+			for _, summary := range set {
+				path := summary.path
+				if options.Relative {
+					path = relPath(refDir, path)
+				}
+				fmt.Fprintf(ps.outStream, "  %s%c\n", path, filepath.Separator)
+			}
+			first = false
+		}
+	}
 	for {
 		mu.Lock()
 		for !done && buf.Len() == 0 {
@@ -77,19 +131,83 @@ func (ps *Periscope) Report(dir string, options *ReportOptions) herror.Interface
 		buf.Remove(front)
 		mu.Unlock()
 
+		if options.Dirs && coveredByDirectories(summaries, set) {
+			continue
+		}
 		if !first {
 			fmt.Fprintf(ps.outStream, "\n")
 		}
 		fmt.Fprintf(ps.outStream, "%s\n", humanize.Bytes(uint64(set[0].Size))) // all files within a set have the same size
-		for _, info := range set {
-			path := info.Path
-			if options.Relative {
-				path = relPath(refDir, path)
+		// hardlinks are listed under the first path to the same file
+		for _, links := range groupLinks(set) {
+			for i, info := range links {
+				path := info.Path
+				if options.Relative {
+					path = relPath(refDir, path)
+				}
+				if i == 0 {
+					fmt.Fprintf(ps.outStream, "  %s\n", path)
+				} else {
+					fmt.Fprintf(ps.outStream, "    %s (hardlink)\n", path)
+				}
 			}
-			fmt.Fprintf(ps.outStream, "  %s\n", path)
 		}
 		first = false
 	}
 
+	// equivalent files are reported after the duplicates, because they
+	// have different contents
+	for _, equivalence := range equivalences {
+		sets, err := ps.db.AllEquivalent(equivalence.kind, absDir)
+		if err != nil {
+			return err
+		}
+		for _, set := range sets {
+			if allIdentical(set) {
+				continue
+			}
+			if !first {
+				fmt.Fprintf(ps.outStream, "\n")
+			}
+			fmt.Fprintf(ps.outStream, "%s\n", equivalence.header)
+			for _, links := range groupLinks(set) {
+				for i, info := range links {
+					path := info.Path
+					if options.Relative {
+						path = relPath(refDir, path)
+					}
+					if i == 0 {
+						note := humanize.Bytes(uint64(info.Size))
+						if equivalence.annotate != nil {
+							note += ", " + equivalence.annotate(&info)
+						}
+						fmt.Fprintf(ps.outStream, "  %s (%s)\n", path, note)
+					} else {
+						fmt.Fprintf(ps.outStream, "    %s (hardlink)\n", path)
+					}
+				}
+			}
+			first = false
+		}
+	}
+
 	return nil
 }
+
+// groups the infos in a set by underlying file, so that hardlinks to the same
+// file are grouped together; groups are ordered by their first appearance in
+// the set
+func groupLinks(set db.DuplicateSet) [][]db.FileInfo {
+	var groups [][]db.FileInfo
+outer:
+	for _, info := range set {
+		for i := range groups {
+			if groups[i][0].SameFile(&info) {
+				groups[i] = append(groups[i], info)
+				continue outer
+			}
+		}
+		groups = append(groups, []db.FileInfo{info})
+	}
+	return groups
+}
@@ -143,3 +143,117 @@ func TestReportRelativeArgument(t *testing.T) {
 		t.Fatalf("expected '%s', got '%s'", expected, got)
 	}
 }
+
+func TestReportHardlinks(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := hardlinkDir()
+	defer os.RemoveAll(dir)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{dir}, &ScanOptions{})
+	err := ps.Report(dir, &ReportOptions{Relative: true})
+	check(t, err)
+	got := strings.TrimSpace(out.String())
+	expected := strings.TrimSpace(`
+4 B
+  a
+    b (hardlink)
+  c
+	`)
+	if got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+}
+
+func TestReportDirs(t *testing.T) {
+	fs := testfs.Read(`
+/photos/2019/a.jpg [1000 1]
+/photos/2019/b.jpg [2000 2]
+/photos/2019/sub/c.jpg [3000 3]
+/backup/photos/2019/a.jpg [1000 1]
+/backup/photos/2019/b.jpg [2000 2]
+/backup/photos/2019/sub/c.jpg [3000 3]
+/other/a.jpg [1000 1]
+/renamed/x.jpg [2000 2]
+/renamed/sub/c.jpg [3000 3]
+/extra/sub/c.jpg [3000 3]
+/extra/sub/d.jpg [4000 4]
+	`).Mkfs()
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Report("", &ReportOptions{Dirs: true})
+	check(t, err)
+	got := strings.TrimSpace(out.String())
+	expected := strings.TrimSpace(`
+6.0 kB in 3 files (identical tracked contents)
+  /backup/photos/
+  /photos/
+
+3.0 kB in 1 file (identical tracked contents)
+  /backup/photos/2019/sub/
+  /photos/2019/sub/
+  /renamed/sub/
+
+3.0 kB
+  /backup/photos/2019/sub/c.jpg
+  /extra/sub/c.jpg
+  /photos/2019/sub/c.jpg
+  /renamed/sub/c.jpg
+
+2.0 kB
+  /backup/photos/2019/b.jpg
+  /photos/2019/b.jpg
+  /renamed/x.jpg
+
+1.0 kB
+  /backup/photos/2019/a.jpg
+  /other/a.jpg
+  /photos/2019/a.jpg
+	`)
+	if got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+}
+
+func TestReportDirsNested(t *testing.T) {
+	fs := testfs.Read(`
+/photos/2019/a.jpg [1000 1]
+/photos/2019/sub/c.jpg [3000 3]
+/backup/photos/2019/a.jpg [1000 1]
+/backup/photos/2019/sub/c.jpg [3000 3]
+/renamed/x.jpg [2000 2]
+/renamed/sub/c.jpg [3000 3]
+	`).Mkfs()
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	// the sub directories are identical, but that's implied by their
+	// parents, except for "/renamed/sub", whose parent is different
+	err := ps.Report("/renamed", &ReportOptions{Dirs: true, Relative: true})
+	check(t, err)
+	got := out.String()
+	expected := "3.0 kB in 1 file (identical tracked contents)\n  /backup/photos/2019/sub/\n  /photos/2019/sub/\n  sub/\n"
+	if !strings.HasPrefix(got, expected) {
+		t.Fatalf("expected '%s...', got '%s'", expected, got)
+	}
+}
+
+func TestReportDirsIgnoresUntracked(t *testing.T) {
+	fs := testfs.Read(`
+/photos/2019/a.jpg [1000 1]
+/photos/2019/b.jpg [2000 2]
+/photos/2019/sub/c.jpg [3000 3]
+/backup/photos/2019/a.jpg [1000 1]
+/backup/photos/2019/b.jpg [2000 2]
+/backup/photos/2019/sub/c.jpg [3000 3]
+	`).Mkfs()
+	// neither an empty file nor an empty directory is in the database
+	afero.WriteFile(fs, "/photos/2019/empty.txt", nil, 0o644)
+	fs.MkdirAll("/backup/photos/2019/empty", 0o755)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Report("", &ReportOptions{Dirs: true})
+	check(t, err)
+	expected := "6.0 kB in 3 files (identical tracked contents)\n  /backup/photos/\n  /photos/\n"
+	if !strings.HasPrefix(out.String(), expected) {
+		t.Fatalf("expected '%s...', got '%s'", expected, out.String())
+	}
+}
@@ -1,13 +1,19 @@
 package periscope
 
 import (
+	"github.com/anishathalye/periscope/internal/db"
 	"github.com/anishathalye/periscope/internal/herror"
 
 	"bytes"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
+
+	"github.com/spf13/afero"
 )
 
 type RmOptions struct {
@@ -16,6 +22,62 @@ type RmOptions struct {
 	DryRun    bool
 	Contained []string
 	Arbitrary bool
+	// compare files byte-for-byte with the surviving copy before removing
+	// them, rather than relying on hashes alone
+	Paranoid bool
+	// treat images with the same pixels as copies of each other, even if
+	// their metadata differs; see image.go
+	AllowMetadataDifferences bool
+	// move files to the trash instead of deleting them; see trash.go
+	Trash bool
+	// after deleting duplicates in a directory, also delete the
+	// directories inside it (and the directory itself) that are empty
+	PruneEmpty bool
+	// replace files with symbolic links to their copies instead of
+	// deleting them; set by Symlink
+	symlink *SymlinkOptions
+	// replace files with hardlinks to copies on the same device instead of
+	// deleting them; set by Link
+	hardlink bool
+	// only keep this copy, rather than any copy outside the files being
+	// removed; set by Dedupe
+	survivor string
+	// called when a set of candidates is left alone because they, or the
+	// copy to keep, no longer match or can't be read; set by Dedupe
+	unverified func()
+}
+
+// the verb used in messages about files that can't be removed
+func (options *RmOptions) action() string {
+	if options.symlink != nil {
+		return "replace"
+	}
+	if options.hardlink {
+		return "link"
+	}
+	return "remove"
+}
+
+// reports a set of candidates that is left alone because it can't be
+// verified; see unverified
+func (options *RmOptions) notVerified() {
+	if options.unverified != nil {
+		options.unverified()
+	}
+}
+
+// how the operation is described in the journal
+func (options *RmOptions) command() string {
+	switch {
+	case options.symlink != nil:
+		return "symlink"
+	case options.hardlink:
+		return "link"
+	case options.Trash:
+		return "rm --trash"
+	default:
+		return "rm"
+	}
 }
 
 func (ps *Periscope) Rm(paths []string, options *RmOptions) herror.Interface {
@@ -30,9 +92,37 @@ func (ps *Periscope) Rm(paths []string, options *RmOptions) herror.Interface {
 		}
 		absContained = append(absContained, absPath)
 	}
+	// refuse to start if some of the files can't be moved to the trash
+	if options.Trash {
+		for _, path := range paths {
+			absPath, err := filepath.Abs(path)
+			if err != nil {
+				return herror.Internal(err, "")
+			}
+			stat, err := ps.fs.Stat(absPath)
+			if err != nil {
+				continue // reported below
+			}
+			devices, herr := ps.devicesIn(absPath, stat)
+			if herr != nil {
+				return herr
+			}
+			if herr := ps.checkTrashDevices(path, devices); herr != nil {
+				return herr
+			}
+		}
+	}
+	// everything removed by this call is undone together
+	ps.operation = &journalOperation{command: options.command(), time: time.Now()}
+	defer func() { ps.operation = nil }()
 
 	for _, path := range paths {
-		absPath, info, err := ps.checkFile(path, false, false, "remove", false, false)
+		if isArchiveMember(path) {
+			fmt.Fprintf(ps.errStream, "cannot %s '%s': file is inside an archive\n", options.action(), path)
+			herr = herror.Silent()
+			continue
+		}
+		absPath, info, err := ps.checkFile(path, false, false, options.action(), false, false)
 		if err != nil {
 			if !herror.IsSilent(err) {
 				return err
@@ -56,28 +146,46 @@ func (ps *Periscope) Rm(paths []string, options *RmOptions) herror.Interface {
 }
 
 func (ps *Periscope) removeFile(path string, options *RmOptions, absContained []string) herror.Interface {
-	return ps.remove1(map[string]struct{}{path: {}}, options, true, "", absContained)
+	return ps.remove1(map[string]struct{}{path: {}}, options, true, "", absContained, nil)
 }
 
 func (ps *Periscope) removeDirectory(path string, absPath string, options *RmOptions, absContained []string) herror.Interface {
 	if !options.Recursive {
-		fmt.Fprintf(ps.errStream, "cannot remove '%s': must specify -r/--recursive to delete directories\n", path)
+		if options.symlink != nil {
+			fmt.Fprintf(ps.errStream, "cannot replace '%s': must specify -r/--recursive to replace files in directories\n", path)
+		} else if options.hardlink {
+			fmt.Fprintf(ps.errStream, "cannot link '%s': must specify -r/--recursive to link directories\n", path)
+		} else {
+			fmt.Fprintf(ps.errStream, "cannot remove '%s': must specify -r/--recursive to delete directories\n", path)
+		}
 		return herror.Silent()
 	}
-	c, herr := ps.db.LookupAllC(absPath, true)
+	candidateSets, herr := ps.candidateSets(absPath)
 	if herr != nil {
 		return herr
 	}
-	byHash := make(map[[HashSize]byte]map[string]struct{})
-	for dupInfo := range c {
-		hash := hashToArray(dupInfo.FullHash)
-		if byHash[hash] == nil {
-			byHash[hash] = make(map[string]struct{})
+	// images that differ from their copies only in metadata are only
+	// found by their pixel hashes; files that were removed as duplicates
+	// above are skipped by remove1
+	if options.AllowMetadataDifferences {
+		sets, err := ps.db.AllEquivalent(db.SamePixels, absPath)
+		if err != nil {
+			return err
+		}
+		for _, set := range sets {
+			candidates := make(map[string]struct{})
+			for _, info := range set {
+				if containedInAny(info.Path, []string{absPath}) && !isArchiveMember(info.Path) {
+					candidates[info.Path] = struct{}{}
+				}
+			}
+			candidateSets = append(candidateSets, candidates)
 		}
-		byHash[hash][dupInfo.Path] = struct{}{}
 	}
-	for _, candidates := range byHash {
-		err := ps.remove1(candidates, options, false, path, absContained)
+	// files that are deleted, or would be deleted in a dry run
+	removed := make(map[string]struct{})
+	for _, candidates := range candidateSets {
+		err := ps.remove1(candidates, options, false, path, absContained, removed)
 		if err != nil {
 			herr = err
 		}
@@ -86,10 +194,95 @@ func (ps *Periscope) removeDirectory(path string, absPath string, options *RmOpt
 			return herr
 		}
 	}
+	if options.PruneEmpty {
+		ps.pruneEmpty(path, absPath, options, removed)
+	}
 	return herr
 }
 
-func (ps *Periscope) remove1(candidates map[string]struct{}, options *RmOptions, singleFile bool, directory string, absContained []string) herror.Interface {
+// deletes the directories under dir that are left empty by removing files,
+// deepest first; only directories that contained a removed file, directly or
+// in a subdirectory, are considered, and dir itself is never deleted, so
+// directories that were already empty are left alone
+//
+// files and directories in removed count as already deleted, and pruned
+// directories are added to it
+func (ps *Periscope) pruneEmpty(directory, dir string, options *RmOptions, removed map[string]struct{}) {
+	seen := make(map[string]struct{})
+	var parents []string
+	for path := range removed {
+		for parent := filepath.Dir(path); containedInAny(parent, []string{dir}); parent = filepath.Dir(parent) {
+			if _, ok := seen[parent]; ok {
+				break
+			}
+			seen[parent] = struct{}{}
+			parents = append(parents, parent)
+		}
+	}
+	// a directory is longer than any of its ancestors
+	sort.Slice(parents, func(i, j int) bool {
+		if len(parents[i]) != len(parents[j]) {
+			return len(parents[i]) > len(parents[j])
+		}
+		return parents[i] < parents[j]
+	})
+	for _, parent := range parents {
+		entries, err := afero.ReadDir(ps.fs, parent)
+		if err != nil {
+			log.Printf("ReadDir('%s') returned an error: %s", parent, err)
+			continue
+		}
+		empty := true
+		for _, entry := range entries {
+			if _, ok := removed[filepath.Join(parent, entry.Name())]; !ok {
+				empty = false
+				break
+			}
+		}
+		if !empty {
+			continue
+		}
+		if options.Verbose {
+			fmt.Fprintf(ps.outStream, "rmdir %s\n", relFrom(directory, parent))
+		}
+		if !options.DryRun {
+			if err := ps.fs.Remove(parent); err != nil {
+				log.Printf("Remove('%s') returned an error: %s", parent, err)
+				continue
+			}
+		}
+		removed[parent] = struct{}{}
+	}
+}
+
+// groups the files in a directory that have duplicates by their contents;
+// files inside archives are left out, because they can't be changed
+func (ps *Periscope) candidateSets(absPath string) ([]map[string]struct{}, herror.Interface) {
+	c, herr := ps.db.LookupAllC(absPath, true)
+	if herr != nil {
+		return nil, herr
+	}
+	byHash := make(map[string]map[string]struct{})
+	for dupInfo := range c {
+		if isArchiveMember(dupInfo.Path) {
+			continue
+		}
+		hash := string(dupInfo.FullHash)
+		if byHash[hash] == nil {
+			byHash[hash] = make(map[string]struct{})
+		}
+		byHash[hash][dupInfo.Path] = struct{}{}
+	}
+	candidateSets := make([]map[string]struct{}, 0, len(byHash))
+	for _, candidates := range byHash {
+		candidateSets = append(candidateSets, candidates)
+	}
+	return candidateSets, nil
+}
+
+// removes the candidates if they have a copy elsewhere; when deleting a
+// directory, the paths that are removed are added to removed
+func (ps *Periscope) remove1(candidates map[string]struct{}, options *RmOptions, singleFile bool, directory string, absContained []string, removed map[string]struct{}) herror.Interface {
 	// take a conservative approach to deleting files:
 	// - compute a full hash of all files in the set; if they're not all the same, abort
 	// - go through all other files in the same duplicate set that are
@@ -103,7 +296,7 @@ func (ps *Periscope) remove1(candidates map[string]struct{}, options *RmOptions,
 	absPaths := make(map[string]struct{})
 	infos := make(map[string]os.FileInfo)
 	for path := range candidates {
-		absPath, info, err := ps.checkFile(path, true, false, "remove", !singleFile, false)
+		absPath, info, err := ps.checkFile(path, true, false, options.action(), !singleFile, false)
 		if err != nil {
 			if singleFile {
 				return err
@@ -117,14 +310,51 @@ func (ps *Periscope) remove1(candidates map[string]struct{}, options *RmOptions,
 	}
 	if len(infos) == 0 {
 		// no candidates when deleting a directory (all files disappeared)
+		options.notVerified()
 		return nil
 	}
+	// hardlinks can't cross devices, so each device's candidates are
+	// linked to a copy on that device
+	if options.hardlink && !singleFile {
+		byDevice := make(map[int64]map[string]struct{})
+		for absPath, info := range infos {
+			_, _, device := sysStat(info)
+			if byDevice[device] == nil {
+				byDevice[device] = make(map[string]struct{})
+			}
+			byDevice[device][absPath] = struct{}{}
+		}
+		if len(byDevice) > 1 {
+			var herr herror.Interface
+			for _, group := range byDevice {
+				if err := ps.remove1(group, options, false, directory, absContained, removed); err != nil {
+					if !herror.IsSilent(err) {
+						return err
+					}
+					herr = err
+				}
+			}
+			return herr
+		}
+	}
 	// `candidates` is never used after this point
 	set, _ := ps.db.Lookup(absPath0)
+	// files are compared by their contents, or, for images when metadata
+	// differences are allowed, by their pixels
+	fingerprint := ps.hashFile
+	compare := ps.sameContents
+	compared := "contents"
+	if options.AllowMetadataDifferences && len(set) > 0 && set[0].PixelHash != nil {
+		pixelSet, _ := ps.db.LookupEquivalent(db.SamePixels, absPath0)
+		set = mergeSets(set, pixelSet)
+		fingerprint = ps.hashPixels
+		compare = ps.samePixels
+		compared = "pixels"
+	}
 	// ensure all candidates contained in set
-	duplicateSet := make(map[string]struct{})
+	duplicateSet := make(map[string]db.FileInfo)
 	for _, info := range set {
-		duplicateSet[info.Path] = struct{}{}
+		duplicateSet[info.Path] = info
 	}
 	allContained := true
 	for path := range absPaths {
@@ -136,9 +366,10 @@ func (ps *Periscope) remove1(candidates map[string]struct{}, options *RmOptions,
 	if !allContained {
 		if singleFile {
 			// use path0 to use the non-absolute path that was passed in
-			fmt.Fprintf(ps.errStream, "cannot remove '%s': no duplicates\n", path0)
+			fmt.Fprintf(ps.errStream, "cannot %s '%s': no duplicates\n", options.action(), path0)
 			return herror.Silent()
 		}
+		options.notVerified()
 		return nil
 	}
 
@@ -162,14 +393,14 @@ func (ps *Periscope) remove1(candidates map[string]struct{}, options *RmOptions,
 	// candidates match each other
 	var hash []byte
 	for path := range absPaths {
-		currHash, err := ps.hashFile(path)
+		currHash, err := fingerprint(path)
 		if err != nil {
-			log.Printf("hashFile('%s') returned error: %s", path, err)
+			log.Printf("hashing '%s' returned error: %s", path, err)
 			if singleFile {
 				if os.IsPermission(err) {
-					fmt.Fprintf(ps.errStream, "cannot remove '%s': permission denied\n", path0)
+					fmt.Fprintf(ps.errStream, "cannot %s '%s': permission denied\n", options.action(), path0)
 				} else {
-					fmt.Fprintf(ps.errStream, "cannot remove '%s': %s\n", path0, err)
+					fmt.Fprintf(ps.errStream, "cannot %s '%s': %s\n", options.action(), path0, err)
 				}
 				return herror.Silent()
 			}
@@ -182,6 +413,7 @@ func (ps *Periscope) remove1(candidates map[string]struct{}, options *RmOptions,
 		} else {
 			if !bytes.Equal(hash, currHash) {
 				// files within set don't agree; give up
+				options.notVerified()
 				return nil
 			}
 		}
@@ -190,16 +422,41 @@ func (ps *Periscope) remove1(candidates map[string]struct{}, options *RmOptions,
 	// because we might delete paths in the above loop, check to see that
 	// there are still paths to remove
 	if len(absPaths) == 0 {
+		options.notVerified()
 		return nil
 	}
 
 	// ensure that a copy exists elsewhere
 	otherMatch := false
-	for path := range duplicateSet {
+	var survivor string
+	var survivorInfo os.FileInfo
+	_, _, device := sysStat(infos[absPath0])
+	for path, info := range duplicateSet {
 		if _, ok := absPaths[path]; ok {
 			// this is one of the paths we are considering deleting
 			continue // bad candidate
 		}
+		if options.survivor != "" && path != options.survivor {
+			continue // bad candidate: not the chosen copy
+		}
+		// a hardlink to one of the paths we are deleting is not a
+		// separate copy; this is checked again below with the live
+		// file system, but we can skip known hardlinks without hashing
+		//
+		// when linking, a file that's already linked to a candidate is
+		// as good a copy as any, because linking a file to itself
+		// changes nothing
+		linked := false
+		for delPath := range absPaths {
+			delInfo := duplicateSet[delPath]
+			if info.SameFile(&delInfo) && !options.hardlink {
+				linked = true
+				break
+			}
+		}
+		if linked {
+			continue // bad candidate
+		}
 		if len(absContained) > 0 {
 			// check if path is in any of the contained directories
 			inContained := false
@@ -217,20 +474,35 @@ func (ps *Periscope) remove1(candidates map[string]struct{}, options *RmOptions,
 		}
 		// check that the hash still matches, that the file still
 		// exists and hasn't changed
-		otherHash, err := ps.hashFile(path)
+		otherHash, err := fingerprint(path)
 		if err != nil {
-			log.Printf("hashFile('%s') returned error: %s", path, err.Error())
+			log.Printf("hashing '%s' returned error: %s", path, err.Error())
 			continue // bad candidate
 		}
 		if bytes.Equal(hash, otherHash) {
+			if isArchiveMember(path) && (options.symlink != nil || options.hardlink) {
+				continue // bad candidate: it can't be linked to
+			}
+			if isArchiveMember(path) {
+				// can't be the same file as any of the paths we
+				// are deleting
+				otherMatch = true
+				survivor = path
+				break
+			}
 			_, otherInfo, err := ps.checkFile(path, true, false, "", true, false)
 			if err != nil {
 				log.Printf("checkFile('%s') returned error: %s", path, err.Error())
 				continue // bad candidate
 			}
+			if options.hardlink {
+				if _, _, d := sysStat(otherInfo); d != device {
+					continue // bad candidate: it can't be linked to
+				}
+			}
 			// be extra sure that they aren't the same file
 			bad := false
-			if ps.realFs {
+			if ps.realFs && !options.hardlink {
 				for delPath := range absPaths {
 					if os.SameFile(infos[delPath], otherInfo) {
 						bad = true
@@ -240,49 +512,119 @@ func (ps *Periscope) remove1(candidates map[string]struct{}, options *RmOptions,
 			}
 			if !bad {
 				otherMatch = true
+				survivor = path
+				survivorInfo = otherInfo
 				break
 			}
 		}
 		// keep trying to find a duplicate ...
 	}
+	// when linking a directory, candidates with no copy elsewhere on the
+	// same device are linked to one of them, which loses no paths
+	if !otherMatch && options.hardlink && !singleFile && options.survivor == "" && len(absPaths) > 1 {
+		for path := range absPaths {
+			if survivor == "" || path < survivor {
+				survivor = path
+			}
+		}
+		otherMatch = true
+		survivorInfo = infos[survivor]
+		delete(absPaths, survivor)
+	}
+	if otherMatch && options.hardlink {
+		for path := range absPaths {
+			if ps.realFs && os.SameFile(infos[path], survivorInfo) {
+				delete(absPaths, path) // already linked
+			}
+		}
+		if len(absPaths) == 0 {
+			return nil
+		}
+	}
 	if !otherMatch {
 		if singleFile {
 			if len(absContained) > 0 {
 				if len(absContained) == 1 {
-					fmt.Fprintf(ps.errStream, "cannot remove '%s': no duplicates in '%s'\n", path0, options.Contained[0])
+					fmt.Fprintf(ps.errStream, "cannot %s '%s': no duplicates in '%s'\n", options.action(), path0, options.Contained[0])
 				} else {
-					fmt.Fprintf(ps.errStream, "cannot remove '%s': no duplicates in specified contained directories\n", path0)
+					fmt.Fprintf(ps.errStream, "cannot %s '%s': no duplicates in specified contained directories\n", options.action(), path0)
 				}
+			} else if options.hardlink {
+				fmt.Fprintf(ps.errStream, "cannot %s '%s': no copy on the same device\n", options.action(), path0)
 			} else {
-				fmt.Fprintf(ps.errStream, "cannot remove '%s': no duplicates\n", path0)
+				fmt.Fprintf(ps.errStream, "cannot %s '%s': no duplicates\n", options.action(), path0)
 			}
 			return herror.Silent()
 		}
+		options.notVerified()
 		return nil
 	}
 
+	// in paranoid mode, don't trust hashes: compare every candidate to the
+	// copy that will survive
+	if options.Paranoid {
+		for path := range absPaths {
+			showPath := path0
+			if !singleFile {
+				showPath = relFrom(directory, path)
+			}
+			equal, err := compare(path, survivor)
+			if err != nil {
+				log.Printf("comparing '%s' and '%s' returned error: %s", path, survivor, err)
+				if singleFile {
+					fmt.Fprintf(ps.errStream, "cannot %s '%s': %s\n", options.action(), showPath, err)
+					return herror.Silent()
+				}
+				delete(absPaths, path) // note: this is safe to do while iterating over the map
+				continue
+			}
+			if !equal {
+				fmt.Fprintf(ps.errStream, "WARNING: not removing '%s': it has the same hash as '%s' but different %s; either this is a hash collision, or a file changed during the operation\n", showPath, survivor, compared)
+				return herror.Silent()
+			}
+		}
+		if len(absPaths) == 0 {
+			options.notVerified()
+			return nil
+		}
+	}
+
+	// the journal records how the candidates were verified, because
+	// images with the same pixels can only be recreated from the survivor
+	// up to their metadata
+	var pixelHash []byte
+	if compared == "pixels" {
+		pixelHash = hash
+	}
+
 	// okay, we can delete all candidates in the set
 	if singleFile {
 		// path that is passed in, path0, is what the user typed, so we
 		// use that for printing purposes
 		if !options.DryRun {
-			err := ps.fs.Remove(absPath0)
+			err := ps.delete(absPath0, survivor, duplicateSet[absPath0], infos[absPath0], pixelHash, options)
 			if os.IsNotExist(err) {
-				fmt.Fprintf(ps.errStream, "cannot remove '%s': no such file\n", path0)
+				fmt.Fprintf(ps.errStream, "cannot %s '%s': no such file\n", options.action(), path0)
 				return herror.Silent()
 			} else if os.IsPermission(err) {
-				fmt.Fprintf(ps.errStream, "cannot remove '%s': permission denied\n", path0)
+				fmt.Fprintf(ps.errStream, "cannot %s '%s': permission denied\n", options.action(), path0)
+				return herror.Silent()
+			} else if err != nil && options.Trash {
+				fmt.Fprintf(ps.errStream, "cannot move '%s' to trash: %s\n", path0, err)
+				return herror.Silent()
+			} else if err != nil && (options.symlink != nil || options.hardlink) {
+				fmt.Fprintf(ps.errStream, "cannot %s '%s': %s\n", options.action(), path0, err)
 				return herror.Silent()
 			} else if err != nil {
 				return herror.Internal(err, "")
 			}
-			herr := ps.db.Remove(absPath0)
+			herr := ps.removeFromDb(absPath0, duplicateSet[absPath0], options)
 			if herr != nil {
 				return herr
 			}
 		}
 		if options.Verbose {
-			fmt.Fprintf(ps.outStream, "rm %s\n", path0)
+			ps.showRemoval(path0, absPath0, survivor, options)
 		}
 	} else {
 		// delete in sorted order
@@ -290,15 +632,22 @@ func (ps *Periscope) remove1(candidates map[string]struct{}, options *RmOptions,
 			// calculate a nicer version to print to the user
 			rel := relFrom(directory, absPath)
 			if options.Verbose {
-				fmt.Fprintf(ps.outStream, "rm %s\n", rel)
+				ps.showRemoval(rel, absPath, survivor, options)
 			}
-			if !options.DryRun {
-				err := ps.fs.Remove(absPath)
-				if err != nil && !(os.IsNotExist(err) || os.IsPermission(err)) {
+			if options.DryRun {
+				removed[absPath] = struct{}{}
+			} else {
+				err := ps.delete(absPath, survivor, duplicateSet[absPath], infos[absPath], pixelHash, options)
+				if err != nil && options.Trash && !(os.IsNotExist(err) || os.IsPermission(err)) {
+					fmt.Fprintf(ps.errStream, "cannot move '%s' to trash: %s\n", rel, err)
+				} else if err != nil && (options.symlink != nil || options.hardlink) && !(os.IsNotExist(err) || os.IsPermission(err)) {
+					fmt.Fprintf(ps.errStream, "cannot %s '%s': %s\n", options.action(), rel, err)
+				} else if err != nil && !(os.IsNotExist(err) || os.IsPermission(err)) {
 					log.Printf("Remove('%s') returned an error: %s", absPath, err)
 				}
 				if err == nil {
-					herr := ps.db.Remove(absPath)
+					removed[absPath] = struct{}{}
+					herr := ps.removeFromDb(absPath, duplicateSet[absPath], options)
 					if herr != nil {
 						return herr
 					}
@@ -306,5 +655,80 @@ func (ps *Periscope) remove1(candidates map[string]struct{}, options *RmOptions,
 			}
 		}
 	}
+	// linking changes the survivor's ctime
+	if options.hardlink && !options.DryRun {
+		return ps.updateLinked(duplicateSet[survivor])
+	}
+	return nil
+}
+
+// deletes a file, moves it to the trash, or replaces it with a symbolic link
+// to the survivor, recording it in the journal first so it can be restored;
+// pixelHash is set if the file was only verified to have the survivor's pixels
+func (ps *Periscope) delete(absPath, survivor string, info db.FileInfo, stat os.FileInfo, pixelHash []byte, options *RmOptions) error {
+	id, herr := ps.journal(absPath, survivor, info, stat, pixelHash)
+	if herr != nil {
+		return herr
+	}
+	if err := ps.delete1(absPath, survivor, info, id, options); err != nil {
+		ps.db.RemoveJournalEntry(id)
+		return err
+	}
+	return nil
+}
+
+func (ps *Periscope) delete1(absPath, survivor string, info db.FileInfo, journal int64, options *RmOptions) error {
+	if options.symlink != nil {
+		return ps.replaceWithSymlink(survivor, absPath, options.symlink.Relative)
+	}
+	if options.hardlink {
+		return ps.replaceWithLink(survivor, absPath)
+	}
+	if options.Trash {
+		return ps.moveToTrash(absPath, info, journal)
+	}
+	return ps.fs.Remove(absPath)
+}
+
+// lists a file that's removed (or replaced) in verbose mode
+func (ps *Periscope) showRemoval(path, absPath, survivor string, options *RmOptions) {
+	if options.symlink != nil {
+		fmt.Fprintf(ps.outStream, "ln -s %s %s\n", symlinkTarget(survivor, absPath, options.symlink.Relative), path)
+	} else if options.hardlink {
+		fmt.Fprintf(ps.outStream, "ln %s %s\n", survivor, path)
+	} else {
+		fmt.Fprintf(ps.outStream, "rm %s\n", path)
+	}
+}
+
+// removes a deleted file from the database, along with its members if it's an
+// archive; a file that was replaced with a hardlink is kept, with its new
+// metadata
+func (ps *Periscope) removeFromDb(absPath string, info db.FileInfo, options *RmOptions) herror.Interface {
+	if options.hardlink {
+		return ps.updateLinked(info)
+	}
+	if err := ps.db.Remove(absPath); err != nil {
+		return err
+	}
+	if archiveFormatOf(absPath) != notArchive {
+		return ps.db.RemoveDir(archiveMembersDir(absPath), 0, 0)
+	}
 	return nil
 }
+
+// the union of two sets, keeping the first set's order, so the first info stays
+// first
+func mergeSets(a, b db.DuplicateSet) db.DuplicateSet {
+	merged := append(db.DuplicateSet{}, a...)
+	seen := make(map[string]struct{})
+	for _, info := range a {
+		seen[info.Path] = struct{}{}
+	}
+	for _, info := range b {
+		if _, ok := seen[info.Path]; !ok {
+			merged = append(merged, info)
+		}
+	}
+	return merged
+}
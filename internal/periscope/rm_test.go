@@ -862,3 +862,141 @@ func TestRmDeleted(t *testing.T) {
 		t.Fatalf("expected stderr to contain '%s', was '%s'", expected, got)
 	}
 }
+
+func TestRmHardlinkNotDuplicate(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := hardlinkDir()
+	defer os.RemoveAll(dir)
+	ps, _, errStream := newTest(fs)
+	ps.Scan([]string{dir}, &ScanOptions{})
+	err := ps.Rm([]string{filepath.Join(dir, "x")}, &RmOptions{})
+	checkErr(t, err)
+	if !strings.Contains(errStream.String(), "no duplicates") {
+		t.Fatalf("expected error message to mention no duplicates, got '%s'", errStream.String())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "x")); err != nil {
+		t.Fatal("expected x to be preserved")
+	}
+	err = ps.Rm([]string{filepath.Join(dir, "a"), filepath.Join(dir, "b")}, &RmOptions{})
+	check(t, err)
+	if _, err := os.Stat(filepath.Join(dir, "c")); err != nil {
+		t.Fatal("expected c to be preserved")
+	}
+}
+
+func TestRmParanoidCollision(t *testing.T) {
+	fs := testfs.Read(`
+/a [10000 1]
+/b [10000 2]
+	`).Mkfs()
+	ps, _, errStream := newTest(fs)
+	ps.hash = collidingHashAlgorithm
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Rm([]string{"/a"}, &RmOptions{Paranoid: true})
+	checkErr(t, err)
+	if !strings.Contains(errStream.String(), "WARNING: not removing '/a'") {
+		t.Fatalf("expected a warning, got '%s'", errStream.String())
+	}
+	if _, err := fs.Stat("/a"); err != nil {
+		t.Fatal("expected /a to be kept")
+	}
+}
+
+func TestRmParanoidRecursiveCollision(t *testing.T) {
+	fs := testfs.Read(`
+/d/a [10000 1]
+/b [10000 2]
+	`).Mkfs()
+	ps, _, errStream := newTest(fs)
+	ps.hash = collidingHashAlgorithm
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Rm([]string{"/d"}, &RmOptions{Recursive: true, Paranoid: true})
+	checkErr(t, err)
+	if !strings.Contains(errStream.String(), "WARNING") {
+		t.Fatalf("expected a warning, got '%s'", errStream.String())
+	}
+	if _, err := fs.Stat("/d/a"); err != nil {
+		t.Fatal("expected /d/a to be kept")
+	}
+}
+
+func TestRmParanoid(t *testing.T) {
+	fs := testfs.Read(`
+/a [10000 1]
+/b [10000 1]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Rm([]string{"/a"}, &RmOptions{Paranoid: true})
+	check(t, err)
+	if _, err := fs.Stat("/a"); !os.IsNotExist(err) {
+		t.Fatal("expected /a to be removed")
+	}
+	if _, err := fs.Stat("/b"); err != nil {
+		t.Fatal("expected /b to be kept")
+	}
+}
+
+func TestRmPruneEmpty(t *testing.T) {
+	fs := testfs.Read(`
+/keep/x [1000 1]
+/keep/y [2000 2]
+/old/x [1000 1]
+/old/sub/y [2000 2]
+/other/a/y [2000 2]
+/other/b/z [3000 3]
+	`).Mkfs()
+	// directories that were already empty are left alone
+	fs.MkdirAll("/old/empty", 0o755)
+	fs.MkdirAll("/other/c", 0o755)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Rm([]string{"/old", "/other"}, &RmOptions{Recursive: true, PruneEmpty: true, Verbose: true})
+	check(t, err)
+	expected := testfs.Read(`
+/keep/x [1000 1]
+/keep/y [2000 2]
+/other/b/z [3000 3]
+	`)
+	if !testfs.Equal(fs, expected) {
+		t.Fatalf("expected:\n%sgot:\n%s", expected.ShowIndent(2), testfs.ShowIndent(fs, 2))
+	}
+	for _, dir := range []string{"/old/sub", "/other/a"} {
+		if _, err := fs.Stat(dir); !os.IsNotExist(err) {
+			t.Fatalf("expected '%s' to be deleted", dir)
+		}
+	}
+	// neither are the given directories, even if they're left empty
+	for _, dir := range []string{"/old", "/old/empty", "/other/b", "/other/c"} {
+		if _, err := fs.Stat(dir); err != nil {
+			t.Fatalf("expected '%s' to be kept, got %s", dir, err)
+		}
+	}
+	for _, line := range []string{"rmdir /old/sub\n", "rmdir /other/a\n"} {
+		if !strings.Contains(out.String(), line) {
+			t.Fatalf("expected output to contain '%s', got '%s'", line, out.String())
+		}
+	}
+	if strings.Count(out.String(), "rmdir") != 2 {
+		t.Fatalf("expected only two directories to be deleted, got '%s'", out.String())
+	}
+}
+
+func TestRmPruneEmptyDryRun(t *testing.T) {
+	fs := testfs.Read(`
+/keep/x [1000 1]
+/old/x [1000 1]
+/old/sub/x [1000 1]
+	`).Mkfs()
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Rm([]string{"/old"}, &RmOptions{Recursive: true, PruneEmpty: true, DryRun: true, Verbose: true})
+	check(t, err)
+	if _, err := fs.Stat("/old/sub/x"); err != nil {
+		t.Fatalf("expected files to be kept in a dry run, got %s", err)
+	}
+	got := out.String()
+	if !strings.HasSuffix(got, "\nrmdir /old/sub\n") || strings.Contains(got, "rmdir /old\n") {
+		t.Fatalf("expected directories to be listed, got '%s'", got)
+	}
+}
@@ -6,8 +6,12 @@ import (
 	"github.com/anishathalye/periscope/internal/par"
 
 	"encoding/binary"
+	"encoding/json"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"time"
 
 	"github.com/spf13/afero"
 )
@@ -15,9 +19,75 @@ import (
 type ScanOptions struct {
 	Minimum int64
 	Maximum int64
+	Exclude []string
+	Include []string
+	// the content hash algorithm; can only be changed while the database
+	// is empty, and defaults to the algorithm already in use
+	Hash string
+	// confirm that files with equal hashes are identical by comparing their
+	// contents byte-for-byte
+	Paranoid bool
+	// the number of concurrent readers for the device that each path is
+	// on; by default, this is chosen based on whether the device is
+	// rotational
+	DeviceThreads map[string]int
+	// also scan the files inside zip and tar archives; see archive.go
+	Archives bool
+	// compute perceptual hashes of images, for finding similar images;
+	// see image.go
+	SimilarImages bool
+	// compute hashes of the decoded pixels of images, for finding images
+	// that differ only in their metadata; see image.go
+	ImagePixels bool
+	// compute hashes of the normalized contents of text files, for
+	// finding files that differ only in line endings, a byte order mark,
+	// or a trailing newline; see text.go
+	NormalizeText bool
+	// compute similarity digests of files, for finding files with
+	// similar contents; see fuzzy.go
+	Fuzzy bool
+	// decompress gzip, bzip2, xz, and zstd files, to find compressed
+	// copies of other files; see compress.go
+	Decompress bool
+	// resume an interrupted scan; the paths and other options are taken
+	// from the interrupted scan
+	Resume bool
+}
+
+// results are checkpointed (committed to the database) after this many files
+// have been processed, or after checkpointInterval, whichever comes first
+var checkpointSize = envGetInt("PERISCOPE_CHECKPOINT_SIZE", 10000)
+
+const checkpointInterval = 10 * time.Second
+
+// the parameters of a scan in progress, saved in the database so that an
+// interrupted scan can be resumed
+const scanStateKey = "scan"
+
+type scanState struct {
+	Paths         []string       `json:"paths"`
+	Minimum       int64          `json:"minimum"`
+	Maximum       int64          `json:"maximum"`
+	Exclude       []string       `json:"exclude"`
+	Include       []string       `json:"include"`
+	Paranoid      bool           `json:"paranoid"`
+	DeviceThreads map[string]int `json:"device_threads"`
+	Archives      bool           `json:"archives"`
+	SimilarImages bool           `json:"similar_images"`
+	ImagePixels   bool           `json:"image_pixels"`
+	NormalizeText bool           `json:"normalize_text"`
+	Fuzzy         bool           `json:"fuzzy"`
+	Decompress    bool           `json:"decompress"`
 }
 
 func (ps *Periscope) Scan(paths []string, options *ScanOptions) herror.Interface {
+	if options.Resume {
+		var err herror.Interface
+		paths, options, err = ps.interruptedScan()
+		if err != nil {
+			return err
+		}
+	}
 	// check that paths exist before starting any work
 	absPaths := make([]string, len(paths))
 	for i, path := range paths {
@@ -27,26 +97,96 @@ func (ps *Periscope) Scan(paths []string, options *ScanOptions) herror.Interface
 		}
 		absPaths[i] = abs
 	}
-	dupes, done := ps.findDuplicates(absPaths, options)
+	filter, err := ps.newScanFilter(options)
+	if err != nil {
+		return err
+	}
+	limiter, err := ps.newIOLimiter(options.DeviceThreads)
+	if err != nil {
+		return err
+	}
+	if err := ps.setHashAlgorithm(options.Hash); err != nil {
+		return err
+	}
+	// record the scan, so it can be resumed if it's interrupted
+	state, jsonErr := json.Marshal(scanState{
+		Paths:         absPaths,
+		Minimum:       options.Minimum,
+		Maximum:       options.Maximum,
+		Exclude:       options.Exclude,
+		Include:       options.Include,
+		Paranoid:      options.Paranoid,
+		DeviceThreads: options.DeviceThreads,
+		Archives:      options.Archives,
+		SimilarImages: options.SimilarImages,
+		ImagePixels:   options.ImagePixels,
+		NormalizeText: options.NormalizeText,
+		Fuzzy:         options.Fuzzy,
+		Decompress:    options.Decompress,
+	})
+	if jsonErr != nil {
+		return herror.Internal(jsonErr, "")
+	}
+	if err := ps.db.SetMeta(scanStateKey, string(state)); err != nil {
+		return err
+	}
+	// every file found by the scan is re-added to the database, so anything
+	// in the scanned paths from this generation or earlier is stale once
+	// the scan completes
+	generation, err := ps.db.Generation()
+	if err != nil {
+		return err
+	}
+	dupes, done, err := ps.findDuplicates(absPaths, options, filter, limiter, generation)
+	if err != nil {
+		return err
+	}
+	// results are committed in batches, so that if the scan is interrupted,
+	// the work that's been done so far isn't lost: a resumed scan (or any
+	// later scan of the same paths) reuses the hashes of unchanged files
 	tx, err := ps.db.Begin()
 	if err != nil {
 		return err
 	}
-	// remove previously scanned files in paths we are now searching
-	for _, path := range absPaths {
-		err := tx.RemoveDir(path, options.Minimum, options.Maximum)
+	pending := 0
+	lastCheckpoint := time.Now()
+	mismatches := 0
+	for result := range dupes {
+		if mismatch, ok := result.(contentMismatch); ok {
+			fmt.Fprintf(ps.errStream, "WARNING: '%s' and '%s' have the same hash but different contents; either this is a hash collision, or a file changed during the scan\n", mismatch.path, mismatch.other)
+			mismatches++
+			continue
+		}
+		err := tx.Add(result.(db.FileInfo))
 		if err != nil {
 			tx.Rollback()
 			return err
 		}
+		pending++
+		if pending >= checkpointSize || time.Since(lastCheckpoint) >= checkpointInterval {
+			if err := tx.Commit(); err != nil {
+				return err
+			}
+			tx, err = ps.db.Begin()
+			if err != nil {
+				return err
+			}
+			pending = 0
+			lastCheckpoint = time.Now()
+		}
 	}
-	// add all the new things we've found
-	for info := range dupes {
-		err := tx.Add(info.(db.FileInfo))
+	// remove previously scanned files in paths we are now searching that
+	// weren't found again
+	for _, path := range absPaths {
+		err := tx.RemoveDirOlder(path, options.Minimum, options.Maximum, generation)
 		if err != nil {
 			tx.Rollback()
 			return err
 		}
+		if err := tx.RemoveArchivesOlder(path, generation); err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
 	// create indexes if they don't exist already
 	err = tx.CreateIndexes()
@@ -54,14 +194,49 @@ func (ps *Periscope) Scan(paths []string, options *ScanOptions) herror.Interface
 		tx.Rollback()
 		return err
 	}
+	if err = tx.DeleteMeta(scanStateKey); err != nil {
+		tx.Rollback()
+		return err
+	}
 	if err = tx.Commit(); err != nil {
 		return err
 	}
 	done()
+	if mismatches > 0 {
+		return herror.Silent()
+	}
 	return nil
 }
 
-// we use this to avoid database writes; findFilesBySize finds files in the
+func (ps *Periscope) interruptedScan() ([]string, *ScanOptions, herror.Interface) {
+	value, ok, err := ps.db.Meta(scanStateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, herror.User(nil, "no interrupted scan to resume")
+	}
+	var state scanState
+	if err := json.Unmarshal([]byte(value), &state); err != nil {
+		return nil, nil, herror.Internal(err, "")
+	}
+	return state.Paths, &ScanOptions{
+		Minimum:       state.Minimum,
+		Maximum:       state.Maximum,
+		Exclude:       state.Exclude,
+		Include:       state.Include,
+		Paranoid:      state.Paranoid,
+		DeviceThreads: state.DeviceThreads,
+		Archives:      state.Archives,
+		SimilarImages: state.SimilarImages,
+		ImagePixels:   state.ImagePixels,
+		NormalizeText: state.NormalizeText,
+		Fuzzy:         state.Fuzzy,
+		Decompress:    state.Decompress,
+	}, nil
+}
+
+// we use this to avoid database writes; spoolFiles finds files in the
 // directory to be scanned, and it also looks up relevant files to consider
 // from the database; we want to add newly scanned files to the database
 // regardless, but for infos that were already there, we only want to write to
@@ -72,75 +247,491 @@ type searchResult struct {
 	old  bool
 }
 
-// return value also includes the relevant stuff in the DB
+// reported by a paranoid scan when files with equal hashes differ
+type contentMismatch struct {
+	path  string
+	other string
+}
+
+// all the search results for a particular size
+type sizeBucket struct {
+	size    int64
+	results []searchResult
+	// the decompressed contents of compressed files that have this size,
+	// from SpoolDecompressed entries
+	decompressed []db.FileInfo
+}
+
+// writes the files to scan to a spool, along with the relevant stuff in the DB
+//
+// the number of files can be arbitrarily large, so they're not kept in memory;
+// the spool lets us process the files one size at a time
 //
 // we do this here so that there are no db reads in the rest of findDuplicates,
 // so we can do a streaming write into the db without concurrent reads
-func (ps *Periscope) findFilesBySize(paths []string, options *ScanOptions) (map[int64][]searchResult, int) {
-	sizeToInfos := make(map[int64][]searchResult)
+//
+// files under paths that are already in the database are rescanned; their
+// previous infos are also spooled, so if their stat metadata hasn't changed
+// since they were hashed, the known hashes can be carried over and we don't
+// need to read the files again
+//
+// archives whose members are hashed are recorded as part of the given
+// generation
+//
+// also returns the set of devices that the files are on
+func (ps *Periscope) spoolFiles(paths []string, options *ScanOptions, filter *scanFilter, limiter *ioLimiter, generation int64) (*db.Spool, int, map[int64]struct{}, herror.Interface) {
+	spool, herr := db.NewSpool()
+	if herr != nil {
+		return nil, 0, nil, herr
+	}
 	files := 0
+	devices := make(map[int64]struct{})
 
 	bar := ps.progressBar(0, `searching: {{ counters . }} files {{ etime . }} `)
 
 	for _, root := range paths {
+		filter.start(root)
 		err := afero.Walk(ps.fs, root, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				log.Printf("%s", err)
 				return nil
 			}
+			if info.IsDir() && ps.isTrash(path) {
+				return filepath.SkipDir
+			}
+			if filter.skip(path, info) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
 			if !info.Mode().IsRegular() {
 				return nil
 			}
 			size := info.Size()
+			newInfo := db.FileInfo{
+				Path:      path,
+				Size:      size,
+				ShortHash: nil,
+				FullHash:  nil,
+			}
+			setStatMetadata(&newInfo, info)
+			if options.Archives && archiveFormatOf(path) != notArchive {
+				if herr = spool.AddPending(newInfo, db.PendingArchive); herr != nil {
+					return herr
+				}
+			}
 			if size > options.Minimum && (options.Maximum == 0 || size <= options.Maximum) {
-				if len(sizeToInfos[size]) == 0 {
-					// find all relevant files from the database, skipping the
-					// ones that are included in paths; we only do this once per
-					// size (after we've seen a particular size, the [size] key
-					// will contain at least one element, so we won't re-do this
-					if known, err := ps.db.InfosBySize(size); err == nil {
-						for _, k := range known {
-							if !containedInAny(k.Path, paths) {
-								sizeToInfos[size] = append(sizeToInfos[size], searchResult{info: k, old: true})
-								files++
-							}
-						}
+				if options.Decompress && compressionOf(path) != notCompressed {
+					// spooled once it's decompressed
+					if herr = spool.AddPending(newInfo, db.PendingCompressed); herr != nil {
+						return herr
 					}
+					files++
+					devices[newInfo.Device] = struct{}{}
+					bar.Increment()
+					return nil
+				}
+				if herr = spool.Add(newInfo, db.SpoolFound); herr != nil {
+					return herr
 				}
-				sizeToInfos[size] = append(sizeToInfos[size], searchResult{
-					info: db.FileInfo{
-						Path:      path,
-						Size:      size,
-						ShortHash: nil,
-						FullHash:  nil,
-					},
-					old: false,
-				})
 				files++
+				devices[newInfo.Device] = struct{}{}
 				bar.Increment()
 			}
 			return nil
 		})
+		if herr != nil {
+			bar.Finish()
+			spool.Close()
+			return nil, 0, nil, herr
+		}
 		if err != nil {
 			log.Printf("Walk() returned error: %s", err)
 		}
 	}
 	bar.Finish()
-	return sizeToInfos, files
+
+	// archive members are hashed up front, because they can only be read
+	// sequentially
+	if herr = ps.spoolArchives(spool, options, limiter, devices, generation, &files); herr != nil {
+		spool.Close()
+		return nil, 0, nil, herr
+	}
+
+	// compressed files are decompressed up front too, because the size of
+	// the decompressed contents determines which files they're compared to
+	if herr = ps.spoolDecompressed(spool, limiter, devices); herr != nil {
+		spool.Close()
+		return nil, 0, nil, herr
+	}
+
+	// find all relevant files from the database, for every size we've
+	// found; the ones that are included in paths are only used for their
+	// hashes
+	size := int64(-1)
+	for {
+		var ok bool
+		size, ok, herr = spool.NextSize(size)
+		if herr != nil {
+			break
+		}
+		if !ok {
+			break
+		}
+		var known []db.FileInfo
+		known, herr = ps.db.InfosBySize(size)
+		if herr != nil {
+			break
+		}
+		for _, k := range known {
+			if !containedInAny(k.Path, paths) {
+				herr = spool.Add(k, db.SpoolKnown)
+				files++
+				devices[k.Device] = struct{}{}
+			} else {
+				herr = spool.Add(k, db.SpoolPrevious)
+			}
+			if herr != nil {
+				break
+			}
+		}
+		if herr != nil {
+			break
+		}
+		// compressed files elsewhere may be compressed copies of files
+		// with this size
+		known, herr = ps.db.InfosByDecompressedSize(size)
+		if herr != nil {
+			break
+		}
+		for _, k := range known {
+			if !containedInAny(k.Path, paths) {
+				herr = spool.Add(decompressedEntry(k), db.SpoolDecompressed)
+				if herr != nil {
+					break
+				}
+			}
+		}
+		if herr != nil {
+			break
+		}
+	}
+	if herr != nil {
+		spool.Close()
+		return nil, 0, nil, herr
+	}
+	return spool, files, devices, nil
+}
+
+// pending files are read from the spool in batches of this size, so that
+// they're never all in memory at once
+const pendingBatchSize = 1000
+
+// calls process with every pending file of the given kind, one batch at a time
+func forEachPending(spool *db.Spool, kind db.PendingKind, process func(batch []db.FileInfo) herror.Interface) herror.Interface {
+	after := int64(0)
+	for {
+		entries, herr := spool.PendingAfter(kind, after, pendingBatchSize)
+		if herr != nil {
+			return herr
+		}
+		if len(entries) == 0 {
+			return nil
+		}
+		after = entries[len(entries)-1].ID
+		batch := make([]db.FileInfo, len(entries))
+		for i, entry := range entries {
+			batch[i] = entry.Info
+		}
+		if herr := process(batch); herr != nil {
+			return herr
+		}
+	}
+}
+
+// hashes the members of pending archives and adds them to the spool
+//
+// an archive is only read if it has changed since its members were last
+// hashed (or if they were hashed for a different size range); otherwise, its
+// members are carried over from the database
+func (ps *Periscope) spoolArchives(spool *db.Spool, options *ScanOptions, limiter *ioLimiter, devices map[int64]struct{}, generation int64, files *int) herror.Interface {
+	count, herr := spool.PendingCount(db.PendingArchive)
+	if herr != nil || count == 0 {
+		return herr
+	}
+	bar := ps.progressBar(count, `archives: {{ counters . }} {{ bar . "[" "=" ">" " " "]" }} {{ etime . }} {{ rtime . "ETA %s" "%.0s" " " }} `)
+	defer bar.Finish()
+	add := func(info db.FileInfo) herror.Interface {
+		*files++
+		devices[info.Device] = struct{}{}
+		return spool.Add(info, db.SpoolFound)
+	}
+	return forEachPending(spool, db.PendingArchive, func(archives []db.FileInfo) herror.Interface {
+		var todo []db.FileInfo
+		for _, archive := range archives {
+			members, herr := ps.unchangedArchiveMembers(&archive, options)
+			if herr != nil {
+				return herr
+			}
+			if members == nil {
+				todo = append(todo, archive)
+				continue
+			}
+			for _, info := range members {
+				if herr := add(info); herr != nil {
+					return herr
+				}
+			}
+			if herr := ps.db.AddArchive(archiveInfo(&archive, options, len(members)), generation); herr != nil {
+				return herr
+			}
+			bar.Increment()
+		}
+		var herr herror.Interface
+		for result := range par.MapN(todo, limiter.total(devices), func(_, v interface{}, emit func(x interface{})) {
+			archive := v.(db.FileInfo)
+			release := limiter.acquire(archive.Device)
+			members := 0
+			err := ps.hashArchive(&archive, options, func(info db.FileInfo) {
+				members++
+				emit(info)
+			})
+			release()
+			if err != nil {
+				log.Printf("unable to read archive '%s': %s", archive.Path, err)
+			} else {
+				emit(archiveInfo(&archive, options, members))
+			}
+			bar.Increment()
+		}) {
+			if herr != nil {
+				continue
+			}
+			switch result := result.(type) {
+			case db.FileInfo:
+				herr = add(result)
+			case db.ArchiveInfo:
+				herr = ps.db.AddArchive(result, generation)
+			}
+		}
+		return herr
+	})
+}
+
+// the record of an archive whose members were hashed
+func archiveInfo(archive *db.FileInfo, options *ScanOptions, members int) db.ArchiveInfo {
+	return db.ArchiveInfo{
+		Path:    archive.Path,
+		Size:    archive.Size,
+		Mtime:   archive.Mtime,
+		Ctime:   archive.Ctime,
+		Inode:   archive.Inode,
+		Device:  archive.Device,
+		Minimum: options.Minimum,
+		Maximum: options.Maximum,
+		Members: int64(members),
+	}
+}
+
+// returns the members of an archive from the database, with their hashes, if
+// the archive hasn't changed since they were hashed; returns nil if the
+// archive needs to be read again
+//
+// members get the archive's stat metadata, so members left over from an
+// earlier version of the archive (by an interrupted scan) are told apart by
+// their metadata, and all of them must be accounted for
+func (ps *Periscope) unchangedArchiveMembers(archive *db.FileInfo, options *ScanOptions) ([]db.FileInfo, herror.Interface) {
+	recorded, ok, herr := ps.db.LookupArchive(archive.Path)
+	if herr != nil || !ok {
+		return nil, herr
+	}
+	if recorded.Size != archive.Size || recorded.Mtime != archive.Mtime || recorded.Ctime != archive.Ctime ||
+		recorded.Inode != archive.Inode || recorded.Device != archive.Device ||
+		recorded.Minimum != options.Minimum || recorded.Maximum != options.Maximum {
+		return nil, nil
+	}
+	infos, herr := ps.db.InfosUnder(archiveMembersDir(archive.Path))
+	if herr != nil {
+		return nil, herr
+	}
+	// an archive without members in range still has a (non-nil) empty
+	// list of members
+	members := []db.FileInfo{}
+	for _, info := range infos {
+		if !(info.Size > options.Minimum && (options.Maximum == 0 || info.Size <= options.Maximum)) {
+			continue
+		}
+		if info.Mtime != archive.Mtime || info.Ctime != archive.Ctime || info.Device != archive.Device {
+			return nil, nil
+		}
+		members = append(members, info)
+	}
+	if int64(len(members)) != recorded.Members {
+		return nil, nil
+	}
+	return members, nil
+}
+
+// decompresses pending compressed files and adds them to the spool, along
+// with their decompressed contents
+//
+// a compressed file is only decompressed if it has changed since it was last
+// decompressed
+func (ps *Periscope) spoolDecompressed(spool *db.Spool, limiter *ioLimiter, devices map[int64]struct{}) herror.Interface {
+	count, herr := spool.PendingCount(db.PendingCompressed)
+	if herr != nil || count == 0 {
+		return herr
+	}
+	bar := ps.progressBar(count, `decompressing: {{ counters . }} {{ bar . "[" "=" ">" " " "]" }} {{ etime . }} {{ rtime . "ETA %s" "%.0s" " " }} `)
+	defer bar.Finish()
+	return forEachPending(spool, db.PendingCompressed, func(compressed []db.FileInfo) herror.Interface {
+		var todo []db.FileInfo
+		for _, info := range compressed {
+			set, herr := ps.db.Lookup(info.Path)
+			if herr != nil {
+				return herr
+			}
+			if len(set) > 0 && set[0].DecompressedHash != nil && sameStatMetadata(&set[0], &info) {
+				info.DecompressedHash = set[0].DecompressedHash
+				info.DecompressedSize = set[0].DecompressedSize
+				if herr := spoolCompressed(spool, info); herr != nil {
+					return herr
+				}
+				bar.Increment()
+				continue
+			}
+			todo = append(todo, info)
+		}
+		var herr herror.Interface
+		for result := range par.MapN(todo, limiter.total(devices), func(_, v interface{}, emit func(x interface{})) {
+			info := v.(db.FileInfo)
+			release := limiter.acquire(info.Device)
+			hash, size, err := ps.hashDecompressed(info.Path)
+			release()
+			if err != nil {
+				log.Printf("unable to decompress '%s': %s", info.Path, err)
+			} else if size > 0 {
+				info.DecompressedHash = hash
+				info.DecompressedSize = size
+			}
+			bar.Increment()
+			emit(info)
+		}) {
+			if herr == nil {
+				herr = spoolCompressed(spool, result.(db.FileInfo))
+			}
+		}
+		return herr
+	})
+}
+
+func spoolCompressed(spool *db.Spool, info db.FileInfo) herror.Interface {
+	if herr := spool.Add(info, db.SpoolFound); herr != nil {
+		return herr
+	}
+	if info.DecompressedHash == nil {
+		return nil
+	}
+	return spool.Add(decompressedEntry(info), db.SpoolDecompressed)
+}
+
+// the spool entry for the decompressed contents of a compressed file
+func decompressedEntry(info db.FileInfo) db.FileInfo {
+	return db.FileInfo{
+		Path:     info.Path,
+		Size:     info.DecompressedSize,
+		FullHash: info.DecompressedHash,
+		Device:   info.Device,
+	}
+}
+
+// reads size buckets from the spool, smallest size first
+//
+// at most a handful of buckets are in memory at any time; the spool is closed
+// once all buckets have been read
+func readBuckets(spool *db.Spool) <-chan sizeBucket {
+	buckets := make(chan sizeBucket)
+	go func() {
+		defer close(buckets)
+		defer spool.Close()
+		size := int64(-1)
+		for {
+			var ok bool
+			var err herror.Interface
+			size, ok, err = spool.NextSize(size)
+			if err != nil {
+				log.Printf("NextSize() returned error: %s", err)
+				return
+			}
+			if !ok {
+				return
+			}
+			entries, err := spool.EntriesBySize(size)
+			if err != nil {
+				log.Printf("EntriesBySize() returned error: %s", err)
+				return
+			}
+			// reuse the hashes of files that were already in the
+			// database, if they haven't changed
+			previous := make(map[string]db.FileInfo)
+			for _, entry := range entries {
+				if entry.Kind == db.SpoolPrevious {
+					previous[entry.Info.Path] = entry.Info
+				}
+			}
+			bucket := sizeBucket{size: size}
+			for _, entry := range entries {
+				switch entry.Kind {
+				case db.SpoolFound:
+					info := entry.Info
+					if prev, ok := previous[info.Path]; ok && sameStatMetadata(&prev, &info) {
+						info.ShortHash = prev.ShortHash
+						info.SampleHash = prev.SampleHash
+						info.FullHash = prev.FullHash
+						info.ImageHash = prev.ImageHash
+						info.PixelHash = prev.PixelHash
+						info.TextHash = prev.TextHash
+						info.FuzzyHash = prev.FuzzyHash
+						if info.DecompressedHash == nil {
+							// compressed files that were
+							// decompressed by this scan
+							// already have these
+							info.DecompressedHash = prev.DecompressedHash
+							info.DecompressedSize = prev.DecompressedSize
+						}
+					}
+					bucket.results = append(bucket.results, searchResult{info: info, old: false})
+				case db.SpoolKnown:
+					bucket.results = append(bucket.results, searchResult{info: entry.Info, old: true})
+				case db.SpoolDecompressed:
+					bucket.decompressed = append(bucket.decompressed, entry.Info)
+				}
+			}
+			buckets <- bucket
+		}
+	}()
+	return buckets
 }
 
 // paths consists of absolute paths with no symlinks
-func (ps *Periscope) findDuplicates(searchPaths []string, options *ScanOptions) (<-chan interface{}, func()) {
-	sizeToInfos, files := ps.findFilesBySize(searchPaths, options)
+func (ps *Periscope) findDuplicates(searchPaths []string, options *ScanOptions, filter *scanFilter, limiter *ioLimiter, generation int64) (<-chan interface{}, func(), herror.Interface) {
+	spool, files, devices, err := ps.spoolFiles(searchPaths, options, filter, limiter, generation)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	bar := ps.progressBar(files, `analyzing: {{ counters . }} {{ bar . "[" "=" ">" " " "]" }} {{ etime . }} {{ rtime . "ETA %s" "%.0s" " " }} `)
 	done := func() {
 		bar.Finish()
 	}
 
-	return par.MapN(sizeToInfos, scanThreads, func(k, v interface{}, emit func(x interface{})) {
-		size := k.(int64)
-		searchResults := v.([]searchResult)
+	// there are enough workers to keep every device busy, but reads are
+	// limited per device
+	return par.MapN(readBuckets(spool), limiter.total(devices), func(k, v interface{}, emit func(x interface{})) {
+		bucket := v.(sizeBucket)
+		size := bucket.size
+		searchResults := bucket.results
 
 		// files may appear multiple times, if the same directory is repeated in the
 		// arguments to scan; skip those
@@ -164,8 +755,161 @@ func (ps *Periscope) findDuplicates(searchPaths []string, options *ScanOptions)
 			}
 		}
 
-		// if there's only one file with this size, we don't need to do any hashing
-		if len(infos) == 1 {
+		// hardlinks to the same file only need to be hashed once; links
+		// always have the same size, so they're all in this bucket
+		links := make(map[[2]int64][]int) // (device, inode) -> indices into infos array
+		for i := range infos {
+			if infos[i].Inode != 0 {
+				key := [2]int64{infos[i].Device, infos[i].Inode}
+				links[key] = append(links[key], i)
+			}
+		}
+		linkedHash := func(i int, hash func(info *db.FileInfo) []byte) []byte {
+			if infos[i].Inode == 0 {
+				return nil
+			}
+			for _, j := range links[[2]int64{infos[i].Device, infos[i].Inode}] {
+				if h := hash(&infos[j]); h != nil {
+					return h
+				}
+			}
+			return nil
+		}
+
+		// of the given files, returns the first one of each file and the
+		// others, which are hardlinks to one of the first ones
+		firstLinks := func(indices []int) (first, others []int) {
+			reading := make(map[[2]int64]struct{})
+			for _, i := range indices {
+				if infos[i].Inode != 0 {
+					key := [2]int64{infos[i].Device, infos[i].Inode}
+					if _, ok := reading[key]; ok {
+						others = append(others, i)
+						continue
+					}
+					reading[key] = struct{}{}
+				}
+				first = append(first, i)
+			}
+			return first, others
+		}
+		// fills in a hash for each of the given files that doesn't have
+		// it yet, and returns the files that have it afterwards
+		fill := func(indices []int, field func(info *db.FileInfo) *[]byte, hash func(path string) ([]byte, error), name string) []int {
+			get := func(info *db.FileInfo) []byte { return *field(info) }
+			var todo []int
+			for _, i := range indices {
+				if get(&infos[i]) != nil {
+					continue
+				}
+				if h := linkedHash(i, get); h != nil {
+					*field(&infos[i]) = h
+					updated[i] = true
+					continue
+				}
+				todo = append(todo, i)
+			}
+			first, others := firstLinks(todo)
+			ps.readFiles(infos, first, limiter, func(info *db.FileInfo) func(info *db.FileInfo) {
+				h, err := hash(info.Path)
+				if err != nil {
+					log.Printf("%s() returned error: %s", name, err)
+					return nil
+				}
+				return func(info *db.FileInfo) { *field(info) = h }
+			})
+			for _, i := range others {
+				*field(&infos[i]) = linkedHash(i, get)
+			}
+			for _, i := range todo {
+				if get(&infos[i]) != nil {
+					updated[i] = true
+				}
+			}
+			var have []int
+			for _, i := range indices {
+				if get(&infos[i]) != nil {
+					have = append(have, i)
+				}
+			}
+			return have
+		}
+
+		// images need their image hashes regardless of their size; only
+		// files found by this scan (the ones marked as updated so far)
+		// are hashed, not ones outside the scanned paths
+		if options.SimilarImages || options.ImagePixels {
+			var todo []int
+			for i := range infos {
+				info := &infos[i]
+				if !updated[i] || !isImage(info.Path) {
+					continue
+				}
+				if info.ImageHash == nil {
+					info.ImageHash = linkedHash(i, func(info *db.FileInfo) []byte { return info.ImageHash })
+				}
+				if info.PixelHash == nil {
+					info.PixelHash = linkedHash(i, func(info *db.FileInfo) []byte { return info.PixelHash })
+				}
+				if (options.SimilarImages && info.ImageHash == nil) || (options.ImagePixels && info.PixelHash == nil) {
+					todo = append(todo, i)
+				}
+			}
+			first, others := firstLinks(todo)
+			ps.readFiles(infos, first, limiter, func(info *db.FileInfo) func(info *db.FileInfo) {
+				perceptual := options.SimilarImages && info.ImageHash == nil
+				pixels := options.ImagePixels && info.PixelHash == nil
+				imageHash, pixelHash, err := ps.hashImage(info.Path, perceptual, pixels)
+				if err != nil {
+					log.Printf("hashImage() returned error: %s", err)
+					return nil
+				}
+				return func(info *db.FileInfo) {
+					if perceptual {
+						info.ImageHash = imageHash
+					}
+					if pixels {
+						info.PixelHash = pixelHash
+					}
+				}
+			})
+			for _, i := range others {
+				info := &infos[i]
+				if info.ImageHash == nil {
+					info.ImageHash = linkedHash(i, func(info *db.FileInfo) []byte { return info.ImageHash })
+				}
+				if info.PixelHash == nil {
+					info.PixelHash = linkedHash(i, func(info *db.FileInfo) []byte { return info.PixelHash })
+				}
+			}
+		}
+
+		// likewise, text files need their normalized hashes regardless
+		// of their size, and files need their similarity digests
+		// regardless of their size, other than empty files, which aren't
+		// similar to anything
+		if options.NormalizeText || options.Fuzzy {
+			var found []int
+			for i := range infos {
+				if updated[i] {
+					found = append(found, i)
+				}
+			}
+			if options.NormalizeText {
+				fill(found, func(info *db.FileInfo) *[]byte { return &info.TextHash }, ps.hashText, "hashText")
+			}
+			if options.Fuzzy && size > 0 {
+				fill(found, func(info *db.FileInfo) *[]byte { return &info.FuzzyHash }, ps.hashFuzzy, "hashFuzzy")
+			}
+		}
+
+		if len(infos) == 0 {
+			return
+		}
+
+		// if there's only one file with this size, we don't need to do any more hashing,
+		// unless it may be an uncompressed copy of a compressed file
+		if len(infos) == 1 && len(bucket.decompressed) == 0 {
 			// the following check should always be true
 			if updated[0] {
 				emit(infos[0])
@@ -174,51 +918,76 @@ func (ps *Periscope) findDuplicates(searchPaths []string, options *ScanOptions)
 			return
 		}
 
+		all := make([]int, len(infos))
+		for i := range infos {
+			all[i] = i
+		}
+
 		// compute short hashes for all files (skipping the ones where
 		// we already have short hashes), bucketing results by short hash
 		szBuf := make([]byte, 8)
 		binary.LittleEndian.PutUint64(szBuf, uint64(size))
 		byShortHash := make(map[[ShortHashSize]byte][]int) // indices into infos array
-		for i := range infos {
-			info := &infos[i]
-			// compute short hash if necessary
-			if info.ShortHash == nil {
-				// key by size to have unique short hashes, so we can use them as global identifiers
-				hash, err := ps.hashPartial(info.Path, szBuf)
-				if err != nil {
-					log.Printf("hashPartial() returned error: %s", err)
-					bar.Add(1) // ignored; no more work to do for this file
-					continue   // ignore this file
-				}
-				info.ShortHash = hash
-				updated[i] = true
-			}
-			hashArr := shortHashToArray(info.ShortHash)
+		// key by size to have unique short hashes, so we can use them as global identifiers
+		hashed := fill(all, func(info *db.FileInfo) *[]byte { return &info.ShortHash }, func(path string) ([]byte, error) {
+			return ps.hashPartial(path, szBuf)
+		}, "hashPartial")
+		bar.Add(len(all) - len(hashed)) // ignored; no more work to do for these
+		for _, i := range hashed {
+			hashArr := shortHashToArray(infos[i].ShortHash)
 			byShortHash[hashArr] = append(byShortHash[hashArr], i)
 		}
 
-		// wherever there is > 1 file in a bucket, compute the full
-		// hashes (skipping the ones where we already have full hashes)
+		// wherever there is > 1 file in a bucket, compute sample hashes of
+		// large files (skipping the ones where we already have sample
+		// hashes), bucketing results by sample hash
+		var collisions [][]int
 		for _, indices := range byShortHash {
 			if len(indices) <= 1 {
 				// no need to compute full hash
 				bar.Add(len(indices)) // no more work to do for these
 				continue
 			}
-			// collide on short hash; hash full file
-			for _, index := range indices {
-				info := &infos[index]
-				if info.FullHash == nil {
-					hash, err := ps.hashFile(info.Path)
-					if err != nil {
-						log.Printf("hashFile() returned error: %s", err)
-						bar.Add(1) // ignored; no more work to do for this file
-						continue   // ignore this file
-					}
-					info.FullHash = hash
-					updated[index] = true
+			if !needsSampleHash(size) {
+				collisions = append(collisions, indices)
+				continue
+			}
+			bySampleHash := make(map[[ShortHashSize]byte][]int) // indices into infos array
+			hashed := fill(indices, func(info *db.FileInfo) *[]byte { return &info.SampleHash }, func(path string) ([]byte, error) {
+				return ps.hashSamples(path, size, szBuf)
+			}, "hashSamples")
+			bar.Add(len(indices) - len(hashed)) // ignored; no more work to do for these
+			for _, index := range hashed {
+				hashArr := shortHashToArray(infos[index].SampleHash)
+				bySampleHash[hashArr] = append(bySampleHash[hashArr], index)
+			}
+			for _, sampled := range bySampleHash {
+				if len(sampled) <= 1 {
+					bar.Add(len(sampled)) // no more work to do for these
+					continue
 				}
-				bar.Add(1)
+				collisions = append(collisions, sampled)
+			}
+		}
+
+		// wherever there is still > 1 file in a bucket, compute the full
+		// hashes (skipping the ones where we already have full hashes)
+		var colliding []int
+		for _, indices := range collisions {
+			colliding = append(colliding, indices...)
+		}
+		fill(colliding, func(info *db.FileInfo) *[]byte { return &info.FullHash }, ps.hashFile, "hashFile")
+		bar.Add(len(colliding))
+
+		// files that may be uncompressed copies of compressed files need
+		// full hashes, to compare against the decompressed contents
+		if len(bucket.decompressed) > 0 {
+			fill(all, func(info *db.FileInfo) *[]byte { return &info.FullHash }, ps.hashFile, "hashFile")
+		}
+
+		if options.Paranoid {
+			for _, indices := range collisions {
+				ps.verifyContents(infos, indices, updated, limiter, emit)
 			}
 		}
 
@@ -229,5 +998,106 @@ func (ps *Periscope) findDuplicates(searchPaths []string, options *ScanOptions)
 				emit(info)
 			}
 		}
-	}), done
+	}), done, nil
+}
+
+// the results of reading one of the files in a bucket
+type fileRead struct {
+	index int
+	store func(info *db.FileInfo)
+}
+
+// reads the files at the given indices concurrently, holding a read slot on
+// each file's device while it's read, so that even the files in a single
+// bucket are read with as much concurrency as their devices allow
+//
+// read must not modify the info it's given; instead, it returns a function
+// (or nil) that stores its results, which is called from the caller's
+// goroutine
+func (ps *Periscope) readFiles(infos []db.FileInfo, indices []int, limiter *ioLimiter, read func(info *db.FileInfo) func(info *db.FileInfo)) {
+	if len(indices) == 0 {
+		return
+	}
+	devices := make(map[int64]struct{})
+	for _, i := range indices {
+		devices[infos[i].Device] = struct{}{}
+	}
+	for result := range par.MapN(indices, min(len(indices), limiter.total(devices)), func(_, v interface{}, emit func(x interface{})) {
+		i := v.(int)
+		release := limiter.acquire(infos[i].Device)
+		store := read(&infos[i])
+		release()
+		emit(fileRead{index: i, store: store})
+	}) {
+		r := result.(fileRead)
+		if r.store != nil {
+			r.store(&infos[r.index])
+		}
+	}
+}
+
+// compares files with equal full hashes byte-for-byte, partitioning them into
+// classes of files with the same contents; the largest class (the first one,
+// if there's a tie) keeps the full hash, while files in the other classes lose
+// theirs, so they aren't treated as duplicates, and the mismatch is emitted
+func (ps *Periscope) verifyContents(infos []db.FileInfo, indices []int, updated []bool, limiter *ioLimiter, emit func(x interface{})) {
+	byFullHash := make(map[string][]int)
+	for _, index := range indices {
+		if infos[index].FullHash != nil {
+			hash := string(infos[index].FullHash)
+			byFullHash[hash] = append(byFullHash[hash], index)
+		}
+	}
+	for _, same := range byFullHash {
+		// each class is a list of indices, the first of which is the
+		// class's representative
+		var classes [][]int
+		for _, index := range same {
+			info := &infos[index]
+			found := false
+			for c, class := range classes {
+				ref := &infos[class[0]]
+				if !info.SameFile(ref) {
+					release := limiter.acquire(ref.Device, info.Device)
+					equal, err := ps.sameContents(ref.Path, info.Path)
+					release()
+					if err != nil {
+						log.Printf("sameContents() returned error: %s", err)
+						// nothing is known about the file,
+						// so it's left alone
+						found = true
+						break
+					}
+					if !equal {
+						continue
+					}
+				}
+				// hardlinks are trivially identical
+				classes[c] = append(class, index)
+				found = true
+				break
+			}
+			if !found {
+				classes = append(classes, []int{index})
+			}
+		}
+		largest := 0
+		for c, class := range classes {
+			if len(class) > len(classes[largest]) {
+				largest = c
+			}
+		}
+		ref := &infos[classes[largest][0]]
+		for c, class := range classes {
+			if c == largest {
+				continue
+			}
+			for _, index := range class {
+				info := &infos[index]
+				info.FullHash = nil
+				updated[index] = true
+				emit(contentMismatch{path: info.Path, other: ref.Path})
+			}
+		}
+	}
 }
@@ -7,7 +7,9 @@ import (
 	"bytes"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/spf13/afero"
 )
@@ -606,3 +608,575 @@ func TestScanIncrementalRepeatNoticeChange(t *testing.T) {
 		t.Fatalf("expected 3 duplicates in the set, got %d", len(got[0]))
 	}
 }
+
+func TestScanReuseHashes(t *testing.T) {
+	fs := testfs.Read(`
+/a/x [1000 1]
+/a/y [1000 1]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/a"}, &ScanOptions{})
+	got, _ := ps.db.AllDuplicates("")
+	if len(got) != 1 {
+		t.Fatalf("expected 1 duplicate set, got %d", len(got))
+	}
+	// change the contents of "/a/y" without changing its metadata; the
+	// rescan should trust the previously computed hashes
+	info, _ := fs.Stat("/a/y")
+	mtime := info.ModTime()
+	b, _ := afero.ReadFile(fs, "/a/y")
+	b[0] ^= 0xff
+	afero.WriteFile(fs, "/a/y", b, 0o644)
+	fs.Chtimes("/a/y", mtime, mtime)
+	ps.Scan([]string{"/a"}, &ScanOptions{})
+	got, _ = ps.db.AllDuplicates("")
+	if len(got) != 1 {
+		t.Fatalf("expected 1 duplicate set, got %d", len(got))
+	}
+	// once the mtime changes, the file should be re-hashed
+	fs.Chtimes("/a/y", mtime, mtime.Add(time.Second))
+	ps.Scan([]string{"/a"}, &ScanOptions{})
+	got, _ = ps.db.AllDuplicates("")
+	if len(got) != 0 {
+		t.Fatalf("expected no duplicate sets, got %d", len(got))
+	}
+}
+
+func TestScanRecordsStatMetadata(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+	os.WriteFile(filepath.Join(dir, "x"), []byte{'a'}, 0o644)
+	os.WriteFile(filepath.Join(dir, "y"), []byte{'a'}, 0o644)
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{dir}, &ScanOptions{})
+	check(t, err)
+	infos, _ := ps.db.AllInfos()
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 infos, got %d", len(infos))
+	}
+	for _, info := range infos {
+		stat, _ := os.Stat(info.Path)
+		if info.Mtime != stat.ModTime().UnixNano() {
+			t.Fatalf("expected mtime %d, got %d", stat.ModTime().UnixNano(), info.Mtime)
+		}
+		if info.Inode == 0 || info.Ctime == 0 {
+			t.Fatalf("expected inode and ctime to be recorded for '%s'", info.Path)
+		}
+	}
+	if infos[0].Inode == infos[1].Inode {
+		t.Fatalf("expected distinct inodes")
+	}
+}
+
+func TestScanExclude(t *testing.T) {
+	fs := testfs.Read(`
+/a/x [1000 1]
+/a/node_modules/x [1000 1]
+/a/b/node_modules/y [1000 1]
+/a/disk.vmdk [2000 2]
+/b/disk.vmdk [2000 2]
+/c/.git/objects/z [1000 1]
+/c/.git/HEAD [1000 1]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{Exclude: []string{"node_modules", "*.vmdk", "c/.git/objects"}})
+	check(t, err)
+	expected := []db.FileInfo{
+		{Path: "/a/x", Size: 1000, ShortHash: dummyHash, FullHash: dummyHash},
+		{Path: "/c/.git/HEAD", Size: 1000, ShortHash: dummyHash, FullHash: dummyHash},
+	}
+	got, _ := ps.db.AllInfos()
+	checkEquivalentInfos(t, expected, got)
+}
+
+func TestScanInclude(t *testing.T) {
+	fs := testfs.Read(`
+/a/x.jpg [1000 1]
+/a/y.jpg [1000 1]
+/a/z.txt [1000 1]
+/photos/raw [1000 1]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{Include: []string{"*.jpg", "photos"}})
+	check(t, err)
+	expected := []db.FileInfo{
+		{Path: "/a/x.jpg", Size: 1000, ShortHash: dummyHash, FullHash: dummyHash},
+		{Path: "/a/y.jpg", Size: 1000, ShortHash: dummyHash, FullHash: dummyHash},
+		{Path: "/photos/raw", Size: 1000, ShortHash: dummyHash, FullHash: dummyHash},
+	}
+	got, _ := ps.db.AllInfos()
+	checkEquivalentInfos(t, expected, got)
+}
+
+func TestScanExcludeRemovesFromDB(t *testing.T) {
+	fs := testfs.Read(`
+/a/x [1000 1]
+/a/cache/x [1000 1]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/a"}, &ScanOptions{})
+	got, _ := ps.db.AllDuplicates("")
+	if len(got) != 1 {
+		t.Fatalf("expected 1 duplicate set, got %d", len(got))
+	}
+	ps.Scan([]string{"/a"}, &ScanOptions{Exclude: []string{"cache/"}})
+	got, _ = ps.db.AllDuplicates("")
+	if len(got) != 0 {
+		t.Fatalf("expected no duplicate sets, got %d", len(got))
+	}
+}
+
+func TestScanBadPattern(t *testing.T) {
+	fs := testfs.Read(`
+/a/x [1000 1]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{Exclude: []string{"[abc"}})
+	checkErr(t, err)
+}
+
+func TestScanIgnoreFile(t *testing.T) {
+	fs := testfs.Read(`
+/a/x [1000 1]
+/a/y.tmp [1000 1]
+/a/b/keep.tmp [1000 1]
+/a/b/z [1000 1]
+/a/cache/w [1000 1]
+/c/y.tmp [1000 1]
+	`).Mkfs()
+	afero.WriteFile(fs, "/a/"+ignoreFileName, []byte("# comment\n*.tmp\ncache/\n"), 0o644)
+	afero.WriteFile(fs, "/a/b/"+ignoreFileName, []byte("!keep.tmp\nz\n"), 0o644)
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{Minimum: 100})
+	check(t, err)
+	expected := []db.FileInfo{
+		{Path: "/a/b/keep.tmp", Size: 1000, ShortHash: dummyHash, FullHash: dummyHash},
+		{Path: "/a/x", Size: 1000, ShortHash: dummyHash, FullHash: dummyHash},
+		{Path: "/c/y.tmp", Size: 1000, ShortHash: dummyHash, FullHash: dummyHash},
+	}
+	got, _ := ps.db.AllInfos()
+	checkEquivalentInfos(t, expected, got)
+}
+
+type openRecordingFs struct {
+	afero.Fs
+	opened []string
+}
+
+func (fs *openRecordingFs) Open(name string) (afero.File, error) {
+	fs.opened = append(fs.opened, name)
+	return fs.Fs.Open(name)
+}
+
+func TestScanExcludePrunes(t *testing.T) {
+	fs := &openRecordingFs{Fs: testfs.Read(`
+/a/x [1000 1]
+/a/node_modules/x [1000 1]
+/a/node_modules/y/z [1000 1]
+	`).Mkfs()}
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{Exclude: []string{"node_modules"}})
+	check(t, err)
+	for _, name := range fs.opened {
+		if strings.HasPrefix(name, "/a/node_modules") {
+			t.Fatalf("expected excluded directory not to be walked, but opened '%s'", name)
+		}
+	}
+}
+
+func TestScanHardlinks(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := hardlinkDir()
+	defer os.RemoveAll(dir)
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{dir}, &ScanOptions{})
+	check(t, err)
+	got, _ := ps.db.AllDuplicates("")
+	// x and y are hardlinks to a single file, so they aren't duplicates
+	expected := []db.DuplicateSet{{
+		{Path: filepath.Join(dir, "a"), Size: 4, ShortHash: nil, FullHash: nil},
+		{Path: filepath.Join(dir, "b"), Size: 4, ShortHash: nil, FullHash: nil},
+		{Path: filepath.Join(dir, "c"), Size: 4, ShortHash: nil, FullHash: nil},
+	}}
+	checkEquivalentDuplicateSet(t, expected, got)
+	if got[0].Files() != 2 {
+		t.Fatalf("expected 2 distinct files, got %d", got[0].Files())
+	}
+	// but they're still hashed, and known to be hardlinks
+	links, _ := ps.db.Lookup(filepath.Join(dir, "x"))
+	if len(links) != 2 || links[0].FullHash == nil || links.Files() != 1 {
+		t.Fatalf("expected x and y to be recorded as hardlinks, got %+v", links)
+	}
+}
+
+func TestScanHashesHardlinksOnce(t *testing.T) {
+	fs := &openRecordingFs{Fs: afero.NewOsFs()}
+	dir := hardlinkDir()
+	defer os.RemoveAll(dir)
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{dir}, &ScanOptions{})
+	check(t, err)
+	opens := 0
+	for _, name := range fs.opened {
+		if name == filepath.Join(dir, "a") || name == filepath.Join(dir, "b") {
+			opens++
+		}
+	}
+	// once for the short hash, once for the full hash
+	if opens != 2 {
+		t.Fatalf("expected 2 opens of a/b, got %d", opens)
+	}
+}
+
+func TestScanResume(t *testing.T) {
+	fs := testfs.Read(`
+/a/x [1000 1]
+/b/x [1000 1]
+/c/x [1000 1]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	// simulate an interrupted scan of "/a" and "/b"
+	err := ps.db.SetMeta(scanStateKey, `{"paths":["/a","/b"],"minimum":0,"maximum":0}`)
+	check(t, err)
+	err = ps.Scan(nil, &ScanOptions{Resume: true})
+	check(t, err)
+	expected := []db.DuplicateSet{{
+		{Path: "/a/x", Size: 1000, ShortHash: nil, FullHash: nil},
+		{Path: "/b/x", Size: 1000, ShortHash: nil, FullHash: nil},
+	}}
+	got, _ := ps.db.AllDuplicates("")
+	checkEquivalentDuplicateSet(t, expected, got)
+	// once the scan has completed, there is nothing left to resume
+	_, ok, _ := ps.db.Meta(scanStateKey)
+	if ok {
+		t.Fatal("expected scan state to be cleared")
+	}
+	err = ps.Scan(nil, &ScanOptions{Resume: true})
+	checkErr(t, err)
+}
+
+func TestScanResumeKeepsOptions(t *testing.T) {
+	fs := testfs.Read(`
+/a/x [1000 1]
+/a/y [1000 1]
+/a/z.tmp [2000 2]
+/a/w.tmp [2000 2]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	err := ps.db.SetMeta(scanStateKey, `{"paths":["/a"],"minimum":0,"maximum":0,"exclude":["*.tmp"]}`)
+	check(t, err)
+	err = ps.Scan(nil, &ScanOptions{Resume: true})
+	check(t, err)
+	got, _ := ps.db.AllInfos()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 infos, got %d", len(got))
+	}
+}
+
+func TestScanCheckpoints(t *testing.T) {
+	defer func(size int) { checkpointSize = size }(checkpointSize)
+	checkpointSize = 1
+	fs := testfs.Read(`
+/a/x [1000 1]
+/a/y [1000 1]
+/a/z [1000 1]
+/a/w [2000 2]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/a"}, &ScanOptions{})
+	check(t, err)
+	fs.Remove("/a/w")
+	err = ps.Scan([]string{"/a"}, &ScanOptions{})
+	check(t, err)
+	expected := []db.FileInfo{
+		{Path: "/a/x", Size: 1000, ShortHash: dummyHash, FullHash: dummyHash},
+		{Path: "/a/y", Size: 1000, ShortHash: dummyHash, FullHash: dummyHash},
+		{Path: "/a/z", Size: 1000, ShortHash: dummyHash, FullHash: dummyHash},
+	}
+	got, _ := ps.db.AllInfos()
+	checkEquivalentInfos(t, expected, got)
+	_, ok, _ := ps.db.Meta(scanStateKey)
+	if ok {
+		t.Fatal("expected scan state to be cleared")
+	}
+}
+
+func TestScanInterruptedKeepsPreviousResults(t *testing.T) {
+	fs := testfs.Read(`
+/a/x [1000 1]
+/a/y [1000 1]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/a"}, &ScanOptions{})
+	check(t, err)
+	// an interrupted scan doesn't get as far as removing files from the
+	// database; until it's resumed, the results of earlier scans remain
+	filter, _ := ps.newScanFilter(&ScanOptions{})
+	limiter, _ := ps.newIOLimiter(nil)
+	generation, _ := ps.db.Generation()
+	dupes, done, err := ps.findDuplicates([]string{"/a"}, &ScanOptions{}, filter, limiter, generation)
+	check(t, err)
+	for range dupes {
+		break
+	}
+	got, _ := ps.db.AllDuplicates("")
+	if len(got) != 1 {
+		t.Fatalf("expected 1 duplicate set, got %d", len(got))
+	}
+	for range dupes {
+	}
+	done()
+}
+
+func TestScanHashAlgorithm(t *testing.T) {
+	fs := testfs.Read(`
+/a [10000 1]
+/b [10000 1]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{Hash: "xxh3"})
+	check(t, err)
+	name, _, _ := ps.db.Meta(hashAlgorithmKey)
+	if name != "xxh3" {
+		t.Fatalf("expected xxh3 to be recorded, got '%s'", name)
+	}
+	infos, _ := ps.db.Lookup("/a")
+	if len(infos) != 2 || len(infos[0].FullHash) != 16 {
+		t.Fatalf("expected 128-bit hashes, got %v", infos)
+	}
+	// rescanning without specifying an algorithm keeps using the same one
+	loaded, err := loadHashAlgorithm(ps.db)
+	check(t, err)
+	ps.hash = loaded
+	err = ps.Scan([]string{"/"}, &ScanOptions{})
+	check(t, err)
+	if ps.hash.name != "xxh3" {
+		t.Fatalf("expected xxh3, got %s", ps.hash.name)
+	}
+	err = ps.Scan([]string{"/"}, &ScanOptions{Hash: "xxh3"})
+	check(t, err)
+}
+
+func TestScanHashAlgorithmMismatch(t *testing.T) {
+	fs := testfs.Read(`
+/a [10000 1]
+/b [10000 1]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{})
+	check(t, err)
+	err = ps.Scan([]string{"/"}, &ScanOptions{Hash: "sha256"})
+	checkErr(t, err)
+	if ps.hash.name != DefaultHashAlgorithm {
+		t.Fatalf("expected algorithm to be unchanged, got %s", ps.hash.name)
+	}
+}
+
+func TestScanHashAlgorithmRemovedFiles(t *testing.T) {
+	fs := testfs.Read(`
+/a [10000 1]
+/b [10000 1]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{})
+	check(t, err)
+	// the removed file's hash is still in the journal
+	err = ps.Rm([]string{"/b"}, &RmOptions{})
+	check(t, err)
+	err = ps.Forget([]string{"/"}, &ForgetOptions{})
+	check(t, err)
+	err = ps.Scan([]string{"/"}, &ScanOptions{Hash: "sha256"})
+	if err == nil || !strings.Contains(err.Error(), "journal") {
+		t.Fatalf("expected an error about the journal, got %v", err)
+	}
+	err = ps.Undo(&UndoOptions{})
+	check(t, err)
+	err = ps.Forget([]string{"/"}, &ForgetOptions{})
+	check(t, err)
+	check(t, ps.db.AddTrashed(db.TrashedFile{Name: "b", Path: "/b", Size: 10000, FullHash: []byte{1}}))
+	err = ps.Scan([]string{"/"}, &ScanOptions{Hash: "sha256"})
+	if err == nil || !strings.Contains(err.Error(), "trash") {
+		t.Fatalf("expected an error about the trash, got %v", err)
+	}
+	if ps.hash.name != DefaultHashAlgorithm {
+		t.Fatalf("expected algorithm to be unchanged, got %s", ps.hash.name)
+	}
+}
+
+func TestScanUnknownHashAlgorithm(t *testing.T) {
+	fs := testfs.Read(`
+/a [10000 1]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{Hash: "md5"})
+	checkErr(t, err)
+}
+
+// writes two large files that are identical except for the byte at the given
+// offset (if offset is negative, the files are identical)
+func largeFilePair(fs afero.Fs, offset int) {
+	data := make([]byte, sampleThreshold+1000)
+	afero.WriteFile(fs, "/a", data, 0o644)
+	if offset >= 0 {
+		data[offset] = 1
+	}
+	afero.WriteFile(fs, "/b", data, 0o644)
+}
+
+func TestScanSampleHashDifferentEnd(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	largeFilePair(fs, sampleThreshold+999)
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{})
+	check(t, err)
+	infos, _ := ps.db.AllInfos()
+	if len(infos) != 2 {
+		t.Fatalf("expected 2 infos, got %d", len(infos))
+	}
+	for _, info := range infos {
+		if info.ShortHash == nil || info.SampleHash == nil {
+			t.Fatalf("expected short and sample hashes for %s", info.Path)
+		}
+		if info.FullHash != nil {
+			t.Fatalf("expected full hash of %s not to be computed", info.Path)
+		}
+	}
+	if bytes.Equal(infos[0].SampleHash, infos[1].SampleHash) {
+		t.Fatal("expected sample hashes to differ")
+	}
+}
+
+func TestScanSampleHashDifferentMiddle(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	largeFilePair(fs, (sampleThreshold+1000)/2)
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{})
+	check(t, err)
+	infos, _ := ps.db.AllInfos()
+	for _, info := range infos {
+		if info.FullHash != nil {
+			t.Fatalf("expected full hash of %s not to be computed", info.Path)
+		}
+	}
+}
+
+func TestScanSampleHashSame(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	largeFilePair(fs, -1)
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{})
+	check(t, err)
+	got, _ := ps.db.AllDuplicates("")
+	if len(got) != 1 || len(got[0]) != 2 {
+		t.Fatalf("expected a duplicate set of 2 files, got %v", got)
+	}
+}
+
+func TestScanSampleHashUnsampledDifference(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	// differs outside of the header and the samples, so only the full
+	// hash can tell the files apart
+	largeFilePair(fs, 1024*1024)
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{})
+	check(t, err)
+	got, _ := ps.db.AllDuplicates("")
+	if len(got) != 0 {
+		t.Fatalf("expected no duplicates, got %v", got)
+	}
+	infos, _ := ps.db.AllInfos()
+	for _, info := range infos {
+		if info.SampleHash == nil || info.FullHash == nil {
+			t.Fatalf("expected sample and full hashes for %s", info.Path)
+		}
+	}
+}
+
+func TestScanParanoidCollision(t *testing.T) {
+	fs := testfs.Read(`
+/a [10000 1]
+/b [10000 2]
+	`).Mkfs()
+	ps, _, errStream := newTest(fs)
+	ps.hash = collidingHashAlgorithm
+	err := ps.Scan([]string{"/"}, &ScanOptions{Paranoid: true})
+	checkErr(t, err)
+	if !strings.Contains(errStream.String(), "same hash but different contents") {
+		t.Fatalf("expected a warning, got '%s'", errStream.String())
+	}
+	got, _ := ps.db.AllDuplicates("")
+	if len(got) != 0 {
+		t.Fatalf("expected no duplicates, got %v", got)
+	}
+}
+
+func TestScanCollisionWithoutParanoid(t *testing.T) {
+	fs := testfs.Read(`
+/a [10000 1]
+/b [10000 2]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	ps.hash = collidingHashAlgorithm
+	err := ps.Scan([]string{"/"}, &ScanOptions{})
+	check(t, err)
+	got, _ := ps.db.AllDuplicates("")
+	if len(got) != 1 {
+		t.Fatalf("expected colliding files to be treated as duplicates, got %v", got)
+	}
+}
+
+func TestScanParanoidCollisionOutlierFirst(t *testing.T) {
+	fs := testfs.Read(`
+/a [10000 2]
+/b [10000 1]
+/c [10000 1]
+	`).Mkfs()
+	ps, _, errStream := newTest(fs)
+	ps.hash = collidingHashAlgorithm
+	err := ps.Scan([]string{"/"}, &ScanOptions{Paranoid: true})
+	checkErr(t, err)
+	warnings := strings.Count(errStream.String(), "same hash but different contents")
+	if warnings != 1 || !strings.Contains(errStream.String(), "'/a'") {
+		t.Fatalf("expected a single warning about /a, got '%s'", errStream.String())
+	}
+	got, _ := ps.db.AllDuplicates("")
+	if len(got) != 1 || len(got[0]) != 2 || got[0][0].Path != "/b" || got[0][1].Path != "/c" {
+		t.Fatalf("expected /b and /c to be duplicates, got %v", got)
+	}
+}
+
+func TestScanParanoidSame(t *testing.T) {
+	fs := testfs.Read(`
+/a [10000 1]
+/b [10000 1]
+/c [10000 1]
+	`).Mkfs()
+	ps, _, errStream := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{Paranoid: true})
+	check(t, err)
+	if errStream.Len() != 0 {
+		t.Fatalf("expected no warnings, got '%s'", errStream.String())
+	}
+	got, _ := ps.db.AllDuplicates("")
+	if len(got) != 1 || len(got[0]) != 3 {
+		t.Fatalf("expected a duplicate set of 3 files, got %v", got)
+	}
+}
+
+func TestScanDeviceThreads(t *testing.T) {
+	fs := testfs.Read(`
+/a [10000 1]
+/b [10000 1]
+/c [10000 2]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{DeviceThreads: map[string]int{"/": 1}})
+	check(t, err)
+	got, _ := ps.db.AllDuplicates("")
+	if len(got) != 1 || len(got[0]) != 2 {
+		t.Fatalf("expected a duplicate set of 2 files, got %v", got)
+	}
+	err = ps.Scan([]string{"/"}, &ScanOptions{DeviceThreads: map[string]int{"/nonexistent": 1}})
+	checkErr(t, err)
+}
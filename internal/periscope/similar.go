@@ -0,0 +1,291 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/db"
+	"github.com/anishathalye/periscope/internal/fuzzyhash"
+	"github.com/anishathalye/periscope/internal/herror"
+	"github.com/anishathalye/periscope/internal/imagehash"
+
+	"bytes"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+
+	"github.com/dustin/go-humanize"
+)
+
+// the number of bits of the 64-bit image hash that can differ between images
+// that are considered similar
+const DefaultSimilarDistance = 10
+
+// the minimum similarity score (out of 100) of files that are considered
+// similar
+const DefaultSimilarThreshold = 50
+
+type SimilarOptions struct {
+	Distance  int
+	Threshold int
+	Relative  bool
+}
+
+// Reports groups of images that look alike, based on their perceptual hashes,
+// or, if path is a file, the files similar to it: the images that look like it
+// if it's an image and perceptual hashes were computed, and otherwise the files
+// with similar contents, based on their similarity digests.
+//
+// Similar files are not duplicates: they generally have different contents,
+// so they are reported separately, and they are never considered by rm.
+func (ps *Periscope) Similar(path string, options *SimilarOptions) herror.Interface {
+	if options.Distance < 0 || options.Distance > 64 {
+		return herror.UserF(nil, "invalid distance %d: must be between 0 and 64", options.Distance)
+	}
+	if options.Threshold < 0 || options.Threshold > 100 {
+		return herror.UserF(nil, "invalid threshold %d: must be between 0 and 100", options.Threshold)
+	}
+	var absDir string
+	if path != "" {
+		absPath, stat, err := ps.checkFile(path, false, false, "access", false, true)
+		if err != nil {
+			return err
+		}
+		if stat.Mode().IsRegular() {
+			if isImage(absPath) {
+				asImage, err := ps.compareAsImage(absPath)
+				if err != nil {
+					return err
+				}
+				if asImage {
+					return ps.similarImages(path, absPath, options)
+				}
+			}
+			return ps.similarFiles(path, absPath, options)
+		}
+		absDir = absPath
+	}
+	infos, err := ps.db.ImageInfos()
+	if err != nil {
+		return err
+	}
+	var refDir string
+	if options.Relative {
+		var err error
+		refDir, err = filepath.Abs(path) // if path == "", this will treat it like path = "."
+		if err != nil {
+			return herror.Internal(err, "")
+		}
+	}
+	first := true
+	for _, group := range similarGroups(infos, options.Distance) {
+		if absDir != "" && !anyContainedIn(group, absDir) {
+			continue
+		}
+		if !first {
+			fmt.Fprintf(ps.outStream, "\n")
+		}
+		for _, info := range group {
+			path := info.Path
+			if options.Relative {
+				path = relPath(refDir, path)
+			}
+			fmt.Fprintf(ps.outStream, "%s (%s)\n", path, humanize.Bytes(uint64(info.Size)))
+		}
+		first = false
+	}
+	return nil
+}
+
+// whether an image is compared to other images by its perceptual hash, which
+// is the case if the scan that found it computed one, or, if it's not in the
+// database, if any images have one; otherwise, it's compared like any other
+// file, by its similarity digest
+func (ps *Periscope) compareAsImage(absPath string) (bool, herror.Interface) {
+	set, err := ps.db.Lookup(absPath)
+	if err != nil {
+		return false, err
+	}
+	if len(set) > 0 {
+		return set[0].ImageHash != nil, nil
+	}
+	infos, err := ps.db.ImageInfos()
+	if err != nil {
+		return false, err
+	}
+	return len(infos) > 0, nil
+}
+
+// lists the images in the database whose perceptual hashes are within the
+// distance of the one of the given image, most similar first, with the number
+// of bits by which their hashes differ
+func (ps *Periscope) similarImages(path, absPath string, options *SimilarOptions) herror.Interface {
+	hash, _, err := ps.hashImage(absPath, true, false)
+	if err != nil {
+		return herror.UserF(err, "cannot read '%s' as an image", path)
+	}
+	infos, herr := ps.db.ImageInfos()
+	if herr != nil {
+		return herr
+	}
+	type match struct {
+		info     db.FileInfo
+		distance int
+	}
+	var matches []match
+	for _, info := range infos {
+		if info.Path == absPath {
+			continue
+		}
+		distance := imagehash.Distance(imageHashToUint64(hash), imageHashToUint64(info.ImageHash))
+		if distance <= options.Distance {
+			matches = append(matches, match{info: info, distance: distance})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].distance < matches[j].distance })
+	var refDir string
+	if options.Relative {
+		refDir = filepath.Dir(absPath)
+	}
+	for _, m := range matches {
+		path := m.info.Path
+		if options.Relative {
+			path = relPath(refDir, path)
+		}
+		fmt.Fprintf(ps.outStream, "%2d %s (%s)\n", m.distance, path, humanize.Bytes(uint64(m.info.Size)))
+	}
+	return nil
+}
+
+// lists the files in the database whose similarity digests are similar to the
+// one of the given file, most similar first, with their scores
+func (ps *Periscope) similarFiles(path, absPath string, options *SimilarOptions) herror.Interface {
+	hash, err := ps.hashFuzzy(absPath)
+	if err != nil {
+		return herror.UserF(err, "cannot read '%s'", path)
+	}
+	digest, err := fuzzyhash.Parse(string(hash))
+	if err != nil {
+		return herror.Internal(err, "")
+	}
+	infos, herr := ps.db.FuzzyInfos()
+	if herr != nil {
+		return herr
+	}
+	type match struct {
+		info  db.FileInfo
+		score int
+	}
+	var matches []match
+	for _, info := range infos {
+		if info.Path == absPath {
+			continue
+		}
+		other, err := fuzzyhash.Parse(string(info.FuzzyHash))
+		if err != nil {
+			log.Printf("fuzzyhash.Parse(%q) returned error: %s", info.FuzzyHash, err)
+			continue
+		}
+		score := fuzzyhash.Compare(digest, other)
+		if score > 0 && score >= options.Threshold {
+			matches = append(matches, match{info: info, score: score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].score > matches[j].score })
+	var refDir string
+	if options.Relative {
+		refDir = filepath.Dir(absPath)
+	}
+	for _, m := range matches {
+		path := m.info.Path
+		if options.Relative {
+			path = relPath(refDir, path)
+		}
+		fmt.Fprintf(ps.outStream, "%3d %s (%s)\n", m.score, path, humanize.Bytes(uint64(m.info.Size)))
+	}
+	return nil
+}
+
+// groups infos whose image hashes are all within the given distance of each
+// other; groups where all the files are identical are left out, because
+// they're ordinary duplicates
+//
+// similarity isn't transitive, so files aren't grouped just because they're
+// connected by a chain of similar files: starting with the first file by path
+// that isn't in a group yet, a group is formed by adding the files that are
+// similar to every file already in it, in order of their paths
+//
+// groups are sorted by path, as are the infos within each group
+func similarGroups(infos []db.FileInfo, distance int) [][]db.FileInfo {
+	var tree imagehash.Tree
+	hashes := make([]uint64, len(infos))
+	order := make([]int, len(infos))
+	for i := range infos {
+		hashes[i] = imageHashToUint64(infos[i].ImageHash)
+		tree.Add(hashes[i], i)
+		order[i] = i
+	}
+	byPath := func(indices []int) {
+		sort.Slice(indices, func(i, j int) bool { return infos[indices[i]].Path < infos[indices[j]].Path })
+	}
+	byPath(order)
+	grouped := make([]bool, len(infos))
+	var groups [][]db.FileInfo
+	for _, i := range order {
+		if grouped[i] {
+			continue
+		}
+		var candidates []int
+		tree.Within(hashes[i], distance, func(j int) {
+			if j != i && !grouped[j] {
+				candidates = append(candidates, j)
+			}
+		})
+		byPath(candidates)
+		members := []int{i}
+		for _, j := range candidates {
+			similar := true
+			for _, m := range members {
+				if imagehash.Distance(hashes[j], hashes[m]) > distance {
+					similar = false
+					break
+				}
+			}
+			if similar {
+				members = append(members, j)
+			}
+		}
+		group := make([]db.FileInfo, len(members))
+		for k, m := range members {
+			grouped[m] = true
+			group[k] = infos[m]
+		}
+		if len(group) <= 1 || allIdentical(group) {
+			continue
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+func allIdentical(group []db.FileInfo) bool {
+	for i := 1; i < len(group); i++ {
+		if !knownIdentical(&group[i], &group[0]) {
+			return false
+		}
+	}
+	return true
+}
+
+// whether two infos are known to have the same contents, because they're the
+// same file or have the same full hash
+func knownIdentical(a, b *db.FileInfo) bool {
+	return a.SameFile(b) || (a.FullHash != nil && bytes.Equal(a.FullHash, b.FullHash))
+}
+
+func anyContainedIn(infos []db.FileInfo, dir string) bool {
+	for _, info := range infos {
+		if containedInAny(info.Path, []string{dir}) {
+			return true
+		}
+	}
+	return false
+}
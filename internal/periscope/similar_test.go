@@ -0,0 +1,166 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/db"
+
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/afero"
+)
+
+// draws a picture with some structure at the given size, and encodes it as a
+// PNG, or as a JPEG if the path ends in ".jpg"
+func writePicture(fs afero.Fs, path string, w, h int, inverted bool) int64 {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x)/float64(w), float64(y)/float64(h)
+			v := uint8(255 * (fx*fx + fy) / 2)
+			if inverted {
+				v = 255 - v
+			}
+			img.Set(x, y, color.RGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if strings.HasSuffix(path, ".jpg") {
+		jpeg.Encode(&buf, img, nil)
+	} else {
+		png.Encode(&buf, img)
+	}
+	afero.WriteFile(fs, path, buf.Bytes(), 0o644)
+	return int64(buf.Len())
+}
+
+func TestScanSimilarImages(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writePicture(fs, "/a.png", 400, 300, false)
+	writePicture(fs, "/b.jpg", 200, 150, false)
+	writePicture(fs, "/c.png", 400, 300, true)
+	afero.WriteFile(fs, "/d.png", []byte("not really an image"), 0o644)
+	afero.WriteFile(fs, "/e.txt", []byte("text"), 0o644)
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{SimilarImages: true})
+	check(t, err)
+	infos, _ := ps.db.AllInfos()
+	hashed := make(map[string]bool)
+	for _, info := range infos {
+		hashed[info.Path] = info.ImageHash != nil
+	}
+	expected := map[string]bool{"/a.png": true, "/b.jpg": true, "/c.png": true, "/d.png": false, "/e.txt": false}
+	for path, want := range expected {
+		if hashed[path] != want {
+			t.Errorf("%s: expected image hash %v, got %v", path, want, hashed[path])
+		}
+	}
+
+	// the hashes are kept by a rescan without --similar-images, as long
+	// as the files haven't changed
+	err = ps.Scan([]string{"/"}, &ScanOptions{})
+	check(t, err)
+	infos, _ = ps.db.ImageInfos()
+	if len(infos) != 3 {
+		t.Fatalf("expected 3 images with hashes, got %d", len(infos))
+	}
+}
+
+func TestSimilar(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	sizeA := writePicture(fs, "/photos/a.png", 400, 300, false)
+	sizeB := writePicture(fs, "/backup/b.jpg", 200, 150, false)
+	writePicture(fs, "/photos/c.png", 400, 300, true)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{SimilarImages: true})
+	err := ps.Similar("", &SimilarOptions{Distance: DefaultSimilarDistance})
+	check(t, err)
+	got := strings.TrimSpace(out.String())
+	expected := fmt.Sprintf("/backup/b.jpg (%s)\n/photos/a.png (%s)", humanize.Bytes(uint64(sizeB)), humanize.Bytes(uint64(sizeA)))
+	if got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+
+	out.Reset()
+	err = ps.Similar("/photos", &SimilarOptions{Distance: DefaultSimilarDistance, Relative: true})
+	check(t, err)
+	got = strings.TrimSpace(out.String())
+	expected = fmt.Sprintf("/backup/b.jpg (%s)\na.png (%s)", humanize.Bytes(uint64(sizeB)), humanize.Bytes(uint64(sizeA)))
+	if got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+
+	out.Reset()
+	err = ps.Similar("/photos", &SimilarOptions{Distance: 64})
+	check(t, err)
+	if got := strings.Count(out.String(), "\n"); got != 3 {
+		t.Fatalf("expected all 3 images to be similar at the maximum distance, got '%s'", out.String())
+	}
+}
+
+func TestSimilarImage(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	sizeB := writePicture(fs, "/backup/b.jpg", 200, 150, false)
+	writePicture(fs, "/photos/a.png", 400, 300, false)
+	writePicture(fs, "/photos/c.png", 400, 300, true)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{SimilarImages: true})
+	// images are compared by their perceptual hashes, even without
+	// similarity digests
+	err := ps.Similar("/photos/a.png", &SimilarOptions{Distance: DefaultSimilarDistance})
+	check(t, err)
+	got := strings.TrimSpace(out.String())
+	expected := regexp.MustCompile(fmt.Sprintf(`^ ?\d+ /backup/b\.jpg \(%s\)$`, regexp.QuoteMeta(humanize.Bytes(uint64(sizeB)))))
+	if !expected.MatchString(got) {
+		t.Fatalf("unexpected output '%s'", got)
+	}
+}
+
+func TestSimilarGroupsNotTransitive(t *testing.T) {
+	image := func(path string, hash uint64) db.FileInfo {
+		return db.FileInfo{Path: path, ImageHash: binary.BigEndian.AppendUint64(nil, hash)}
+	}
+	// a and b differ in 3 bits, as do b and c, but a and c differ in 6
+	infos := []db.FileInfo{
+		image("/c", 0b111111),
+		image("/a", 0),
+		image("/b", 0b111),
+		image("/d", 0b111111111111111111),
+	}
+	groups := similarGroups(infos, 4)
+	if len(groups) != 1 || len(groups[0]) != 2 || groups[0][0].Path != "/a" || groups[0][1].Path != "/b" {
+		t.Fatalf("expected a single group of /a and /b, got %v", groups)
+	}
+	groups = similarGroups(infos, 6)
+	if len(groups) != 1 || len(groups[0]) != 3 {
+		t.Fatalf("expected a single group of /a, /b, and /c, got %v", groups)
+	}
+}
+
+func TestSimilarSkipsDuplicates(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	writePicture(fs, "/a.png", 100, 100, false)
+	writePicture(fs, "/b.png", 100, 100, false)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{SimilarImages: true})
+	err := ps.Similar("", &SimilarOptions{Distance: DefaultSimilarDistance})
+	check(t, err)
+	if out.String() != "" {
+		t.Fatalf("expected no output, got '%s'", out.String())
+	}
+}
+
+func TestSimilarInvalidDistance(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	ps, _, _ := newTest(fs)
+	err := ps.Similar("", &SimilarOptions{Distance: 65})
+	checkErr(t, err)
+}
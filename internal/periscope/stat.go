@@ -0,0 +1,23 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/db"
+
+	"os"
+)
+
+// fills in the stat metadata fields of info
+func setStatMetadata(info *db.FileInfo, stat os.FileInfo) {
+	info.Mtime = stat.ModTime().UnixNano()
+	info.Ctime, info.Inode, info.Device = sysStat(stat)
+}
+
+// if the stat metadata matches what was recorded when a file was hashed, we
+// assume that the file is unchanged and that the hashes can be reused
+func sameStatMetadata(a, b *db.FileInfo) bool {
+	return a.Size == b.Size &&
+		a.Mtime == b.Mtime &&
+		a.Ctime == b.Ctime &&
+		a.Inode == b.Inode &&
+		a.Device == b.Device
+}
@@ -0,0 +1,13 @@
+package periscope
+
+import (
+	"os"
+	"syscall"
+)
+
+func sysStat(stat os.FileInfo) (ctime, inode, device int64) {
+	if st, ok := stat.Sys().(*syscall.Stat_t); ok {
+		return st.Ctimespec.Nano(), int64(st.Ino), int64(st.Dev)
+	}
+	return 0, 0, 0
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package periscope
+
+import (
+	"os"
+)
+
+// ctime, inode, and device are not available; rescans fall back to comparing
+// only the size and mtime
+func sysStat(stat os.FileInfo) (ctime, inode, device int64) {
+	return 0, 0, 0
+}
@@ -21,6 +21,9 @@ func (ps *Periscope) Summary(options *SummaryOptions) herror.Interface {
 	fmt.Fprintf(w, "tracked\v %s\v\n", humanize.Comma(summary.Files))
 	fmt.Fprintf(w, "unique\v %s\v\n", humanize.Comma(summary.Unique))
 	fmt.Fprintf(w, "duplicate\v %s\v\n", humanize.Comma(summary.Duplicate))
+	if summary.Hardlinks > 0 {
+		fmt.Fprintf(w, "hardlinks\v %s\v\n", humanize.Comma(summary.Hardlinks))
+	}
 	fmt.Fprintf(w, "overhead\v %s\v\n", humanize.Bytes(uint64(summary.Overhead)))
 	w.Flush()
 	return nil
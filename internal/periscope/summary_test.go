@@ -3,8 +3,11 @@ package periscope
 import (
 	"github.com/anishathalye/periscope/internal/testfs"
 
+	"os"
 	"strings"
 	"testing"
+
+	"github.com/spf13/afero"
 )
 
 func TestSummaryBasic(t *testing.T) {
@@ -49,3 +52,24 @@ duplicate   0
 		t.Fatalf("expected '%s', got '%s'", expected, got)
 	}
 }
+
+func TestSummaryHardlinks(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := hardlinkDir()
+	defer os.RemoveAll(dir)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{dir}, &ScanOptions{})
+	err := ps.Summary(&SummaryOptions{})
+	check(t, err)
+	got := strings.TrimSpace(out.String())
+	expected := strings.TrimSpace(`
+  tracked   5
+   unique   2
+duplicate   1
+hardlinks   2
+ overhead 4 B
+	`)
+	if got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+}
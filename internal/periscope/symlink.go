@@ -0,0 +1,79 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/herror"
+
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/spf13/afero"
+)
+
+type SymlinkOptions struct {
+	Recursive bool
+	Verbose   bool
+	DryRun    bool
+	Contained []string
+	// make links relative to the directory they're in, rather than
+	// absolute
+	Relative bool
+	Paranoid bool
+}
+
+// Replaces duplicates with symbolic links to a copy, which works across file
+// systems, unlike hardlinks.
+//
+// Files are checked exactly like in Rm, and the copy that a file is linked to
+// is the one that Rm would have kept: it's never one of the files being
+// replaced, and never a file inside an archive. Each file is replaced
+// atomically, by creating a link with a temporary name and renaming it over
+// the file. Replaced files are removed from the database: later commands
+// refuse to operate on paths with symbolic links, so they're not tracked.
+func (ps *Periscope) Symlink(paths []string, options *SymlinkOptions) herror.Interface {
+	return ps.Rm(paths, &RmOptions{
+		Recursive: options.Recursive,
+		Verbose:   options.Verbose,
+		DryRun:    options.DryRun,
+		Contained: options.Contained,
+		Paranoid:  options.Paranoid,
+		symlink:   options,
+	})
+}
+
+// the contents of a symbolic link at absPath that points to target
+func symlinkTarget(target, absPath string, relative bool) string {
+	if !relative {
+		return target
+	}
+	rel, err := filepath.Rel(filepath.Dir(absPath), target)
+	if err != nil {
+		return target
+	}
+	return rel
+}
+
+// atomically replaces path with a symbolic link to target
+func (ps *Periscope) replaceWithSymlink(target, path string, relative bool) error {
+	linker, ok := ps.fs.(afero.Linker)
+	if !ok {
+		return errors.New("symbolic links are not supported")
+	}
+	dir, base := filepath.Dir(path), filepath.Base(path)
+	for {
+		tmp := filepath.Join(dir, "."+base+".psc-symlink-"+strconv.FormatUint(uint64(rand.Uint32()), 36))
+		err := linker.SymlinkIfPossible(symlinkTarget(target, path, relative), tmp)
+		if os.IsExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		if err := ps.fs.Rename(tmp, path); err != nil {
+			ps.fs.Remove(tmp)
+			return err
+		}
+		return nil
+	}
+}
@@ -0,0 +1,158 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/testfs"
+
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func checkSymlink(t *testing.T, path, target, contents string) {
+	t.Helper()
+	got, err := os.Readlink(path)
+	check(t, err)
+	if got != target {
+		t.Fatalf("expected '%s' to link to '%s', got '%s'", path, target, got)
+	}
+	data, err := os.ReadFile(path)
+	check(t, err)
+	if string(data) != contents {
+		t.Fatalf("expected '%s' to contain '%s', got '%s'", path, contents, data)
+	}
+}
+
+func TestSymlinkBasic(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "a"), 0o755)
+	os.MkdirAll(filepath.Join(dir, "b"), 0o755)
+	os.WriteFile(filepath.Join(dir, "a", "x"), []byte("contents"), 0o644)
+	os.WriteFile(filepath.Join(dir, "b", "x"), []byte("contents"), 0o644)
+	os.WriteFile(filepath.Join(dir, "b", "y"), []byte("unique"), 0o644)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{dir}, &ScanOptions{})
+	err := ps.Symlink([]string{filepath.Join(dir, "b", "x")}, &SymlinkOptions{Verbose: true})
+	check(t, err)
+	checkSymlink(t, filepath.Join(dir, "b", "x"), filepath.Join(dir, "a", "x"), "contents")
+	expected := "ln -s " + filepath.Join(dir, "a", "x") + " " + filepath.Join(dir, "b", "x") + "\n"
+	if got := out.String(); got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+	// no temporary files are left behind
+	entries, _ := os.ReadDir(filepath.Join(dir, "b"))
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 files, got %d", len(entries))
+	}
+	// the replaced file is no longer in the database
+	set, err := ps.db.Lookup(filepath.Join(dir, "a", "x"))
+	check(t, err)
+	if len(set) != 1 || set[0].Path != filepath.Join(dir, "a", "x") {
+		t.Fatalf("expected only the surviving copy, got %+v", set)
+	}
+	// unique files can't be replaced
+	err = ps.Symlink([]string{filepath.Join(dir, "b", "y")}, &SymlinkOptions{})
+	checkErr(t, err)
+	// and neither can the link itself, now that it has no duplicates
+	err = ps.Symlink([]string{filepath.Join(dir, "a", "x")}, &SymlinkOptions{})
+	checkErr(t, err)
+	if _, err := os.Readlink(filepath.Join(dir, "a", "x")); err == nil {
+		t.Fatal("expected the surviving copy to be left alone")
+	}
+}
+
+func TestSymlinkRelative(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "a"), 0o755)
+	os.MkdirAll(filepath.Join(dir, "b", "c"), 0o755)
+	os.WriteFile(filepath.Join(dir, "a", "x"), []byte("contents"), 0o644)
+	os.WriteFile(filepath.Join(dir, "b", "c", "x"), []byte("contents"), 0o644)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{dir}, &ScanOptions{})
+	err := ps.Symlink([]string{filepath.Join(dir, "b")}, &SymlinkOptions{Recursive: true, Relative: true, Verbose: true})
+	check(t, err)
+	target := filepath.Join("..", "..", "a", "x")
+	checkSymlink(t, filepath.Join(dir, "b", "c", "x"), target, "contents")
+	expected := "ln -s " + target + " " + filepath.Join(dir, "b", "c", "x") + "\n"
+	if got := out.String(); got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+}
+
+func TestSymlinkRecursive(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "a"), 0o755)
+	os.MkdirAll(filepath.Join(dir, "b"), 0o755)
+	os.WriteFile(filepath.Join(dir, "a", "x1"), []byte("xxxx"), 0o644)
+	os.WriteFile(filepath.Join(dir, "a", "x2"), []byte("xxxx"), 0o644)
+	os.WriteFile(filepath.Join(dir, "a", "y1"), []byte("yyyy"), 0o644)
+	os.WriteFile(filepath.Join(dir, "a", "y2"), []byte("yyyy"), 0o644)
+	os.WriteFile(filepath.Join(dir, "b", "x"), []byte("xxxx"), 0o644)
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{dir}, &ScanOptions{})
+	err := ps.Symlink([]string{filepath.Join(dir, "a")}, &SymlinkOptions{})
+	checkErr(t, err)
+	err = ps.Symlink([]string{filepath.Join(dir, "a")}, &SymlinkOptions{Recursive: true})
+	check(t, err)
+	// files are only linked to a copy outside the directory
+	checkSymlink(t, filepath.Join(dir, "a", "x1"), filepath.Join(dir, "b", "x"), "xxxx")
+	checkSymlink(t, filepath.Join(dir, "a", "x2"), filepath.Join(dir, "b", "x"), "xxxx")
+	for _, name := range []string{"y1", "y2"} {
+		if _, err := os.Readlink(filepath.Join(dir, "a", name)); err == nil {
+			t.Fatalf("expected '%s' not to be replaced", name)
+		}
+	}
+}
+
+func TestSymlinkDryRun(t *testing.T) {
+	fs := afero.NewOsFs()
+	dir := tempDir()
+	defer os.RemoveAll(dir)
+	os.WriteFile(filepath.Join(dir, "x1"), []byte("xxxx"), 0o644)
+	os.WriteFile(filepath.Join(dir, "x2"), []byte("xxxx"), 0o644)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{dir}, &ScanOptions{})
+	err := ps.Symlink([]string{filepath.Join(dir, "x2")}, &SymlinkOptions{Verbose: true, DryRun: true})
+	check(t, err)
+	expected := "ln -s " + filepath.Join(dir, "x1") + " " + filepath.Join(dir, "x2") + "\n"
+	if got := out.String(); got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+	if _, err := os.Readlink(filepath.Join(dir, "x2")); err == nil {
+		t.Fatal("expected file not to be replaced")
+	}
+	set, err := ps.db.Lookup(filepath.Join(dir, "x1"))
+	check(t, err)
+	if len(set) != 2 {
+		t.Fatalf("expected 2 duplicates, got %+v", set)
+	}
+}
+
+func TestSymlinkUnsupported(t *testing.T) {
+	fs := testfs.Read(`
+/a [10 1]
+/b [10 1]
+	`).Mkfs()
+	ps, _, errOut := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Symlink([]string{"/b"}, &SymlinkOptions{})
+	checkErr(t, err)
+	expectedErr := "cannot replace '/b': symbolic links are not supported\n"
+	if got := errOut.String(); got != expectedErr {
+		t.Fatalf("expected '%s', got '%s'", expectedErr, got)
+	}
+	expected := testfs.Read(`
+/a [10 1]
+/b [10 1]
+	`)
+	if !testfs.Equal(fs, expected) {
+		t.Fatalf("expected:\n%sgot:\n%s", expected.ShowIndent(2), testfs.ShowIndent(fs, 2))
+	}
+}
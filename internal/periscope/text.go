@@ -0,0 +1,134 @@
+package periscope
+
+import (
+	"bytes"
+	"io"
+	"unicode/utf8"
+)
+
+// the key for text hashes, so that they never collide with full hashes
+var textHashKey = []byte("text")
+
+// the amount of a file that's examined to decide whether it's text
+const textSniffSize = 8 * 1024
+
+var utf8BOM = []byte{0xef, 0xbb, 0xbf}
+
+// whether the beginning of a file looks like UTF-8 (or ASCII) text: it must be
+// valid UTF-8, apart from a rune cut off at the end, and contain no NUL bytes
+// or other control characters that don't appear in text
+func looksLikeText(head []byte) bool {
+	if len(head) == 0 {
+		return false
+	}
+	// a rune may be cut off at the end of the sniffed region
+	for i := 0; i < utf8.UTFMax-1 && len(head) > 0 && !utf8.Valid(head); i++ {
+		head = head[:len(head)-1]
+	}
+	if !utf8.Valid(head) {
+		return false
+	}
+	for _, b := range head {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' && b != '\f' {
+			return false
+		}
+	}
+	return true
+}
+
+// hashes the normalized contents of a text file; returns nil if the file
+// doesn't look like text
+//
+// normalization removes a UTF-8 byte order mark, converts CRLF line endings to
+// LF, and removes a single trailing newline, so files that differ only in
+// those ways have the same text hash
+func (ps *Periscope) hashText(path string) ([]byte, error) {
+	f, err := ps.open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	head := make([]byte, textSniffSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+	if !looksLikeText(head) {
+		return nil, nil
+	}
+	h := ps.hash.new(textHashKey)
+	norm := &textNormalizer{w: h, start: true}
+	norm.Write(head)
+	buf := make([]byte, readChunkSize)
+	if _, err := io.CopyBuffer(norm, f, buf); err != nil {
+		return nil, err
+	}
+	norm.Close()
+	return h.Sum(nil), nil
+}
+
+// normalizes text as it's written through to a hash; Close must be called
+// after the last write
+type textNormalizer struct {
+	w io.Writer
+	// at the beginning of the input, where a BOM may appear
+	start   bool
+	pending []byte // held back: a partial BOM, a CR, or a final LF
+}
+
+func (n *textNormalizer) Write(p []byte) (int, error) {
+	written := len(p)
+	if n.start {
+		// hold back input until we know whether it starts with a BOM
+		n.pending = append(n.pending, p...)
+		if len(n.pending) < len(utf8BOM) && bytes.HasPrefix(utf8BOM, n.pending) {
+			return written, nil
+		}
+		p = bytes.TrimPrefix(n.pending, utf8BOM)
+		n.pending = nil
+		n.start = false
+	}
+	if len(p) == 0 {
+		return written, nil
+	}
+	if len(n.pending) > 0 {
+		held := n.pending[0]
+		n.pending = nil
+		// a held CR followed by LF is a CRLF, so the CR is dropped; the
+		// LF is handled below
+		if !(held == '\r' && p[0] == '\n') {
+			n.w.Write([]byte{held})
+		}
+	}
+	// write everything up to the last byte, dropping the CR of each CRLF;
+	// the last byte is held back if it may be a CR that starts a CRLF or
+	// a final LF
+	i := 0
+	for j := 0; j < len(p)-1; j++ {
+		if p[j] == '\r' && p[j+1] == '\n' {
+			n.w.Write(p[i:j])
+			i = j + 1
+		}
+	}
+	n.w.Write(p[i : len(p)-1])
+	last := p[len(p)-1]
+	if last == '\r' || last == '\n' {
+		n.pending = []byte{last}
+	} else {
+		n.w.Write([]byte{last})
+	}
+	return written, nil
+}
+
+func (n *textNormalizer) Close() error {
+	if n.start {
+		// short input that's a prefix of a BOM
+		n.w.Write(n.pending)
+	} else if len(n.pending) > 0 && n.pending[0] == '\r' {
+		n.w.Write(n.pending)
+	}
+	// a pending LF is the trailing newline, which is dropped
+	n.pending = nil
+	return nil
+}
@@ -0,0 +1,125 @@
+package periscope
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+func TestLooksLikeText(t *testing.T) {
+	cases := []struct {
+		head string
+		text bool
+	}{
+		{"hello\r\nworld\n", true},
+		{"\xef\xbb\xbfcaf\xc3\xa9", true},
+		{"caf\xc3", true}, // rune cut off by the end of the sniffed region
+		{"caf\xc3 ok", false},
+		{"a\x00b", false},
+		{"\x7fELF\x02\x01", false},
+		{"", false},
+	}
+	for _, c := range cases {
+		if got := looksLikeText([]byte(c.head)); got != c.text {
+			t.Errorf("looksLikeText(%q): expected %v, got %v", c.head, c.text, got)
+		}
+	}
+}
+
+func TestTextNormalizer(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"a\nb\n", "a\nb"},
+		{"a\r\nb\r\n", "a\nb"},
+		{"\xef\xbb\xbfa\nb", "a\nb"},
+		{"a\n\n", "a\n"},
+		{"a\r\n\r\n", "a\n"},
+		{"a\rb\r", "a\rb\r"},
+		{"\xef\xbb", "\xef\xbb"},
+		{"\xef\xbb\xbf", ""},
+		{"\n", ""},
+	}
+	for _, c := range cases {
+		// the result must not depend on how the input is split into
+		// writes
+		for split := 0; split <= len(c.input); split++ {
+			var out bytes.Buffer
+			n := &textNormalizer{w: &out, start: true}
+			n.Write([]byte(c.input[:split]))
+			n.Write([]byte(c.input[split:]))
+			n.Close()
+			if out.String() != c.expected {
+				t.Errorf("normalizing %q split at %d: expected %q, got %q", c.input, split, c.expected, out.String())
+			}
+		}
+	}
+}
+
+func TestScanNormalizeText(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/unix.txt", []byte("one\ntwo\n"), 0o644)
+	afero.WriteFile(fs, "/windows.txt", []byte("one\r\ntwo\r\n"), 0o644)
+	afero.WriteFile(fs, "/bom.txt", []byte("\xef\xbb\xbfone\ntwo"), 0o644)
+	afero.WriteFile(fs, "/other.txt", []byte("one\nthree\n"), 0o644)
+	afero.WriteFile(fs, "/binary", []byte("one\x00two\x00"), 0o644)
+	ps, _, _ := newTest(fs)
+	err := ps.Scan([]string{"/"}, &ScanOptions{NormalizeText: true})
+	check(t, err)
+	infos, _ := ps.db.AllInfos()
+	textHashes := make(map[string][]byte)
+	for _, info := range infos {
+		textHashes[info.Path] = info.TextHash
+	}
+	if textHashes["/binary"] != nil {
+		t.Fatal("expected no text hash for binary file")
+	}
+	unix := textHashes["/unix.txt"]
+	if unix == nil || !bytes.Equal(unix, textHashes["/windows.txt"]) || !bytes.Equal(unix, textHashes["/bom.txt"]) {
+		t.Fatal("expected equivalent text files to have the same text hash")
+	}
+	if bytes.Equal(unix, textHashes["/other.txt"]) {
+		t.Fatal("expected different text files to have different text hashes")
+	}
+}
+
+func TestReportEquivalentText(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/unix.txt", []byte("one\ntwo\n"), 0o644)
+	afero.WriteFile(fs, "/windows.txt", []byte("one\r\ntwo\r\n"), 0o644)
+	afero.WriteFile(fs, "/bom.txt", []byte("\xef\xbb\xbfone\ntwo"), 0o644)
+	afero.WriteFile(fs, "/other.txt", []byte("one\nthree\n"), 0o644)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{NormalizeText: true})
+	err := ps.Report("", &ReportOptions{})
+	check(t, err)
+	got := strings.TrimSpace(out.String())
+	expected := strings.TrimSpace(`
+equivalent modulo line endings/BOM
+  /bom.txt (10 B)
+  /windows.txt (10 B)
+  /unix.txt (8 B)
+	`)
+	if got != expected {
+		t.Fatalf("expected '%s', got '%s'", expected, got)
+	}
+}
+
+func TestInfoEquivalentText(t *testing.T) {
+	fs := afero.NewMemMapFs()
+	afero.WriteFile(fs, "/unix.txt", []byte("one\ntwo\n"), 0o644)
+	afero.WriteFile(fs, "/windows.txt", []byte("one\r\ntwo\r\n"), 0o644)
+	afero.WriteFile(fs, "/bom.txt", []byte("\xef\xbb\xbfone\ntwo"), 0o644)
+	afero.WriteFile(fs, "/other.txt", []byte("one\nthree\n"), 0o644)
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{NormalizeText: true})
+	err := ps.Info([]string{"/unix.txt"}, &InfoOptions{})
+	check(t, err)
+	got := out.String()
+	if !strings.Contains(got, "text hash: ") || !strings.Contains(got, "equivalent text: 2") || !strings.Contains(got, "    /bom.txt (equivalent text)\n    /windows.txt (equivalent text)\n") {
+		t.Fatalf("unexpected info output '%s'", got)
+	}
+}
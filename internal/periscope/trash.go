@@ -0,0 +1,343 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/db"
+	"github.com/anishathalye/periscope/internal/herror"
+
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/spf13/afero"
+)
+
+// the format of DeletionDate in .trashinfo files
+const trashInfoTime = "2006-01-02T15:04:05"
+
+// Returns the trash directory, following the freedesktop.org Trash
+// specification: files moved to the trash go in "files", with a ".trashinfo"
+// file in "info" that records where they came from, so they can also be
+// restored by a file manager.
+//
+// Only the home trash is supported, so files can only be moved to the trash if
+// they're on the same file system as it; see checkTrashDevices.
+func (ps *Periscope) trashDir() (string, herror.Interface) {
+	if ps.trash != "" {
+		return ps.trash, nil
+	}
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", herror.Unlikely(err, "unable to determine trash directory", `
+Ensure that $HOME or $XDG_DATA_HOME is set.
+			`)
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	ps.trash = filepath.Join(dataHome, "Trash")
+	return ps.trash, nil
+}
+
+// Checks that files on the given devices can be moved to the trash, so that
+// rm can refuse before anything is moved, rather than failing for each file;
+// path is the argument that the files are in, for the error message.
+//
+// A file can only be moved to the trash if it's on the same file system as the
+// trash, because moving it anywhere else would mean copying it.
+func (ps *Periscope) checkTrashDevices(path string, devices map[int64]struct{}) herror.Interface {
+	trash, herr := ps.trashDir()
+	if herr != nil {
+		return herr
+	}
+	// the trash might not exist yet, in which case it will be created
+	// on the file system of its closest existing parent
+	dir := trash
+	stat, err := ps.fs.Stat(dir)
+	for err != nil && os.IsNotExist(err) && filepath.Dir(dir) != dir {
+		dir = filepath.Dir(dir)
+		stat, err = ps.fs.Stat(dir)
+	}
+	if err != nil {
+		return herror.UserF(err, "unable to access trash directory '%s'", trash)
+	}
+	_, _, trashDevice := sysStat(stat)
+	if trashDevice == 0 {
+		return nil // the file system doesn't report devices
+	}
+	for device := range devices {
+		// the device is 0 when it's unknown, like for files that were
+		// scanned by an older version, in which case moveToTrash
+		// still refuses to copy them
+		if device != 0 && device != trashDevice {
+			return herror.UserF(nil, "cannot move '%s' to trash: it has files on a different file system than the trash ('%s'), and files can't be trashed across file systems", path, trash)
+		}
+	}
+	return nil
+}
+
+// the devices that the files in a path that rm could remove are on
+func (ps *Periscope) devicesIn(absPath string, stat os.FileInfo) (map[int64]struct{}, herror.Interface) {
+	devices := make(map[int64]struct{})
+	_, _, device := sysStat(stat)
+	devices[device] = struct{}{}
+	if !stat.IsDir() {
+		return devices, nil
+	}
+	infos, err := ps.db.InfosUnder(absPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range infos {
+		if !isArchiveMember(info.Path) {
+			devices[info.Device] = struct{}{}
+		}
+	}
+	return devices, nil
+}
+
+// Moves a file to the trash, and records it in the database, along with its
+// original path, full hash, and the journal entry that records its removal.
+func (ps *Periscope) moveToTrash(absPath string, info db.FileInfo, journal int64) error {
+	trash, herr := ps.trashDir()
+	if herr != nil {
+		return herr
+	}
+	filesDir := filepath.Join(trash, "files")
+	infoDir := filepath.Join(trash, "info")
+	for _, dir := range []string{filesDir, infoDir} {
+		if err := ps.fs.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+	}
+	now := time.Now()
+	// the name is claimed by creating the .trashinfo file, so concurrent
+	// trashers don't pick the same one
+	base := filepath.Base(absPath)
+	var name string
+	var f afero.File
+	for i := 1; ; i++ {
+		name = base
+		if i > 1 {
+			name = base + "." + strconv.Itoa(i)
+		}
+		if _, err := ps.fs.Stat(filepath.Join(filesDir, name)); err == nil {
+			continue
+		}
+		var err error
+		f, err = ps.fs.OpenFile(filepath.Join(infoDir, name+".trashinfo"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if os.IsExist(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		break
+	}
+	infoPath := filepath.Join(infoDir, name+".trashinfo")
+	escaped := (&url.URL{Path: absPath}).EscapedPath()
+	_, err := fmt.Fprintf(f, "[Trash Info]\nPath=%s\nDeletionDate=%s\n", escaped, now.Format(trashInfoTime))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = ps.fs.Rename(absPath, filepath.Join(filesDir, name))
+		if errors.Is(err, syscall.EXDEV) {
+			// the file system changed since it was checked
+			err = errors.New("cannot trash across file systems")
+		}
+	}
+	if err != nil {
+		ps.fs.Remove(infoPath)
+		return err
+	}
+	return ps.db.AddTrashed(db.TrashedFile{
+		Name:     name,
+		Path:     absPath,
+		Size:     info.Size,
+		FullHash: info.FullHash,
+		Deleted:  now.Unix(),
+		Journal:  journal,
+	})
+}
+
+// the files in the trash that were moved there by rm
+func (ps *Periscope) trashed() (string, []db.TrashedFile, herror.Interface) {
+	trash, err := ps.trashDir()
+	if err != nil {
+		return "", nil, err
+	}
+	files, err := ps.db.AllTrashed()
+	if err != nil {
+		return "", nil, err
+	}
+	return trash, files, nil
+}
+
+type TrashListOptions struct {
+	Relative bool
+}
+
+// Lists the files that rm moved to the trash, by their original paths.
+func (ps *Periscope) TrashList(options *TrashListOptions) herror.Interface {
+	trash, files, err := ps.trashed()
+	if err != nil {
+		return err
+	}
+	var refDir string
+	if options.Relative {
+		var err error
+		refDir, err = filepath.Abs(".")
+		if err != nil {
+			return herror.Internal(err, "")
+		}
+	}
+	w := tabwriter.NewWriter(ps.outStream, 0, 0, 0, ' ', tabwriter.DiscardEmptyColumns|tabwriter.AlignRight)
+	for _, file := range files {
+		path := file.Path
+		if options.Relative {
+			path = relPath(refDir, path)
+		}
+		note := ""
+		if _, err := ps.fs.Stat(filepath.Join(trash, "files", file.Name)); err != nil {
+			note = " (missing)"
+		}
+		deleted := time.Unix(file.Deleted, 0).Format("2006-01-02 15:04")
+		fmt.Fprintf(w, "%s\v %s\v  %s%s\n", deleted, humanize.Bytes(uint64(file.Size)), path, note)
+	}
+	w.Flush()
+	return nil
+}
+
+type TrashRestoreOptions struct {
+	Verbose bool
+}
+
+// Moves files from the trash back to where they came from. Each path can be a
+// file, or a directory, in which case everything that was in it is restored.
+// If a file was moved to the trash more than once, the most recent one is
+// restored.
+//
+// Restored files are no longer in the journal, so Undo won't restore them
+// again. They aren't added back to the database; scan them again to find
+// their duplicates.
+func (ps *Periscope) TrashRestore(paths []string, options *TrashRestoreOptions) herror.Interface {
+	trash, files, err := ps.trashed()
+	if err != nil {
+		return err
+	}
+	var herr herror.Interface
+	for _, path := range paths {
+		absPath, err := filepath.Abs(path)
+		if err != nil {
+			return herror.Internal(err, "")
+		}
+		attempted := make(map[string]struct{})
+		found := false
+		for _, file := range files {
+			if file.Path != absPath && !containedInAny(file.Path, []string{absPath}) {
+				continue
+			}
+			found = true
+			if _, ok := attempted[file.Path]; ok {
+				continue // an older copy
+			}
+			attempted[file.Path] = struct{}{}
+			if err := ps.restore1(trash, file, options); err != nil {
+				if !herror.IsSilent(err) {
+					return err
+				}
+				herr = err
+			}
+		}
+		if !found {
+			fmt.Fprintf(ps.errStream, "cannot restore '%s': not in trash\n", path)
+			herr = herror.Silent()
+		}
+	}
+	if err := ps.db.RemoveEmptyOperations(); err != nil {
+		return err
+	}
+	return herr
+}
+
+func (ps *Periscope) restore1(trash string, file db.TrashedFile, options *TrashRestoreOptions) herror.Interface {
+	// a rename would replace an existing file, even a dangling symbolic
+	// link
+	exists := func(path string) bool {
+		if lstater, ok := ps.fs.(afero.Lstater); ok {
+			_, _, err := lstater.LstatIfPossible(path)
+			return err == nil
+		}
+		_, err := ps.fs.Stat(path)
+		return err == nil
+	}
+	if exists(file.Path) {
+		fmt.Fprintf(ps.errStream, "cannot restore '%s': file exists\n", file.Path)
+		return herror.Silent()
+	}
+	if err := ps.fs.MkdirAll(filepath.Dir(file.Path), 0o755); err != nil {
+		fmt.Fprintf(ps.errStream, "cannot restore '%s': %s\n", file.Path, err)
+		return herror.Silent()
+	}
+	if err := ps.fs.Rename(filepath.Join(trash, "files", file.Name), file.Path); err != nil {
+		if os.IsNotExist(err) {
+			fmt.Fprintf(ps.errStream, "cannot restore '%s': no longer in trash\n", file.Path)
+		} else {
+			fmt.Fprintf(ps.errStream, "cannot restore '%s': %s\n", file.Path, err)
+		}
+		return herror.Silent()
+	}
+	ps.fs.Remove(filepath.Join(trash, "info", file.Name+".trashinfo"))
+	if options.Verbose {
+		fmt.Fprintf(ps.outStream, "restore %s\n", file.Path)
+	}
+	if file.Journal != 0 {
+		if err := ps.db.RemoveJournalEntry(file.Journal); err != nil {
+			return err
+		}
+	}
+	return ps.db.RemoveTrashed(file.Name)
+}
+
+type TrashEmptyOptions struct {
+	Verbose bool
+}
+
+// Permanently deletes the files that rm moved to the trash. Other files in the
+// trash are left alone.
+func (ps *Periscope) TrashEmpty(options *TrashEmptyOptions) herror.Interface {
+	trash, files, err := ps.trashed()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		err := ps.fs.RemoveAll(filepath.Join(trash, "files", file.Name))
+		if err != nil {
+			fmt.Fprintf(ps.errStream, "cannot delete '%s' from trash: %s\n", file.Path, err)
+			continue
+		}
+		ps.fs.Remove(filepath.Join(trash, "info", file.Name+".trashinfo"))
+		if options.Verbose {
+			fmt.Fprintf(ps.outStream, "rm %s\n", file.Path)
+		}
+		if err := ps.db.RemoveTrashed(file.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// whether a path is the trash directory, which isn't scanned, so that files
+// in the trash never count as copies
+func (ps *Periscope) isTrash(path string) bool {
+	trash, err := ps.trashDir()
+	return err == nil && strings.TrimSuffix(path, string(filepath.Separator)) == trash
+}
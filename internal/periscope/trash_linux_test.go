@@ -0,0 +1,137 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/db"
+	"github.com/anishathalye/periscope/internal/testfs"
+
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// a file system where everything under mount is on a second device, like a
+// separately mounted volume, so files can't be renamed into or out of it
+type mountedFs struct {
+	afero.Fs
+	mount string
+}
+
+type mountedFileInfo struct {
+	os.FileInfo
+	stat *syscall.Stat_t
+}
+
+func (fi mountedFileInfo) Sys() interface{} { return fi.stat }
+
+func (fs mountedFs) device(path string) uint64 {
+	if path == fs.mount || containedInAny(path, []string{fs.mount}) {
+		return 2
+	}
+	return 1
+}
+
+func (fs mountedFs) withDevice(path string, fi os.FileInfo) os.FileInfo {
+	return mountedFileInfo{fi, &syscall.Stat_t{Dev: fs.device(path), Mtim: syscall.NsecToTimespec(fi.ModTime().UnixNano())}}
+}
+
+func (fs mountedFs) Stat(path string) (os.FileInfo, error) {
+	fi, err := fs.Fs.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return fs.withDevice(path, fi), nil
+}
+
+func (fs mountedFs) LstatIfPossible(path string) (os.FileInfo, bool, error) {
+	fi, err := fs.Stat(path)
+	return fi, false, err
+}
+
+func (fs mountedFs) Rename(oldname, newname string) error {
+	if fs.device(oldname) != fs.device(newname) {
+		return &os.LinkError{Op: "rename", Old: oldname, New: newname, Err: syscall.EXDEV}
+	}
+	return fs.Fs.Rename(oldname, newname)
+}
+
+func TestRmTrashAcrossFileSystems(t *testing.T) {
+	memFs := testfs.Read(`
+/a/x [10000 1]
+/a/y [2000 2]
+/mnt/usb/x [10000 1]
+/mnt/usb/sub/y [2000 2]
+	`).Mkfs()
+	fs := mountedFs{memFs, "/mnt/usb"}
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	for _, path := range []string{"/mnt", "/mnt/usb/x"} {
+		err := ps.Rm([]string{"/a", path}, &RmOptions{Recursive: true, Trash: true, Arbitrary: true})
+		if err == nil || !strings.Contains(err.Error(), "trashed across file systems") {
+			t.Fatalf("expected an error about file systems, got %v", err)
+		}
+	}
+	// nothing was moved, not even the files on the same file system
+	expected := testfs.Read(`
+/a/x [10000 1]
+/a/y [2000 2]
+/mnt/usb/x [10000 1]
+/mnt/usb/sub/y [2000 2]
+	`)
+	if !testfs.Equal(memFs, expected) {
+		t.Fatalf("expected:\n%sgot:\n%s", expected.ShowIndent(2), testfs.ShowIndent(memFs, 2))
+	}
+	err := ps.Dedupe("", &DedupeOptions{Rules: []DedupeRule{{"keep-under", "/a"}}, Trash: true})
+	if err == nil || !strings.Contains(err.Error(), "trashed across file systems") {
+		t.Fatalf("expected an error about file systems, got %v", err)
+	}
+	// files on the trash's file system can still be trashed
+	err = ps.Rm([]string{"/a/x"}, &RmOptions{Trash: true})
+	check(t, err)
+	if _, err := memFs.Stat("/trash/files/x"); err != nil {
+		t.Fatalf("expected file to be in the trash, got %s", err)
+	}
+}
+
+func TestRmTrashUnknownDevice(t *testing.T) {
+	memFs := testfs.Read(`
+/a/x [10000 1]
+/b/x [10000 1]
+	`).Mkfs()
+	fs := mountedFs{memFs, "/mnt/usb"}
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	// files scanned by an older version have no device
+	infos, err := ps.db.InfosUnder("/")
+	check(t, err)
+	for _, info := range infos {
+		info.Device = 0
+		check(t, ps.db.Add(info))
+	}
+	err = ps.Rm([]string{"/a"}, &RmOptions{Recursive: true, Trash: true})
+	check(t, err)
+	if _, err := memFs.Stat("/trash/files/x"); err != nil {
+		t.Fatalf("expected file to be in the trash, got %s", err)
+	}
+}
+
+func TestMoveToTrashAcrossFileSystems(t *testing.T) {
+	memFs := testfs.Read(`
+/a/x [10000 1]
+	`).Mkfs()
+	// the trash is on another file system, which rm checks for before
+	// moving anything; if it changes in the meantime, the error is still
+	// a clear one
+	fs := mountedFs{memFs, "/trash"}
+	ps, _, _ := newTest(fs)
+	err := ps.moveToTrash("/a/x", db.FileInfo{Path: "/a/x", Size: 10000}, 0)
+	if err == nil || err.Error() != "cannot trash across file systems" {
+		t.Fatalf("expected an error about file systems, got %v", err)
+	}
+	if _, err := memFs.Stat("/a/x"); err != nil {
+		t.Fatalf("expected file to be kept, got %s", err)
+	}
+	checkTrashInfo(t, memFs)
+}
@@ -0,0 +1,242 @@
+package periscope
+
+import (
+	"github.com/anishathalye/periscope/internal/testfs"
+
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/spf13/afero"
+)
+
+// checks that the trash has info files for exactly the given names, and then
+// removes them, because their contents depend on the time
+func checkTrashInfo(t *testing.T, fs afero.Fs, names ...string) {
+	entries, _ := afero.ReadDir(fs, "/trash/info")
+	var got []string
+	for _, entry := range entries {
+		got = append(got, entry.Name())
+	}
+	var expected []string
+	for _, name := range names {
+		expected = append(expected, name+".trashinfo")
+	}
+	sort.Strings(expected)
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Fatalf("expected trash info files %v, got %v", expected, got)
+	}
+	fs.RemoveAll("/trash/info")
+}
+
+func TestRmTrash(t *testing.T) {
+	fs := testfs.Read(`
+/a/x [10000 1]
+/b/x [10000 1]
+/b/y [2000 2]
+/c/y [2000 2]
+/c/z [3000 3]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Rm([]string{"/a/x", "/c"}, &RmOptions{Recursive: true, Trash: true})
+	check(t, err)
+	expected := testfs.Read(`
+/b/x [10000 1]
+/b/y [2000 2]
+/c/z [3000 3]
+/trash/files/x [10000 1]
+/trash/files/y [2000 2]
+	`)
+	info, _ := afero.ReadFile(fs, "/trash/info/x.trashinfo")
+	if !strings.HasPrefix(string(info), "[Trash Info]\nPath=/a/x\nDeletionDate=") {
+		t.Fatalf("unexpected trash info '%s'", info)
+	}
+	checkTrashInfo(t, fs, "x", "y")
+	if !testfs.Equal(fs, expected) {
+		t.Fatalf("expected:\n%sgot:\n%s", expected.ShowIndent(2), testfs.ShowIndent(fs, 2))
+	}
+	// the database is updated like with rm
+	set, _ := ps.db.Lookup("/b/x")
+	if len(set) != 1 {
+		t.Fatalf("expected trashed file to be removed from the database, got %+v", set)
+	}
+	files, err := ps.db.AllTrashed()
+	check(t, err)
+	if len(files) != 2 || files[0].Path != "/a/x" || files[0].Size != 10000 || files[0].FullHash == nil || files[1].Path != "/c/y" {
+		t.Fatalf("unexpected trashed files %+v", files)
+	}
+}
+
+func TestTrashNameCollision(t *testing.T) {
+	fs := testfs.Read(`
+/a/x [10000 1]
+/b/x [10000 1]
+/c/x [10000 1]
+	`).Mkfs()
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Rm([]string{"/a/x", "/b/x"}, &RmOptions{Trash: true})
+	check(t, err)
+	expected := testfs.Read(`
+/c/x [10000 1]
+/trash/files/x [10000 1]
+/trash/files/x.2 [10000 1]
+	`)
+	checkTrashInfo(t, fs, "x", "x.2")
+	if !testfs.Equal(fs, expected) {
+		t.Fatalf("expected:\n%sgot:\n%s", expected.ShowIndent(2), testfs.ShowIndent(fs, 2))
+	}
+	err = ps.TrashList(&TrashListOptions{})
+	check(t, err)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], " 10 kB  /a/x") || !strings.HasSuffix(lines[1], " 10 kB  /b/x") {
+		t.Fatalf("unexpected list '%s'", out.String())
+	}
+}
+
+func TestScanSkipsTrash(t *testing.T) {
+	fs := testfs.Read(`
+/a/x [10000 1]
+/b/x [10000 1]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Rm([]string{"/a/x"}, &RmOptions{Trash: true})
+	check(t, err)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	// the copy in the trash doesn't count, so the remaining file can't be
+	// removed
+	err = ps.Rm([]string{"/b/x"}, &RmOptions{Trash: true})
+	checkErr(t, err)
+	if _, err := fs.Stat("/b/x"); err != nil {
+		t.Fatalf("expected file to be kept, got %s", err)
+	}
+}
+
+func TestTrashRestore(t *testing.T) {
+	fs := testfs.Read(`
+/a/x [10000 1]
+/a/sub/y [2000 2]
+/b/x [10000 1]
+/b/y [2000 2]
+	`).Mkfs()
+	ps, out, errOut := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Rm([]string{"/a"}, &RmOptions{Recursive: true, Trash: true, PruneEmpty: true})
+	check(t, err)
+	if _, err := fs.Stat("/a/sub"); !os.IsNotExist(err) {
+		t.Fatal("expected directory to be pruned")
+	}
+	err = ps.TrashRestore([]string{"/a/sub"}, &TrashRestoreOptions{Verbose: true})
+	check(t, err)
+	if got := out.String(); got != "restore /a/sub/y\n" {
+		t.Fatalf("unexpected output '%s'", got)
+	}
+	expected := testfs.Read(`
+/a/sub/y [2000 2]
+/b/x [10000 1]
+/b/y [2000 2]
+/trash/files/x [10000 1]
+	`)
+	checkTrashInfo(t, fs, "x")
+	if !testfs.Equal(fs, expected) {
+		t.Fatalf("expected:\n%sgot:\n%s", expected.ShowIndent(2), testfs.ShowIndent(fs, 2))
+	}
+	// existing files are never replaced
+	afero.WriteFile(fs, "/a/x", []byte("new"), 0o644)
+	err = ps.TrashRestore([]string{"/a"}, &TrashRestoreOptions{})
+	checkErr(t, err)
+	if !strings.Contains(errOut.String(), "cannot restore '/a/x': file exists") {
+		t.Fatalf("unexpected error output '%s'", errOut.String())
+	}
+	files, _ := ps.db.AllTrashed()
+	if len(files) != 1 {
+		t.Fatalf("expected file to still be in the trash, got %+v", files)
+	}
+	err = ps.TrashRestore([]string{"/nothing"}, &TrashRestoreOptions{})
+	checkErr(t, err)
+}
+
+func TestTrashEmpty(t *testing.T) {
+	fs := testfs.Read(`
+/a/x [10000 1]
+/b/x [10000 1]
+/trash/files/other [1000 5]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Rm([]string{"/a/x"}, &RmOptions{Trash: true})
+	check(t, err)
+	err = ps.TrashEmpty(&TrashEmptyOptions{})
+	check(t, err)
+	// files that weren't moved to the trash by rm are left alone
+	expected := testfs.Read(`
+/b/x [10000 1]
+/trash/files/other [1000 5]
+	`)
+	if !testfs.Equal(fs, expected) {
+		t.Fatalf("expected:\n%sgot:\n%s", expected.ShowIndent(2), testfs.ShowIndent(fs, 2))
+	}
+	files, _ := ps.db.AllTrashed()
+	if len(files) != 0 {
+		t.Fatalf("expected trash to be empty, got %+v", files)
+	}
+}
+
+func TestTrashRestoreForgetsJournal(t *testing.T) {
+	fs := testfs.Read(`
+/a/x [10000 1]
+/b/x [10000 1]
+	`).Mkfs()
+	ps, _, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Rm([]string{"/a/x"}, &RmOptions{Trash: true})
+	check(t, err)
+	err = ps.TrashRestore([]string{"/a/x"}, &TrashRestoreOptions{})
+	check(t, err)
+	// the removal was undone, so there's nothing left to undo
+	ops, _ := ps.db.Operations()
+	if len(ops) != 0 {
+		t.Fatalf("expected the journal to be empty, got %+v", ops)
+	}
+	err = ps.Undo(&UndoOptions{})
+	checkErr(t, err)
+}
+
+func TestUndoTrash(t *testing.T) {
+	fs := testfs.Read(`
+/a/x [10000 1]
+/a/y [2000 2]
+/b/x [10000 1]
+/b/y [2000 2]
+	`).Mkfs()
+	ps, out, _ := newTest(fs)
+	ps.Scan([]string{"/"}, &ScanOptions{})
+	err := ps.Rm([]string{"/a"}, &RmOptions{Recursive: true, Trash: true})
+	check(t, err)
+	// a file deleted from the trash is copied from the survivor instead
+	fs.Remove("/trash/files/y")
+	err = ps.Undo(&UndoOptions{Verbose: true})
+	check(t, err)
+	got := strings.Split(strings.TrimSpace(out.String()), "\n")
+	sort.Strings(got)
+	if strings.Join(got, "\n") != "cp /b/y /a/y\nrestore /a/x" {
+		t.Fatalf("unexpected output '%s'", out.String())
+	}
+	checkTrashInfo(t, fs)
+	expected := testfs.Read(`
+/a/x [10000 1]
+/a/y [2000 2]
+/b/x [10000 1]
+/b/y [2000 2]
+	`)
+	if !testfs.Equal(fs, expected) {
+		t.Fatalf("expected:\n%sgot:\n%s", expected.ShowIndent(2), testfs.ShowIndent(fs, 2))
+	}
+	files, _ := ps.db.AllTrashed()
+	if len(files) != 0 {
+		t.Fatalf("expected trash to be empty, got %+v", files)
+	}
+}